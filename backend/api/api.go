@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,10 +16,31 @@ import (
 	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
 	"github.com/projuktisheba/vpanel/backend/internal/driver"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/sitelock"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/totp"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
 )
 
 var app *Application
 
+// loginAttemptRetention is how long sign-in attempts are kept for review
+const loginAttemptRetention = 90 * 24 * time.Hour
+
+// jobRetention is how long finished background jobs are kept
+const jobRetention = 30 * 24 * time.Hour
+
+// defaultAccessTokenExpiry is the lifetime of an access token when JWT_EXPIRY is unset.
+// A stolen token is only useful for minutes; clients renew it with the refresh token.
+const defaultAccessTokenExpiry = 15 * time.Minute
+
+// jobWorkers is the number of background jobs run at the same time
+const jobWorkers = 2
+
 // serve starts the server and listens for requests
 func (app *Application) serve() error {
 	srv := &http.Server{
@@ -27,7 +49,7 @@ func (app *Application) serve() error {
 		IdleTimeout:       60 * time.Minute,  // optional increase
 		ReadTimeout:       10 * time.Minute, // allow large uploads
 		ReadHeaderTimeout: 2 * time.Minute,
-		WriteTimeout:      60 * time.Minute, // allow long deployment
+		WriteTimeout:      60 * time.Minute, // allow long SQL imports; deployments run as background jobs
 	}
 
 	app.server = srv
@@ -50,6 +72,9 @@ func (app *Application) ShutdownServer() error {
 	}
 
 	app.infoLog.Println("Server exited gracefully")
+
+	// Let running jobs finish; any still running are recovered on the next start
+	app.jobs.Stop(time.Minute)
 	return nil
 }
 
@@ -70,10 +95,39 @@ func RunServer(ctx context.Context) error {
 		Issuer:    cfg.JWT.Issuer,
 		Audience:  cfg.JWT.Audience,
 		Algorithm: "HS256",
-		Expiry:    time.Hour * 24,
+		Expiry:    cfg.JWT.Expiry,
+		Refresh:   cfg.JWT.Refresh,
+	}
+	if cfg.JWT.Expiry <= 0 {
+		cfg.JWT.Expiry = defaultAccessTokenExpiry
+	}
+	if cfg.JWT.Refresh <= 0 {
+		cfg.JWT.Refresh = time.Hour * 24 * 30
+	}
+	// An empty key would let anyone sign their own tokens
+	if cfg.JWT.SecretKey == "" {
+		err := errors.New("JWT_SECRET_KEY is required")
+		errorLog.Println(err)
+		return err
+	}
+
+	// Without it the TOTP secrets would be stored in the clear
+	if len(cfg.Security.TOTPKey) == 0 {
+		err := errors.New("TOTP_ENCRYPTION_KEY is required (generate one with: openssl rand -base64 32)")
+		errorLog.Println(err)
+		return err
+	}
+	sealer, err := totp.NewSealer(cfg.Security.TOTPKey)
+	if err != nil {
+		errorLog.Println(err)
+		return err
 	}
 
 	infoLog.Println(cfg)
+	layout.Set(cfg.Layout)
+	if cfg.Layout.Root != "" {
+		infoLog.Println("Sandboxed: every host path is under", cfg.Layout.Root)
+	}
 	// Connection to database
 	var dbConn *pgxpool.Pool
 	if cfg.Env == "production" {
@@ -89,11 +143,53 @@ func RunServer(ctx context.Context) error {
 	}
 	defer dbConn.Close()
 
-	dbRepo := dbrepo.NewDBRepository(dbConn)
+	dbRepo := dbrepo.NewDBRepository(dbConn, sealer)
 	infoLog.Println("Connected to database")
 
+	// Encrypt the TOTP secrets enrolled before they were encrypted at rest
+	if n, err := dbRepo.MFA.SealPlaintextSecrets(ctx); err != nil {
+		errorLog.Println("failed to encrypt the stored TOTP secrets:", err)
+		return err
+	} else if n > 0 {
+		infoLog.Printf("Encrypted %d stored TOTP secrets", n)
+	}
+
+	// Send privileged operations (nginx, PHP-FPM, certbot, project ownership) to the broker,
+	// or run them through sudo on a host explicitly configured without it
+	if cfg.SudoFallback {
+		syscmd.SetDefault(syscmd.SudoFallback{})
+		infoLog.Println("PRIVILEGE_MODE=sudo: privileged operations run through sudo, not the broker")
+	} else {
+		syscmd.SetDefault(broker.NewExecutor(cfg.BrokerSocket))
+		infoLog.Println("Using privilege broker at", cfg.BrokerSocket)
+	}
+
+	// Background job queue; handlers register their job types while the routes are built.
+	// Its site locks are shared with the handlers' quick site operations.
+	queue := jobs.New(dbRepo.Job, oplog.NewStore(utils.GetOperationLogDirectory()), sitelock.New(), infoLog, errorLog)
+
+	// Periodically drop expired refresh tokens, revocation entries, old sign-in attempts and finished jobs
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := dbRepo.Token.PurgeExpired(ctx); err != nil {
+				errorLog.Println("failed to purge expired tokens:", err)
+			}
+			if err := dbRepo.Login.PurgeAttempts(ctx, loginAttemptRetention); err != nil {
+				errorLog.Println("failed to purge login attempts:", err)
+			}
+			if err := dbRepo.Job.PurgeJobs(ctx, jobRetention); err != nil {
+				errorLog.Println("failed to purge finished jobs:", err)
+			}
+			if err := queue.Logs().Purge(jobRetention); err != nil {
+				errorLog.Println("failed to purge job output logs:", err)
+			}
+		}
+	}()
+
 	// create router instance
-	routes := routes.Routes(cfg.Host, cfg.Env, dbRepo, cfg.JWT, infoLog, errorLog, cfg.DB.MySQLRootDSN, cfg.DB.PostgreSQLRootDSN)
+	routes := routes.Routes(cfg.Host, cfg.Env, dbRepo, queue, cfg.JWT, cfg.Security, infoLog, errorLog, cfg.DB.MySQLRootDSN, cfg.DB.PostgreSQLRootDSN)
 	//Initiate handlers
 	app = &Application{
 		config:    cfg,
@@ -102,9 +198,16 @@ func RunServer(ctx context.Context) error {
 		version:   "1.0.0",
 		db:        dbRepo,
 		appRoutes: routes,
+		jobs:      queue,
 		ctx:       ctx,
 	}
 
+	// Recover jobs interrupted by the last shutdown and start the workers
+	if err := queue.Start(jobWorkers); err != nil {
+		errorLog.Println(err)
+		return err
+	}
+
 	// Run the server in a separate goroutine so we can wait for shutdown signals
 	go func() {
 		if err := app.serve(); err != nil {
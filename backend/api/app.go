@@ -7,6 +7,7 @@ import (
 
 	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
 )
 
 // application is the receiver for the various parts of the application
@@ -18,6 +19,7 @@ type Application struct {
 	appRoutes http.Handler
 	db        *dbrepo.DBRepository
 	server    *http.Server
+	jobs      *jobs.Queue
 	ctx       context.Context
 }
 
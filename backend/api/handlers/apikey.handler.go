@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+const maxAPIKeyNameLength = 100
+
+type APIKeyHandler struct {
+	DB       *dbrepo.DBRepository
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func newAPIKeyHandler(db *dbrepo.DBRepository, infoLog, errorLog *log.Logger) APIKeyHandler {
+	return APIKeyHandler{
+		DB:       db,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+// ==================== Create API Key ====================
+// request body: {name, scopes, allowedIps, expiresAt}
+// scopes are rbac permissions (e.g. "project:deploy") and must all be held by the caller's role.
+// allowedIps are IPs/CIDRs; empty allows any address. expiresAt (RFC 3339) is optional.
+// response: {apiKey, key} - key is shown only once
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	var req struct {
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		AllowedIPs []string   `json:"allowedIps"`
+		ExpiresAt  *time.Time `json:"expiresAt"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		h.errorLog.Println("ERROR_01_CreateAPIKey: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		utils.BadRequest(w, fmt.Errorf("name is required (max %d characters)", maxAPIKeyNameLength))
+		return
+	}
+
+	scopes, err := validateScopes(req.Scopes, claims.Role)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	allowedIPs := []string{}
+	nets, err := utils.ParseIPNets(req.AllowedIPs)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	for _, n := range nets {
+		allowedIPs = append(allowedIPs, n.String())
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.BadRequest(w, errors.New("expiresAt must be in the future"))
+		return
+	}
+
+	// vpk_<prefix>_<secret>; the prefix is stored in clear to recognise the key later
+	prefix, err := utils.RandomToken(6)
+	if err != nil {
+		utils.ServerError(w, err)
+		return
+	}
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		utils.ServerError(w, err)
+		return
+	}
+	key := models.APIKeyPrefix + prefix + "_" + secret
+
+	apiKey := &models.APIKey{
+		UserID:     claims.ID,
+		Name:       req.Name,
+		Prefix:     models.APIKeyPrefix + prefix,
+		KeyHash:    utils.HashToken(key),
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := h.DB.APIKey.CreateAPIKey(r.Context(), apiKey); err != nil {
+		h.errorLog.Println("ERROR_02_CreateAPIKey:", err)
+		utils.ServerError(w, fmt.Errorf("failed to create API key: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool           `json:"error"`
+		Message string         `json:"message"`
+		Key     string         `json:"key"`
+		APIKey  *models.APIKey `json:"apiKey"`
+	}{
+		Error:   false,
+		Message: "API key created. Copy it now, it will not be shown again.",
+		Key:     key,
+		APIKey:  apiKey,
+	}
+	utils.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// ==================== List API Keys ====================
+// Lists the caller's keys. Users with user:manage may pass user_id, or user_id=0 for every user.
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	userID := claims.ID
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		if !rbac.Allows(claims.Role, rbac.UserManage) {
+			utils.WriteJSON(w, http.StatusForbidden, models.Response{Error: true, Message: "Forbidden: your role does not allow " + string(rbac.UserManage)})
+			return
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			utils.BadRequest(w, errors.New("invalid user ID"))
+			return
+		}
+		userID = id
+	}
+
+	keys, err := h.DB.APIKey.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ListAPIKeys:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch API keys: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool             `json:"error"`
+		Message string           `json:"message"`
+		APIKeys []*models.APIKey `json:"apiKeys"`
+	}{
+		Error:   false,
+		Message: "API keys fetched successfully",
+		APIKeys: keys,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Revoke API Key ====================
+// query parameter: key_id. Users with user:manage may revoke anyone's key.
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	keyID, err := strconv.ParseInt(r.URL.Query().Get("key_id"), 10, 64)
+	if err != nil || keyID <= 0 {
+		utils.BadRequest(w, errors.New("invalid API key ID"))
+		return
+	}
+
+	owner := claims.ID
+	if rbac.Allows(claims.Role, rbac.UserManage) {
+		owner = 0
+	}
+	if err := h.DB.APIKey.RevokeAPIKey(r.Context(), keyID, owner); err != nil {
+		h.errorLog.Println("ERROR_01_RevokeAPIKey:", err)
+		utils.BadRequest(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: "API key revoked successfully",
+	})
+}
+
+// ==================== List Scopes ====================
+// Lists the scopes the caller may grant to a key
+func (h *APIKeyHandler) ListScopes(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	scopes := []rbac.Permission{}
+	for _, p := range rbac.APIKeyScopes() {
+		if rbac.Allows(claims.Role, p) {
+			scopes = append(scopes, p)
+		}
+	}
+
+	resp := struct {
+		Error  bool              `json:"error"`
+		Scopes []rbac.Permission `json:"scopes"`
+	}{
+		Error:  false,
+		Scopes: scopes,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// validateScopes checks that every scope exists, may be put on a key and is held by role
+func validateScopes(scopes []string, role string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	seen := map[string]bool{}
+	valid := []string{}
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		p := rbac.Permission(s)
+		if !rbac.ValidAPIKeyScope(p) {
+			return nil, fmt.Errorf("unknown or disallowed scope %q", s)
+		}
+		if !rbac.Allows(role, p) {
+			return nil, fmt.Errorf("your role does not allow %q", s)
+		}
+		valid = append(valid, s)
+	}
+	return valid, nil
+}
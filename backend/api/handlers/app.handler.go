@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/deploy"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// defaultAppLogLines is the number of journal lines GetAppLogs returns by default
+const defaultAppLogLines = 200
+
+// maskedEnvValue replaces the values of an application's environment for the users who
+// may not deploy the project
+const maskedEnvValue = "********"
+
+// AppHandler manages the applications of proxied and Python projects: their systemd
+// service and its logs. The site itself is deployed, suspended and deleted like any other.
+type AppHandler struct {
+	DB       *dbrepo.DBRepository
+	Jobs     *jobs.Queue
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func newAppHandler(db *dbrepo.DBRepository, queue *jobs.Queue, infoLog, errorLog *log.Logger) AppHandler {
+	return AppHandler{
+		DB:       db,
+		Jobs:     queue,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+// ==================== Get Application ====================
+// query parameter: project_id
+func (h *AppHandler) GetApp(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r, deploy.FrameworkApp)
+	if !ok {
+		return
+	}
+	app, err := h.DB.Apps.GetProjectApp(r.Context(), project.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		h.errorLog.Println("ERROR_01_GetApp:", err)
+		utils.ServerError(w, fmt.Errorf("failed to load the application: %w", err))
+		return
+	}
+	if app != nil && !canSeeEnv(r) {
+		app.Env = maskEnv(app.Env)
+	}
+
+	resp := struct {
+		Error   bool               `json:"error"`
+		Message string             `json:"message"`
+		App     *models.ProjectApp `json:"app"` // null until the application is saved
+	}{
+		Error:   false,
+		Message: "Application fetched successfully",
+		App:     app,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Save Application ====================
+// Allocates the application's port the first time it is saved
+// query parameter: project_id
+func (h *AppHandler) SaveApp(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r, deploy.FrameworkApp)
+	if !ok {
+		return
+	}
+
+	var app models.ProjectApp
+	if err := utils.ReadJSON(w, r, &app); err != nil {
+		h.errorLog.Println("ERROR_01_SaveApp: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+	app.ProjectID = project.ID
+	app.Command = strings.TrimSpace(app.Command)
+	app.WorkingDir = strings.TrimSpace(app.WorkingDir)
+	app.RunAs = strings.TrimSpace(app.RunAs)
+	app.Restart = strings.TrimSpace(app.Restart)
+
+	// the port stays with the project; a new application gets the first free one
+	existing, err := h.DB.Apps.GetProjectApp(r.Context(), project.ID)
+	switch {
+	case err == nil:
+		app.Port = existing.Port
+	case errors.Is(err, pgx.ErrNoRows):
+		used, err := h.DB.Apps.ListAppPorts(r.Context())
+		if err != nil {
+			h.errorLog.Println("ERROR_02_SaveApp:", err)
+			utils.ServerError(w, fmt.Errorf("failed to allocate a port: %w", err))
+			return
+		}
+		if app.Port, err = deploy.FreeAppPort(used); err != nil {
+			h.errorLog.Println("ERROR_03_SaveApp:", err)
+			utils.ServerError(w, err)
+			return
+		}
+	default:
+		h.errorLog.Println("ERROR_04_SaveApp:", err)
+		utils.ServerError(w, fmt.Errorf("failed to load the application: %w", err))
+		return
+	}
+
+	target := deploy.TargetFor(project, nil)
+	target.App = &app
+	if _, err := deploy.AppUnit(target); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	if err := h.DB.Apps.SaveProjectApp(r.Context(), &app); err != nil {
+		h.errorLog.Println("ERROR_05_SaveApp:", err)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			writeConflict(w, fmt.Sprintf("port %d was just taken by another project, save again", app.Port), 0)
+			return
+		}
+		utils.ServerError(w, fmt.Errorf("failed to save the application: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool               `json:"error"`
+		Message string             `json:"message"`
+		App     *models.ProjectApp `json:"app"`
+	}{
+		Error:   false,
+		Message: "Application saved; it takes effect on the next deploy",
+		App:     &app,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Get Python Application ====================
+// query parameter: project_id
+func (h *AppHandler) GetPythonApp(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r, deploy.FrameworkPython)
+	if !ok {
+		return
+	}
+	app, err := h.DB.Apps.GetProjectPythonApp(r.Context(), project.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		h.errorLog.Println("ERROR_01_GetPythonApp:", err)
+		utils.ServerError(w, fmt.Errorf("failed to load the application: %w", err))
+		return
+	}
+	if app != nil && !canSeeEnv(r) {
+		app.Env = maskEnv(app.Env)
+	}
+
+	resp := struct {
+		Error   bool                     `json:"error"`
+		Message string                   `json:"message"`
+		App     *models.ProjectPythonApp `json:"app"` // null until the application is saved
+	}{
+		Error:   false,
+		Message: "Application fetched successfully",
+		App:     app,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Save Python Application ====================
+// query parameter: project_id
+func (h *AppHandler) SavePythonApp(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r, deploy.FrameworkPython)
+	if !ok {
+		return
+	}
+
+	var app models.ProjectPythonApp
+	if err := utils.ReadJSON(w, r, &app); err != nil {
+		h.errorLog.Println("ERROR_01_SavePythonApp: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+	app.ProjectID = project.ID
+	app.Module = strings.TrimSpace(app.Module)
+	app.Server = strings.ToLower(strings.TrimSpace(app.Server))
+	if app.Server == "" {
+		app.Server = deploy.PythonServerGunicorn
+	}
+	app.StaticURL = strings.TrimSpace(app.StaticURL)
+	app.StaticDir = strings.Trim(strings.TrimSpace(app.StaticDir), "/")
+
+	target := deploy.TargetFor(project, nil)
+	target.Python = &app
+	if _, err := deploy.PythonUnit(target); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	if err := h.DB.Apps.SaveProjectPythonApp(r.Context(), &app); err != nil {
+		h.errorLog.Println("ERROR_02_SavePythonApp:", err)
+		utils.ServerError(w, fmt.Errorf("failed to save the application: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool                     `json:"error"`
+		Message string                   `json:"message"`
+		App     *models.ProjectPythonApp `json:"app"`
+	}{
+		Error:   false,
+		Message: "Application saved; it takes effect on the next deploy",
+		App:     &app,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Start / Stop / Restart Application ====================
+// query parameter: project_id
+func (h *AppHandler) StartApp(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "start", "Application started")
+}
+
+// query parameter: project_id
+func (h *AppHandler) StopApp(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "stop", "Application stopped")
+}
+
+// query parameter: project_id
+func (h *AppHandler) RestartApp(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "restart", "Application restarted")
+}
+
+func (h *AppHandler) control(w http.ResponseWriter, r *http.Request, action, message string) {
+	project, ok := h.project(w, r, deploy.FrameworkApp, deploy.FrameworkPython)
+	if !ok {
+		return
+	}
+
+	ctx, release, ok := lockSite(w, r, h.DB, h.Jobs, project, action)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := syscmd.Privileged(ctx, &broker.ControlApp{Domain: project.DomainName, Action: action}); err != nil {
+		h.errorLog.Printf("ERROR_01_%sApp: %v", action, err)
+		utils.ServerError(w, fmt.Errorf("failed to %s the application: %w", action, err))
+		return
+	}
+
+	resp := models.Response{
+		Error:   false,
+		Message: message,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Application Status ====================
+// query parameter: project_id
+func (h *AppHandler) GetAppStatus(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r, deploy.FrameworkApp, deploy.FrameworkPython)
+	if !ok {
+		return
+	}
+
+	service, err := deploy.AppServiceStatus(r.Context(), project.DomainName)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_GetAppStatus:", err)
+		utils.ServerError(w, fmt.Errorf("failed to read the service status: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool               `json:"error"`
+		Message string             `json:"message"`
+		Service *deploy.AppService `json:"service"`
+	}{
+		Error:   false,
+		Message: "Application " + service.ActiveState,
+		Service: service,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Application Logs ====================
+// query parameters: project_id, lines (default 200), since (journalctl syntax, e.g. "1 hour ago")
+func (h *AppHandler) GetAppLogs(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r, deploy.FrameworkApp, deploy.FrameworkPython)
+	if !ok {
+		return
+	}
+
+	lines := defaultAppLogLines
+	if v := r.URL.Query().Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			utils.BadRequest(w, errors.New("invalid lines"))
+			return
+		}
+		lines = n
+	}
+	since := strings.TrimSpace(r.URL.Query().Get("since"))
+
+	out, err := deploy.AppLogs(r.Context(), project.DomainName, lines, since)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_GetAppLogs:", err)
+		utils.BadRequest(w, fmt.Errorf("failed to read the logs: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Logs    string `json:"logs"`
+	}{
+		Error:   false,
+		Message: "Logs fetched successfully",
+		Logs:    string(out),
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// project returns the project of the project_id query parameter, replying with an error
+// when it is missing, unknown or not of one of frameworks
+func (h *AppHandler) project(w http.ResponseWriter, r *http.Request, frameworks ...string) (*models.Project, bool) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("project_id"), 10, 64)
+	if err != nil {
+		utils.BadRequest(w, errors.New("invalid project ID"))
+		return nil, false
+	}
+	project, err := h.DB.ProjectRepo.GetProjectByID(r.Context(), id)
+	if err != nil {
+		utils.NotFound(w, "Project not found")
+		return nil, false
+	}
+	if !slices.Contains(frameworks, project.ProjectFramework) {
+		utils.BadRequest(w, fmt.Errorf("project %d is not an application (framework %q)", id, project.ProjectFramework))
+		return nil, false
+	}
+	return project, true
+}
+
+// canSeeEnv reports whether the user of r may see the values of an application's
+// environment, which hold its credentials: only those who may deploy the project do
+func canSeeEnv(r *http.Request) bool {
+	user, ok := middlewares.UserFromContext(r.Context())
+	if !ok || !rbac.Allows(user.Role, rbac.ProjectDeploy) {
+		return false
+	}
+	// an API key also needs the permission in its scopes
+	return user.APIKeyID == 0 || slices.Contains(user.Scopes, string(rbac.ProjectDeploy))
+}
+
+// maskEnv returns the names of env with their values masked
+func maskEnv(env map[string]string) map[string]string {
+	masked := make(map[string]string, len(env))
+	for name := range env {
+		masked[name] = maskedEnvValue
+	}
+	return masked
+}
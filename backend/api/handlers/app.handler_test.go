@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// scopedKeys authenticates every API key as an operator's key with scopes
+type scopedKeys []string
+
+func (s scopedKeys) GetActiveAPIKey(context.Context, string) (*models.APIKey, error) {
+	return &models.APIKey{
+		ID:          1,
+		UserID:      1,
+		Scopes:      s,
+		OwnerRole:   rbac.RoleOperator,
+		OwnerStatus: models.UserStatusActive,
+	}, nil
+}
+
+func (scopedKeys) TouchAPIKey(context.Context, int64, string) error { return nil }
+
+func TestCanSeeEnv(t *testing.T) {
+	cfg := models.JWTConfig{SecretKey: "test-secret", Algorithm: "HS256", Expiry: time.Minute}
+	bearer := func(role string) string {
+		token, err := utils.GenerateJWT(models.JWT{ID: 1, Role: role, TokenID: role, SessionID: "session"}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+
+	tests := []struct {
+		name          string
+		scopes        scopedKeys
+		authorization string
+		apiKey        string
+		want          bool
+	}{
+		{"admin", nil, bearer(rbac.RoleAdmin), "", true},
+		{"operator", nil, bearer(rbac.RoleOperator), "", true},
+		{"viewer", nil, bearer(rbac.RoleViewer), "", false},
+		{"key with deploy scope", scopedKeys{string(rbac.ProjectRead), string(rbac.ProjectDeploy)}, "", "vpk_test", true},
+		{"key with read scope only", scopedKeys{string(rbac.ProjectRead)}, "", "vpk_test", false},
+	}
+	discard := log.New(io.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := middlewares.NewAuth(cfg, models.SecurityConfig{}, noRevocations{}, tt.scopes, discard)
+			var got, called bool
+			h := auth.AuthUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, called = canSeeEnv(r), true
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/projects/app?project_id=1", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if !called {
+				t.Fatal("request not authenticated")
+			}
+			if got != tt.want {
+				t.Errorf("canSeeEnv = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if canSeeEnv(httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Error("canSeeEnv without a user = true")
+	}
+}
+
+func TestMaskEnv(t *testing.T) {
+	env := map[string]string{"DATABASE_URL": "postgres://u:p@db/app", "PORT": "8080"}
+	want := map[string]string{"DATABASE_URL": maskedEnvValue, "PORT": maskedEnvValue}
+	if got := maskEnv(env); !maps.Equal(got, want) {
+		t.Errorf("maskEnv = %v, want %v", got, want)
+	}
+	if env["PORT"] != "8080" {
+		t.Error("maskEnv changed its argument")
+	}
+	if got := maskEnv(nil); len(got) != 0 {
+		t.Errorf("maskEnv(nil) = %v", got)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// maxAuditExport caps how many events a single export returns
+const maxAuditExport = 10000
+
+type AuditHandler struct {
+	DB       *dbrepo.DBRepository
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func newAuditHandler(db *dbrepo.DBRepository, infoLog, errorLog *log.Logger) AuditHandler {
+	return AuditHandler{
+		DB:       db,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+// ==================== List Audit Events ====================
+// query parameters: page, limit, actor_id, action, target, outcome, from, to (RFC 3339 or YYYY-MM-DD)
+func (h *AuditHandler) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	if filter.Page <= 0 {
+		filter.Page = 1
+	}
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+
+	events, total, err := h.DB.Audit.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ListAuditEvents:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch audit events: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool                 `json:"error"`
+		Message string               `json:"message"`
+		Page    int                  `json:"page"`
+		Limit   int                  `json:"limit"`
+		Total   int                  `json:"total"`
+		Events  []*models.AuditEvent `json:"events"`
+	}{
+		Error:   false,
+		Message: "Audit events fetched successfully",
+		Page:    filter.Page,
+		Limit:   filter.Limit,
+		Total:   total,
+		Events:  events,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Export Audit Events ====================
+// query parameters: format (csv|json, default csv) and the same filters as /list.
+// At most 10000 events (newest first) are exported; narrow the date range for more.
+func (h *AuditHandler) ExportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	filter.Page = 1
+	filter.Limit = maxAuditExport
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		utils.BadRequest(w, errors.New("format must be csv or json"))
+		return
+	}
+
+	events, _, err := h.DB.Audit.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ExportAuditEvents:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch audit events: %w", err))
+		return
+	}
+
+	fileName := fmt.Sprintf("audit-%s.%s", time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(events); err != nil {
+			h.errorLog.Println("ERROR_02_ExportAuditEvents:", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{
+		"id", "created_at", "actor_user_id", "actor_name", "actor_role", "api_key_id", "ip_address",
+		"action", "method", "path", "target", "params", "status_code", "outcome", "error_message", "duration_ms",
+	})
+	for _, e := range events {
+		_ = cw.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.Format(time.RFC3339),
+			optionalID(e.ActorUserID),
+			csvSafe(e.ActorName),
+			e.ActorRole,
+			optionalID(e.APIKeyID),
+			e.IPAddress,
+			e.Action,
+			e.Method,
+			e.Path,
+			csvSafe(e.Target),
+			csvSafe(string(e.Params)),
+			strconv.Itoa(e.StatusCode),
+			e.Outcome,
+			csvSafe(e.ErrorMessage),
+			strconv.FormatInt(e.DurationMs, 10),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		h.errorLog.Println("ERROR_03_ExportAuditEvents:", err)
+	}
+}
+
+// auditFilterFromQuery reads the shared list/export filters
+func auditFilterFromQuery(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		Action:  q.Get("action"),
+		Target:  q.Get("target"),
+		Outcome: q.Get("outcome"),
+	}
+	f.Page, _ = strconv.Atoi(q.Get("page"))
+	f.Limit, _ = strconv.Atoi(q.Get("limit"))
+
+	if v := q.Get("actor_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, errors.New("invalid actor_id")
+		}
+		f.ActorUserID = id
+	}
+
+	var err error
+	if f.From, err = parseTimeParam(q.Get("from"), false); err != nil {
+		return f, fmt.Errorf("invalid from: %w", err)
+	}
+	if f.To, err = parseTimeParam(q.Get("to"), true); err != nil {
+		return f, fmt.Errorf("invalid to: %w", err)
+	}
+	return f, nil
+}
+
+// parseTimeParam accepts RFC 3339 or a plain date. A plain "to" date includes the whole day.
+func parseTimeParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC 3339 or YYYY-MM-DD")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func optionalID(id *int64) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatInt(*id, 10)
+}
+
+// csvSafe stops spreadsheet apps from evaluating user-controlled cells as formulas
+func csvSafe(s string) string {
+	if s != "" && (s[0] == '=' || s[0] == '+' || s[0] == '-' || s[0] == '@') {
+		return "'" + s
+	}
+	return s
+}
@@ -1,30 +1,41 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
 	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/loginguard"
 	"github.com/projuktisheba/vpanel/backend/internal/utils"
 )
 
 type AuthHandler struct {
-	DB        *dbrepo.DBRepository
-	JWTConfig models.JWTConfig
-	infoLog   *log.Logger
-	errorLog  *log.Logger
+	DB         *dbrepo.DBRepository
+	JWTConfig  models.JWTConfig
+	Security   models.SecurityConfig
+	challenges *mfaChallenges
+	guard      *loginguard.Guard
+	infoLog    *log.Logger
+	errorLog   *log.Logger
 }
 
-func newAuthHandler(db *dbrepo.DBRepository, JWTConfig models.JWTConfig, infoLog, errorLog *log.Logger) AuthHandler {
+func newAuthHandler(db *dbrepo.DBRepository, JWTConfig models.JWTConfig, security models.SecurityConfig, infoLog, errorLog *log.Logger) AuthHandler {
 	return AuthHandler{
-		DB:        db,
-		JWTConfig: JWTConfig,
-		infoLog:   infoLog,
-		errorLog:  errorLog,
+		DB:         db,
+		JWTConfig:  JWTConfig,
+		Security:   security,
+		challenges: newMFAChallenges(),
+		guard:      loginguard.New(loginguard.DefaultUserPolicy, loginguard.DefaultIPPolicy),
+		infoLog:    infoLog,
+		errorLog:   errorLog,
 	}
 }
 
@@ -50,36 +61,81 @@ func (h *AuthHandler) Signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Throttle repeated failures per username and per client IP
+	ip := utils.ClientIP(r, h.Security.TrustedProxies)
+	if wait := h.guard.Wait(req.Username, ip); wait > 0 {
+		tooManyAttempts(w, wait, "too many failed sign-in attempts, please try again later")
+		return
+	}
+
 	// Fetch employee by mobile OR email
 	user, err := h.DB.UserRepo.GetUserByUsername(r.Context(), req.Username)
 	if err != nil {
 		h.errorLog.Println("ERROR_02_Signin: user not found:", err)
+		h.signinFailed(r, req.Username, nil, ip, models.LoginReasonUnknownUser)
 		utils.BadRequest(w, errors.New("invalid username or password"))
 		return
 	}
 
+	// A locked account is refused before the password is even checked
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		h.recordAttempt(r, req.Username, &user.ID, ip, false, models.LoginReasonLocked)
+		tooManyAttempts(w, time.Until(*user.LockedUntil), "account is temporarily locked after too many failed sign-in attempts")
+		return
+	}
+
 	// Check password (assumes hashed in DB)
 	if !utils.CheckPassword(req.Password, user.Password) {
 		h.errorLog.Println("ERROR_03_Signin: password mismatch")
+		if lockedUntil := h.signinFailed(r, req.Username, user, ip, models.LoginReasonInvalidPassword); lockedUntil != nil {
+			tooManyAttempts(w, time.Until(*lockedUntil), "account is temporarily locked after too many failed sign-in attempts")
+			return
+		}
 		utils.BadRequest(w, errors.New("invalid username or password"))
 		return
 	}
 
-	// Generate JWT
-	token, err := utils.GenerateJWT(models.JWT{
-		ID:        user.ID,
-		Name:      user.Name,
-		Username:  user.Email,
-		Role:      user.Role,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-	}, h.JWTConfig)
+	// Deactivated accounts cannot sign in
+	if user.Status != models.UserStatusActive {
+		h.recordAttempt(r, req.Username, &user.ID, ip, false, models.LoginReasonInactive)
+		utils.BadRequest(w, errors.New("this account has been deactivated"))
+		return
+	}
+
+	// With TOTP enabled the password only earns a challenge token for /verify-totp
+	if user.TOTPEnabled {
+		challengeToken, err := h.mfaChallengeToken(user)
+		if err != nil {
+			h.errorLog.Println("ERROR_05_Signin: failed to issue challenge:", err)
+			utils.ServerError(w, fmt.Errorf("failed to generate token: %w", err))
+			return
+		}
+
+		resp := struct {
+			Error          bool   `json:"error"`
+			Message        string `json:"message"`
+			MFARequired    bool   `json:"mfaRequired"`
+			ChallengeToken string `json:"challengeToken"`
+			ExpiresIn      int    `json:"expiresIn"` // seconds
+		}{
+			Error:          false,
+			Message:        "Enter the code from your authenticator app",
+			MFARequired:    true,
+			ChallengeToken: challengeToken,
+			ExpiresIn:      int(mfaChallengeTTL.Seconds()),
+		}
+		utils.WriteJSON(w, http.StatusOK, resp)
+		return
+	}
 
+	// Issue an access token and start a new refresh token family
+	tokens, err := h.issueTokens(r.Context(), user, "")
 	if err != nil {
-		h.errorLog.Println("ERROR_04_Signin: failed to generate JWT:", err)
-		utils.BadRequest(w, fmt.Errorf("failed to generate token: %w", err))
+		h.errorLog.Println("ERROR_04_Signin: failed to issue tokens:", err)
+		utils.ServerError(w, fmt.Errorf("failed to generate token: %w", err))
 		return
 	}
+	h.signinSucceeded(r, req.Username, user, ip)
 
 	// Build response
 	resp := struct {
@@ -88,10 +144,178 @@ func (h *AuthHandler) Signin(w http.ResponseWriter, r *http.Request) {
 		RefreshToken string       `json:"refreshToken"`
 		User         *models.User `json:"user"`
 	}{
-		Error:       false,
-		AccessToken: token,
-		User:        user,
+		Error:        false,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		User:         user,
 	}
 
 	utils.WriteJSON(w, http.StatusOK, resp)
 }
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+// The presented refresh token is single use; presenting it twice revokes the whole session.
+func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+	req.RefreshToken = strings.TrimSpace(req.RefreshToken)
+	if req.RefreshToken == "" {
+		utils.BadRequest(w, errors.New("refreshToken is required"))
+		return
+	}
+
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		utils.ServerError(w, err)
+		return
+	}
+	next := models.RefreshToken{
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(h.JWTConfig.Refresh),
+	}
+
+	err = h.DB.Token.RotateRefreshToken(r.Context(), utils.HashToken(req.RefreshToken), &next)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_RefreshToken:", err)
+		if errors.Is(err, dbrepo.ErrRefreshTokenInvalid) || errors.Is(err, dbrepo.ErrRefreshTokenReused) {
+			utils.WriteJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: err.Error()})
+			return
+		}
+		utils.ServerError(w, fmt.Errorf("failed to refresh token: %w", err))
+		return
+	}
+
+	// Reload the user so role changes take effect on refresh
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), next.UserID)
+	if err == nil && user.Status != models.UserStatusActive {
+		err = errors.New("user is deactivated")
+	}
+	if err != nil {
+		h.errorLog.Println("ERROR_02_RefreshToken:", err)
+		_ = h.DB.Token.RevokeFamily(r.Context(), next.FamilyID)
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: "user no longer exists or is deactivated"})
+		return
+	}
+
+	accessToken, err := h.accessToken(user, next.FamilyID)
+	if err != nil {
+		h.errorLog.Println("ERROR_03_RefreshToken:", err)
+		utils.ServerError(w, fmt.Errorf("failed to generate token: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error        bool   `json:"error"`
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+	}{
+		Error:        false,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// Logout revokes the caller's access token and every refresh token of its session family
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middlewares.UserFromContext(r.Context())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: "Unauthorized"})
+		return
+	}
+
+	if err := h.DB.Token.RevokeAccessToken(r.Context(), claims.TokenID, claims.ID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		h.errorLog.Println("ERROR_01_Logout:", err)
+		utils.ServerError(w, fmt.Errorf("failed to revoke token: %w", err))
+		return
+	}
+	if err := h.DB.Token.RevokeFamily(r.Context(), claims.SessionID); err != nil {
+		h.errorLog.Println("ERROR_02_Logout:", err)
+		utils.ServerError(w, fmt.Errorf("failed to revoke session: %w", err))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: "Logged out successfully",
+	})
+}
+
+// RevokeUserSessions kills every session of a user (query parameter: user_id).
+// Their access tokens stop working immediately and their refresh tokens can no longer be used.
+func (h *AuthHandler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.BadRequest(w, errors.New("invalid user ID"))
+		return
+	}
+
+	count, err := h.DB.Token.RevokeUserSessions(r.Context(), userID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_RevokeUserSessions:", err)
+		utils.ServerError(w, fmt.Errorf("failed to revoke sessions: %w", err))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: fmt.Sprintf("%d session token(s) revoked", count),
+	})
+}
+
+type issuedTokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// issueTokens creates a refresh token in familyID (a new family when empty) and a matching access token
+func (h *AuthHandler) issueTokens(ctx context.Context, user *models.User, familyID string) (issuedTokens, error) {
+	var tokens issuedTokens
+	var err error
+
+	if familyID == "" {
+		if familyID, err = utils.NewTokenID(); err != nil {
+			return tokens, err
+		}
+	}
+
+	if tokens.RefreshToken, err = utils.RandomToken(32); err != nil {
+		return tokens, err
+	}
+	err = h.DB.Token.CreateRefreshToken(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(tokens.RefreshToken),
+		ExpiresAt: time.Now().Add(h.JWTConfig.Refresh),
+	})
+	if err != nil {
+		return tokens, err
+	}
+
+	tokens.AccessToken, err = h.accessToken(user, familyID)
+	return tokens, err
+}
+
+// accessToken signs a short-lived JWT bound to the session family
+func (h *AuthHandler) accessToken(user *models.User, familyID string) (string, error) {
+	jti, err := utils.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	return utils.GenerateJWT(models.JWT{
+		ID:        user.ID,
+		Name:      user.Name,
+		Username:  user.Email,
+		Role:      user.Role,
+		TokenID:   jti,
+		SessionID: familyID,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}, h.JWTConfig)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
 )
 
 type HandlerRepo struct {
@@ -13,18 +14,34 @@ type HandlerRepo struct {
 	PostgreSQLManager     PostgreSQLManagerHandler
 	WordPress     WordPressHandler
 	PHP           PHPHandler
+	Site          SiteHandler
+	App           AppHandler
 	DomainHandler DomainHandler
 	SSLHandler    SSLHandler
+	User          UserHandler
+	APIKey        APIKeyHandler
+	Audit         AuditHandler
+	Job           JobHandler
+	Nginx         NginxHandler
 }
 
-func NewHandlerRepo(host string, db *dbrepo.DBRepository, JWT models.JWTConfig, infoLog, errorLog *log.Logger, mysqlRootDSN string, postgresqlRootDSN string) *HandlerRepo {
-	return &HandlerRepo{
-		Auth:          newAuthHandler(db, JWT, infoLog, errorLog),
+func NewHandlerRepo(host string, db *dbrepo.DBRepository, queue *jobs.Queue, JWT models.JWTConfig, security models.SecurityConfig, infoLog, errorLog *log.Logger, mysqlRootDSN string, postgresqlRootDSN string) *HandlerRepo {
+	repo := &HandlerRepo{
+		Auth:          newAuthHandler(db, JWT, security, infoLog, errorLog),
 		MySQLManager:     newMySQLManagerHandler(db, infoLog, errorLog, mysqlRootDSN),
 		PostgreSQLManager:     newPostgreSQLManagerHandler(db, infoLog, errorLog, postgresqlRootDSN),
-		WordPress:     newWordPressHandler(db, infoLog, errorLog),
-		PHP:           newPHPHandler(db, infoLog, errorLog),
+		WordPress:     newWordPressHandler(db, queue, infoLog, errorLog),
+		PHP:           newPHPHandler(db, queue, infoLog, errorLog),
+		Site:          newSiteHandler(db, queue, infoLog, errorLog),
+		App:           newAppHandler(db, queue, infoLog, errorLog),
 		DomainHandler: newDomainHandler(host, db, infoLog, errorLog),
-		SSLHandler:    newSSLHandler(infoLog, errorLog),
+		SSLHandler:    newSSLHandler(queue, infoLog, errorLog),
+		User:          newUserHandler(db, infoLog, errorLog),
+		APIKey:        newAPIKeyHandler(db, infoLog, errorLog),
+		Audit:         newAuditHandler(db, infoLog, errorLog),
+		Job:           newJobHandler(db, queue, JWT, infoLog, errorLog),
+		Nginx:         newNginxHandler(db, infoLog, errorLog),
 	}
+	repo.registerJobs(queue)
+	return repo
 }
@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// jobLogPollInterval is how often a log stream checks whether a queued job has started
+const jobLogPollInterval = time.Second
+
+// jobLogTicketTTL is how long a log stream ticket can be redeemed
+const jobLogTicketTTL = 30 * time.Second
+
+// jobLogUpgrader accepts WebSocket log streams. Any origin is allowed because the stream
+// is authorised by a ticket or header, not by cookies a foreign page could ride on.
+var jobLogUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type JobHandler struct {
+	DB        *dbrepo.DBRepository
+	Jobs      *jobs.Queue
+	JWTConfig models.JWTConfig
+	infoLog   *log.Logger
+	errorLog  *log.Logger
+}
+
+func newJobHandler(db *dbrepo.DBRepository, queue *jobs.Queue, JWTConfig models.JWTConfig, infoLog, errorLog *log.Logger) JobHandler {
+	return JobHandler{
+		DB:        db,
+		Jobs:      queue,
+		JWTConfig: JWTConfig,
+		infoLog:   infoLog,
+		errorLog:  errorLog,
+	}
+}
+
+// projectJobPayload identifies the project a deployment job works on
+type projectJobPayload struct {
+	ProjectID int64  `json:"projectId"`
+	Domain    string `json:"domain,omitempty"`
+	Framework string `json:"framework,omitempty"`
+}
+
+// registerJobs connects every job type to the handler method that runs it
+func (repo *HandlerRepo) registerJobs(queue *jobs.Queue) {
+	// a half-finished deployment is not safe to repeat blindly; flag the project instead
+	projectInterrupted := func(ctx context.Context, job *models.Job) {
+		var payload projectJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err == nil && payload.ProjectID > 0 {
+			_, _ = repo.Site.DB.ProjectRepo.UpdateProjectStatus(ctx, payload.ProjectID, models.ProjectStatusError)
+		}
+	}
+
+	queue.Register(models.JobTypeWordPressDeploy, jobs.Handler{
+		Run:         repo.Site.runDeploy,
+		Interrupted: projectInterrupted,
+	})
+	queue.Register(models.JobTypePHPDeploy, jobs.Handler{
+		Run:         repo.Site.runDeploy,
+		Interrupted: projectInterrupted,
+	})
+	queue.Register(models.JobTypeSSLIssue, jobs.Handler{
+		Run:         repo.SSLHandler.runIssue,
+		Resumable:   true,
+		MaxAttempts: 2,
+	})
+}
+
+// requesterID returns the ID of the signed-in user making the request, if any
+func requesterID(r *http.Request) *int64 {
+	claims, ok := middlewares.UserFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	id := claims.ID
+	return &id
+}
+
+// ==================== List Jobs ====================
+// query parameters: page, limit, status, type, target
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := models.JobFilter{
+		Status: q.Get("status"),
+		Type:   q.Get("type"),
+		Target: q.Get("target"),
+	}
+	filter.Page, _ = strconv.Atoi(q.Get("page"))
+	filter.Limit, _ = strconv.Atoi(q.Get("limit"))
+	if filter.Page <= 0 {
+		filter.Page = 1
+	}
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+
+	list, total, err := h.DB.Job.ListJobs(r.Context(), filter)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ListJobs:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch jobs: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Page    int           `json:"page"`
+		Limit   int           `json:"limit"`
+		Total   int           `json:"total"`
+		Jobs    []*models.Job `json:"jobs"`
+	}{
+		Error:   false,
+		Message: "Jobs fetched successfully",
+		Page:    filter.Page,
+		Limit:   filter.Limit,
+		Total:   total,
+		Jobs:    list,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Get Job ====================
+// query parameter: job_id
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("job_id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.BadRequest(w, errors.New("invalid job ID"))
+		return
+	}
+
+	job, err := h.DB.Job.GetJob(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, dbrepo.ErrJobNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
+		h.errorLog.Println("ERROR_01_GetJob:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch job: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool        `json:"error"`
+		Message string      `json:"message"`
+		Job     *models.Job `json:"job"`
+	}{
+		Error:   false,
+		Message: "Job fetched successfully",
+		Job:     job,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Cancel Job ====================
+// query parameter: job_id
+// A queued job is cancelled at once. A running job has its current command stopped
+// (SIGTERM, then SIGKILL) and reaches status "cancelled" shortly after.
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("job_id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.BadRequest(w, errors.New("invalid job ID"))
+		return
+	}
+
+	job, err := h.DB.Job.GetJob(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, dbrepo.ErrJobNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
+		h.errorLog.Println("ERROR_01_CancelJob:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch job: %w", err))
+		return
+	}
+
+	by := "unknown"
+	if claims, ok := middlewares.UserFromContext(r.Context()); ok {
+		by = claims.Username
+	}
+	if err := h.Jobs.Cancel(r.Context(), id, by); err != nil {
+		if errors.Is(err, jobs.ErrNotCancellable) {
+			writeConflict(w, fmt.Sprintf("job %d is %s: %v", id, job.Status, err), id)
+			return
+		}
+		h.errorLog.Println("ERROR_02_CancelJob:", err)
+		utils.ServerError(w, fmt.Errorf("failed to cancel job: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		JobID   int64  `json:"jobId"`
+	}{
+		Error:   false,
+		Message: "Cancellation requested",
+		JobID:   id,
+	}
+	utils.WriteJSON(w, http.StatusAccepted, resp)
+}
+
+// ==================== Get Job Log ====================
+// query parameters: job_id, after (return only lines with a greater seq, for polling)
+// response: {lines, running} - lines carry seq, time, stream (stdout|stderr|info) and text
+func (h *JobHandler) GetJobLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("job_id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.BadRequest(w, errors.New("invalid job ID"))
+		return
+	}
+	after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+
+	if _, err := h.DB.Job.GetJob(r.Context(), id); err != nil {
+		if errors.Is(err, dbrepo.ErrJobNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
+		h.errorLog.Println("ERROR_01_GetJobLog:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch job: %w", err))
+		return
+	}
+
+	logID := jobs.LogID(id)
+	lines, err := h.Jobs.Logs().Read(logID, after)
+	if err != nil && !errors.Is(err, oplog.ErrNotFound) {
+		h.errorLog.Println("ERROR_02_GetJobLog:", err)
+		utils.ServerError(w, fmt.Errorf("failed to read job log: %w", err))
+		return
+	}
+	if lines == nil {
+		lines = []oplog.Line{} // the job has not started yet
+	}
+	_, running := h.Jobs.Logs().Active(logID)
+
+	resp := struct {
+		Error   bool         `json:"error"`
+		Message string       `json:"message"`
+		Running bool         `json:"running"`
+		Lines   []oplog.Line `json:"lines"`
+	}{
+		Error:   false,
+		Message: "Job log fetched successfully",
+		Running: running,
+		Lines:   lines,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// jobLogMessage is one WebSocket message of a log stream
+type jobLogMessage struct {
+	Type string      `json:"type"` // "line" for output, "end" once the job has finished
+	Line *oplog.Line `json:"line,omitempty"`
+	Job  *models.Job `json:"job,omitempty"` // the finished job, on "end"
+}
+
+// ==================== Job Log Ticket ====================
+// query parameter: job_id, response: {error, message, ticket, expiresIn}
+// The ticket opens one log stream of the job, within jobLogTicketTTL.
+// It is signed for the caller's session, so signing out revokes it too.
+func (h *JobHandler) IssueJobLogTicket(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("job_id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.BadRequest(w, errors.New("invalid job ID"))
+		return
+	}
+	if _, err := h.DB.Job.GetJob(r.Context(), id); err != nil {
+		if errors.Is(err, dbrepo.ErrJobNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
+		h.errorLog.Println("ERROR_01_IssueJobLogTicket:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch job: %w", err))
+		return
+	}
+
+	claims, _ := middlewares.UserFromContext(r.Context())
+	jti, err := utils.NewTokenID()
+	if err != nil {
+		h.errorLog.Println("ERROR_02_IssueJobLogTicket:", err)
+		utils.ServerError(w, errors.New("failed to issue ticket"))
+		return
+	}
+	cfg := h.JWTConfig
+	cfg.Expiry = jobLogTicketTTL
+	ticket, err := utils.GenerateJWT(models.JWT{
+		ID:        claims.ID,
+		Name:      claims.Name,
+		Username:  claims.Username,
+		Role:      claims.Role,
+		TokenID:   jti,
+		SessionID: claims.SessionID,
+		Purpose:   models.TokenPurposeJobLog,
+		Target:    strconv.FormatInt(id, 10),
+	}, cfg)
+	if err != nil {
+		h.errorLog.Println("ERROR_03_IssueJobLogTicket:", err)
+		utils.ServerError(w, errors.New("failed to issue ticket"))
+		return
+	}
+
+	resp := struct {
+		Error     bool   `json:"error"`
+		Message   string `json:"message"`
+		Ticket    string `json:"ticket"`
+		ExpiresIn int64  `json:"expiresIn"` // seconds
+	}{
+		Error:     false,
+		Message:   "Ticket issued successfully",
+		Ticket:    ticket,
+		ExpiresIn: int64(jobLogTicketTTL.Seconds()),
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Stream Job Log ====================
+// WebSocket. query parameters: job_id, ticket (browsers cannot set headers on a WebSocket handshake)
+// Sends every line written so far, then new lines as they are written, then an "end" message
+// with the finished job. A queued job is waited for.
+func (h *JobHandler) StreamJobLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("job_id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.BadRequest(w, errors.New("invalid job ID"))
+		return
+	}
+	// a ticket only opens the stream of the job it was issued for
+	if user, ok := middlewares.UserFromContext(r.Context()); ok && user.Purpose != "" &&
+		(user.Purpose != models.TokenPurposeJobLog || user.Target != strconv.FormatInt(id, 10)) {
+		utils.WriteJSON(w, http.StatusForbidden, models.Response{Error: true, Message: "Forbidden: the ticket was issued for another job"})
+		return
+	}
+	if _, err := h.DB.Job.GetJob(r.Context(), id); err != nil {
+		if errors.Is(err, dbrepo.ErrJobNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
+		h.errorLog.Println("ERROR_01_StreamJobLog:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch job: %w", err))
+		return
+	}
+
+	conn, err := jobLogUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.errorLog.Println("ERROR_02_StreamJobLog:", err)
+		return // the upgrader has already replied
+	}
+	defer conn.Close()
+
+	// the client only ever closes; reading notices that
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msg jobLogMessage) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(msg)
+	}
+
+	logID := jobs.LogID(id)
+	lastSeq := 0
+	for {
+		l, running := h.Jobs.Logs().Active(logID)
+
+		// subscribe before reading the file so no line falls in between
+		var lines <-chan oplog.Line
+		unsubscribe := func() {}
+		if running {
+			lines, unsubscribe = l.Subscribe()
+		}
+
+		stored, err := h.Jobs.Logs().Read(logID, lastSeq)
+		if err != nil && !errors.Is(err, oplog.ErrNotFound) {
+			unsubscribe()
+			h.errorLog.Println("ERROR_03_StreamJobLog:", err)
+			return
+		}
+		for i := range stored {
+			if send(jobLogMessage{Type: "line", Line: &stored[i]}) != nil {
+				unsubscribe()
+				return
+			}
+			lastSeq = stored[i].Seq
+		}
+
+		if running {
+			// the channel closes when the job ends or when this client falls behind
+			if !h.forwardLines(ctx, lines, &lastSeq, send) {
+				unsubscribe()
+				return
+			}
+			unsubscribe()
+			continue // catch up from the file, then finish or resubscribe
+		}
+
+		job, err := h.DB.Job.GetJob(ctx, id)
+		if err != nil {
+			h.errorLog.Println("ERROR_04_StreamJobLog:", err)
+			return
+		}
+		if job.Status != models.JobStatusQueued && job.Status != models.JobStatusRunning {
+			_ = send(jobLogMessage{Type: "end", Job: job})
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		}
+
+		// queued, or about to start on a worker
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobLogPollInterval):
+		}
+	}
+}
+
+// forwardLines sends lines newer than lastSeq until the channel closes.
+// It returns false when the client has gone away.
+func (h *JobHandler) forwardLines(ctx context.Context, lines <-chan oplog.Line, lastSeq *int, send func(jobLogMessage) error) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case line, ok := <-lines:
+			if !ok {
+				return true
+			}
+			if line.Seq <= *lastSeq {
+				continue
+			}
+			if send(jobLogMessage{Type: "line", Line: &line}) != nil {
+				return false
+			}
+			*lastSeq = line.Seq
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+type dryRunReply struct {
+	Error     bool          `json:"error"`
+	Message   string        `json:"message"`
+	DryRun    bool          `json:"dryRun"`
+	Steps     []syscmd.Step `json:"steps"`
+	PlanError string        `json:"planError"`
+}
+
+func TestWriteDryRun(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/project/php/deploy?dry_run=true", nil)
+	if !isDryRun(r) {
+		t.Fatal("dry_run=true is not a dry run")
+	}
+
+	w := httptest.NewRecorder()
+	writeDryRun(w, r, func(ctx context.Context) error {
+		// queries run for real and are left out of the plan
+		if _, err := syscmd.Query(ctx, "true"); err != nil {
+			return err
+		}
+		if err := syscmd.Run(ctx, "touch", marker); err != nil {
+			return err
+		}
+		return syscmd.WriteFile(ctx, "/etc/nginx/sites-available/example.com.conf", []byte("server {}\n"))
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	var reply dryRunReply
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error || !reply.DryRun || reply.PlanError != "" {
+		t.Errorf("reply = %+v, want a complete dry run", reply)
+	}
+	if len(reply.Steps) != 2 {
+		t.Fatalf("%d steps, want 2: %+v", len(reply.Steps), reply.Steps)
+	}
+	if s := reply.Steps[0]; s.Kind != syscmd.StepRun || !slices.Equal(s.Args, []string{"touch", marker}) {
+		t.Errorf("step 0 = %+v, want touch %s", s, marker)
+	}
+	if s := reply.Steps[1]; s.Kind != syscmd.StepWrite || s.Path != "/etc/nginx/sites-available/example.com.conf" || s.Content != "server {}\n" {
+		t.Errorf("step 1 = %+v, want the vhost write", s)
+	}
+	if _, err := os.Stat(marker); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the dry run ran touch: %v", err)
+	}
+}
+
+func TestWriteDryRunError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/project/php/deploy?dry_run=true", nil)
+	w := httptest.NewRecorder()
+	writeDryRun(w, r, func(ctx context.Context) error {
+		if err := syscmd.Run(ctx, "systemctl", "restart", "php8.3-fpm"); err != nil {
+			return err
+		}
+		return errors.New("no artisan")
+	})
+
+	var reply dryRunReply
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.PlanError != "no artisan" || len(reply.Steps) != 1 {
+		t.Errorf("reply = %+v, want the plan up to the failed step", reply)
+	}
+}
+
+func TestIsDryRun(t *testing.T) {
+	for query, want := range map[string]bool{"": false, "?dry_run=true": true, "?dry_run=1": true, "?dry_run=false": false, "?dry_run=yes": false} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/project/php/deploy"+query, nil)
+		if got := isDryRun(r); got != want {
+			t.Errorf("isDryRun(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+type noRevocations struct{}
+
+func (noRevocations) IsAccessTokenRevoked(context.Context, string, string) (bool, error) {
+	return false, nil
+}
+
+func TestStreamJobLogTicketForOtherJob(t *testing.T) {
+	cfg := models.JWTConfig{SecretKey: "test-secret", Algorithm: "HS256", Expiry: time.Minute}
+	ticket, err := utils.GenerateJWT(models.JWT{
+		ID:        1,
+		Role:      "viewer",
+		TokenID:   "t1",
+		SessionID: "session",
+		Purpose:   models.TokenPurposeJobLog,
+		Target:    "42",
+	}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discard := log.New(io.Discard, "", 0)
+	auth := middlewares.NewAuth(cfg, models.SecurityConfig{}, noRevocations{}, nil, discard)
+	h := newJobHandler(nil, nil, cfg, discard, discard)
+	stream := auth.AuthTicket(models.TokenPurposeJobLog)(http.HandlerFunc(h.StreamJobLog))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/log/stream?job_id=7&ticket="+ticket, nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	stream.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// ==================== Unlock User ====================
+// Lifts a sign-in lockout before it expires (query parameter: user_id)
+func (h *AuthHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.BadRequest(w, errors.New("invalid user ID"))
+		return
+	}
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.NotFound(w, "user not found")
+		return
+	}
+
+	if err := h.DB.Login.UnlockUser(r.Context(), userID); err != nil {
+		h.errorLog.Println("ERROR_01_UnlockUser:", err)
+		utils.ServerError(w, fmt.Errorf("failed to unlock user: %w", err))
+		return
+	}
+	// users sign in with either their email or their mobile
+	h.guard.Clear(user.Email, user.Mobile)
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: "User unlocked successfully",
+	})
+}
+
+// ==================== List Login Attempts ====================
+// query parameters: page, limit, username, ip, user_id, success (true|false)
+func (h *AuthHandler) ListLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	userID, _ := strconv.ParseInt(q.Get("user_id"), 10, 64)
+
+	var success *bool
+	if v := q.Get("success"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.BadRequest(w, errors.New("success must be true or false"))
+			return
+		}
+		success = &b
+	}
+
+	attempts, total, err := h.DB.Login.PaginatedAttempts(r.Context(), page, limit, q.Get("username"), q.Get("ip"), userID, success)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ListLoginAttempts:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch login attempts: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error    bool                   `json:"error"`
+		Message  string                 `json:"message"`
+		Page     int                    `json:"page"`
+		Limit    int                    `json:"limit"`
+		Total    int                    `json:"total"`
+		Attempts []*models.LoginAttempt `json:"attempts"`
+	}{
+		Error:    false,
+		Message:  "Login attempts fetched successfully",
+		Page:     page,
+		Limit:    limit,
+		Total:    total,
+		Attempts: attempts,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Helpers ====================
+
+// signinFailed records a failed attempt, feeds the throttle and, for a known user, the lockout counter.
+// It returns the lock expiry when this failure locked the account.
+func (h *AuthHandler) signinFailed(r *http.Request, username string, user *models.User, ip, reason string) *time.Time {
+	h.guard.Fail(username, ip)
+
+	var userID *int64
+	var lockedUntil *time.Time
+	if user != nil {
+		userID = &user.ID
+		var err error
+		lockedUntil, err = h.DB.Login.RegisterFailure(r.Context(), user.ID, h.Security.MaxLoginFailures, h.Security.LockoutDuration)
+		if err != nil {
+			h.errorLog.Println("ERROR_01_signinFailed: failed to update lockout counter:", err)
+		}
+		if lockedUntil != nil {
+			h.infoLog.Printf("account %d locked until %s after repeated sign-in failures from %s", user.ID, lockedUntil.Format(time.RFC3339), ip)
+		}
+	}
+
+	h.recordAttempt(r, username, userID, ip, false, reason)
+	return lockedUntil
+}
+
+// signinSucceeded clears the failure counters once the user is fully signed in
+func (h *AuthHandler) signinSucceeded(r *http.Request, username string, user *models.User, ip string) {
+	h.guard.Succeed(username)
+	if err := h.DB.Login.ResetFailures(r.Context(), user.ID); err != nil {
+		h.errorLog.Println("ERROR_01_signinSucceeded: failed to reset lockout counter:", err)
+	}
+	h.recordAttempt(r, username, &user.ID, ip, true, "")
+}
+
+// recordAttempt writes to the sign-in log; a logging failure never blocks the sign-in itself
+func (h *AuthHandler) recordAttempt(r *http.Request, username string, userID *int64, ip string, success bool, reason string) {
+	err := h.DB.Login.RecordAttempt(r.Context(), &models.LoginAttempt{
+		Username:  truncate(username, 255),
+		UserID:    userID,
+		IPAddress: ip,
+		UserAgent: truncate(r.UserAgent(), 512),
+		Success:   success,
+		Reason:    reason,
+	})
+	if err != nil {
+		h.errorLog.Println("ERROR_01_recordAttempt:", err)
+	}
+}
+
+// tooManyAttempts answers 429 with a Retry-After header in whole seconds
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration, message string) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	headers := http.Header{}
+	headers.Set("Retry-After", strconv.Itoa(retryAfter))
+
+	resp := struct {
+		Error      bool   `json:"error"`
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retryAfter"` // seconds
+	}{
+		Error:      true,
+		Message:    message,
+		RetryAfter: retryAfter,
+	}
+	utils.WriteJSON(w, http.StatusTooManyRequests, resp, headers)
+}
+
+// truncate cuts s to at most n bytes without leaving a partial UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/services/drift"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+type NginxHandler struct {
+	DB       *dbrepo.DBRepository
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func newNginxHandler(db *dbrepo.DBRepository, infoLog, errorLog *log.Logger) NginxHandler {
+	return NginxHandler{
+		DB:       db,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+// ==================== Drift Report ====================
+// Scans sites-enabled and reports orphaned configs, projects missing a config,
+// broken symlinks and server_name collisions
+func (h *NginxHandler) DriftReport(w http.ResponseWriter, r *http.Request) {
+	configs, err := nginx.ScanEnabled()
+	if err != nil {
+		h.errorLog.Println("ERROR_01_DriftReport:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	projects, err := h.DB.ProjectRepo.ListProjects(r.Context())
+	if err != nil {
+		h.errorLog.Println("ERROR_02_DriftReport:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	domains, err := h.DB.Domain.ListDomains(r.Context())
+	if err != nil {
+		h.errorLog.Println("ERROR_03_DriftReport:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	resp := struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Report  *drift.Report `json:"report"`
+	}{
+		Error:   false,
+		Message: "Nginx drift report generated",
+		Report:  drift.Build(layout.SitesEnabled(""), configs, projects, domains),
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Get Site Settings ====================
+// query parameter: project_id
+func (h *NginxHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+
+	settings, err := h.DB.NginxSettings.GetProjectNginxSettings(r.Context(), project.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_GetSettings:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	resp := struct {
+		Error    bool                         `json:"error"`
+		Message  string                       `json:"message"`
+		Settings *models.ProjectNginxSettings `json:"settings"`
+	}{
+		Error:    false,
+		Message:  "Nginx settings fetched successfully",
+		Settings: settings,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Save Site Settings ====================
+// Validates the custom rules, checks nginx accepts them (nginx -t) and saves them.
+// They are rendered into the vhost on the next deploy.
+// query parameter: project_id
+// request body: {redirects: [{from, to, code, regex}], clientMaxBodySize, snippet}
+func (h *NginxHandler) SaveSettings(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+
+	var settings models.ProjectNginxSettings
+	if err := utils.ReadJSON(w, r, &settings); err != nil {
+		h.errorLog.Println("ERROR_01_SaveSettings: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+	settings.ProjectID = project.ID
+	settings.ClientMaxBodySize = strings.TrimSpace(settings.ClientMaxBodySize)
+	for i := range settings.Redirects {
+		settings.Redirects[i].From = strings.TrimSpace(settings.Redirects[i].From)
+		settings.Redirects[i].To = strings.TrimSpace(settings.Redirects[i].To)
+		if settings.Redirects[i].Code == 0 {
+			settings.Redirects[i].Code = 301
+		}
+	}
+
+	if err := nginx.ValidateSettings(&settings); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	// nginx must accept the rules before they are saved, or the next deploy would fail
+	site := nginx.TestSite(&settings)
+	if err := site.Validate(); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	if err := syscmd.Privileged(r.Context(), &broker.TestNginxVhost{Site: site}); err != nil {
+		h.errorLog.Println("ERROR_02_SaveSettings:", err)
+		writeSiteError(w, fmt.Errorf("nginx rejected the settings: %w", err))
+		return
+	}
+
+	if err := h.DB.NginxSettings.SaveProjectNginxSettings(r.Context(), &settings); err != nil {
+		h.errorLog.Println("ERROR_03_SaveSettings:", err)
+		utils.ServerError(w, fmt.Errorf("failed to save nginx settings: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error    bool                         `json:"error"`
+		Message  string                       `json:"message"`
+		Settings *models.ProjectNginxSettings `json:"settings"`
+	}{
+		Error:    false,
+		Message:  "Nginx settings saved; they take effect on the next deploy",
+		Settings: &settings,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// project returns the project of the project_id query parameter, replying with an error
+// when it is missing or unknown
+func (h *NginxHandler) project(w http.ResponseWriter, r *http.Request) (*models.Project, bool) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("project_id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.BadRequest(w, errors.New("invalid project ID"))
+		return nil, false
+	}
+	project, err := h.DB.ProjectRepo.GetProjectByID(r.Context(), id)
+	if err != nil {
+		utils.NotFound(w, "project not found")
+		return nil, false
+	}
+	return project, true
+}
+
+// ==================== Get Site Access ====================
+// query parameter: project_id
+func (h *NginxHandler) GetAccess(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := h.DB.NginxSettings.ListAccessRules(r.Context(), project.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_GetAccess:", err)
+		utils.ServerError(w, err)
+		return
+	}
+	users, err := h.DB.NginxSettings.ListAccessUsers(r.Context(), project.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_02_GetAccess:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	resp := struct {
+		Error   bool                `json:"error"`
+		Message string              `json:"message"`
+		Rules   []models.AccessRule `json:"rules"`
+		Users   []models.AccessUser `json:"users"`
+	}{
+		Error:   false,
+		Message: "Access rules fetched successfully",
+		Rules:   rules,
+		Users:   users,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Save Access Rule ====================
+// Creates the access rule of a path or replaces the one it has. Checked with nginx -t
+// before it is saved; rendered into the vhost on the next deploy.
+// query parameter: project_id
+// request body: {path, basicAuth, allow, deny, satisfyAny}
+func (h *NginxHandler) SaveAccessRule(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+
+	var rule models.AccessRule
+	if err := utils.ReadJSON(w, r, &rule); err != nil {
+		h.errorLog.Println("ERROR_01_SaveAccessRule: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+	rule.ProjectID = project.ID
+	rule.Path = strings.TrimSpace(rule.Path)
+	if rule.Path == "" {
+		rule.Path = "/"
+	}
+	for i := range rule.Allow {
+		rule.Allow[i] = strings.TrimSpace(rule.Allow[i])
+	}
+	for i := range rule.Deny {
+		rule.Deny[i] = strings.TrimSpace(rule.Deny[i])
+	}
+	if err := nginx.ValidateAccessRule(rule); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	// nginx must accept the site's rules with this one, or the next deploy would fail
+	settings, err := h.DB.NginxSettings.GetProjectNginxSettings(r.Context(), project.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_02_SaveAccessRule:", err)
+		utils.ServerError(w, err)
+		return
+	}
+	access := []models.AccessRule{rule}
+	for _, a := range settings.Access {
+		if a.Path != rule.Path {
+			access = append(access, a)
+		}
+	}
+	settings.Access = access
+	site := nginx.TestSite(settings)
+	if err := site.Validate(); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	if err := syscmd.Privileged(r.Context(), &broker.TestNginxVhost{Site: site}); err != nil {
+		h.errorLog.Println("ERROR_03_SaveAccessRule:", err)
+		writeSiteError(w, fmt.Errorf("nginx rejected the access rule: %w", err))
+		return
+	}
+
+	if err := h.DB.NginxSettings.SaveAccessRule(r.Context(), &rule); err != nil {
+		h.errorLog.Println("ERROR_04_SaveAccessRule:", err)
+		utils.ServerError(w, fmt.Errorf("failed to save access rule: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool               `json:"error"`
+		Message string             `json:"message"`
+		Rule    *models.AccessRule `json:"rule"`
+	}{
+		Error:   false,
+		Message: "Access rule saved; it takes effect on the next deploy",
+		Rule:    &rule,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Delete Access Rule ====================
+// query parameter: project_id, rule_id
+func (h *NginxHandler) DeleteAccessRule(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+	ruleID, err := strconv.ParseInt(r.URL.Query().Get("rule_id"), 10, 64)
+	if err != nil || ruleID <= 0 {
+		utils.BadRequest(w, errors.New("invalid rule ID"))
+		return
+	}
+
+	if err := h.DB.NginxSettings.DeleteAccessRule(r.Context(), project.ID, ruleID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.NotFound(w, "access rule not found")
+			return
+		}
+		h.errorLog.Println("ERROR_01_DeleteAccessRule:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	resp := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Access rule deleted; it is lifted on the next deploy",
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Save Access User ====================
+// Creates a basic auth user or sets its password. The htpasswd file is rewritten at
+// once and nginx reads it on every request, so no deploy is needed.
+// query parameter: project_id
+// request body: {username, password}
+func (h *NginxHandler) SaveAccessUser(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		h.errorLog.Println("ERROR_01_SaveAccessUser: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+	if len(req.Password) < 8 {
+		utils.BadRequest(w, errors.New("password must be at least 8 characters"))
+		return
+	}
+	hash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		h.errorLog.Println("ERROR_02_SaveAccessUser:", err)
+		utils.ServerError(w, err)
+		return
+	}
+	user := models.AccessUser{ProjectID: project.ID, Username: strings.TrimSpace(req.Username), PasswordHash: hash}
+	if err := nginx.ValidateAccessUser(user); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	if err := h.DB.NginxSettings.SaveAccessUser(r.Context(), &user); err != nil {
+		h.errorLog.Println("ERROR_03_SaveAccessUser:", err)
+		utils.ServerError(w, fmt.Errorf("failed to save access user: %w", err))
+		return
+	}
+	if err := h.writeHtpasswd(r, project); err != nil {
+		h.errorLog.Println("ERROR_04_SaveAccessUser:", err)
+		utils.ServerError(w, fmt.Errorf("user saved but the htpasswd file was not written: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool               `json:"error"`
+		Message string             `json:"message"`
+		User    *models.AccessUser `json:"user"`
+	}{
+		Error:   false,
+		Message: "Access user saved",
+		User:    &user,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Delete Access User ====================
+// query parameter: project_id, username
+func (h *NginxHandler) DeleteAccessUser(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	if username == "" {
+		utils.BadRequest(w, errors.New("username is required"))
+		return
+	}
+
+	if err := h.DB.NginxSettings.DeleteAccessUser(r.Context(), project.ID, username); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.NotFound(w, "access user not found")
+			return
+		}
+		h.errorLog.Println("ERROR_01_DeleteAccessUser:", err)
+		utils.ServerError(w, err)
+		return
+	}
+	if err := h.writeHtpasswd(r, project); err != nil {
+		h.errorLog.Println("ERROR_02_DeleteAccessUser:", err)
+		utils.ServerError(w, fmt.Errorf("user deleted but the htpasswd file was not written: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Access user deleted",
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// writeHtpasswd rewrites the htpasswd file of project from its access users
+func (h *NginxHandler) writeHtpasswd(r *http.Request, project *models.Project) error {
+	users, err := h.DB.NginxSettings.ListAccessUsers(r.Context(), project.ID)
+	if err != nil {
+		return err
+	}
+	content, err := nginx.Htpasswd(users)
+	if err != nil {
+		return err
+	}
+	return syscmd.Privileged(r.Context(), &broker.WriteHtpasswd{
+		Domain:  strings.ToLower(project.DomainName),
+		Content: string(content),
+	})
+}
+
+// ==================== Get Maintenance Mode ====================
+// query parameter: project_id
+func (h *NginxHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+
+	maintenance, err := h.DB.NginxSettings.GetProjectMaintenance(r.Context(), project.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_GetMaintenance:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	resp := struct {
+		Error       bool                       `json:"error"`
+		Message     string                     `json:"message"`
+		Maintenance *models.ProjectMaintenance `json:"maintenance"`
+	}{
+		Error:       false,
+		Message:     "Maintenance mode fetched successfully",
+		Maintenance: maintenance,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Set Maintenance Mode ====================
+// Switches maintenance mode on or off at once. While it is on the site answers 503 with
+// Retry-After and the maintenance page, except to the allowed addresses; unlike a
+// suspension the site keeps running behind it.
+// query parameter: project_id
+// request body: {enabled, retryAfter, pageHtml, allowIps}
+func (h *NginxHandler) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.project(w, r)
+	if !ok {
+		return
+	}
+
+	var m models.ProjectMaintenance
+	if err := utils.ReadJSON(w, r, &m); err != nil {
+		h.errorLog.Println("ERROR_01_SetMaintenance: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+	m.ProjectID = project.ID
+	if m.RetryAfter == 0 {
+		m.RetryAfter = 3600
+	}
+	for i := range m.AllowIPs {
+		m.AllowIPs[i] = strings.TrimSpace(m.AllowIPs[i])
+	}
+	domain := strings.ToLower(project.DomainName)
+	if err := (nginx.Maintenance{Domain: domain, RetryAfter: m.RetryAfter, AllowIPs: m.AllowIPs}).Validate(); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	op := &broker.SetMaintenance{Domain: domain, Enabled: m.Enabled}
+	if m.Enabled {
+		// vhosts rendered before maintenance mode existed do not include the snippet
+		if conf, err := os.ReadFile(layout.SitesAvailable(nginx.ConfName(domain))); err == nil && !nginx.HasMaintenanceHook(conf, domain) {
+			utils.BadRequest(w, errors.New("the site's nginx config predates maintenance mode; redeploy the site first"))
+			return
+		}
+		page := []byte(m.PageHTML)
+		if strings.TrimSpace(m.PageHTML) == "" {
+			var err error
+			if page, err = nginx.DefaultMaintenancePage(domain); err != nil {
+				h.errorLog.Println("ERROR_02_SetMaintenance:", err)
+				utils.ServerError(w, err)
+				return
+			}
+		}
+		op.RetryAfter = m.RetryAfter
+		op.AllowIPs = m.AllowIPs
+		op.Page = string(page)
+	}
+	if err := syscmd.Privileged(r.Context(), op); err != nil {
+		h.errorLog.Println("ERROR_03_SetMaintenance:", err)
+		writeSiteError(w, fmt.Errorf("failed to set maintenance mode: %w", err))
+		return
+	}
+
+	if err := h.DB.NginxSettings.SaveProjectMaintenance(r.Context(), &m); err != nil {
+		h.errorLog.Println("ERROR_04_SetMaintenance:", err)
+		utils.ServerError(w, fmt.Errorf("maintenance mode was set but not saved: %w", err))
+		return
+	}
+
+	message := "Maintenance mode switched off"
+	if m.Enabled {
+		message = "Maintenance mode switched on"
+	}
+	resp := struct {
+		Error       bool                       `json:"error"`
+		Message     string                     `json:"message"`
+		Maintenance *models.ProjectMaintenance `json:"maintenance"`
+	}{
+		Error:       false,
+		Message:     message,
+		Maintenance: &m,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
@@ -17,19 +17,21 @@ import (
 	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
 	"github.com/projuktisheba/vpanel/backend/internal/deploy"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
-	user "github.com/projuktisheba/vpanel/backend/internal/pkg/sysuser"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
 	"github.com/projuktisheba/vpanel/backend/internal/utils"
 )
 
 type PHPHandler struct {
 	DB       *dbrepo.DBRepository
+	Jobs     *jobs.Queue
 	infoLog  *log.Logger
 	errorLog *log.Logger
 }
 
-func newPHPHandler(db *dbrepo.DBRepository, infoLog, errorLog *log.Logger) PHPHandler {
+func newPHPHandler(db *dbrepo.DBRepository, queue *jobs.Queue, infoLog, errorLog *log.Logger) PHPHandler {
 	return PHPHandler{
 		DB:       db,
+		Jobs:     queue,
 		infoLog:  infoLog,
 		errorLog: errorLog,
 	}
@@ -50,11 +52,23 @@ func (h *PHPHandler) InitProject(w http.ResponseWriter, r *http.Request) {
 		utils.BadRequest(w, errors.New("domainName is missing"))
 		return
 	}
-	//database name
+	//framework, optional until the project is deployed
+	req.ProjectFramework = strings.TrimSpace(req.ProjectFramework)
+	if req.ProjectFramework != "" {
+		if _, err := deploy.For(req.ProjectFramework); err != nil {
+			utils.BadRequest(w, err)
+			return
+		}
+	}
+	//database name, optional for static sites and applications (a Python one may use SQLite)
 	req.DBName = strings.TrimSpace(req.DBName)
-	if req.DBName == "" {
-		utils.BadRequest(w, errors.New("dbName is missing"))
-		return
+	switch req.ProjectFramework {
+	case deploy.FrameworkStatic, deploy.FrameworkApp, deploy.FrameworkPython:
+	default:
+		if req.DBName == "" {
+			utils.BadRequest(w, errors.New("dbName is missing"))
+			return
+		}
 	}
 
 	// Generate new project object
@@ -63,6 +77,7 @@ func (h *PHPHandler) InitProject(w http.ResponseWriter, r *http.Request) {
 	projectData.ProjectName = utils.GetPHPProjectName(req.DomainName)
 	projectData.DomainName = req.DomainName
 	projectData.DBName = req.DBName
+	projectData.ProjectFramework = req.ProjectFramework
 	projectData.TemplatePath = ""
 	projectData.ProjectDirectory = utils.GetPHPProjectDirectory(req.DomainName)
 	projectData.Status = models.ProjectStatusInit
@@ -132,18 +147,23 @@ func (h *PHPHandler) UploadProjectFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Read projectName
-	projectName := r.FormValue("projectName")
-	if projectName == "" {
-		utils.BadRequest(w, fmt.Errorf("projectName is required"))
-		return
-	}
-	// Read projectName
+	// Read projectID: the directory and the lock of the site come from the stored project
 	projectIDStr := r.FormValue("projectID")
 	projectID, err := strconv.Atoi(projectIDStr)
 	if err != nil || projectID == 0 {
 		utils.BadRequest(w, fmt.Errorf("Invalid project id"))
 		return
 	}
+	project, err := h.DB.ProjectRepo.GetProjectByID(r.Context(), int64(projectID))
+	if err != nil {
+		h.errorLog.Println("ERROR_09_UploadProjectFolder:", err)
+		utils.NotFound(w, "Project not found")
+		return
+	}
+	if project.ProjectDirectory == "" {
+		utils.BadRequest(w, fmt.Errorf("project %d has no project directory", projectID))
+		return
+	}
 
 	// Read filename and extract extension
 	originalFilename := r.FormValue("filename")
@@ -181,7 +201,7 @@ func (h *PHPHandler) UploadProjectFile(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Directory to save the final project after rebuild
-	projectDir := utils.GetPHPProjectDirectory(projectName)
+	projectDir := project.ProjectDirectory
 
 	// Temporary directory for chunks
 	tmpDir := filepath.Join(projectDir, "tmp_chunks")
@@ -208,10 +228,17 @@ func (h *PHPHandler) UploadProjectFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ==================== Merge on last chunk ====================
-	// Final zip path = projectDir/projectName + extension
-	finalZipPath := filepath.Join(projectDir, projectName+extension)
+	// Final zip path = projectDir/domain + extension
+	finalZipPath := filepath.Join(projectDir, siteKey(project)+extension)
 
 	if chunkIndex+1 == totalChunks {
+		// Unpacking replaces the project files, so nothing else may touch the site meanwhile
+		ctx, release, ok := lockSite(w, r, h.DB, h.Jobs, project, "upload")
+		if !ok {
+			return
+		}
+		defer release()
+
 		// Ensure project directory exists
 		if err := os.MkdirAll(projectDir, os.ModePerm); err != nil {
 			h.errorLog.Println("ERROR_05_UploadProjectFolder: failed to create project directory:", err)
@@ -236,7 +263,7 @@ func (h *PHPHandler) UploadProjectFile(w http.ResponseWriter, r *http.Request) {
 		os.RemoveAll(finalZipPath)
 
 		// set project status to file uploaded
-		if _, err := h.DB.ProjectRepo.UpdateProjectStatus(r.Context(), int64(projectID), models.ProjectStatusFileUploaded); err != nil {
+		if _, err := h.DB.ProjectRepo.UpdateProjectStatus(ctx, int64(projectID), models.ProjectStatusFileUploaded); err != nil {
 			h.errorLog.Println("ERROR_08_UploadProjectFolder: failed to update status:", err)
 			utils.ServerError(w, fmt.Errorf("failed to update status: %w", err))
 			return
@@ -264,47 +291,95 @@ func (h *PHPHandler) DeploySite(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	domainName := strings.TrimSpace(r.FormValue("projectName"))
-	if domainName == "" {
-		utils.BadRequest(w, fmt.Errorf("domainName is required"))
-		return
-	}
 	projectFramework := strings.TrimSpace(r.FormValue("projectFramework"))
 	if projectFramework == "" {
 		utils.BadRequest(w, fmt.Errorf("projectFramework is required"))
 		return
 	}
 
-	projectDir := utils.GetPHPProjectDirectory(domainName)
-	h.infoLog.Println("Project Dir: ", projectDir)
-
-	// Step 1: Deploy the PHP site (script execution)
-	if projectFramework == "Laravel" {
-		err = deploy.DeployLaravelSite(domainName, projectDir, user.GetCurrentUser().Username)
-	} else if projectFramework == "CodeIgniter" {
-		err = deploy.DeployCodeIgniterSite(r.Context(), projectDir, user.GetCurrentUser().Username, domainName)
+	deployer, err := deploy.For(projectFramework)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
 	}
 
+	project, err := h.DB.ProjectRepo.GetProjectByID(r.Context(), int64(projectID))
+	if err != nil {
+		h.errorLog.Println("ERROR_03_DeploySite:", err)
+		utils.NotFound(w, "Project not found")
+		return
+	}
+	project.ProjectFramework = projectFramework
+	settings, err := h.DB.NginxSettings.GetProjectNginxSettings(r.Context(), project.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_04_DeploySite:", err)
+		utils.ServerError(w, fmt.Errorf("failed to load the nginx settings of the project: %w", err))
+		return
+	}
+	target, err := projectTarget(r.Context(), h.DB, project, settings)
 	if err != nil {
+		h.errorLog.Println("ERROR_05_DeploySite:", err)
+		utils.ServerError(w, err)
+		return
+	}
+
+	// Reject a project that cannot be deployed (e.g. its files are missing) before queueing it
+	if err := deployer.Prepare(r.Context(), target); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	// ?dry_run=true: report what the deployment would do without changing the project
+	if isDryRun(r) {
+		// the plan is sent back with the files written: keep the database password out of it
+		if db := target.Database; db != nil && db.User != nil {
+			masked, dbUser := *db, *db.User
+			dbUser.Password = "********"
+			masked.User = &dbUser
+			target.Database = &masked
+		}
+		writeDryRun(w, r, func(ctx context.Context) error {
+			return deployer.Deploy(ctx, target)
+		})
+		return
+	}
+
+	// A site that is still being deployed or changed cannot be deployed again
+	if siteHasActiveJob(w, r, h.DB, project) {
+		return
+	}
+
+	// Step 1: Store the framework, the deployment job deploys the project with its deployer
+	project.Status = models.ProjectStatusDeploying
+	if err := h.DB.ProjectRepo.UpdateProject(r.Context(), project); err != nil {
 		h.errorLog.Println("ERROR_01_DeploySite:", err)
-		_, _ = h.DB.ProjectRepo.UpdateProjectStatus(context.Background(), int64(projectID), models.ProjectStatusError)
-		utils.BadRequest(w, fmt.Errorf("failed to deploy project status:%w", err))
+		utils.BadRequest(w, fmt.Errorf("failed to update project status:%w", err))
 		return
 	}
 
-	// Step 2: Update project status to running
-	if _, err := h.DB.ProjectRepo.UpdateProjectStatus(context.Background(), int64(projectID), models.ProjectStatusRunning); err != nil {
+	// Step 2: Queue the deployment; it runs on a background worker
+	payload := projectJobPayload{ProjectID: project.ID, Domain: project.DomainName, Framework: projectFramework}
+	job, err := h.Jobs.Enqueue(r.Context(), models.JobTypePHPDeploy, siteKey(project), payload, requesterID(r))
+	if err != nil {
 		h.errorLog.Println("ERROR_02_DeploySite:", err)
-		utils.BadRequest(w, fmt.Errorf("Warning: failed to update project status:%w", err))
+		_, _ = h.DB.ProjectRepo.UpdateProjectStatus(context.Background(), int64(projectID), models.ProjectStatusError)
+		if errors.Is(err, dbrepo.ErrJobTargetBusy) {
+			writeConflict(w, fmt.Sprintf("%s is busy: another job is already queued or running", siteKey(project)), 0)
+			return
+		}
+		utils.ServerError(w, fmt.Errorf("failed to queue deployment: %w", err))
 		return
 	}
+
 	// Respond immediately to client
 	resp := struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
+		JobID   int64  `json:"jobId"`
 	}{
 		Error:   false,
-		Message: "Deployment Completed",
+		Message: "Deployment queued",
+		JobID:   job.ID,
 	}
 	utils.WriteJSON(w, http.StatusAccepted, resp)
 }
@@ -340,44 +415,3 @@ func (h *PHPHandler) ListProjects(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteJSON(w, http.StatusOK, resp)
 }
-
-func (h *PHPHandler) DeleteSite(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("project_id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		utils.BadRequest(w, errors.New("invalid project ID"))
-		return
-	}
-	//Get the project info
-	var project *models.Project
-	project, err = h.DB.ProjectRepo.GetProjectByID(r.Context(), int64(id))
-	if err != nil {
-		h.errorLog.Println("ERROR_01_DeleteSite: failed to delete project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
-		return
-	}
-	//Only Wordpress site can be deleted
-	if project.ProjectFramework != "Wordpress" {
-		utils.ServerError(w, fmt.Errorf("Only Wordpress site can be deleted"))
-		return
-	}
-	//delete the project files and users
-	// if err := deploy.DeletePHPSite(r.Context(), project.ProjectDirectory, user.GetCurrentUser().Username, project.DomainName); err != nil {
-	// 	h.errorLog.Println("ERROR_02_DeleteSite: failed to delete project:", err)
-	// 	utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
-	// 	return
-	// }
-
-	// if err := h.DB.ProjectRepo.DeleteProject(r.Context(), id); err != nil {
-	// 	h.errorLog.Println("ERROR_01_DeleteSite: failed to delete project:", err)
-	// 	utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
-	// 	return
-	// }
-
-	resp := models.Response{
-		Error:   false,
-		Message: "PHP Project deleted successfully",
-	}
-
-	utils.WriteJSON(w, http.StatusOK, resp)
-}
@@ -6,7 +6,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -235,10 +234,9 @@ func (h *PostgreSQLManagerHandler) DeletePostgreSQLDatabase(w http.ResponseWrite
 	// 3 delete backup sql file
 	// ----------------------------------------
 	// Create path to save SQL file
-	homeDir, _ := os.UserHomeDir()
-	sqlPath := filepath.Join(homeDir, "projuktisheba", "templates", "databases", "postgresql", fmt.Sprintf("%s.sql", registryDB.DBName))
-	// Soft delete (ignore errors)
-	_ = exec.Command("sudo", []string{"rm", sqlPath}...)
+	sqlPath := filepath.Join(utils.GetDatabaseTemplateDirectory("postgresql"), fmt.Sprintf("%s.sql", registryDB.DBName))
+	// Soft delete (ignore errors); the panel wrote the file, no root needed
+	_ = os.Remove(sqlPath)
 
 	// ----------------------------------------
 	// 4 Response
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/deploy"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// SiteHandler manages deployed sites whatever their framework, through the framework's
+// deploy.Deployer
+type SiteHandler struct {
+	DB       *dbrepo.DBRepository
+	Jobs     *jobs.Queue
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func newSiteHandler(db *dbrepo.DBRepository, queue *jobs.Queue, infoLog, errorLog *log.Logger) SiteHandler {
+	return SiteHandler{
+		DB:       db,
+		Jobs:     queue,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+// ==================== Frameworks ====================
+// Lists the frameworks that can be deployed
+func (h *SiteHandler) ListFrameworks(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		Error      bool     `json:"error"`
+		Message    string   `json:"message"`
+		Frameworks []string `json:"frameworks"`
+	}{
+		Error:      false,
+		Message:    "Frameworks fetched successfully",
+		Frameworks: deploy.Frameworks(),
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Site Status ====================
+// query parameter: project_id
+func (h *SiteHandler) GetSiteStatus(w http.ResponseWriter, r *http.Request) {
+	project, deployer, ok := h.projectDeployer(w, r, "GetSiteStatus")
+	if !ok {
+		return
+	}
+
+	target, err := projectTarget(r.Context(), h.DB, project, nil)
+	if err != nil {
+		h.errorLog.Println("ERROR_02_GetSiteStatus:", err)
+		utils.ServerError(w, err)
+		return
+	}
+	site, err := deployer.Status(r.Context(), target)
+	if err != nil {
+		h.errorLog.Println("ERROR_03_GetSiteStatus: failed to inspect site:", err)
+		utils.ServerError(w, fmt.Errorf("failed to inspect site: %w", err))
+		return
+	}
+
+	var resp struct {
+		Error      bool               `json:"error"`
+		Message    string             `json:"message"`
+		SiteStatus string             `json:"siteStatus"`
+		Site       *deploy.SiteStatus `json:"site"`
+	}
+	resp.Error = false
+	resp.Message = "Site status: " + project.Status
+	resp.SiteStatus = project.Status
+	resp.Site = site
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Suspend Site ====================
+// query parameter: project_id
+func (h *SiteHandler) SuspendSite(w http.ResponseWriter, r *http.Request) {
+	project, deployer, ok := h.projectDeployer(w, r, "SuspendSite")
+	if !ok {
+		return
+	}
+	//Only running site can be suspended
+	if project.Status != models.ProjectStatusRunning {
+		utils.BadRequest(w, errors.New("Only running site can be suspended"))
+		return
+	}
+
+	ctx, release, ok := lockSite(w, r, h.DB, h.Jobs, project, "suspend")
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := deployer.Suspend(ctx, deploy.TargetFor(project, nil)); err != nil {
+		h.errorLog.Println("ERROR_02_SuspendSite: failed to suspend project:", err)
+		writeSiteError(w, fmt.Errorf("failed to suspend project: %w", err))
+		return
+	}
+
+	//update status to suspended
+	if _, err := h.DB.ProjectRepo.UpdateProjectStatus(ctx, project.ID, models.ProjectStatusSuspended); err != nil {
+		h.errorLog.Println("ERROR_03_SuspendSite: failed to suspend project:", err)
+		utils.ServerError(w, fmt.Errorf("failed to suspend project: %w", err))
+		return
+	}
+
+	resp := models.Response{
+		Error:   false,
+		Message: "Project suspended successfully",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Restart Site ====================
+// Resumes a suspended site
+// query parameter: project_id
+func (h *SiteHandler) RestartSite(w http.ResponseWriter, r *http.Request) {
+	project, deployer, ok := h.projectDeployer(w, r, "RestartSite")
+	if !ok {
+		return
+	}
+	//Only suspended site can be restarted
+	if project.Status != models.ProjectStatusSuspended {
+		utils.BadRequest(w, errors.New("Only suspended project can be restarted"))
+		return
+	}
+
+	ctx, release, ok := lockSite(w, r, h.DB, h.Jobs, project, "restart")
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := deployer.Resume(ctx, deploy.TargetFor(project, nil)); err != nil {
+		h.errorLog.Println("ERROR_02_RestartSite: failed to restart project:", err)
+		writeSiteError(w, fmt.Errorf("failed to restart project: %w", err))
+		return
+	}
+
+	//update status to running
+	if _, err := h.DB.ProjectRepo.UpdateProjectStatus(ctx, project.ID, models.ProjectStatusRunning); err != nil {
+		h.errorLog.Println("ERROR_03_RestartSite: failed to restart project:", err)
+		utils.ServerError(w, fmt.Errorf("failed to restart project: %w", err))
+		return
+	}
+
+	resp := models.Response{
+		Error:   false,
+		Message: "Project restarted successfully",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Delete Site ====================
+// query parameter: project_id
+func (h *SiteHandler) DeleteSite(w http.ResponseWriter, r *http.Request) {
+	project, deployer, ok := h.projectDeployer(w, r, "DeleteSite")
+	if !ok {
+		return
+	}
+
+	ctx, release, ok := lockSite(w, r, h.DB, h.Jobs, project, "delete")
+	if !ok {
+		return
+	}
+	defer release()
+
+	//delete the project files and configuration
+	if err := deployer.Delete(ctx, deploy.TargetFor(project, nil)); err != nil {
+		h.errorLog.Println("ERROR_02_DeleteSite: failed to delete project:", err)
+		utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
+		return
+	}
+
+	if err := h.DB.ProjectRepo.DeleteProject(ctx, project.ID); err != nil {
+		h.errorLog.Println("ERROR_03_DeleteSite: failed to delete project:", err)
+		utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
+		return
+	}
+
+	resp := models.Response{
+		Error:   false,
+		Message: "Project deleted successfully",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// runDeploy is the deployment job of every framework (wordpress.deploy, php.deploy):
+// it runs the Deploy of the project's deployer
+func (h *SiteHandler) runDeploy(ctx context.Context, job *models.Job) (any, error) {
+	var payload projectJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("invalid job payload: %w", err)
+	}
+	project, err := h.DB.ProjectRepo.GetProjectByID(ctx, payload.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("project %d not found: %w", payload.ProjectID, err)
+	}
+	// jobs queued before the framework was stored on the project carry it
+	if project.ProjectFramework == "" {
+		project.ProjectFramework = payload.Framework
+	}
+	deployer, err := deploy.For(project.ProjectFramework)
+	if err != nil {
+		_, _ = h.DB.ProjectRepo.UpdateProjectStatus(ctx, project.ID, models.ProjectStatusError)
+		return nil, err
+	}
+
+	settings, err := h.DB.NginxSettings.GetProjectNginxSettings(ctx, project.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the nginx settings of the project: %w", err)
+	}
+
+	target, err := projectTarget(ctx, h.DB, project, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := deployer.Deploy(ctx, target); err != nil {
+		// ctx is already done when the job was cancelled; the status must be recorded anyway
+		_, _ = h.DB.ProjectRepo.UpdateProjectStatus(context.WithoutCancel(ctx), project.ID, models.ProjectStatusError)
+		return failureResult(err), fmt.Errorf("failed to deploy project: %w", err)
+	}
+
+	updatedAt, err := h.DB.ProjectRepo.UpdateProjectStatus(ctx, project.ID, models.ProjectStatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("site deployed but the project status could not be updated: %w", err)
+	}
+	project.Status = models.ProjectStatusRunning
+	project.UpdatedAt = updatedAt
+	return project, nil
+}
+
+// projectDeployer returns the project of the project_id query parameter and the deployer
+// of its framework, replying with an error when either cannot be found
+func (h *SiteHandler) projectDeployer(w http.ResponseWriter, r *http.Request, caller string) (*models.Project, deploy.Deployer, bool) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("project_id"), 10, 64)
+	if err != nil {
+		utils.BadRequest(w, errors.New("invalid project ID"))
+		return nil, nil, false
+	}
+	project, err := h.DB.ProjectRepo.GetProjectByID(r.Context(), id)
+	if err != nil {
+		h.errorLog.Printf("ERROR_01_%s: failed to get project information: %v", caller, err)
+		utils.NotFound(w, "Site information not found")
+		return nil, nil, false
+	}
+	deployer, err := deploy.For(project.ProjectFramework)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return nil, nil, false
+	}
+	return project, deployer, true
+}
+
+// projectTarget returns the deploy target of project with its database and application
+func projectTarget(ctx context.Context, db *dbrepo.DBRepository, project *models.Project, settings *models.ProjectNginxSettings) (deploy.Target, error) {
+	target := deploy.TargetFor(project, settings)
+	target.Database = projectDatabase(ctx, db, project)
+	app, err := db.Apps.GetProjectApp(ctx, project.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return target, fmt.Errorf("failed to load the application of the project: %w", err)
+	}
+	target.App = app
+	python, err := db.Apps.GetProjectPythonApp(ctx, project.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return target, fmt.Errorf("failed to load the Python application of the project: %w", err)
+	}
+	target.Python = python
+	return target, nil
+}
+
+// projectDatabase returns the registry entry of the project's database with its user, or
+// nil when the project has none
+func projectDatabase(ctx context.Context, db *dbrepo.DBRepository, project *models.Project) *models.Database {
+	if project.DBName == "" {
+		return nil
+	}
+	database, err := db.DBRegistry.GetDatabaseByName(ctx, project.DBName)
+	if err != nil {
+		oplog.Printf(ctx, "⚠ Database %s of the project not found in the registry: %v\n", project.DBName, err)
+		return nil
+	}
+	return &database
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// siteOperationTimeout bounds quick synchronous operations on a site (suspend, restart, delete)
+const siteOperationTimeout = 5 * time.Minute
+
+// dryRunTimeout bounds a dry run; only its read-only queries touch the host
+const dryRunTimeout = time.Minute
+
+// siteKey returns the key the jobs and the lock of the site of project go by: its stored
+// domain, lowercased, never a name the client sent
+func siteKey(project *models.Project) string {
+	return strings.ToLower(project.DomainName)
+}
+
+// lockSite prepares a quick synchronous operation on the site of project. It replies 409 and
+// returns ok=false while a job is queued or running for the site or another operation holds
+// its lock. The returned context is detached from the client connection, so a dropped
+// connection cannot abort the operation halfway; siteOperationTimeout bounds it instead.
+func lockSite(w http.ResponseWriter, r *http.Request, db *dbrepo.DBRepository, queue *jobs.Queue, project *models.Project, operation string) (ctx context.Context, release func(), ok bool) {
+	if siteHasActiveJob(w, r, db, project) {
+		return nil, nil, false
+	}
+
+	holder := operation
+	if claims, found := middlewares.UserFromContext(r.Context()); found {
+		holder = fmt.Sprintf("%s by %s", operation, claims.Username)
+	}
+	unlock, err := queue.Locks().TryLock(siteKey(project), holder)
+	if err != nil {
+		writeConflict(w, err.Error(), 0)
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), siteOperationTimeout)
+	return ctx, func() {
+		cancel()
+		unlock()
+	}, true
+}
+
+// siteHasActiveJob replies 409 and returns true when a job is queued or running for the
+// site of project
+func siteHasActiveJob(w http.ResponseWriter, r *http.Request, db *dbrepo.DBRepository, project *models.Project) bool {
+	domain := siteKey(project)
+	job, err := db.Job.GetActiveJobForTarget(r.Context(), domain)
+	if err != nil {
+		utils.ServerError(w, fmt.Errorf("failed to check running jobs: %w", err))
+		return true
+	}
+	if job == nil {
+		return false
+	}
+	writeConflict(w, fmt.Sprintf("%s is busy: job %d (%s) is %s", domain, job.ID, job.Type, job.Status), job.ID)
+	return true
+}
+
+// writeConflict replies 409 with the job that is in the way, if any
+func writeConflict(w http.ResponseWriter, message string, jobID int64) {
+	resp := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		JobID   int64  `json:"jobId,omitempty"`
+	}{
+		Error:   true,
+		Message: message,
+		JobID:   jobID,
+	}
+	utils.WriteJSON(w, http.StatusConflict, resp)
+}
+
+// writeSiteError replies to a failed site operation. When nginx rejected the new
+// configuration it replies 422 with the parsed error (file, line, message), otherwise 500.
+func writeSiteError(w http.ResponseWriter, err error) {
+	var te *nginx.TestError
+	if !errors.As(err, &te) {
+		utils.ServerError(w, err)
+		return
+	}
+	resp := struct {
+		Error   bool             `json:"error"`
+		Message string           `json:"message"`
+		Nginx   *nginx.TestError `json:"nginx"`
+	}{
+		Error:   true,
+		Message: err.Error(),
+		Nginx:   te,
+	}
+	utils.WriteJSON(w, http.StatusUnprocessableEntity, resp)
+}
+
+// failureResult is the result stored with a failed job: the parsed nginx error when
+// nginx rejected the configuration, so API callers get the file and line
+func failureResult(err error) any {
+	var te *nginx.TestError
+	if errors.As(err, &te) {
+		return map[string]any{"nginx": te}
+	}
+	return nil
+}
+
+// isDryRun reports whether the request asks for a dry run (?dry_run=true)
+func isDryRun(r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return dryRun
+}
+
+// writeDryRun runs fn against a dry-run executor and replies with the commands and file
+// writes it would have made. Nothing on the host changes; read-only queries (installed PHP
+// versions, existing configs) run for real so the plan follows what the host would see.
+// An error from fn is reported with the plan up to the step that failed.
+func writeDryRun(w http.ResponseWriter, r *http.Request, fn func(ctx context.Context) error) {
+	rec := syscmd.NewDryRun()
+	ctx, cancel := context.WithTimeout(syscmd.WithExecutor(r.Context(), rec), dryRunTimeout)
+	defer cancel()
+	err := fn(ctx)
+
+	resp := struct {
+		Error     bool          `json:"error"`
+		Message   string        `json:"message"`
+		DryRun    bool          `json:"dryRun"`
+		Steps     []syscmd.Step `json:"steps"`
+		PlanError string        `json:"planError,omitempty"`
+	}{
+		Error:   false,
+		Message: "Dry run: nothing was changed",
+		DryRun:  true,
+		Steps:   rec.Changes(),
+	}
+	if err != nil {
+		resp.Message = "Dry run stopped early: nothing was changed"
+		resp.PlanError = err.Error()
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
@@ -1,31 +1,45 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/projuktisheba/vpanel/backend/internal/config"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
 	"github.com/projuktisheba/vpanel/backend/internal/pkg/ssl"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
 	"github.com/projuktisheba/vpanel/backend/internal/utils"
 )
 
 type SSLHandler struct {
+	Jobs     *jobs.Queue
 	infoLog  *log.Logger
 	errorLog *log.Logger
 }
 
-func newSSLHandler(infoLog, errorLog *log.Logger) SSLHandler {
+func newSSLHandler(queue *jobs.Queue, infoLog, errorLog *log.Logger) SSLHandler {
 	return SSLHandler{
+		Jobs:     queue,
 		infoLog:  infoLog,
 		errorLog: errorLog,
 	}
 }
 
 func (h *SSLHandler) CheckSSL(w http.ResponseWriter, r *http.Request) {
-	domain := r.URL.Query().Get("domain")
+	domain := strings.TrimSpace(r.URL.Query().Get("domain"))
 
 	// checking the ssl certificate
-	hasSSL := ssl.CheckSSL(domain)
+	hasSSL, err := ssl.CheckSSL(r.Context(), domain)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
 
 	// ======== Build Response ========
 	var response struct {
@@ -40,15 +54,13 @@ func (h *SSLHandler) CheckSSL(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *SSLHandler) CheckAndIssueSSL(w http.ResponseWriter, r *http.Request) {
-	domain := r.URL.Query().Get("domain")
+	domain := strings.TrimSpace(r.URL.Query().Get("domain"))
 
 	// checking the ssl certificate
-	hasSSL := ssl.CheckSSL(domain)
-
-	var err error
-	if !hasSSL {
-		// setup ssl certificate
-		err = ssl.SetupSSL(r.Context(), domain, config.Email, true)
+	hasSSL, err := ssl.CheckSSL(r.Context(), domain)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
 	}
 
 	// ======== Build Response ========
@@ -56,39 +68,87 @@ func (h *SSLHandler) CheckAndIssueSSL(w http.ResponseWriter, r *http.Request) {
 		Error     bool   `json:"error"`
 		Message   string `json:"string"`
 		SSLStatus bool   `json:"ssl_status"`
+		JobID     int64  `json:"jobId,omitempty"`
+	}
+	if hasSSL {
+		response.Error = false
+		response.Message = "SSL Certificated checked"
+		response.SSLStatus = true
+		utils.WriteJSON(w, http.StatusOK, response)
+		return
 	}
+
+	// setup ssl certificate on a background worker
+	job, err := h.queueIssue(r, domain)
 	if err != nil {
 		response.Error = true
 		response.Message = err.Error()
-		response.SSLStatus = false
-	} else {
-		response.Error = false
-		response.Message = "SSL Certificated checked"
-		response.SSLStatus = hasSSL
+		utils.WriteJSON(w, http.StatusOK, response)
+		return
 	}
-	utils.WriteJSON(w, http.StatusOK, response)
+	response.Error = false
+	response.Message = "SSL certificate issuance queued"
+	response.JobID = job.ID
+	utils.WriteJSON(w, http.StatusAccepted, response)
 }
 
 func (h *SSLHandler) IssueSSL(w http.ResponseWriter, r *http.Request) {
 	domain := r.URL.Query().Get("domain")
 
-	// setup ssl certificate
-	err := ssl.SetupSSL(r.Context(), domain, config.Email, true)
+	// setup ssl certificate on a background worker
+	job, err := h.queueIssue(r, domain)
 
 	// ======== Build Response ========
 	var response struct {
 		Error     bool   `json:"error"`
 		Message   string `json:"string"`
 		SSLStatus bool   `json:"ssl_status"`
+		JobID     int64  `json:"jobId,omitempty"`
 	}
 	if err != nil {
 		response.Error = true
 		response.Message = err.Error()
-		response.SSLStatus = false
-	} else {
-		response.Error = false
-		response.Message = "SSL Certificated checked"
-		response.SSLStatus = true
+		utils.WriteJSON(w, http.StatusOK, response)
+		return
 	}
-	utils.WriteJSON(w, http.StatusOK, response)
+	response.Error = false
+	response.Message = "SSL certificate issuance queued"
+	response.JobID = job.ID
+	utils.WriteJSON(w, http.StatusAccepted, response)
+}
+
+func (h *SSLHandler) queueIssue(r *http.Request, domain string) (*models.Job, error) {
+	domain = strings.TrimSpace(domain)
+	if domain == "" {
+		return nil, errors.New("domain is required")
+	}
+	if err := ssl.ValidateDomain(domain); err != nil {
+		return nil, err
+	}
+	domain = strings.ToLower(domain)
+	job, err := h.Jobs.Enqueue(r.Context(), models.JobTypeSSLIssue, domain, sslJobPayload{Domain: domain}, requesterID(r))
+	if err != nil {
+		if errors.Is(err, dbrepo.ErrJobTargetBusy) {
+			return nil, fmt.Errorf("%s is busy: another job is already queued or running", domain)
+		}
+		h.errorLog.Println("ERROR_01_IssueSSL: failed to queue SSL issuance:", err)
+		return nil, errors.New("failed to queue SSL issuance")
+	}
+	return job, nil
+}
+
+type sslJobPayload struct {
+	Domain string `json:"domain"`
+}
+
+// runIssue is the ssl.issue job. Certbot can safely be re-run, so the job is resumable.
+func (h *SSLHandler) runIssue(ctx context.Context, job *models.Job) (any, error) {
+	var payload sslJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("invalid job payload: %w", err)
+	}
+	if err := ssl.SetupSSL(ctx, payload.Domain, config.Email, true); err != nil {
+		return failureResult(err), err
+	}
+	return map[string]any{"domain": payload.Domain, "sslStatus": true}, nil
 }
@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/totp"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+const (
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	defaultTOTPIssuer       = "vPanel"
+)
+
+// mfaChallenges counts the codes tried per challenge token (by jti) so a challenge
+// cannot be used to brute-force the 6-digit code within its lifetime
+type mfaChallenges struct {
+	mu       sync.Mutex
+	attempts map[string]int
+	expires  map[string]time.Time
+}
+
+func newMFAChallenges() *mfaChallenges {
+	return &mfaChallenges{
+		attempts: make(map[string]int),
+		expires:  make(map[string]time.Time),
+	}
+}
+
+// reserve takes one of the attempts of the challenge, reporting false when none is left.
+// The check and the count happen under one lock, so concurrent requests cannot try more
+// codes than allowed. An attempt is never given back: a wrong code keeps it and a right
+// one consumes the challenge.
+func (c *mfaChallenges) reserve(jti string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.attempts[jti] >= mfaChallengeMaxAttempts {
+		return false
+	}
+	c.attempts[jti]++
+	c.expires[jti] = expiresAt
+	c.purge()
+	return true
+}
+
+// consume makes a challenge unusable after a successful verification
+func (c *mfaChallenges) consume(jti string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts[jti] = mfaChallengeMaxAttempts
+	c.expires[jti] = expiresAt
+	c.purge()
+}
+
+func (c *mfaChallenges) purge() {
+	now := time.Now()
+	for jti, exp := range c.expires {
+		if now.After(exp) {
+			delete(c.expires, jti)
+			delete(c.attempts, jti)
+		}
+	}
+}
+
+// mfaChallengeToken signs a short-lived token that proves the password step passed.
+// It carries Purpose "mfa", so AuthUser refuses it as an access token.
+func (h *AuthHandler) mfaChallengeToken(user *models.User) (string, error) {
+	jti, err := utils.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	cfg := h.JWTConfig
+	cfg.Expiry = mfaChallengeTTL
+	return utils.GenerateJWT(models.JWT{
+		ID:       user.ID,
+		Username: user.Email,
+		Role:     user.Role,
+		TokenID:  jti,
+		Purpose:  models.TokenPurposeMFA,
+	}, cfg)
+}
+
+// ==================== Verify TOTP (second sign-in step) ====================
+// request body: {challengeToken, code} or {challengeToken, recoveryCode}
+// response: same as /signin
+func (h *AuthHandler) VerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recoveryCode"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		h.errorLog.Println("ERROR_01_VerifyTOTP: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	challenge, err := utils.ParseJWT(strings.TrimSpace(req.ChallengeToken), h.JWTConfig)
+	if err != nil || challenge.Purpose != models.TokenPurposeMFA || challenge.TokenID == "" {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: "invalid or expired challenge, please sign in again"})
+		return
+	}
+	expiresAt := time.Unix(challenge.ExpiresAt, 0)
+	if !h.challenges.reserve(challenge.TokenID, expiresAt) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: "too many attempts, please sign in again"})
+		return
+	}
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), challenge.ID)
+	if err != nil || user.Status != models.UserStatusActive || !user.TOTPEnabled {
+		h.errorLog.Println("ERROR_02_VerifyTOTP: user cannot complete sign-in:", err)
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: "invalid or expired challenge, please sign in again"})
+		return
+	}
+
+	ip := utils.ClientIP(r, h.Security.TrustedProxies)
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		h.recordAttempt(r, challenge.Username, &user.ID, ip, false, models.LoginReasonLocked)
+		tooManyAttempts(w, time.Until(*user.LockedUntil), "account is temporarily locked after too many failed sign-in attempts")
+		return
+	}
+
+	ok, err := h.verifySecondFactor(r.Context(), user.ID, req.Code, req.RecoveryCode)
+	if err != nil {
+		h.errorLog.Println("ERROR_03_VerifyTOTP:", err)
+		utils.ServerError(w, errors.New("failed to verify code"))
+		return
+	}
+	if !ok {
+		// wrong codes count toward the same lockout as wrong passwords
+		if lockedUntil := h.signinFailed(r, challenge.Username, user, ip, models.LoginReasonInvalidTOTP); lockedUntil != nil {
+			tooManyAttempts(w, time.Until(*lockedUntil), "account is temporarily locked after too many failed sign-in attempts")
+			return
+		}
+		utils.BadRequest(w, errors.New("invalid authentication code"))
+		return
+	}
+	h.challenges.consume(challenge.TokenID, expiresAt)
+
+	tokens, err := h.issueTokens(r.Context(), user, "")
+	if err != nil {
+		h.errorLog.Println("ERROR_04_VerifyTOTP: failed to issue tokens:", err)
+		utils.ServerError(w, fmt.Errorf("failed to generate token: %w", err))
+		return
+	}
+	h.signinSucceeded(r, challenge.Username, user, ip)
+
+	resp := struct {
+		Error        bool         `json:"error"`
+		AccessToken  string       `json:"accessToken"`
+		RefreshToken string       `json:"refreshToken"`
+		User         *models.User `json:"user"`
+	}{
+		Error:        false,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		User:         user,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== TOTP Status ====================
+func (h *AuthHandler) TOTPStatus(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	_, enabled, err := h.DB.MFA.GetTOTP(r.Context(), claims.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_TOTPStatus:", err)
+		utils.ServerError(w, fmt.Errorf("failed to load two-factor status: %w", err))
+		return
+	}
+	remaining, err := h.DB.MFA.CountRecoveryCodes(r.Context(), claims.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_02_TOTPStatus:", err)
+		utils.ServerError(w, fmt.Errorf("failed to load two-factor status: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error             bool `json:"error"`
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+	}{
+		Error:             false,
+		Enabled:           enabled,
+		RecoveryCodesLeft: remaining,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Enroll TOTP ====================
+// Generates a secret for the caller. TOTP stays off until /totp/confirm receives a valid code.
+// response: {secret, provisioningUri} - render provisioningUri as a QR code
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		utils.NotFound(w, "user not found")
+		return
+	}
+	if user.TOTPEnabled {
+		utils.BadRequest(w, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.errorLog.Println("ERROR_01_EnrollTOTP:", err)
+		utils.ServerError(w, errors.New("failed to generate secret"))
+		return
+	}
+	if err := h.DB.MFA.SetPendingTOTPSecret(r.Context(), user.ID, secret); err != nil {
+		h.errorLog.Println("ERROR_02_EnrollTOTP:", err)
+		utils.BadRequest(w, err)
+		return
+	}
+
+	issuer := h.JWTConfig.Issuer
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+	account := user.Email
+	if account == "" {
+		account = user.Mobile
+	}
+
+	resp := struct {
+		Error           bool   `json:"error"`
+		Message         string `json:"message"`
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioningUri"`
+	}{
+		Error:           false,
+		Message:         "Scan the QR code, then confirm with a code from your authenticator app",
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, issuer, account),
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Confirm TOTP ====================
+// request body: {code}, response: {recoveryCodes} - shown once, only hashes are stored
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	secret, enabled, err := h.DB.MFA.GetTOTP(r.Context(), claims.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ConfirmTOTP:", err)
+		utils.ServerError(w, fmt.Errorf("failed to load two-factor status: %w", err))
+		return
+	}
+	if enabled {
+		utils.BadRequest(w, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+	if secret == "" {
+		utils.BadRequest(w, errors.New("start enrollment first"))
+		return
+	}
+
+	step, ok := totp.Validate(secret, req.Code, time.Now(), totp.DefaultSkew)
+	if !ok {
+		utils.BadRequest(w, errors.New("invalid authentication code"))
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		h.errorLog.Println("ERROR_02_ConfirmTOTP:", err)
+		utils.ServerError(w, errors.New("failed to generate recovery codes"))
+		return
+	}
+	if err := h.DB.MFA.EnableTOTP(r.Context(), claims.ID, step, hashes); err != nil {
+		h.errorLog.Println("ERROR_03_ConfirmTOTP:", err)
+		utils.BadRequest(w, err)
+		return
+	}
+
+	resp := struct {
+		Error         bool     `json:"error"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}{
+		Error:         false,
+		Message:       "Two-factor authentication enabled. Store these recovery codes somewhere safe.",
+		RecoveryCodes: codes,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Disable TOTP ====================
+// request body: {password, code} or {password, recoveryCode}
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		utils.NotFound(w, "user not found")
+		return
+	}
+	if !user.TOTPEnabled {
+		utils.BadRequest(w, errors.New("two-factor authentication is not enabled"))
+		return
+	}
+	if !utils.CheckPassword(req.Password, user.Password) {
+		utils.BadRequest(w, errors.New("password is incorrect"))
+		return
+	}
+
+	ok, err := h.verifySecondFactor(r.Context(), user.ID, req.Code, req.RecoveryCode)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_DisableTOTP:", err)
+		utils.ServerError(w, errors.New("failed to verify code"))
+		return
+	}
+	if !ok {
+		utils.BadRequest(w, errors.New("invalid authentication code"))
+		return
+	}
+
+	if err := h.DB.MFA.DisableTOTP(r.Context(), user.ID); err != nil {
+		h.errorLog.Println("ERROR_02_DisableTOTP:", err)
+		utils.ServerError(w, fmt.Errorf("failed to disable two-factor authentication: %w", err))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// ==================== Regenerate Recovery Codes ====================
+// request body: {code}, response: {recoveryCodes}. Every previous recovery code stops working.
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	ok, err := h.verifySecondFactor(r.Context(), claims.ID, req.Code, "")
+	if err != nil {
+		h.errorLog.Println("ERROR_01_RegenerateRecoveryCodes:", err)
+		utils.ServerError(w, errors.New("failed to verify code"))
+		return
+	}
+	if !ok {
+		utils.BadRequest(w, errors.New("invalid authentication code"))
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		h.errorLog.Println("ERROR_02_RegenerateRecoveryCodes:", err)
+		utils.ServerError(w, errors.New("failed to generate recovery codes"))
+		return
+	}
+	if err := h.DB.MFA.ReplaceRecoveryCodes(r.Context(), claims.ID, hashes); err != nil {
+		h.errorLog.Println("ERROR_03_RegenerateRecoveryCodes:", err)
+		utils.ServerError(w, fmt.Errorf("failed to store recovery codes: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error         bool     `json:"error"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}{
+		Error:         false,
+		Message:       "New recovery codes generated",
+		RecoveryCodes: codes,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Reset User TOTP ====================
+// Administrative reset for a user who lost their authenticator and recovery codes (query parameter: user_id).
+// Their sessions are revoked so they sign in again with password only and can re-enroll.
+func (h *AuthHandler) ResetUserTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.BadRequest(w, errors.New("invalid user ID"))
+		return
+	}
+
+	if err := h.DB.MFA.DisableTOTP(r.Context(), userID); err != nil {
+		h.errorLog.Println("ERROR_01_ResetUserTOTP:", err)
+		utils.ServerError(w, fmt.Errorf("failed to reset two-factor authentication: %w", err))
+		return
+	}
+	if _, err := h.DB.Token.RevokeUserSessions(r.Context(), userID); err != nil {
+		h.errorLog.Println("ERROR_02_ResetUserTOTP: failed to revoke sessions:", err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: "Two-factor authentication reset",
+	})
+}
+
+// verifySecondFactor accepts either a TOTP code (single use per step) or an unused recovery code
+func (h *AuthHandler) verifySecondFactor(ctx context.Context, userID int64, code, recoveryCode string) (bool, error) {
+	if recoveryCode = totp.NormalizeRecoveryCode(recoveryCode); recoveryCode != "" {
+		return h.DB.MFA.UseRecoveryCode(ctx, userID, utils.HashToken(recoveryCode))
+	}
+
+	secret, enabled, err := h.DB.MFA.GetTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled || secret == "" {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totp.DefaultSkew)
+	if !ok {
+		return false, nil
+	}
+	return h.DB.MFA.UseTOTPStep(ctx, userID, step)
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.GenerateRecoveryCodes(totp.RecoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = utils.HashToken(totp.NormalizeRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMFAChallengeAttempts(t *testing.T) {
+	c := newMFAChallenges()
+	expiresAt := time.Now().Add(time.Minute)
+
+	// concurrent codes cannot try more than the allowed attempts
+	var reserved atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4*mfaChallengeMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.reserve("a", expiresAt) {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := reserved.Load(); n != mfaChallengeMaxAttempts {
+		t.Fatalf("%d attempts reserved, want %d", n, mfaChallengeMaxAttempts)
+	}
+
+	// a challenge is spent by a right code
+	if !c.reserve("b", expiresAt) {
+		t.Fatal("first attempt refused")
+	}
+	c.consume("b", expiresAt)
+	if c.reserve("b", expiresAt) {
+		t.Error("consumed challenge still has attempts")
+	}
+
+	// expired challenges are forgotten
+	c.reserve("old", time.Now().Add(-time.Second))
+	c.reserve("c", expiresAt)
+	c.mu.Lock()
+	_, kept := c.attempts["old"]
+	c.mu.Unlock()
+	if kept {
+		t.Error("expired challenge not purged")
+	}
+}
@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+const (
+	minPasswordLength = 8
+	maxAvatarSize     = 2 << 20 // 2MB
+	avatarURLPrefix   = "/api/v1/images/avatars/"
+)
+
+// allowed avatar content types and the extension they are saved with
+var avatarTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+type UserHandler struct {
+	DB       *dbrepo.DBRepository
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func newUserHandler(db *dbrepo.DBRepository, infoLog, errorLog *log.Logger) UserHandler {
+	return UserHandler{
+		DB:       db,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+// ==================== Create User ====================
+// request body: {name, role, status, mobile, email, password, address}
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Role     string `json:"role"`
+		Status   string `json:"status"`
+		Mobile   string `json:"mobile"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Address  string `json:"address"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		h.errorLog.Println("ERROR_01_CreateUser: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	user := &models.User{
+		Name:    strings.TrimSpace(req.Name),
+		Role:    strings.TrimSpace(req.Role),
+		Status:  strings.TrimSpace(req.Status),
+		Mobile:  strings.TrimSpace(req.Mobile),
+		Email:   strings.TrimSpace(req.Email),
+		Address: strings.TrimSpace(req.Address),
+	}
+	if user.Role == "" {
+		user.Role = rbac.RoleViewer
+	}
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
+	if err := validateUser(user); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	hash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		h.errorLog.Println("ERROR_02_CreateUser: failed to hash password:", err)
+		utils.ServerError(w, errors.New("failed to hash password"))
+		return
+	}
+	user.Password = hash
+
+	if err := h.DB.UserRepo.CreateUser(r.Context(), user); err != nil {
+		h.errorLog.Println("ERROR_03_CreateUser:", err)
+		utils.BadRequest(w, err)
+		return
+	}
+
+	resp := struct {
+		Error   bool         `json:"error"`
+		Message string       `json:"message"`
+		User    *models.User `json:"user"`
+	}{
+		Error:   false,
+		Message: "User created successfully",
+		User:    user,
+	}
+	utils.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// ==================== List Users ====================
+// query parameters: page, limit, role, status, sort_by, sort_order
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	users, total, err := h.DB.UserRepo.PaginatedUserList(r.Context(), page, limit,
+		q.Get("role"), q.Get("status"), q.Get("sort_by"), strings.ToUpper(q.Get("sort_order")))
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ListUsers:", err)
+		utils.ServerError(w, fmt.Errorf("failed to fetch users: %w", err))
+		return
+	}
+
+	resp := struct {
+		Error   bool           `json:"error"`
+		Message string         `json:"message"`
+		Page    int            `json:"page"`
+		Limit   int            `json:"limit"`
+		Total   int            `json:"total"`
+		Users   []*models.User `json:"users"`
+	}{
+		Error:   false,
+		Message: "Users fetched successfully",
+		Page:    page,
+		Limit:   limit,
+		Total:   total,
+		Users:   users,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Get Own Profile ====================
+func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_GetProfile:", err)
+		utils.NotFound(w, "user not found")
+		return
+	}
+
+	resp := struct {
+		Error       bool              `json:"error"`
+		Message     string            `json:"message"`
+		User        *models.User      `json:"user"`
+		Permissions []rbac.Permission `json:"permissions"`
+	}{
+		Error:       false,
+		Message:     "Profile fetched successfully",
+		User:        user,
+		Permissions: rbac.PermissionsFor(user.Role),
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Update User ====================
+// query parameter: user_id, request body: {name, role, status, mobile, email, address}
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		utils.BadRequest(w, errors.New("invalid user ID"))
+		return
+	}
+
+	var req struct {
+		Name    string `json:"name"`
+		Role    string `json:"role"`
+		Status  string `json:"status"`
+		Mobile  string `json:"mobile"`
+		Email   string `json:"email"`
+		Address string `json:"address"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		h.errorLog.Println("ERROR_01_UpdateUser: invalid JSON:", err)
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), id)
+	if err != nil {
+		utils.NotFound(w, "user not found")
+		return
+	}
+	previousRole, previousStatus := user.Role, user.Status
+
+	user.Name = strings.TrimSpace(req.Name)
+	user.Role = strings.TrimSpace(req.Role)
+	user.Status = strings.TrimSpace(req.Status)
+	user.Mobile = strings.TrimSpace(req.Mobile)
+	user.Email = strings.TrimSpace(req.Email)
+	user.Address = strings.TrimSpace(req.Address)
+	if err := validateUser(user); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	// password and avatar are kept as loaded
+	if err := h.DB.UserRepo.UpdateUser(r.Context(), user); err != nil {
+		if errors.Is(err, dbrepo.ErrLastAdmin) {
+			utils.BadRequest(w, err)
+			return
+		}
+		h.errorLog.Println("ERROR_02_UpdateUser:", err)
+		utils.BadRequest(w, err)
+		return
+	}
+
+	// A role or status change must not wait for outstanding tokens to expire
+	if user.Role != previousRole || user.Status != previousStatus {
+		if _, err := h.DB.Token.RevokeUserSessions(r.Context(), user.ID); err != nil {
+			h.errorLog.Println("ERROR_03_UpdateUser: failed to revoke sessions:", err)
+		}
+	}
+
+	resp := struct {
+		Error   bool         `json:"error"`
+		Message string       `json:"message"`
+		User    *models.User `json:"user"`
+	}{
+		Error:   false,
+		Message: "User updated successfully",
+		User:    user,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Deactivate User ====================
+// query parameter: user_id
+func (h *UserHandler) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		utils.BadRequest(w, errors.New("invalid user ID"))
+		return
+	}
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), id)
+	if err != nil {
+		utils.NotFound(w, "user not found")
+		return
+	}
+
+	if err := h.DB.UserRepo.UpdateUserStatus(r.Context(), id, user.Role, models.UserStatusInactive); err != nil {
+		if errors.Is(err, dbrepo.ErrLastAdmin) {
+			utils.BadRequest(w, err)
+			return
+		}
+		h.errorLog.Println("ERROR_01_DeactivateUser:", err)
+		utils.ServerError(w, fmt.Errorf("failed to deactivate user: %w", err))
+		return
+	}
+
+	if _, err := h.DB.Token.RevokeUserSessions(r.Context(), id); err != nil {
+		h.errorLog.Println("ERROR_02_DeactivateUser: failed to revoke sessions:", err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: "User deactivated successfully",
+	})
+}
+
+// ==================== Change Password ====================
+
+// ChangeOwnPassword changes the caller's password.
+// request body: {currentPassword, newPassword}
+func (h *UserHandler) ChangeOwnPassword(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		utils.NotFound(w, "user not found")
+		return
+	}
+	if !utils.CheckPassword(req.CurrentPassword, user.Password) {
+		utils.BadRequest(w, errors.New("current password is incorrect"))
+		return
+	}
+
+	h.setPassword(w, r, user.ID, req.NewPassword)
+}
+
+// ChangeUserPassword lets an administrator set another user's password.
+// query parameter: user_id, request body: {newPassword}
+func (h *UserHandler) ChangeUserPassword(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		utils.BadRequest(w, errors.New("invalid user ID"))
+		return
+	}
+
+	var req struct {
+		NewPassword string `json:"newPassword"`
+	}
+	if err := utils.ReadJSON(w, r, &req); err != nil {
+		utils.BadRequest(w, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	h.setPassword(w, r, id, req.NewPassword)
+}
+
+// setPassword hashes and stores the new password, then signs the user out everywhere
+func (h *UserHandler) setPassword(w http.ResponseWriter, r *http.Request, userID int64, password string) {
+	if err := validatePassword(password); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		h.errorLog.Println("ERROR_01_ChangePassword: failed to hash password:", err)
+		utils.ServerError(w, errors.New("failed to hash password"))
+		return
+	}
+
+	if err := h.DB.UserRepo.UpdateUserPassword(r.Context(), userID, hash); err != nil {
+		h.errorLog.Println("ERROR_02_ChangePassword:", err)
+		utils.BadRequest(w, err)
+		return
+	}
+
+	if _, err := h.DB.Token.RevokeUserSessions(r.Context(), userID); err != nil {
+		h.errorLog.Println("ERROR_03_ChangePassword: failed to revoke sessions:", err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Response{
+		Error:   false,
+		Message: "Password changed successfully, please sign in again",
+	})
+}
+
+// ==================== Upload Avatar ====================
+
+// UploadOwnAvatar replaces the caller's avatar. multipart field: avatar
+func (h *UserHandler) UploadOwnAvatar(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middlewares.UserFromContext(r.Context())
+	h.saveAvatar(w, r, claims.ID)
+}
+
+// UploadUserAvatar replaces another user's avatar. query parameter: user_id, multipart field: avatar
+func (h *UserHandler) UploadUserAvatar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		utils.BadRequest(w, errors.New("invalid user ID"))
+		return
+	}
+	h.saveAvatar(w, r, id)
+}
+
+// saveAvatar stores the uploaded image under data/images/avatars, served by the /api/v1/images/ file server
+func (h *UserHandler) saveAvatar(w http.ResponseWriter, r *http.Request, userID int64) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+(1<<20))
+	if err := r.ParseMultipartForm(maxAvatarSize); err != nil {
+		utils.BadRequest(w, fmt.Errorf("invalid form data (max 2MB): %w", err))
+		return
+	}
+
+	user, err := h.DB.UserRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.NotFound(w, "user not found")
+		return
+	}
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		utils.BadRequest(w, fmt.Errorf("avatar file is required: %w", err))
+		return
+	}
+	defer file.Close()
+
+	// Sniff the real content type instead of trusting the filename
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	ext, ok := avatarTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		utils.BadRequest(w, errors.New("avatar must be a JPEG, PNG, GIF or WebP image"))
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		utils.ServerError(w, err)
+		return
+	}
+
+	avatarDir := filepath.Join(utils.GetImageDirectory(), "avatars")
+	if err := os.MkdirAll(avatarDir, 0755); err != nil {
+		h.errorLog.Println("ERROR_01_UploadAvatar: failed to create directory:", err)
+		utils.ServerError(w, errors.New("failed to store avatar"))
+		return
+	}
+
+	fileName := fmt.Sprintf("%d_%d%s", userID, time.Now().UnixNano(), ext)
+	outFile, err := os.Create(filepath.Join(avatarDir, fileName))
+	if err != nil {
+		h.errorLog.Println("ERROR_02_UploadAvatar: failed to create file:", err)
+		utils.ServerError(w, errors.New("failed to store avatar"))
+		return
+	}
+	defer outFile.Close()
+
+	if _, err := io.Copy(outFile, file); err != nil {
+		h.errorLog.Println("ERROR_03_UploadAvatar: failed to write file:", err)
+		utils.ServerError(w, errors.New("failed to store avatar"))
+		return
+	}
+
+	avatarLink := avatarURLPrefix + fileName
+	if err := h.DB.UserRepo.UpdateUserAvatarLink(r.Context(), userID, avatarLink); err != nil {
+		h.errorLog.Println("ERROR_04_UploadAvatar:", err)
+		utils.ServerError(w, fmt.Errorf("failed to update avatar: %w", err))
+		return
+	}
+
+	// remove the previous avatar if it was one of ours
+	if strings.HasPrefix(user.AvatarLink, avatarURLPrefix) {
+		_ = os.Remove(filepath.Join(avatarDir, path.Base(user.AvatarLink)))
+	}
+
+	resp := struct {
+		Error      bool   `json:"error"`
+		Message    string `json:"message"`
+		AvatarLink string `json:"avatarLink"`
+	}{
+		Error:      false,
+		Message:    "Avatar uploaded successfully",
+		AvatarLink: avatarLink,
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ==================== Helpers ====================
+
+func validateUser(u *models.User) error {
+	if u.Name == "" {
+		return errors.New("name is required")
+	}
+	if u.Mobile == "" {
+		return errors.New("mobile is required")
+	}
+	if !rbac.ValidRole(u.Role) {
+		return fmt.Errorf("invalid role, expected one of %s", strings.Join(rbac.Roles(), ", "))
+	}
+	if u.Status != models.UserStatusActive && u.Status != models.UserStatusInactive {
+		return errors.New("invalid status, expected active or inactive")
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -12,18 +13,21 @@ import (
 	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
 	"github.com/projuktisheba/vpanel/backend/internal/deploy"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
 	"github.com/projuktisheba/vpanel/backend/internal/utils"
 )
 
 type WordPressHandler struct {
 	DB       *dbrepo.DBRepository
+	Jobs     *jobs.Queue
 	infoLog  *log.Logger
 	errorLog *log.Logger
 }
 
-func newWordPressHandler(db *dbrepo.DBRepository, infoLog, errorLog *log.Logger) WordPressHandler {
+func newWordPressHandler(db *dbrepo.DBRepository, queue *jobs.Queue, infoLog, errorLog *log.Logger) WordPressHandler {
 	return WordPressHandler{
 		DB:       db,
+		Jobs:     queue,
 		infoLog:  infoLog,
 		errorLog: errorLog,
 	}
@@ -64,6 +68,26 @@ func (h *WordPressHandler) DeploySite(w http.ResponseWriter, r *http.Request) {
 	req.ProjectFramework = "Wordpress"
 	req.ProjectDirectory = projectDir
 
+	deployer, err := deploy.For(req.ProjectFramework)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	// ?dry_run=true: report what the deployment would do without creating the project
+	if isDryRun(r) {
+		writeDryRun(w, r, func(ctx context.Context) error {
+			// the project does not exist yet, so it has no custom nginx rules
+			return deployer.Deploy(ctx, deploy.TargetFor(&req, nil))
+		})
+		return
+	}
+
+	// A site that is still being deployed or changed cannot be deployed again
+	if siteHasActiveJob(w, r, h.DB, &req) {
+		return
+	}
+
 	// ======== Create Project ========
 	// step: Insert a record to the projects table
 	if err := h.DB.ProjectRepo.CreateProject(r.Context(), &req); err != nil {
@@ -72,24 +96,24 @@ func (h *WordPressHandler) DeploySite(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// step:2 Call PHP builder function
-	if err := deploy.DeployWordPress(req.DomainName, req.ProjectDirectory); err != nil {
-		h.errorLog.Println("ERROR_03_DeploySite: failed to create project:", err)
-
-		//silently update project status to Error
-		req.Status = models.ProjectStatusError
-		h.DB.ProjectRepo.UpdateProjectStatus(r.Context(), req.ID, req.Status)
-
-		//send the response
-		utils.ServerError(w, fmt.Errorf("failed to create project: %w", err))
+	// step:2 Queue the deployment; it runs on a background worker
+	if _, err := h.DB.ProjectRepo.UpdateProjectStatus(r.Context(), req.ID, models.ProjectStatusDeploying); err != nil {
+		h.errorLog.Println("ERROR_03_DeploySite: failed to update project status:", err)
+		utils.ServerError(w, fmt.Errorf("failed to update project status: %w", err))
 		return
 	}
+	req.Status = models.ProjectStatusDeploying
 
-	// step:3 Update the project status
-	req.Status = models.ProjectStatusRunning
-	if _, err := h.DB.ProjectRepo.UpdateProjectStatus(r.Context(), req.ID, req.Status); err != nil {
-		h.errorLog.Println("ERROR_04_DeploySite: failed to update project status:", err)
-		utils.ServerError(w, fmt.Errorf("failed to update project status: %w", err))
+	job, err := h.Jobs.Enqueue(r.Context(), models.JobTypeWordPressDeploy, siteKey(&req), projectJobPayload{ProjectID: req.ID}, requesterID(r))
+	if err != nil {
+		h.errorLog.Println("ERROR_04_DeploySite: failed to queue deployment:", err)
+		//silently update project status to Error
+		h.DB.ProjectRepo.UpdateProjectStatus(r.Context(), req.ID, models.ProjectStatusError)
+		if errors.Is(err, dbrepo.ErrJobTargetBusy) {
+			writeConflict(w, fmt.Sprintf("%s is busy: another job is already queued or running", siteKey(&req)), 0)
+			return
+		}
+		utils.ServerError(w, fmt.Errorf("failed to queue deployment: %w", err))
 		return
 	}
 
@@ -99,14 +123,16 @@ func (h *WordPressHandler) DeploySite(w http.ResponseWriter, r *http.Request) {
 	resp := struct {
 		Error   bool           `json:"error"`
 		Message string         `json:"message"`
+		JobID   int64          `json:"jobId"`
 		Summary models.Project `json:"summary"`
 	}{
 		Error:   false,
-		Message: "Project created successfully",
+		Message: "Project created, deployment queued",
+		JobID:   job.ID,
 		Summary: req,
 	}
 
-	utils.WriteJSON(w, http.StatusOK, resp)
+	utils.WriteJSON(w, http.StatusAccepted, resp)
 }
 
 func (h *WordPressHandler) UpdateProjectStatus(w http.ResponseWriter, r *http.Request) {
@@ -157,170 +183,6 @@ func (h *WordPressHandler) UpdateProjectStatus(w http.ResponseWriter, r *http.Re
 	utils.WriteJSON(w, http.StatusOK, resp)
 }
 
-func (h *WordPressHandler) GetSiteStatus(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("project_id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		utils.BadRequest(w, errors.New("invalid project ID"))
-		return
-	}
-	//Get the project info
-	project, err := h.DB.ProjectRepo.GetProjectByID(r.Context(), int64(id))
-	if err != nil {
-		h.errorLog.Println("ERROR_01_GetSiteStatus: failed to get project information:", err)
-		utils.ServerError(w, fmt.Errorf("Site information not found"))
-		return
-	}
-
-	var resp struct {
-		Error      bool   `json:"error"`
-		Message    string `json:"message"`
-		SiteStatus string `json:"siteStatus"`
-	}
-	resp.Error = false
-	resp.Message = "Site status: " + project.Status
-	resp.SiteStatus = project.Status
-
-	utils.WriteJSON(w, http.StatusOK, resp)
-}
-
-func (h *WordPressHandler) SuspendSite(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("project_id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		utils.BadRequest(w, errors.New("invalid project ID"))
-		return
-	}
-	//Get the project info
-	var project *models.Project
-	project, err = h.DB.ProjectRepo.GetProjectByID(r.Context(), int64(id))
-	if err != nil {
-		h.errorLog.Println("ERROR_01_SuspendSite: failed to suspend project:", err)
-		utils.ServerError(w, fmt.Errorf("Site information not found"))
-		return
-	}
-
-	//Only Wordpress site can be suspended
-	if project.ProjectFramework != "Wordpress" {
-		utils.ServerError(w, fmt.Errorf("Only Wordpress site can be suspended"))
-		return
-	}
-	//Only running site can be suspended
-	if project.Status != models.ProjectStatusRunning {
-		utils.ServerError(w, fmt.Errorf("Only running site can be suspended"))
-		return
-	}
-
-	//suspend wordpress site
-	if err := deploy.SuspendWordpressSite(project.ProjectName); err != nil {
-		h.errorLog.Println("ERROR_02_SuspendSite: failed to suspend project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to suspend project: %w", err))
-		return
-	}
-
-	//update status to suspended
-	if _, err := h.DB.ProjectRepo.UpdateProjectStatus(r.Context(), id, models.ProjectStatusSuspended); err != nil {
-		h.errorLog.Println("ERROR_01_SuspendSite: failed to suspend project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to suspend project: %w", err))
-		return
-	}
-
-	resp := models.Response{
-		Error:   false,
-		Message: "Project suspended successfully",
-	}
-
-	utils.WriteJSON(w, http.StatusOK, resp)
-}
-
-func (h *WordPressHandler) RestartSite(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("project_id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		utils.BadRequest(w, errors.New("invalid project ID"))
-		return
-	}
-	//Get the project info
-	var project *models.Project
-	project, err = h.DB.ProjectRepo.GetProjectByID(r.Context(), int64(id))
-	if err != nil {
-		h.errorLog.Println("ERROR_01_RestartSite: failed to restart project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to restart project: %w", err))
-		return
-	}
-	//Only Wordpress site can be restarted
-	if project.ProjectFramework != "Wordpress" {
-		utils.ServerError(w, fmt.Errorf("Only Wordpress site can be restarted"))
-		return
-	}
-	//Only suspended site can be restarted
-	if project.Status != models.ProjectStatusSuspended {
-		utils.ServerError(w, fmt.Errorf("Only suspended project can be restarted"))
-		return
-	}
-
-	//restart wordpress site
-	if err := deploy.RestartWordpressSite(project.ProjectName); err != nil {
-		h.errorLog.Println("ERROR_02_RestartSite: failed to restart project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to restart project: %w", err))
-		return
-	}
-
-	//update status to suspended
-	if _, err := h.DB.ProjectRepo.UpdateProjectStatus(r.Context(), id, models.ProjectStatusRunning); err != nil {
-		h.errorLog.Println("ERROR_01_RestartSite: failed to restart project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to restart project: %w", err))
-		return
-	}
-
-	resp := models.Response{
-		Error:   false,
-		Message: "Project restarted successfully",
-	}
-
-	utils.WriteJSON(w, http.StatusOK, resp)
-}
-
-func (h *WordPressHandler) DeleteSite(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("project_id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		utils.BadRequest(w, errors.New("invalid project ID"))
-		return
-	}
-	//Get the project info
-	var project *models.Project
-	project, err = h.DB.ProjectRepo.GetProjectByID(r.Context(), int64(id))
-	if err != nil {
-		h.errorLog.Println("ERROR_01_DeleteProject: failed to delete project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
-		return
-	}
-	//Only Wordpress site can be deleted
-	if project.ProjectFramework != "Wordpress" {
-		utils.ServerError(w, fmt.Errorf("Only Wordpress site can be deleted"))
-		return
-	}
-	//delete the project files and users
-	if err := deploy.DeleteWordpressSite(project.ProjectName,project.DomainName, project.ProjectDirectory); err != nil {
-		h.errorLog.Println("ERROR_02_DeleteProject: failed to delete project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
-		return
-	}
-
-	if err := h.DB.ProjectRepo.DeleteProject(r.Context(), id); err != nil {
-		h.errorLog.Println("ERROR_01_DeleteProject: failed to delete project:", err)
-		utils.ServerError(w, fmt.Errorf("failed to delete project: %w", err))
-		return
-	}
-
-	resp := models.Response{
-		Error:   false,
-		Message: "Project deleted successfully",
-	}
-
-	utils.WriteJSON(w, http.StatusOK, resp)
-}
 func (h *WordPressHandler) ListProjects(w http.ResponseWriter, r *http.Request) {
 	// Get optional query param
 	framework := strings.TrimSpace(r.URL.Query().Get("framework"))
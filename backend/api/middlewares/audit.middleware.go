@@ -0,0 +1,324 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+const (
+	auditMaxBody     = 1 << 20 // JSON bodies larger than this are not captured
+	auditMaxResponse = 4 << 10 // bytes of an error response kept to extract its message
+	auditRedacted    = "[REDACTED]"
+)
+
+// parameters with any of these words in their name (split at _, -, . and camelCase) are
+// never stored: apiKey and db_password are, monkey and author are not
+var auditSecretWords = []string{
+	"password", "passwords", "passwd", "pwd", "passphrase",
+	"secret", "secrets", "token", "tokens", "key", "keys",
+	"credential", "credentials", "authorization", "private", "dsn", "otp",
+}
+
+// parameters named one of these, ignoring case and separators, are never stored either:
+// their words are too common on their own (statusCode, basicAuth)
+var auditSecretNames = []string{"auth", "authheader", "code", "totpcode", "mfacode", "recoverycode", "backupcode"}
+
+// identifiers that match auditSecretWords but are not secret
+var auditPublicKeys = []string{"keyid"}
+
+// environment maps are stored with their variable names only: any variable may hold a secret
+var auditEnvKeys = []string{"env", "environment"}
+
+// AuditStore persists audit events
+type AuditStore interface {
+	RecordAuditEvent(ctx context.Context, e *models.AuditEvent) error
+}
+
+// Auditor records mutating requests to the audit trail
+type Auditor struct {
+	Store    AuditStore
+	Security models.SecurityConfig
+	errorLog *log.Logger
+}
+
+func NewAuditor(store AuditStore, security models.SecurityConfig, errorLog *log.Logger) *Auditor {
+	return &Auditor{
+		Store:    store,
+		Security: security,
+		errorLog: errorLog,
+	}
+}
+
+// ========================= AUDIT ==============================
+// Record stores an audit event named action for every request through the handler.
+// The target is the first non-empty parameter among targetKeys (query, JSON body or form).
+// It should run after AuthUser and before RequirePermission, so denied attempts are recorded too.
+func (a *Auditor) Record(action string, targetKeys ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			body := captureJSONBody(r)
+			rec := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rec, r)
+
+			// the handler has parsed any form by now, so form values can be read without consuming the body
+			params := auditParams(r, body)
+			event := &models.AuditEvent{
+				IPAddress:  utils.ClientIP(r, a.Security.TrustedProxies),
+				UserAgent:  r.UserAgent(),
+				Action:     action,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Target:     auditTarget(params, targetKeys),
+				StatusCode: rec.status,
+				Outcome:    auditOutcome(rec.status),
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if user, ok := UserFromContext(r.Context()); ok {
+				id := user.ID
+				event.ActorUserID = &id
+				event.ActorName = user.Username
+				event.ActorRole = user.Role
+				if user.APIKeyID != 0 {
+					keyID := user.APIKeyID
+					event.APIKeyID = &keyID
+				}
+			}
+			if rec.status >= http.StatusBadRequest {
+				event.ErrorMessage = responseMessage(rec.body.Bytes())
+			}
+			event.Params, _ = json.Marshal(params)
+
+			// the request context may already be cancelled; the record must still be written
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := a.Store.RecordAuditEvent(ctx, event); err != nil {
+				a.errorLog.Printf("Audit: failed to record %s: %v", action, err)
+			}
+		})
+	}
+}
+
+// auditResponseWriter remembers the status code and the start of the response body
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	if room := auditMaxResponse - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(room, len(b))])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming handlers working through the wrapper
+func (w *auditResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// captureJSONBody reads a JSON request body and puts it back for the handler
+func captureJSONBody(r *http.Request) map[string]any {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if r.Body == nil || mediaType != "application/json" || r.ContentLength > auditMaxBody {
+		return nil
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, auditMaxBody+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), r.Body))
+	if err != nil || len(raw) > auditMaxBody {
+		return nil
+	}
+
+	var body map[string]any
+	if json.Unmarshal(raw, &body) != nil {
+		return nil
+	}
+	return body
+}
+
+// auditParams merges query, JSON body and form values into one redacted map.
+// Uploaded files are recorded by name and size only.
+func auditParams(r *http.Request, body map[string]any) map[string]any {
+	params := map[string]any{}
+	for k, v := range r.URL.Query() {
+		params[k] = flatten(v)
+	}
+	for k, v := range body {
+		params[k] = v
+	}
+	if r.PostForm != nil {
+		for k, v := range r.PostForm {
+			params[k] = flatten(v)
+		}
+	}
+	if r.MultipartForm != nil {
+		for k, files := range r.MultipartForm.File {
+			var list []map[string]any
+			for _, f := range files {
+				list = append(list, map[string]any{"filename": f.Filename, "size": f.Size})
+			}
+			params[k] = list
+		}
+	}
+	return redact(params).(map[string]any)
+}
+
+// redact replaces the value of every secret-looking key, at any depth
+func redact(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, inner := range val {
+			switch {
+			case isSecretKey(k):
+				val[k] = auditRedacted
+			case slices.Contains(auditEnvKeys, strings.ToLower(k)):
+				val[k] = redactAll(inner)
+			default:
+				val[k] = redact(inner)
+			}
+		}
+		return val
+	case []any:
+		for i, inner := range val {
+			val[i] = redact(inner)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// redactAll keeps the names of an environment map and replaces every value
+func redactAll(v any) any {
+	env, ok := v.(map[string]any)
+	if !ok {
+		return auditRedacted
+	}
+	for k := range env {
+		env[k] = auditRedacted
+	}
+	return env
+}
+
+func isSecretKey(key string) bool {
+	words := keyWords(key)
+	name := strings.Join(words, "")
+	if slices.Contains(auditPublicKeys, name) {
+		return false
+	}
+	if slices.Contains(auditSecretNames, name) {
+		return true
+	}
+	for _, w := range words {
+		if slices.Contains(auditSecretWords, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyWords splits a parameter name into its lowercased words: at _, -, . and spaces, and
+// at camelCase boundaries, keeping acronyms whole (SSLCertificateKey is ssl certificate key)
+func keyWords(key string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	runes := []rune(key)
+	for i, r := range runes {
+		if r == '_' || r == '-' || r == '.' || r == ' ' {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	flush()
+	return words
+}
+
+func flatten(values []string) any {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+func auditTarget(params map[string]any, keys []string) string {
+	for _, k := range keys {
+		switch v := params[k].(type) {
+		case string:
+			if v != "" && v != auditRedacted {
+				return v
+			}
+		case float64, bool:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	}
+	return ""
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return models.AuditOutcomeDenied
+	case status >= http.StatusBadRequest:
+		return models.AuditOutcomeFailure
+	default:
+		return models.AuditOutcomeSuccess
+	}
+}
+
+// responseMessage extracts "message" from a JSON error response
+func responseMessage(body []byte) string {
+	var resp struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Message != "" {
+		return resp.Message
+	}
+	return strings.TrimSpace(string(body))
+}
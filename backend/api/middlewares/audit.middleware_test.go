@@ -0,0 +1,179 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+type auditStoreFunc func(e *models.AuditEvent) error
+
+func (f auditStoreFunc) RecordAuditEvent(_ context.Context, e *models.AuditEvent) error {
+	return f(e)
+}
+
+// record sends a JSON body through Record and returns the stored event
+func record(t *testing.T, body string, targetKeys ...string) *models.AuditEvent {
+	t.Helper()
+	var event *models.AuditEvent
+	a := NewAuditor(auditStoreFunc(func(e *models.AuditEvent) error {
+		event = e
+		return nil
+	}), models.SecurityConfig{}, log.New(io.Discard, "", 0))
+
+	h := a.Record("test.action", targetKeys...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handler must still see the whole body
+		if b, _ := io.ReadAll(r.Body); string(b) != body {
+			t.Errorf("handler body = %q, want %q", b, body)
+		}
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/test", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if event == nil {
+		t.Fatal("no audit event recorded")
+	}
+	return event
+}
+
+func TestAuditRedactsSecrets(t *testing.T) {
+	event := record(t, `{
+		"domain": "example.com",
+		"password": "p",
+		"db_password": "p",
+		"apiKey": "k",
+		"ssh_key": "k",
+		"credentials": {"user": "u"},
+		"Authorization": "Bearer t",
+		"auth_header": "t",
+		"refresh_token": "t",
+		"client_secret": "s",
+		"private_pem": "s",
+		"dsn": "s",
+		"totp_code": "1",
+		"totpCode": "1",
+		"recoveryCode": "r",
+		"code": "1",
+		"auth": "t",
+		"SSLCertificateKey": "k",
+		"newPassword": "p",
+		"key_id": 7,
+		"nested": [{"password": "p", "name": "n"}]
+	}`, "key_id")
+
+	var params map[string]any
+	if err := json.Unmarshal(event.Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"domain":            "example.com",
+		"password":          auditRedacted,
+		"db_password":       auditRedacted,
+		"apiKey":            auditRedacted,
+		"ssh_key":           auditRedacted,
+		"credentials":       auditRedacted,
+		"Authorization":     auditRedacted,
+		"auth_header":       auditRedacted,
+		"refresh_token":     auditRedacted,
+		"client_secret":     auditRedacted,
+		"private_pem":       auditRedacted,
+		"dsn":               auditRedacted,
+		"totp_code":         auditRedacted,
+		"totpCode":          auditRedacted,
+		"recoveryCode":      auditRedacted,
+		"code":              auditRedacted,
+		"auth":              auditRedacted,
+		"SSLCertificateKey": auditRedacted,
+		"newPassword":       auditRedacted,
+		"key_id":            float64(7),
+		"nested":            []any{map[string]any{"password": auditRedacted, "name": "n"}},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %v\nwant %v", params, want)
+	}
+	if event.Target != "7" {
+		t.Errorf("target = %q, want 7", event.Target)
+	}
+}
+
+func TestAuditKeepsNonSecrets(t *testing.T) {
+	body := `{
+		"author": "a",
+		"statusCode": 200,
+		"basicAuth": true,
+		"authorized": true,
+		"monkey": "m",
+		"keyboard": "k",
+		"tokenizer": "t",
+		"codec": "c",
+		"zipcode": "z",
+		"keyId": 7,
+		"redirects": [{"from": "/a", "to": "/b", "status_code": 301}]
+	}`
+	event := record(t, body)
+
+	var params, want map[string]any
+	if err := json.Unmarshal(event.Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(body), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %v\nwant %v", params, want)
+	}
+}
+
+func TestKeyWords(t *testing.T) {
+	tests := map[string][]string{
+		"password":          {"password"},
+		"db_password":       {"db", "password"},
+		"apiKey":            {"api", "key"},
+		"SSLCertificateKey": {"ssl", "certificate", "key"},
+		"privatePEM":        {"private", "pem"},
+		"X-Auth-Token":      {"x", "auth", "token"},
+		"totp2Code":         {"totp2", "code"},
+		"a..b":              {"a", "b"},
+		"":                  nil,
+	}
+	for key, want := range tests {
+		if got := keyWords(key); !reflect.DeepEqual(got, want) {
+			t.Errorf("keyWords(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestAuditRedactsEnvValues(t *testing.T) {
+	event := record(t, `{
+		"name": "api",
+		"env": {"DATABASE_URL": "postgres://u:p@db/app", "STRIPE_SK": "sk_live", "NODE_ENV": "production"},
+		"app": {"environment": {"SENTRY": "https://x@sentry.io/1"}},
+		"Env": "A=1"
+	}`)
+
+	var params map[string]any
+	if err := json.Unmarshal(event.Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"name": "api",
+		"env": map[string]any{
+			"DATABASE_URL": auditRedacted,
+			"STRIPE_SK":    auditRedacted,
+			"NODE_ENV":     auditRedacted,
+		},
+		"app": map[string]any{"environment": map[string]any{"SENTRY": auditRedacted}},
+		"Env": auditRedacted,
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %v\nwant %v", params, want)
+	}
+}
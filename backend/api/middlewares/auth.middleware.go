@@ -0,0 +1,300 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// consistent context key used everywhere
+type contextKey string
+
+const userContextKey = contextKey("user")
+
+// TokenRevocationChecker reports whether an access token (jti) or its session (sid) was revoked
+type TokenRevocationChecker interface {
+	IsAccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error)
+}
+
+// APIKeyStore looks up API keys by the sha256 of the presented key
+type APIKeyStore interface {
+	GetActiveAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64, ip string) error
+}
+
+// Auth validates bearer tokens issued by AuthHandler.Signin and API keys
+type Auth struct {
+	JWTConfig   models.JWTConfig
+	Security    models.SecurityConfig
+	Revocations TokenRevocationChecker
+	APIKeys     APIKeyStore
+	tickets     *ticketLog
+	errorLog    *log.Logger
+}
+
+func NewAuth(JWTConfig models.JWTConfig, security models.SecurityConfig, revocations TokenRevocationChecker, apiKeys APIKeyStore, errorLog *log.Logger) *Auth {
+	return &Auth{
+		JWTConfig:   JWTConfig,
+		Security:    security,
+		Revocations: revocations,
+		APIKeys:     apiKeys,
+		tickets:     newTicketLog(),
+		errorLog:    errorLog,
+	}
+}
+
+// ticketLog remembers redeemed tickets (by jti) until they expire, so each is accepted once
+type ticketLog struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+func newTicketLog() *ticketLog {
+	return &ticketLog{expires: make(map[string]time.Time)}
+}
+
+// redeem marks the ticket used and reports whether it was unused
+func (l *ticketLog) redeem(jti string, expiresAt time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for id, exp := range l.expires {
+		if now.After(exp) {
+			delete(l.expires, id)
+		}
+	}
+	if _, used := l.expires[jti]; used {
+		return false
+	}
+	l.expires[jti] = expiresAt
+	return true
+}
+
+// ========================= AUTH USER ==============================
+// AuthUser validates the JWT bearer token and attaches *models.JWT to the request context.
+// An API key ("X-API-Key: vpk_..." or "Authorization: Bearer vpk_...") is accepted instead of a JWT.
+// OPTIONS requests (CORS preflight) are let through so preflight won't be blocked.
+func (a *Auth) AuthUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Allow preflight through
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if key, ok := apiKey(r); ok {
+			a.authAPIKey(w, r, next, key)
+			return
+		}
+
+		tokenString, ok := bearerToken(r)
+		if !ok {
+			a.errorLog.Println("AuthUser: missing or invalid Authorization header")
+			unauthorized(w, "Unauthorized: Missing or invalid Authorization header")
+			return
+		}
+
+		tokenUser, err := utils.ParseJWT(tokenString, a.JWTConfig)
+		if err != nil {
+			a.errorLog.Printf("AuthUser: token rejected: %v", err)
+			unauthorized(w, "Unauthorized: Invalid or expired token")
+			return
+		}
+
+		// Limited tokens (e.g. the MFA challenge) only work on the endpoint that consumes them
+		if tokenUser.Purpose != "" {
+			unauthorized(w, "Unauthorized: Token cannot be used for this request")
+			return
+		}
+
+		// Tokens issued before revocation support carry no jti/sid and cannot be killed
+		if tokenUser.TokenID == "" || tokenUser.SessionID == "" {
+			unauthorized(w, "Unauthorized: Token is no longer supported, please sign in again")
+			return
+		}
+		revoked, err := a.Revocations.IsAccessTokenRevoked(r.Context(), tokenUser.TokenID, tokenUser.SessionID)
+		if err != nil {
+			a.errorLog.Printf("AuthUser: revocation check failed: %v", err)
+			utils.ServerError(w, errors.New("failed to verify token"))
+			return
+		}
+		if revoked {
+			unauthorized(w, "Unauthorized: Token has been revoked")
+			return
+		}
+
+		// attach user to context using consistent key
+		ctx := context.WithValue(r.Context(), userContextKey, tokenUser)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ========================= AUTH TICKET ==============================
+// AuthTicket authenticates a WebSocket handshake by the ticket in its "ticket" query parameter.
+// Browsers cannot set headers on the handshake, and an access token in the URL would end up in
+// access logs, so a ticket is short-lived, carries purpose and is accepted once.
+// Its claims are attached like AuthUser's, with Target left for the handler to check.
+// Requests without a ticket go through AuthUser.
+func (a *Auth) AuthTicket(purpose string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authUser := a.AuthUser(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ticket := r.URL.Query().Get("ticket")
+			if ticket == "" {
+				authUser.ServeHTTP(w, r)
+				return
+			}
+			if !websocket.IsWebSocketUpgrade(r) {
+				unauthorized(w, "Unauthorized: Tickets are only accepted on a WebSocket handshake")
+				return
+			}
+
+			tokenUser, err := utils.ParseJWT(ticket, a.JWTConfig)
+			if err != nil || tokenUser.Purpose != purpose || tokenUser.TokenID == "" || tokenUser.SessionID == "" || tokenUser.Target == "" {
+				a.errorLog.Printf("AuthTicket: ticket rejected: %v", err)
+				unauthorized(w, "Unauthorized: Invalid or expired ticket")
+				return
+			}
+
+			// a ticket dies with the session it was issued from
+			revoked, err := a.Revocations.IsAccessTokenRevoked(r.Context(), tokenUser.TokenID, tokenUser.SessionID)
+			if err != nil {
+				a.errorLog.Printf("AuthTicket: revocation check failed: %v", err)
+				utils.ServerError(w, errors.New("failed to verify ticket"))
+				return
+			}
+			if revoked {
+				unauthorized(w, "Unauthorized: Ticket has been revoked")
+				return
+			}
+			if !a.tickets.redeem(tokenUser.TokenID, time.Unix(tokenUser.ExpiresAt, 0)) {
+				unauthorized(w, "Unauthorized: Ticket has already been used")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, tokenUser)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authAPIKey authenticates the request as the key's owner, limited to the key's scopes
+func (a *Auth) authAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	apiKey, err := a.APIKeys.GetActiveAPIKey(r.Context(), utils.HashToken(key))
+	if err != nil {
+		if !errors.Is(err, dbrepo.ErrAPIKeyNotFound) {
+			a.errorLog.Printf("AuthUser: API key lookup failed: %v", err)
+			utils.ServerError(w, errors.New("failed to verify API key"))
+			return
+		}
+		unauthorized(w, "Unauthorized: Invalid or expired API key")
+		return
+	}
+	if apiKey.OwnerStatus != models.UserStatusActive {
+		unauthorized(w, "Unauthorized: API key owner is deactivated")
+		return
+	}
+
+	ip := utils.ClientIP(r, a.Security.TrustedProxies)
+	if len(apiKey.AllowedIPs) > 0 {
+		allowed, err := utils.ParseIPNets(apiKey.AllowedIPs)
+		if err != nil || !utils.IPInNets(ip, allowed) {
+			a.errorLog.Printf("AuthUser: API key %d used from disallowed address %s", apiKey.ID, ip)
+			utils.WriteJSON(w, http.StatusForbidden, models.Response{
+				Error:   true,
+				Message: "Forbidden: this API key cannot be used from " + ip,
+			})
+			return
+		}
+	}
+
+	if err := a.APIKeys.TouchAPIKey(r.Context(), apiKey.ID, ip); err != nil {
+		a.errorLog.Printf("AuthUser: failed to record API key usage: %v", err)
+	}
+
+	tokenUser := &models.JWT{
+		ID:       apiKey.UserID,
+		Name:     apiKey.OwnerName,
+		Username: apiKey.OwnerEmail,
+		Role:     apiKey.OwnerRole,
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	}
+	ctx := context.WithValue(r.Context(), userContextKey, tokenUser)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// ========================= REQUIRE USER SESSION ==============================
+// RequireUserSession only lets through requests signed in with a password (a JWT session).
+// It guards account-level routes (sign-out, 2FA, API key management) from API keys.
+// It must run after AuthUser.
+func RequireUserSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, "Unauthorized: Missing authentication")
+			return
+		}
+		if user.APIKeyID != 0 {
+			utils.WriteJSON(w, http.StatusForbidden, models.Response{
+				Error:   true,
+				Message: "Forbidden: API keys cannot be used for this request",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ========================= CONTEXT HELPERS ==============================
+
+// UserFromContext returns the authenticated token claims attached by AuthUser
+func UserFromContext(ctx context.Context) (*models.JWT, bool) {
+	u, ok := ctx.Value(userContextKey).(*models.JWT)
+	if !ok || u == nil {
+		return nil, false
+	}
+	return u, true
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.Fields(r.Header.Get("Authorization"))
+	if len(parts) == 2 && strings.EqualFold(parts[0], "bearer") {
+		return parts[1], true
+	}
+	return "", false
+}
+
+// apiKey extracts an API key from the X-API-Key header or a "Bearer vpk_..." Authorization header
+func apiKey(r *http.Request) (string, bool) {
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return key, true
+	}
+	if token, ok := bearerToken(r); ok && strings.HasPrefix(token, models.APIKeyPrefix) {
+		return token, true
+	}
+	return "", false
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	utils.WriteJSON(w, http.StatusUnauthorized, models.Response{
+		Error:   true,
+		Message: message,
+	})
+}
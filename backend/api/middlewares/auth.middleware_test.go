@@ -0,0 +1,137 @@
+package middlewares
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+var testJWTConfig = models.JWTConfig{
+	SecretKey: "test-secret",
+	Issuer:    "vpanel",
+	Audience:  "vpanel",
+	Algorithm: "HS256",
+	Expiry:    time.Minute,
+}
+
+// revokedSessions reports the listed session ids as revoked
+type revokedSessions []string
+
+func (s revokedSessions) IsAccessTokenRevoked(_ context.Context, _, sessionID string) (bool, error) {
+	for _, id := range s {
+		if id == sessionID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func signed(t *testing.T, claims models.JWT) string {
+	t.Helper()
+	token, err := utils.GenerateJWT(claims, testJWTConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func jobLogTicket(t *testing.T, jti string) string {
+	return signed(t, models.JWT{
+		ID:        1,
+		Role:      "viewer",
+		TokenID:   jti,
+		SessionID: "session",
+		Purpose:   models.TokenPurposeJobLog,
+		Target:    "42",
+	})
+}
+
+// handshake sends a request through AuthTicket and returns the status and the user the
+// handler behind it saw
+func handshake(a *Auth, query, authorization string, upgrade bool) (int, *models.JWT) {
+	var seen *models.JWT
+	h := a.AuthTicket(models.TokenPurposeJobLog)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = UserFromContext(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/log/stream?job_id=42"+query, nil)
+	if upgrade {
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+	}
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, seen
+}
+
+func testAuth(revoked ...string) *Auth {
+	return NewAuth(testJWTConfig, models.SecurityConfig{}, revokedSessions(revoked), nil, log.New(io.Discard, "", 0))
+}
+
+func TestAuthTicket(t *testing.T) {
+	a := testAuth()
+	ticket := jobLogTicket(t, "t1")
+
+	code, user := handshake(a, "&ticket="+ticket, "", true)
+	if code != http.StatusOK || user == nil {
+		t.Fatalf("first use: status %d, user %v", code, user)
+	}
+	if user.ID != 1 || user.Purpose != models.TokenPurposeJobLog || user.Target != "42" {
+		t.Errorf("user = %+v", user)
+	}
+
+	if code, user := handshake(a, "&ticket="+ticket, "", true); code != http.StatusUnauthorized || user != nil {
+		t.Errorf("second use: status %d, user %v, want 401", code, user)
+	}
+}
+
+func TestAuthTicketRejects(t *testing.T) {
+	access := signed(t, models.JWT{ID: 1, Role: "admin", TokenID: "a1", SessionID: "session"})
+	mfa := signed(t, models.JWT{ID: 1, Role: "admin", TokenID: "m1", SessionID: "session", Purpose: models.TokenPurposeMFA, Target: "42"})
+	unbound := signed(t, models.JWT{ID: 1, Role: "admin", TokenID: "u1", SessionID: "session", Purpose: models.TokenPurposeJobLog})
+
+	tests := []struct {
+		name    string
+		auth    *Auth
+		query   string
+		upgrade bool
+	}{
+		{"not a handshake", testAuth(), "&ticket=" + jobLogTicket(t, "t2"), false},
+		{"access token as ticket", testAuth(), "&ticket=" + access, true},
+		{"other purpose", testAuth(), "&ticket=" + mfa, true},
+		{"no target", testAuth(), "&ticket=" + unbound, true},
+		{"revoked session", testAuth("session"), "&ticket=" + jobLogTicket(t, "t3"), true},
+		{"garbage", testAuth(), "&ticket=abc", true},
+		{"access token in query", testAuth(), "&token=" + access, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, user := handshake(tt.auth, tt.query, "", tt.upgrade); code != http.StatusUnauthorized || user != nil {
+				t.Errorf("status %d, user %v, want 401", code, user)
+			}
+		})
+	}
+}
+
+func TestAuthTicketFallsBackToHeader(t *testing.T) {
+	access := signed(t, models.JWT{ID: 1, Role: "admin", TokenID: "a1", SessionID: "session"})
+	code, user := handshake(testAuth(), "", "Bearer "+access, true)
+	if code != http.StatusOK || user == nil || user.Purpose != "" {
+		t.Fatalf("status %d, user %v", code, user)
+	}
+
+	// a ticket is never an access token
+	ticket := jobLogTicket(t, "t4")
+	if code, _ := handshake(testAuth(), "", "Bearer "+ticket, true); code != http.StatusUnauthorized {
+		t.Errorf("ticket as bearer: status %d, want 401", code)
+	}
+}
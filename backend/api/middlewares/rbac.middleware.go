@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
+)
+
+// ========================= ACCESS CONTROL ==============================
+// RequirePermission rejects requests whose authenticated role does not hold p.
+// Requests made with an API key additionally need p among the key's scopes.
+// It must run after AuthUser.
+func RequirePermission(p rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				unauthorized(w, "Unauthorized: Missing authentication")
+				return
+			}
+
+			if !rbac.Allows(user.Role, p) {
+				utils.WriteJSON(w, http.StatusForbidden, models.Response{
+					Error:   true,
+					Message: "Forbidden: your role does not allow " + string(p),
+				})
+				return
+			}
+
+			if user.APIKeyID != 0 && !slices.Contains(user.Scopes, string(p)) {
+				utils.WriteJSON(w, http.StatusForbidden, models.Response{
+					Error:   true,
+					Message: "Forbidden: this API key is not scoped for " + string(p),
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+)
+
+// serve runs a request for user through RequirePermission(p) and returns the status and
+// whether the handler behind it ran
+func serve(t *testing.T, method string, user *models.JWT, p rbac.Permission) (int, bool) {
+	t.Helper()
+	reached := false
+	h := RequirePermission(p)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	r := httptest.NewRequest(method, "/api/v1/test", nil)
+	if user != nil {
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, reached
+}
+
+func TestRequirePermissionRoutes(t *testing.T) {
+	// representative routes with the permission they are mounted with
+	routes := []struct {
+		route      string
+		permission rbac.Permission
+		viewer     bool
+		operator   bool
+		admin      bool
+	}{
+		{"GET /api/v1/project/status", rbac.ProjectRead, true, true, true},
+		{"POST /api/v1/project/php/deploy", rbac.ProjectDeploy, false, true, true},
+		{"POST /api/v1/project/delete", rbac.ProjectDelete, false, false, true},
+		{"POST /api/v1/db/mysql/create-database", rbac.DatabaseWrite, false, true, true},
+		{"DELETE /api/v1/db/mysql/delete-database", rbac.DatabaseDelete, false, false, true},
+		{"GET /api/v1/ssl/issue", rbac.SSLIssue, false, true, true},
+		{"POST /api/v1/jobs/cancel", rbac.JobCancel, false, true, true},
+		{"POST /api/v1/users/create", rbac.UserManage, false, false, true},
+		{"GET /api/v1/audit/list", rbac.AuditRead, false, false, true},
+	}
+	for _, rt := range routes {
+		for role, allowed := range map[string]bool{rbac.RoleViewer: rt.viewer, rbac.RoleOperator: rt.operator, rbac.RoleAdmin: rt.admin} {
+			code, reached := serve(t, http.MethodPost, &models.JWT{ID: 1, Role: role}, rt.permission)
+			want := http.StatusForbidden
+			if allowed {
+				want = http.StatusOK
+			}
+			if code != want || reached != allowed {
+				t.Errorf("%s as %s: status %d, handler reached %v; want %d", rt.route, role, code, reached, want)
+			}
+		}
+	}
+}
+
+func TestRequirePermissionDenies(t *testing.T) {
+	tests := []struct {
+		name string
+		user *models.JWT
+		want int
+	}{
+		{"no user", nil, http.StatusUnauthorized},
+		{"unknown role", &models.JWT{ID: 1, Role: "editor"}, http.StatusForbidden},
+		{"empty role", &models.JWT{ID: 1}, http.StatusForbidden},
+		// an API key needs the permission among its scopes, whatever its owner's role
+		{"API key without the scope", &models.JWT{ID: 1, Role: rbac.RoleAdmin, APIKeyID: 7, Scopes: []string{string(rbac.ProjectRead)}}, http.StatusForbidden},
+		{"API key with the scope", &models.JWT{ID: 1, Role: rbac.RoleAdmin, APIKeyID: 7, Scopes: []string{string(rbac.ProjectDeploy)}}, http.StatusOK},
+		{"API key of a viewer", &models.JWT{ID: 1, Role: rbac.RoleViewer, APIKeyID: 7, Scopes: []string{string(rbac.ProjectDeploy)}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reached := serve(t, http.MethodPost, tt.user, rbac.ProjectDeploy)
+			if code != tt.want || reached != (tt.want == http.StatusOK) {
+				t.Errorf("status %d, handler reached %v; want %d", code, reached, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequirePermissionPreflight(t *testing.T) {
+	// CORS preflight requests carry no credentials and are let through
+	if code, reached := serve(t, http.MethodOptions, nil, rbac.UserManage); code != http.StatusOK || !reached {
+		t.Errorf("OPTIONS: status %d, handler reached %v", code, reached)
+	}
+}
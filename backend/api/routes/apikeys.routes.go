@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+)
+
+func apiKeyHandlerRoutes() *chi.Mux {
+	mux := chi.NewRouter()
+
+	// ======== API Key Routes ========
+	// Lists the scopes the caller may grant
+	mux.Get("/scopes", handlerRepo.APIKey.ListScopes)
+
+	// request body: {name, scopes, allowedIps, expiresAt}, response: {key, apiKey}
+	mux.With(audit("api_key.create", "name")).Post("/create", handlerRepo.APIKey.CreateAPIKey)
+
+	// query parameter: user_id (optional, user:manage only; 0 lists every user's keys)
+	mux.Get("/list", handlerRepo.APIKey.ListAPIKeys)
+
+	// query parameter: key_id
+	mux.With(audit("api_key.revoke", "key_id")).Patch("/revoke", handlerRepo.APIKey.RevokeAPIKey)
+
+	return mux
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+)
+
+func auditHandlerRoutes() *chi.Mux {
+	mux := chi.NewRouter()
+
+	// ======== Audit Trail Routes ========
+	// query parameters: page, limit, actor_id, action, target, outcome, from, to
+	mux.With(can(rbac.AuditRead)).Get("/list", handlerRepo.Audit.ListAuditEvents)
+
+	// query parameters: format (csv|json), actor_id, action, target, outcome, from, to
+	mux.With(can(rbac.AuditRead)).Get("/export", handlerRepo.Audit.ExportAuditEvents)
+
+	return mux
+}
@@ -2,16 +2,59 @@ package routes
 
 import (
 	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
 )
 
-func authRoutes() *chi.Mux {
+func authRoutes(auth *middlewares.Auth) *chi.Mux {
 	mux := chi.NewRouter()
 
 	// ======== Auth Routes ========
 	mux.Post("/signin", handlerRepo.Auth.Signin)
 	// mux.Post("/signup", handlerRepo.Auth.Signup)
-	// mux.Post("/refresh-token", handlerRepo.Auth.RefreshToken)
-	// mux.Post("/logout", handlerRepo.Auth.Logout)
+
+	// request body: {refreshToken}, response: {error, accessToken, refreshToken}
+	mux.Post("/refresh-token", handlerRepo.Auth.RefreshToken)
+
+	// Second sign-in step when /signin answered mfaRequired
+	// request body: {challengeToken, code} or {challengeToken, recoveryCode}
+	mux.Post("/verify-totp", handlerRepo.Auth.VerifyTOTP)
+
+	// ======== Authenticated Auth Routes ========
+	mux.Group(func(secure chi.Router) {
+		secure.Use(auth.AuthUser)
+		secure.Use(middlewares.RequireUserSession)
+
+		// Revokes the current access token and its refresh token family
+		secure.Post("/logout", handlerRepo.Auth.Logout)
+
+		// query parameter: user_id
+		secure.With(audit("auth.revoke_sessions", "user_id"), can(rbac.UserManage)).Post("/revoke-sessions", handlerRepo.Auth.RevokeUserSessions)
+
+		// query parameter: user_id
+		secure.With(audit("auth.unlock", "user_id"), can(rbac.UserManage)).Post("/unlock", handlerRepo.Auth.UnlockUser)
+
+		// query parameters: page, limit, username, ip, user_id, success (true|false)
+		secure.With(can(rbac.UserManage)).Get("/login-attempts", handlerRepo.Auth.ListLoginAttempts)
+
+		// ======== Two-Factor (TOTP) Routes ========
+		secure.Get("/totp/status", handlerRepo.Auth.TOTPStatus)
+
+		// response: {secret, provisioningUri}
+		secure.With(audit("auth.totp_enroll")).Post("/totp/enroll", handlerRepo.Auth.EnrollTOTP)
+
+		// request body: {code}, response: {recoveryCodes}
+		secure.With(audit("auth.totp_confirm")).Post("/totp/confirm", handlerRepo.Auth.ConfirmTOTP)
+
+		// request body: {password, code} or {password, recoveryCode}
+		secure.With(audit("auth.totp_disable")).Post("/totp/disable", handlerRepo.Auth.DisableTOTP)
+
+		// request body: {code}, response: {recoveryCodes}
+		secure.With(audit("auth.totp_recovery_codes")).Post("/totp/recovery-codes", handlerRepo.Auth.RegenerateRecoveryCodes)
+
+		// query parameter: user_id
+		secure.With(audit("auth.totp_reset", "user_id"), can(rbac.UserManage)).Post("/totp/reset", handlerRepo.Auth.ResetUserTOTP)
+	})
 
 	return mux
 }
@@ -1,30 +1,33 @@
 package routes
 
-import "github.com/go-chi/chi/v5"
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+)
 
 func databaseRegistryRoutes() *chi.Mux {
 	mux := chi.NewRouter()
 
 	// ======== MySQL Management Routes ========
 	// Sensitive / requires DSN → POST
-	mux.Get("/mysql/databases", handlerRepo.MySQLManager.ListMySQLDatabases)
-	mux.Post("/mysql/create-database", handlerRepo.MySQLManager.CreateMySQLDatabase)
-	mux.Post("/mysql/import-database", handlerRepo.MySQLManager.ImportMySQLDatabase)
-	mux.Delete("/mysql/delete-database", handlerRepo.MySQLManager.DeleteMySQLDatabase)
-	mux.Delete("/mysql/reset-database", handlerRepo.MySQLManager.ResetMySQLDatabase)
-	mux.Get("/mysql/users", handlerRepo.MySQLManager.ListMySQLUsers)
-	mux.Post("/mysql/create-user", handlerRepo.MySQLManager.CreateMySQLUser)
+	mux.With(can(rbac.DatabaseRead)).Get("/mysql/databases", handlerRepo.MySQLManager.ListMySQLDatabases)
+	mux.With(audit("mysql.create_database", "database_name"), can(rbac.DatabaseWrite)).Post("/mysql/create-database", handlerRepo.MySQLManager.CreateMySQLDatabase)
+	mux.With(audit("mysql.import_database", "dbName"), can(rbac.DatabaseWrite)).Post("/mysql/import-database", handlerRepo.MySQLManager.ImportMySQLDatabase)
+	mux.With(audit("mysql.delete_database", "db_name"), can(rbac.DatabaseDelete)).Delete("/mysql/delete-database", handlerRepo.MySQLManager.DeleteMySQLDatabase)
+	mux.With(audit("mysql.reset_database", "db_name"), can(rbac.DatabaseDelete)).Delete("/mysql/reset-database", handlerRepo.MySQLManager.ResetMySQLDatabase)
+	mux.With(can(rbac.DatabaseRead)).Get("/mysql/users", handlerRepo.MySQLManager.ListMySQLUsers)
+	mux.With(audit("mysql.create_user", "database_user", "username"), can(rbac.DatabaseWrite)).Post("/mysql/create-user", handlerRepo.MySQLManager.CreateMySQLUser)
 	// mux.Patch("/mysql/grant", handlerRepo.DB.GrantPrivileges)
 
 	// ======== PostgreSQL Management Routes ========
 	// Sensitive / requires DSN → POST
-	mux.Get("/postgresql/databases", handlerRepo.PostgreSQLManager.ListPostgreSQLDatabases)
-	mux.Post("/postgresql/create-database", handlerRepo.PostgreSQLManager.CreatePostgreSQLDatabase)
-	mux.Post("/postgresql/import-database", handlerRepo.PostgreSQLManager.ImportPostgreSQLDatabase)
-	mux.Delete("/postgresql/delete-database", handlerRepo.PostgreSQLManager.DeletePostgreSQLDatabase)
-	mux.Delete("/postgresql/reset-database", handlerRepo.PostgreSQLManager.ResetPostgreSQLDatabase)
-	mux.Get("/postgresql/users", handlerRepo.PostgreSQLManager.ListPostgreSQLUsers)
-	mux.Post("/postgresql/create-user", handlerRepo.PostgreSQLManager.CreatePostgreSQLUser)
+	mux.With(can(rbac.DatabaseRead)).Get("/postgresql/databases", handlerRepo.PostgreSQLManager.ListPostgreSQLDatabases)
+	mux.With(audit("postgresql.create_database", "database_name"), can(rbac.DatabaseWrite)).Post("/postgresql/create-database", handlerRepo.PostgreSQLManager.CreatePostgreSQLDatabase)
+	mux.With(audit("postgresql.import_database", "dbName"), can(rbac.DatabaseWrite)).Post("/postgresql/import-database", handlerRepo.PostgreSQLManager.ImportPostgreSQLDatabase)
+	mux.With(audit("postgresql.delete_database", "db_name"), can(rbac.DatabaseDelete)).Delete("/postgresql/delete-database", handlerRepo.PostgreSQLManager.DeletePostgreSQLDatabase)
+	mux.With(audit("postgresql.reset_database", "db_name"), can(rbac.DatabaseDelete)).Delete("/postgresql/reset-database", handlerRepo.PostgreSQLManager.ResetPostgreSQLDatabase)
+	mux.With(can(rbac.DatabaseRead)).Get("/postgresql/users", handlerRepo.PostgreSQLManager.ListPostgreSQLUsers)
+	mux.With(audit("postgresql.create_user", "database_user", "username"), can(rbac.DatabaseWrite)).Post("/postgresql/create-user", handlerRepo.PostgreSQLManager.CreatePostgreSQLUser)
 	// mux.Patch("/postgresql/grant", handlerRepo.DB.GrantPrivileges)
 
 	return mux
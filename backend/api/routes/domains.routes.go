@@ -1,6 +1,9 @@
 package routes
 
-import "github.com/go-chi/chi/v5"
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+)
 
 func domainHandlerRoutes() *chi.Mux {
 	mux := chi.NewRouter()
@@ -8,19 +11,19 @@ func domainHandlerRoutes() *chi.Mux {
 	// ======== Domain Handler Routes ========
 
 	// Create a new domain
-	mux.Post("/create", handlerRepo.DomainHandler.CreateDomain)
+	mux.With(audit("domain.create", "domain"), can(rbac.DomainWrite)).Post("/create", handlerRepo.DomainHandler.CreateDomain)
 
 	// Update entire domain record (domain name + SSL update date)
-	mux.Put("/update", handlerRepo.DomainHandler.UpdateDomain) //query parameter : domain_id
+	mux.With(audit("domain.update", "domain_id", "domain"), can(rbac.DomainWrite)).Put("/update", handlerRepo.DomainHandler.UpdateDomain) //query parameter : domain_id
 
 	// Update only the domain name
-	mux.Put("/update/name", handlerRepo.DomainHandler.UpdateDomainName) //query parameter : domain_id
+	mux.With(audit("domain.rename", "domain_id", "domain"), can(rbac.DomainWrite)).Put("/update/name", handlerRepo.DomainHandler.UpdateDomainName) //query parameter : domain_id
 
 	// Delete a domain by ID
-	mux.Delete("/remove", handlerRepo.DomainHandler.DeleteDomain) //query parameter : domain_id
+	mux.With(audit("domain.delete", "domain_id"), can(rbac.DomainDelete)).Delete("/remove", handlerRepo.DomainHandler.DeleteDomain) //query parameter : domain_id
 
 	// List all domains
-	mux.Get("/list", handlerRepo.DomainHandler.ListDomains)
+	mux.With(can(rbac.DomainRead)).Get("/list", handlerRepo.DomainHandler.ListDomains)
 
 	return mux
 }
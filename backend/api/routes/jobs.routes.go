@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/api/middlewares"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+)
+
+func jobHandlerRoutes() *chi.Mux {
+	mux := chi.NewRouter()
+
+	// ======== Background Job Routes ========
+	// query parameters: page, limit, status, type, target
+	mux.With(can(rbac.JobRead)).Get("/list", handlerRepo.Job.ListJobs)
+
+	// query parameter: job_id
+	mux.With(can(rbac.JobRead)).Get("/get", handlerRepo.Job.GetJob)
+
+	// Stop a queued or running job
+	// query parameter: job_id
+	mux.With(audit("job.cancel", "job_id"), can(rbac.JobCancel)).Post("/cancel", handlerRepo.Job.CancelJob)
+
+	// Output of a job, line by line
+	// query parameters: job_id, after
+	mux.With(can(rbac.JobRead)).Get("/log", handlerRepo.Job.GetJobLog)
+
+	// One-time ticket for /log/stream, for browsers that cannot set headers on a WebSocket
+	// query parameter: job_id, response: {error, message, ticket, expiresIn}
+	mux.With(middlewares.RequireUserSession, can(rbac.JobRead)).Post("/log/ticket", handlerRepo.Job.IssueJobLogTicket)
+
+	return mux
+}
+
+// jobLogStreamRoutes serves live job output over a WebSocket. It sits outside the bearer-only
+// group: the handshake is authenticated by a ticket from /log/ticket, or by the usual headers.
+// query parameters: job_id, ticket
+func jobLogStreamRoutes(auth *middlewares.Auth) *chi.Mux {
+	mux := chi.NewRouter()
+	mux.With(auth.AuthTicket(models.TokenPurposeJobLog), can(rbac.JobRead)).Get("/", handlerRepo.Job.StreamJobLog)
+	return mux
+}
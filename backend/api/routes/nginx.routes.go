@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+)
+
+func nginxHandlerRoutes() *chi.Mux {
+	mux := chi.NewRouter()
+
+	// ======== Nginx Inventory Routes ========
+	// What sites-enabled holds compared with the projects and domains of the panel:
+	// orphaned configs, projects missing a config, broken symlinks, server_name collisions
+	mux.With(can(rbac.NginxRead)).Get("/drift", handlerRepo.Nginx.DriftReport)
+
+	// ======== Per-site Settings Routes ========
+	// Custom rules of a project, rendered into its vhost on every deploy
+	// query parameter: project_id
+	mux.With(can(rbac.ProjectRead)).Get("/settings", handlerRepo.Nginx.GetSettings)
+
+	// Checked with nginx -t before they are saved
+	// query parameter: project_id
+	// request body: {redirects: [{from, to, code, regex}], clientMaxBodySize, snippet, spaFallback}
+	mux.With(audit("nginx.settings", "project_id"), can(rbac.ProjectDeploy)).Put("/settings", handlerRepo.Nginx.SaveSettings)
+
+	return mux
+}
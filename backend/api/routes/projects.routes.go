@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
 )
 
 func projectHandlerRoutes() *chi.Mux {
@@ -10,36 +11,110 @@ func projectHandlerRoutes() *chi.Mux {
 	// ======== PHP Project Routes ========
 	//Initiate a php project
 	// request body: {domainName, dbName}, response: {error, message, summary}
-	mux.Post("/php/init", handlerRepo.PHP.InitProject)
+	mux.With(audit("php.init", "domainName"), can(rbac.ProjectDeploy)).Post("/php/init", handlerRepo.PHP.InitProject)
 
 	// Upload project folder to the project directory
-	// request body: {projectName, projectID, filename, chunkIndex, totalChunks}, response: {error, message}
-	mux.Post("/php/upload-project-file", handlerRepo.PHP.UploadProjectFile)
+	// request body: {projectID, filename, chunkIndex, totalChunks}, response: {error, message}
+	mux.With(audit("php.upload", "projectID"), can(rbac.ProjectDeploy)).Post("/php/upload-project-file", handlerRepo.PHP.UploadProjectFile)
 
 	// Deploy the project(php-fpm setup, dependency installation, nginx server block setup)
-	mux.Post("/php/deploy", handlerRepo.PHP.DeploySite)
-
-	// Delete PHP project.
-	//query parameter project_id
-	// mux.Post("/php/delete", handlerRepo.PHP.DeleteSite)
+	// request body: {projectID, projectFramework}
+	mux.With(audit("php.deploy", "projectID"), can(rbac.ProjectDeploy)).Post("/php/deploy", handlerRepo.PHP.DeploySite)
 
 	// List all projects
-	// mux.Get("/php/list", handlerRepo.PHP.ListProjects)
+	// mux.With(can(rbac.ProjectRead)).Get("/php/list", handlerRepo.PHP.ListProjects)
+
+	// ======== Site Routes ========
+	// Work for every framework through its deployer
+	// response: {error, message, frameworks}
+	mux.With(can(rbac.ProjectRead)).Get("/frameworks", handlerRepo.Site.ListFrameworks)
+
+	// query parameter: project_id, response: {error, message, siteStatus, site}
+	mux.With(can(rbac.ProjectRead)).Get("/status", handlerRepo.Site.GetSiteStatus)
+
+	// query parameter: project_id
+	mux.With(audit("site.suspend", "project_id"), can(rbac.ProjectDeploy)).Post("/suspend", handlerRepo.Site.SuspendSite)
+
+	// query parameter: project_id
+	mux.With(audit("site.restart", "project_id"), can(rbac.ProjectDeploy)).Post("/restart", handlerRepo.Site.RestartSite)
+
+	// query parameter: project_id
+	mux.With(audit("site.delete", "project_id"), can(rbac.ProjectDelete)).Post("/delete", handlerRepo.Site.DeleteSite)
+
+	// ======== Application Routes ========
+	// Projects of the App and Python frameworks, deployed through /php/deploy like the others
+	// query parameter: project_id, response: {error, message, app}
+	mux.With(can(rbac.ProjectRead)).Get("/app", handlerRepo.App.GetApp)
+
+	// query parameter: project_id, req body {command, workingDir, env, restart, runAs}
+	mux.With(audit("app.save", "project_id"), can(rbac.ProjectDeploy)).Post("/app", handlerRepo.App.SaveApp)
+
+	// query parameter: project_id, response: {error, message, app}
+	mux.With(can(rbac.ProjectRead)).Get("/app/python", handlerRepo.App.GetPythonApp)
+
+	// query parameter: project_id, req body {module, server, workers, env, staticUrl, staticDir, migrate, collectStatic}
+	mux.With(audit("app.python.save", "project_id"), can(rbac.ProjectDeploy)).Post("/app/python", handlerRepo.App.SavePythonApp)
+
+	// Work for App and Python projects
+	// query parameter: project_id
+	mux.With(audit("app.start", "project_id"), can(rbac.ProjectDeploy)).Post("/app/start", handlerRepo.App.StartApp)
+
+	// query parameter: project_id
+	mux.With(audit("app.stop", "project_id"), can(rbac.ProjectDeploy)).Post("/app/stop", handlerRepo.App.StopApp)
+
+	// query parameter: project_id
+	mux.With(audit("app.restart", "project_id"), can(rbac.ProjectDeploy)).Post("/app/restart", handlerRepo.App.RestartApp)
+
+	// query parameter: project_id, response: {error, message, service}
+	mux.With(can(rbac.ProjectRead)).Get("/app/status", handlerRepo.App.GetAppStatus)
+
+	// query parameters: project_id, lines, since, response: {error, message, logs}
+	mux.With(can(rbac.ProjectRead)).Get("/app/logs", handlerRepo.App.GetAppLogs)
 
 	// ======== Wordpress Project Routes ========
 	// req body {domainName, dbName}
-	mux.Post("/wordpress/deploy", handlerRepo.WordPress.DeploySite)
+	mux.With(audit("wordpress.deploy", "domainName"), can(rbac.ProjectDeploy)).Post("/wordpress/deploy", handlerRepo.WordPress.DeploySite)
+
+	// query parameter: project_id
+	mux.With(can(rbac.ProjectRead)).Post("/wordpress/get-status", handlerRepo.Site.GetSiteStatus)
 
 	// query parameter: project_id
-	mux.Post("/wordpress/get-status", handlerRepo.WordPress.GetSiteStatus)
+	mux.With(audit("wordpress.suspend", "project_id"), can(rbac.ProjectDeploy)).Post("/wordpress/suspend", handlerRepo.Site.SuspendSite)
 
 	// query parameter: project_id
-	mux.Post("/wordpress/suspend", handlerRepo.WordPress.SuspendSite)
+	mux.With(audit("wordpress.restart", "project_id"), can(rbac.ProjectDeploy)).Post("/wordpress/restart", handlerRepo.Site.RestartSite)
 
 	// query parameter: project_id
-	mux.Post("/wordpress/restart", handlerRepo.WordPress.RestartSite)
+	mux.With(audit("wordpress.delete", "project_id"), can(rbac.ProjectDelete)).Post("/wordpress/delete", handlerRepo.Site.DeleteSite)
+
+	// ======== Site Access Routes ========
+	// Basic auth users and allow/deny address rules of a project's site
+	// query parameter: project_id, response: {error, message, rules, users}
+	mux.With(can(rbac.ProjectRead)).Get("/access", handlerRepo.Nginx.GetAccess)
+
+	// Rendered into the vhost on the next deploy
+	// query parameter: project_id
+	// request body: {path, basicAuth, allow, deny, satisfyAny}
+	mux.With(audit("access.rule.save", "project_id"), can(rbac.ProjectDeploy)).Post("/access/rules", handlerRepo.Nginx.SaveAccessRule)
+
+	// query parameter: project_id, rule_id
+	mux.With(audit("access.rule.delete", "project_id"), can(rbac.ProjectDeploy)).Post("/access/rules/delete", handlerRepo.Nginx.DeleteAccessRule)
+
+	// Takes effect at once: the htpasswd file is rewritten
+	// query parameter: project_id
+	// request body: {username, password}
+	mux.With(audit("access.user.save", "project_id"), can(rbac.ProjectDeploy)).Post("/access/users", handlerRepo.Nginx.SaveAccessUser)
+
+	// query parameter: project_id, username
+	mux.With(audit("access.user.delete", "project_id"), can(rbac.ProjectDeploy)).Post("/access/users/delete", handlerRepo.Nginx.DeleteAccessUser)
+
+	// ======== Maintenance Mode Routes ========
+	// Works for every framework; the site keeps running for the allowed addresses
+	// query parameter: project_id, response: {error, message, maintenance}
+	mux.With(can(rbac.ProjectRead)).Get("/maintenance", handlerRepo.Nginx.GetMaintenance)
 
 	// query parameter: project_id
-	mux.Post("/wordpress/delete", handlerRepo.WordPress.DeleteSite)
+	// request body: {enabled, retryAfter, pageHtml, allowIps}
+	mux.With(audit("maintenance.set", "project_id"), can(rbac.ProjectDeploy)).Post("/maintenance", handlerRepo.Nginx.SetMaintenance)
 	return mux
 }
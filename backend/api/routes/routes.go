@@ -4,7 +4,6 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"path/filepath"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -12,12 +11,28 @@ import (
 	"github.com/projuktisheba/vpanel/backend/api/middlewares"
 	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+	"github.com/projuktisheba/vpanel/backend/internal/services/jobs"
 	"github.com/projuktisheba/vpanel/backend/internal/utils"
 )
 
 var handlerRepo *handlers.HandlerRepo
 
-func Routes(host, env string, db *dbrepo.DBRepository, jwt models.JWTConfig, infoLogger, errorLogger *log.Logger, mysqlRootDSN, postgresqlRootDSN string) http.Handler {
+// auditor writes the audit trail for routes wrapped with audit()
+var auditor *middlewares.Auditor
+
+// can restricts a route to roles holding permission p (see rbac for the role mapping)
+func can(p rbac.Permission) func(http.Handler) http.Handler {
+	return middlewares.RequirePermission(p)
+}
+
+// audit records every request to the route as action; the target is read from the first
+// non-empty parameter among targetKeys. Put it before can() so denied attempts are recorded too.
+func audit(action string, targetKeys ...string) func(http.Handler) http.Handler {
+	return auditor.Record(action, targetKeys...)
+}
+
+func Routes(host, env string, db *dbrepo.DBRepository, queue *jobs.Queue, jwt models.JWTConfig, security models.SecurityConfig, infoLogger, errorLogger *log.Logger, mysqlRootDSN, postgresqlRootDSN string) http.Handler {
 	mux := chi.NewRouter()
 
 	// --- Global middlewares ---
@@ -25,14 +40,14 @@ func Routes(host, env string, db *dbrepo.DBRepository, jwt models.JWTConfig, inf
 		// AllowedOrigins:   []string{"https://vpanel.pssoft.xyz"},
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Branch-ID"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Branch-ID", "X-API-Key"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 	mux.Use(middlewares.Logger) // logger
 
 	// --- Static file serving for images ---
-	imageDir := filepath.Join(".", "data", "images")
+	imageDir := utils.GetImageDirectory()
 	fs := http.StripPrefix("/api/v1/images/", http.FileServer(http.Dir(imageDir)))
 	mux.Handle("/api/v1/images/*", fs)
 
@@ -51,23 +66,57 @@ func Routes(host, env string, db *dbrepo.DBRepository, jwt models.JWTConfig, inf
 	})
 
 	//get the handler repo
-	handlerRepo = handlers.NewHandlerRepo(host, db, jwt, infoLogger, errorLogger, mysqlRootDSN, postgresqlRootDSN)
+	handlerRepo = handlers.NewHandlerRepo(host, db, queue, jwt, security, infoLogger, errorLogger, mysqlRootDSN, postgresqlRootDSN)
+
+	// Validates bearer tokens (checked against the revocation list) and API keys
+	auth := middlewares.NewAuth(jwt, security, db.Token, db.APIKey, errorLogger)
+
+	// Records mutating requests to the audit trail
+	auditor = middlewares.NewAuditor(db.Audit, security, errorLogger)
 
 	// Mount Auth routes
-	mux.Mount("/api/v1/auth", authRoutes())
+	mux.Mount("/api/v1/auth", authRoutes(auth))
+
+	// Mount the live job log stream (ticket or header authentication)
+	mux.Mount("/api/v1/jobs/log/stream", jobLogStreamRoutes(auth))
 
 	// =========== Secure Routes ===========
-	// Mount database registry routes
-	mux.Mount("/api/v1/db", databaseRegistryRoutes())
+	// Every group mounted here requires a valid bearer token
+	mux.Group(func(secure chi.Router) {
+		secure.Use(auth.AuthUser)
 
-	// Mount project handler routes
-	mux.Mount("/api/v1/project", projectHandlerRoutes())
+		// Mount database registry routes
+		secure.Mount("/api/v1/db", databaseRegistryRoutes())
 
-	// Mount domain handler routes
-	mux.Mount("/api/v1/domain", domainHandlerRoutes())
+		// Mount project handler routes
+		secure.Mount("/api/v1/project", projectHandlerRoutes())
 
-	// Mount ssl handler routes
-	mux.Mount("/api/v1/ssl", sslHandlerRoutes())
+		// Mount domain handler routes
+		secure.Mount("/api/v1/domain", domainHandlerRoutes())
+
+		// Mount ssl handler routes
+		secure.Mount("/api/v1/ssl", sslHandlerRoutes())
+
+		// Mount audit trail routes
+		secure.Mount("/api/v1/audit", auditHandlerRoutes())
+
+		// Mount background job routes
+		secure.Mount("/api/v1/jobs", jobHandlerRoutes())
+
+		// Mount nginx inventory routes
+		secure.Mount("/api/v1/nginx", nginxHandlerRoutes())
+
+		// Account-level routes are for signed-in users only, never API keys
+		secure.Group(func(session chi.Router) {
+			session.Use(middlewares.RequireUserSession)
+
+			// Mount panel user management routes
+			session.Mount("/api/v1/users", userHandlerRoutes())
+
+			// Mount API key routes
+			session.Mount("/api/v1/api-keys", apiKeyHandlerRoutes())
+		})
+	})
 
 	return mux
 }
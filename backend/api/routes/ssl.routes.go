@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
 )
 
 func sslHandlerRoutes() *chi.Mux {
@@ -12,20 +13,20 @@ func sslHandlerRoutes() *chi.Mux {
 	// GET /ssl/check?domain=example.com
 	// Returns: JSON { error, message, ssl_status }
 	// ---------------------------------------------
-	mux.Get("/check", handlerRepo.SSLHandler.CheckSSL)
+	mux.With(can(rbac.SSLRead)).Get("/check", handlerRepo.SSLHandler.CheckSSL)
 
 	// ---------------------------------------------
 	// Route 2: Check SSL and automatically issue if not present
 	// GET /ssl/check-and-issue?domain=example.com
 	// Returns: JSON { error, message, ssl_status }
 	// ---------------------------------------------
-	mux.Get("/check-and-issue", handlerRepo.SSLHandler.CheckAndIssueSSL)
+	mux.With(audit("ssl.check_and_issue", "domain"), can(rbac.SSLIssue)).Get("/check-and-issue", handlerRepo.SSLHandler.CheckAndIssueSSL)
 
 	// ---------------------------------------------
 	// Route 3: Force issue SSL for a domain (even if exists)
 	// GET /ssl/issue?domain=example.com
 	// Returns: JSON { error, message, ssl_status }
 	// ---------------------------------------------
-	mux.Get("/issue", handlerRepo.SSLHandler.IssueSSL)
+	mux.With(audit("ssl.issue", "domain"), can(rbac.SSLIssue)).Get("/issue", handlerRepo.SSLHandler.IssueSSL)
 	return mux
 }
@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/rbac"
+)
+
+func userHandlerRoutes() *chi.Mux {
+	mux := chi.NewRouter()
+
+	// ======== Own Account Routes (any signed-in user) ========
+	mux.Get("/me", handlerRepo.User.GetProfile)
+
+	// request body: {currentPassword, newPassword}
+	mux.With(audit("user.change_own_password")).Put("/me/change-password", handlerRepo.User.ChangeOwnPassword)
+
+	// multipart field: avatar
+	mux.With(audit("user.upload_own_avatar")).Post("/me/upload-avatar", handlerRepo.User.UploadOwnAvatar)
+
+	// ======== User Management Routes ========
+	// request body: {name, role, status, mobile, email, password, address}
+	mux.With(audit("user.create", "email", "mobile"), can(rbac.UserManage)).Post("/create", handlerRepo.User.CreateUser)
+
+	// query parameters: page, limit, role, status, sort_by, sort_order
+	mux.With(can(rbac.UserManage)).Get("/list", handlerRepo.User.ListUsers)
+
+	// query parameter: user_id, request body: {name, role, status, mobile, email, address}
+	mux.With(audit("user.update", "user_id"), can(rbac.UserManage)).Put("/update", handlerRepo.User.UpdateUser)
+
+	// query parameter: user_id
+	mux.With(audit("user.deactivate", "user_id"), can(rbac.UserManage)).Patch("/deactivate", handlerRepo.User.DeactivateUser)
+
+	// query parameter: user_id, request body: {newPassword}
+	mux.With(audit("user.change_password", "user_id"), can(rbac.UserManage)).Put("/change-password", handlerRepo.User.ChangeUserPassword)
+
+	// query parameter: user_id, multipart field: avatar
+	mux.With(audit("user.upload_avatar", "user_id"), can(rbac.UserManage)).Post("/upload-avatar", handlerRepo.User.UploadUserAvatar)
+
+	return mux
+}
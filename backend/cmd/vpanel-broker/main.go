@@ -0,0 +1,77 @@
+// Command vpanel-broker performs the privileged operations of the panel, so the API
+// server can run as an unprivileged user. It must run as root:
+//
+//	sudo vpanel-broker -user <panel user> [-socket /run/vpanel/broker.sock]
+//
+// and the API server started with BROKER_SOCKET pointing at the same socket (the default
+// one when unset).
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/projuktisheba/vpanel/backend/internal/config"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+func main() {
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	socket := flag.String("socket", envOr("BROKER_SOCKET", broker.DefaultSocket), "unix socket to listen on")
+	panelUser := flag.String("user", os.Getenv("BROKER_PANEL_USER"), "user the API server runs as (the only one allowed to connect)")
+	flag.Parse()
+
+	if os.Geteuid() != 0 {
+		errorLog.Fatal("vpanel-broker must run as root")
+	}
+	if *panelUser == "" {
+		errorLog.Fatal("-user is required")
+	}
+	u, err := user.Lookup(*panelUser)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// the broker runs as root, so the panel directory defaults to the panel user's home
+	l := config.LoadLayout()
+	if os.Getenv("LAYOUT_PANEL_DIR") == "" {
+		l.PanelDir = u.HomeDir + "/projuktisheba"
+	}
+	layout.Set(l)
+
+	// the project directories the panel deploys into (see utils.GetWordpressProjectDirectory)
+	roots := []string{layout.Panel("bin")}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := broker.NewServer(*socket, uid, gid, u.Username, roots, infoLog, errorLog)
+	if err := srv.ListenAndServe(ctx); err != nil {
+		errorLog.Fatal(err)
+	}
+	infoLog.Println("Broker stopped")
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -1,12 +1,18 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/totp"
+	"github.com/projuktisheba/vpanel/backend/internal/utils"
 )
 
 func Load() (models.Config, error) {
@@ -64,5 +70,88 @@ func Load() (models.Config, error) {
 	cfg.DB.MySQLRootDSN = os.Getenv("MYSQL_ROOT_DSN")
 	cfg.DB.PostgreSQLRootDSN = os.Getenv("POSTGRESQL_ROOT_DSN")
 
+	// Sign-in security settings
+	cfg.Security.TrustedProxies, err = utils.ParseIPNets(strings.Split(os.Getenv("TRUSTED_PROXIES"), ","))
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.Security.MaxLoginFailures = 5 // Default lockout threshold
+	if maxFailures := os.Getenv("LOGIN_MAX_FAILURES"); maxFailures != "" {
+		n, err := strconv.Atoi(maxFailures)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Security.MaxLoginFailures = n
+	}
+
+	// Key of the TOTP secrets at rest; kept out of the database they are stored in
+	if key := os.Getenv("TOTP_ENCRYPTION_KEY"); key != "" {
+		cfg.Security.TOTPKey, err = totp.ParseKey(key)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	// Privilege broker (cmd/vpanel-broker); sudo only runs privileged operations when asked for
+	cfg.BrokerSocket = os.Getenv("BROKER_SOCKET")
+	if cfg.BrokerSocket == "" {
+		cfg.BrokerSocket = broker.DefaultSocket
+	}
+	switch mode := os.Getenv("PRIVILEGE_MODE"); mode {
+	case "", "broker":
+	case "sudo":
+		cfg.SudoFallback = true
+	default:
+		return cfg, fmt.Errorf("PRIVILEGE_MODE must be broker or sudo, not %q", mode)
+	}
+
+	// Host filesystem layout
+	cfg.Layout = LoadLayout()
+
+	cfg.Security.LockoutDuration = 15 * time.Minute // Default lockout duration
+	if lockout := os.Getenv("LOGIN_LOCKOUT_DURATION"); lockout != "" {
+		dur, err := time.ParseDuration(lockout)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Security.LockoutDuration = dur
+	}
+
 	return cfg, nil
 }
+
+// LoadLayout returns the Debian/Ubuntu filesystem layout with the overrides set in the
+// environment. SANDBOX_ROOT prefixes every path, e.g. to run the panel against a temp
+// directory on a laptop or in integration tests.
+func LoadLayout() models.LayoutConfig {
+	l := layout.Default()
+	l.Root = os.Getenv("SANDBOX_ROOT")
+
+	overrides := []struct {
+		env   string
+		field *string
+	}{
+		{"LAYOUT_NGINX_SITES_AVAILABLE", &l.NginxSitesAvailable},
+		{"LAYOUT_NGINX_SITES_ENABLED", &l.NginxSitesEnabled},
+		{"LAYOUT_NGINX_HTPASSWD_DIR", &l.NginxHtpasswdDir},
+		{"LAYOUT_NGINX_MAINTENANCE_DIR", &l.NginxMaintenanceDir},
+		{"LAYOUT_SYSTEMD_DIR", &l.SystemdDir},
+		{"LAYOUT_PHP_CONF_DIR", &l.PHPConfDir},
+		{"LAYOUT_PHP_RUN_DIR", &l.PHPRunDir},
+		{"LAYOUT_RUN_DIR", &l.RunDir},
+		{"LAYOUT_LOG_DIR", &l.LogDir},
+		{"LAYOUT_LETSENCRYPT_DIR", &l.LetsEncryptDir},
+		{"LAYOUT_SSL_DIR", &l.SSLDir},
+		{"LAYOUT_HOME_DIR", &l.HomeDir},
+		{"LAYOUT_PANEL_DIR", &l.PanelDir},
+		{"LAYOUT_DATA_DIR", &l.DataDir},
+		{"LAYOUT_TEMP_DIR", &l.TempDir},
+	}
+	for _, o := range overrides {
+		if v := strings.TrimSpace(os.Getenv(o.env)); v != "" {
+			*o.field = v
+		}
+	}
+	return l
+}
@@ -0,0 +1,125 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+// ErrAPIKeyNotFound is returned for unknown, revoked or expired keys
+var ErrAPIKeyNotFound = errors.New("invalid or expired API key")
+
+// ============================== API Key Repository ==============================
+type APIKeyRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepo(db *pgxpool.Pool) *APIKeyRepo {
+	return &APIKeyRepo{db: db}
+}
+
+// CreateAPIKey stores a new key (hash only)
+func (r *APIKeyRepo) CreateAPIKey(ctx context.Context, k *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(ctx, query,
+		k.UserID, k.Name, k.Prefix, k.KeyHash, k.Scopes, k.AllowedIPs, k.ExpiresAt,
+	).Scan(&k.ID, &k.CreatedAt)
+}
+
+// GetActiveAPIKey looks up a usable key by hash together with its owner's role and status
+func (r *APIKeyRepo) GetActiveAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.scopes, k.allowed_ips, k.expires_at,
+			k.last_used_at, k.last_used_ip, k.created_at,
+			u.name, u.email, u.role, u.status
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
+			AND k.revoked_at IS NULL
+			AND (k.expires_at IS NULL OR k.expires_at > CURRENT_TIMESTAMP)
+	`
+	k := &models.APIKey{}
+	err := r.db.QueryRow(ctx, query, keyHash).Scan(
+		&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Scopes, &k.AllowedIPs, &k.ExpiresAt,
+		&k.LastUsedAt, &k.LastUsedIP, &k.CreatedAt,
+		&k.OwnerName, &k.OwnerEmail, &k.OwnerRole, &k.OwnerStatus,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+// TouchAPIKey records when and from where a key was last used.
+// It writes at most once a minute per key so busy pipelines don't turn every request into an UPDATE.
+func (r *APIKeyRepo) TouchAPIKey(ctx context.Context, id int64, ip string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+	`, id, ip)
+	return err
+}
+
+// ListAPIKeys returns the keys of a user (every user when userID is 0), newest first
+func (r *APIKeyRepo) ListAPIKeys(ctx context.Context, userID int64) ([]*models.APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, allowed_ips, expires_at,
+			last_used_at, last_used_ip, revoked_at, created_at
+		FROM api_keys
+	`
+	args := []interface{}{}
+	if userID > 0 {
+		query += ` WHERE user_id = $1`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY created_at DESC, id DESC`
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		k := &models.APIKey{}
+		if err := rows.Scan(
+			&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Scopes, &k.AllowedIPs, &k.ExpiresAt,
+			&k.LastUsedAt, &k.LastUsedIP, &k.RevokedAt, &k.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a key. When userID is not 0 the key must belong to that user.
+func (r *APIKeyRepo) RevokeAPIKey(ctx context.Context, id, userID int64) error {
+	query := `UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL`
+	args := []interface{}{id}
+	if userID > 0 {
+		query += ` AND user_id = $2`
+		args = append(args, userID)
+	}
+
+	cmd, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return fmt.Errorf("no active API key found with id %d", id)
+	}
+	return nil
+}
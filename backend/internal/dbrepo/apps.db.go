@@ -0,0 +1,150 @@
+package dbrepo
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+// ============================== Project Apps Repository ==============================
+type AppRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAppRepo(db *pgxpool.Pool) *AppRepo {
+	return &AppRepo{db: db}
+}
+
+// GetProjectApp returns the application of a project, pgx.ErrNoRows when it has none
+func (r *AppRepo) GetProjectApp(ctx context.Context, projectID int64) (*models.ProjectApp, error) {
+	query := `
+		SELECT project_id, command, working_dir, env, restart_policy, run_as, port, updated_at
+		FROM project_apps
+		WHERE project_id = $1
+	`
+	a := &models.ProjectApp{}
+	var env []byte
+	err := r.db.QueryRow(ctx, query, projectID).Scan(
+		&a.ProjectID,
+		&a.Command,
+		&a.WorkingDir,
+		&env,
+		&a.Restart,
+		&a.RunAs,
+		&a.Port,
+		&a.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(env, &a.Env); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SaveProjectApp creates or replaces the application of a project. Another project
+// holding the same port fails with a unique violation.
+func (r *AppRepo) SaveProjectApp(ctx context.Context, a *models.ProjectApp) error {
+	query := `
+		INSERT INTO project_apps (project_id, command, working_dir, env, restart_policy, run_as, port, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+		ON CONFLICT (project_id) DO UPDATE SET
+			command = EXCLUDED.command,
+			working_dir = EXCLUDED.working_dir,
+			env = EXCLUDED.env,
+			restart_policy = EXCLUDED.restart_policy,
+			run_as = EXCLUDED.run_as,
+			port = EXCLUDED.port,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`
+	if a.Env == nil {
+		a.Env = map[string]string{}
+	}
+	env, err := json.Marshal(a.Env)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRow(ctx, query, a.ProjectID, a.Command, a.WorkingDir, string(env), a.Restart, a.RunAs, a.Port).Scan(&a.UpdatedAt)
+}
+
+// ListAppPorts returns the ports held by the applications of all projects
+func (r *AppRepo) ListAppPorts(ctx context.Context) ([]int, error) {
+	rows, err := r.db.Query(ctx, `SELECT port FROM project_apps ORDER BY port`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ports := []int{}
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	return ports, rows.Err()
+}
+
+// GetProjectPythonApp returns the Python application of a project, pgx.ErrNoRows when it
+// has none
+func (r *AppRepo) GetProjectPythonApp(ctx context.Context, projectID int64) (*models.ProjectPythonApp, error) {
+	query := `
+		SELECT project_id, module, server, workers, env, static_url, static_dir, migrate, collect_static, updated_at
+		FROM project_python_apps
+		WHERE project_id = $1
+	`
+	a := &models.ProjectPythonApp{}
+	var env []byte
+	err := r.db.QueryRow(ctx, query, projectID).Scan(
+		&a.ProjectID,
+		&a.Module,
+		&a.Server,
+		&a.Workers,
+		&env,
+		&a.StaticURL,
+		&a.StaticDir,
+		&a.Migrate,
+		&a.CollectStatic,
+		&a.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(env, &a.Env); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SaveProjectPythonApp creates or replaces the Python application of a project
+func (r *AppRepo) SaveProjectPythonApp(ctx context.Context, a *models.ProjectPythonApp) error {
+	query := `
+		INSERT INTO project_python_apps (project_id, module, server, workers, env, static_url, static_dir, migrate, collect_static, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+		ON CONFLICT (project_id) DO UPDATE SET
+			module = EXCLUDED.module,
+			server = EXCLUDED.server,
+			workers = EXCLUDED.workers,
+			env = EXCLUDED.env,
+			static_url = EXCLUDED.static_url,
+			static_dir = EXCLUDED.static_dir,
+			migrate = EXCLUDED.migrate,
+			collect_static = EXCLUDED.collect_static,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`
+	if a.Env == nil {
+		a.Env = map[string]string{}
+	}
+	env, err := json.Marshal(a.Env)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRow(ctx, query, a.ProjectID, a.Module, a.Server, a.Workers, string(env),
+		a.StaticURL, a.StaticDir, a.Migrate, a.CollectStatic).Scan(&a.UpdatedAt)
+}
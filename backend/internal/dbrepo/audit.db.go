@@ -0,0 +1,121 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+// ============================== Audit Repository ==============================
+type AuditRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditRepo(db *pgxpool.Pool) *AuditRepo {
+	return &AuditRepo{db: db}
+}
+
+// RecordAuditEvent appends an event to the audit trail
+func (r *AuditRepo) RecordAuditEvent(ctx context.Context, e *models.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events
+		(actor_user_id, actor_name, actor_role, api_key_id, ip_address, user_agent, action, method, path,
+		 target, params, status_code, outcome, error_message, duration_ms, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+	params := e.Params
+	if len(params) == 0 {
+		params = []byte("{}")
+	}
+	return r.db.QueryRow(ctx, query,
+		e.ActorUserID, e.ActorName, e.ActorRole, e.APIKeyID, e.IPAddress, e.UserAgent, e.Action, e.Method, e.Path,
+		e.Target, string(params), e.StatusCode, e.Outcome, e.ErrorMessage, e.DurationMs,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+// ListAuditEvents returns events matching f, newest first, and the total number of matches.
+// f.Limit <= 0 returns every match (used by export, which caps the limit itself).
+func (r *AuditRepo) ListAuditEvents(ctx context.Context, f models.AuditFilter) ([]*models.AuditEvent, int, error) {
+	where := ` WHERE 1=1`
+	args := []interface{}{}
+	argIdx := 1
+
+	if f.ActorUserID > 0 {
+		where += fmt.Sprintf(" AND actor_user_id = $%d", argIdx)
+		args = append(args, f.ActorUserID)
+		argIdx++
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			where += fmt.Sprintf(" AND starts_with(action, $%d)", argIdx)
+		} else {
+			where += fmt.Sprintf(" AND action = $%d", argIdx)
+		}
+		args = append(args, f.Action)
+		argIdx++
+	}
+	if f.Target != "" {
+		where += fmt.Sprintf(" AND target = $%d", argIdx)
+		args = append(args, f.Target)
+		argIdx++
+	}
+	if f.Outcome != "" {
+		where += fmt.Sprintf(" AND outcome = $%d", argIdx)
+		args = append(args, f.Outcome)
+		argIdx++
+	}
+	if !f.From.IsZero() {
+		where += fmt.Sprintf(" AND created_at >= $%d", argIdx)
+		args = append(args, f.From)
+		argIdx++
+	}
+	if !f.To.IsZero() {
+		where += fmt.Sprintf(" AND created_at < $%d", argIdx)
+		args = append(args, f.To)
+		argIdx++
+	}
+
+	var total int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, actor_user_id, actor_name, actor_role, api_key_id, ip_address, user_agent, action, method, path,
+			target, params, status_code, outcome, error_message, duration_ms, created_at
+		FROM audit_events` + where + ` ORDER BY created_at DESC, id DESC`
+	if f.Limit > 0 {
+		page := f.Page
+		if page <= 0 {
+			page = 1
+		}
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", argIdx, argIdx+1)
+		args = append(args, f.Limit, (page-1)*f.Limit)
+	}
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		var params []byte
+		if err := rows.Scan(
+			&e.ID, &e.ActorUserID, &e.ActorName, &e.ActorRole, &e.APIKeyID, &e.IPAddress, &e.UserAgent,
+			&e.Action, &e.Method, &e.Path, &e.Target, &params, &e.StatusCode, &e.Outcome, &e.ErrorMessage,
+			&e.DurationMs, &e.CreatedAt,
+		); err != nil {
+			return nil, 0, err
+		}
+		e.Params = params
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
@@ -0,0 +1,233 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+// ErrJobNotFound is returned when no job has the requested ID
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobTargetBusy is returned when a job is already queued or running for the same target
+var ErrJobTargetBusy = errors.New("another job is already queued or running for this target")
+
+const jobColumns = `id, type, status, target, payload, result, error, requested_by, attempts, max_attempts,
+	worker_id, started_at, finished_at, created_at, updated_at`
+
+// ============================== Job Repository ==============================
+type JobRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewJobRepo(db *pgxpool.Pool) *JobRepo {
+	return &JobRepo{db: db}
+}
+
+// EnqueueJob stores a new queued job
+func (r *JobRepo) EnqueueJob(ctx context.Context, j *models.Job) error {
+	query := `
+		INSERT INTO jobs (type, status, target, payload, requested_by, max_attempts, created_at, updated_at)
+		VALUES ($1, 'queued', $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, status, created_at, updated_at
+	`
+	payload := j.Payload
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	if j.MaxAttempts <= 0 {
+		j.MaxAttempts = 1
+	}
+	err := r.db.QueryRow(ctx, query, j.Type, j.Target, string(payload), j.RequestedBy, j.MaxAttempts).
+		Scan(&j.ID, &j.Status, &j.CreatedAt, &j.UpdatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation on idx_jobs_active_target
+		return ErrJobTargetBusy
+	}
+	return err
+}
+
+// GetActiveJobForTarget returns the queued or running job for target, or nil
+func (r *JobRepo) GetActiveJobForTarget(ctx context.Context, target string) (*models.Job, error) {
+	j, err := scanJob(r.db.QueryRow(ctx, `
+		SELECT `+jobColumns+` FROM jobs WHERE target = $1 AND status IN ('queued', 'running')
+		ORDER BY id LIMIT 1
+	`, target))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return j, err
+}
+
+// CancelQueuedJob cancels a job that no worker has claimed yet.
+// It reports false when the job is not queued (anymore).
+func (r *JobRepo) CancelQueuedJob(ctx context.Context, id int64, reason string) (bool, error) {
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE jobs SET status = 'cancelled', error = $2, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'queued'
+	`, id, reason)
+	if err != nil {
+		return false, err
+	}
+	return cmd.RowsAffected() > 0, nil
+}
+
+// ClaimNextJob marks the oldest queued job as running on workerID and returns it.
+// It returns nil when the queue is empty. SKIP LOCKED lets several workers claim concurrently.
+func (r *JobRepo) ClaimNextJob(ctx context.Context, workerID string) (*models.Job, error) {
+	query := `
+		UPDATE jobs SET status = 'running', worker_id = $1, attempts = attempts + 1,
+			started_at = CURRENT_TIMESTAMP, finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM jobs WHERE status = 'queued'
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + jobColumns
+	j, err := scanJob(r.db.QueryRow(ctx, query, workerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return j, err
+}
+
+// FinishJob records the final status, result (JSON) and error of a job
+func (r *JobRepo) FinishJob(ctx context.Context, id int64, status string, result []byte, errMsg string) error {
+	var res any
+	if len(result) > 0 {
+		res = string(result)
+	}
+	_, err := r.db.Exec(ctx, `
+		UPDATE jobs SET status = $2, result = $3, error = $4, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, status, res, errMsg)
+	return err
+}
+
+// RecoverJobs handles jobs left running by a panel that stopped.
+// Jobs of a resumable type with attempts left are queued again; the others are marked
+// interrupted and returned so their owners can clean up.
+func (r *JobRepo) RecoverJobs(ctx context.Context, resumableTypes []string) (requeued int64, interrupted []*models.Job, err error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx, `
+		UPDATE jobs SET status = 'queued', worker_id = '', updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'running' AND type = ANY($1) AND attempts < max_attempts
+	`, resumableTypes)
+	if err != nil {
+		return 0, nil, err
+	}
+	requeued = cmd.RowsAffected()
+
+	rows, err := tx.Query(ctx, `
+		UPDATE jobs SET status = 'interrupted', error = 'the panel stopped while the job was running',
+			finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'running'
+		RETURNING `+jobColumns)
+	if err != nil {
+		return 0, nil, err
+	}
+	interrupted, err = collectJobs(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+	return requeued, interrupted, tx.Commit(ctx)
+}
+
+// GetJob returns a job by ID
+func (r *JobRepo) GetJob(ctx context.Context, id int64) (*models.Job, error) {
+	j, err := scanJob(r.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return j, err
+}
+
+// ListJobs returns jobs matching f, newest first, and the total number of matches
+func (r *JobRepo) ListJobs(ctx context.Context, f models.JobFilter) ([]*models.Job, int, error) {
+	where := ` WHERE 1=1`
+	args := []interface{}{}
+	argIdx := 1
+
+	if f.Status != "" {
+		where += fmt.Sprintf(" AND status = $%d", argIdx)
+		args = append(args, f.Status)
+		argIdx++
+	}
+	if f.Type != "" {
+		where += fmt.Sprintf(" AND type = $%d", argIdx)
+		args = append(args, f.Type)
+		argIdx++
+	}
+	if f.Target != "" {
+		where += fmt.Sprintf(" AND target = $%d", argIdx)
+		args = append(args, f.Target)
+		argIdx++
+	}
+
+	var total int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM jobs`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page := f.Page
+	if page <= 0 {
+		page = 1
+	}
+	query := `SELECT ` + jobColumns + ` FROM jobs` + where +
+		fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", argIdx, argIdx+1)
+	args = append(args, f.Limit, (page-1)*f.Limit)
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	jobs, err := collectJobs(rows)
+	return jobs, total, err
+}
+
+// PurgeJobs deletes finished jobs older than the retention period
+func (r *JobRepo) PurgeJobs(ctx context.Context, retention time.Duration) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM jobs WHERE status IN ('succeeded', 'failed', 'interrupted', 'cancelled') AND finished_at < $1
+	`, time.Now().Add(-retention))
+	return err
+}
+
+func scanJob(row pgx.Row) (*models.Job, error) {
+	j := &models.Job{}
+	var payload, result []byte
+	if err := row.Scan(
+		&j.ID, &j.Type, &j.Status, &j.Target, &payload, &result, &j.Error, &j.RequestedBy, &j.Attempts, &j.MaxAttempts,
+		&j.WorkerID, &j.StartedAt, &j.FinishedAt, &j.CreatedAt, &j.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	j.Payload = payload
+	j.Result = result
+	return j, nil
+}
+
+func collectJobs(rows pgx.Rows) ([]*models.Job, error) {
+	defer rows.Close()
+	jobs := []*models.Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,143 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+// ============================== Login Repository ==============================
+// Failed sign-in counters and lockout on the users row, plus the login_attempts log.
+type LoginRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginRepo(db *pgxpool.Pool) *LoginRepo {
+	return &LoginRepo{db: db}
+}
+
+// RecordAttempt appends an entry to the sign-in log
+func (r *LoginRepo) RecordAttempt(ctx context.Context, a *models.LoginAttempt) error {
+	query := `
+		INSERT INTO login_attempts (username, user_id, ip_address, user_agent, success, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(ctx, query,
+		a.Username, a.UserID, a.IPAddress, a.UserAgent, a.Success, a.Reason,
+	).Scan(&a.ID, &a.CreatedAt)
+}
+
+// RegisterFailure increments the user's consecutive failure count and locks the account
+// for lockout once maxFailures is reached. It returns the lock expiry, or nil when not locked.
+// maxFailures <= 0 disables the lockout.
+func (r *LoginRepo) RegisterFailure(ctx context.Context, userID int64, maxFailures int, lockout time.Duration) (*time.Time, error) {
+	query := `
+		UPDATE users
+		SET failed_login_count = failed_login_count + 1,
+			locked_until = CASE
+				WHEN $2 > 0 AND failed_login_count + 1 >= $2 THEN CURRENT_TIMESTAMP + make_interval(secs => $3)
+				ELSE locked_until
+			END
+		WHERE id = $1
+		RETURNING locked_until
+	`
+	var lockedUntil *time.Time
+	err := r.db.QueryRow(ctx, query, userID, maxFailures, lockout.Seconds()).Scan(&lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil != nil && !lockedUntil.After(time.Now()) {
+		return nil, nil
+	}
+	return lockedUntil, nil
+}
+
+// ResetFailures clears the failure count after a successful sign-in
+func (r *LoginRepo) ResetFailures(ctx context.Context, userID int64) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE users SET failed_login_count = 0, locked_until = NULL
+		WHERE id = $1 AND (failed_login_count <> 0 OR locked_until IS NOT NULL)
+	`, userID)
+	return err
+}
+
+// UnlockUser lifts a lockout before it expires
+func (r *LoginRepo) UnlockUser(ctx context.Context, userID int64) error {
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE users SET failed_login_count = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return errors.New("no user found with the given id")
+	}
+	return nil
+}
+
+// PaginatedAttempts lists sign-in attempts, newest first, with optional filters.
+// success may be nil (all), true or false.
+func (r *LoginRepo) PaginatedAttempts(ctx context.Context, page, limit int, username, ip string, userID int64, success *bool) ([]*models.LoginAttempt, int, error) {
+	where := ` WHERE 1=1`
+	args := []interface{}{}
+	argIdx := 1
+
+	if username != "" {
+		where += fmt.Sprintf(" AND username = $%d", argIdx)
+		args = append(args, username)
+		argIdx++
+	}
+	if ip != "" {
+		where += fmt.Sprintf(" AND ip_address = $%d", argIdx)
+		args = append(args, ip)
+		argIdx++
+	}
+	if userID > 0 {
+		where += fmt.Sprintf(" AND user_id = $%d", argIdx)
+		args = append(args, userID)
+		argIdx++
+	}
+	if success != nil {
+		where += fmt.Sprintf(" AND success = $%d", argIdx)
+		args = append(args, *success)
+		argIdx++
+	}
+
+	var total int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM login_attempts`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, username, user_id, ip_address, user_agent, success, reason, created_at
+		FROM login_attempts` + where + fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", argIdx, argIdx+1)
+	args = append(args, limit, (page-1)*limit)
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	attempts := []*models.LoginAttempt{}
+	for rows.Next() {
+		var a models.LoginAttempt
+		if err := rows.Scan(&a.ID, &a.Username, &a.UserID, &a.IPAddress, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		attempts = append(attempts, &a)
+	}
+	return attempts, total, rows.Err()
+}
+
+// PurgeAttempts deletes sign-in attempts older than the retention period
+func (r *LoginRepo) PurgeAttempts(ctx context.Context, retention time.Duration) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM login_attempts WHERE created_at < $1`, time.Now().Add(-retention))
+	return err
+}
@@ -0,0 +1,215 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/totp"
+)
+
+// ============================== MFA Repository ==============================
+// TOTP secrets live on the users row, encrypted with sealer; recovery codes in
+// user_recovery_codes (hashed).
+type MFARepo struct {
+	db     *pgxpool.Pool
+	sealer *totp.Sealer
+}
+
+func NewMFARepo(db *pgxpool.Pool, sealer *totp.Sealer) *MFARepo {
+	return &MFARepo{db: db, sealer: sealer}
+}
+
+// GetTOTP returns the user's TOTP secret (empty when never enrolled) and whether it is enabled
+func (r *MFARepo) GetTOTP(ctx context.Context, userID int64) (string, bool, error) {
+	var secret *string
+	var enabled bool
+	err := r.db.QueryRow(ctx, `SELECT totp_secret, totp_enabled FROM users WHERE id = $1`, userID).Scan(&secret, &enabled)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", false, errors.New("no user found")
+		}
+		return "", false, err
+	}
+	if secret == nil {
+		return "", enabled, nil
+	}
+	plain, err := r.sealer.Open(userID, *secret)
+	if err != nil {
+		return "", false, err
+	}
+	return plain, enabled, nil
+}
+
+// SetPendingTOTPSecret stores a new secret for a user who has not enabled TOTP yet
+func (r *MFARepo) SetPendingTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	sealed, err := r.sealer.Seal(userID, secret)
+	if err != nil {
+		return err
+	}
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE users SET totp_secret = $1, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND totp_enabled = FALSE
+	`, sealed, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return errors.New("two-factor authentication is already enabled")
+	}
+	return nil
+}
+
+// SealPlaintextSecrets encrypts the TOTP secrets stored before they were encrypted at
+// rest. It returns how many it sealed; a secret changed meanwhile is left to its writer.
+func (r *MFARepo) SealPlaintextSecrets(ctx context.Context) (int, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, totp_secret FROM users WHERE totp_secret IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	type stored struct {
+		userID int64
+		secret string
+	}
+	var plaintext []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.userID, &s.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !totp.IsSealed(s.secret) {
+			plaintext = append(plaintext, s)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sealed := 0
+	for _, s := range plaintext {
+		secret, err := r.sealer.Seal(s.userID, s.secret)
+		if err != nil {
+			return sealed, err
+		}
+		cmd, err := r.db.Exec(ctx, `
+			UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_secret = $3
+		`, secret, s.userID, s.secret)
+		if err != nil {
+			return sealed, err
+		}
+		sealed += int(cmd.RowsAffected())
+	}
+	return sealed, nil
+}
+
+// EnableTOTP turns TOTP on and replaces the recovery codes, in one transaction.
+// step is the step of the confirming code, so it cannot be replayed at sign-in.
+func (r *MFARepo) EnableTOTP(ctx context.Context, userID, step int64, recoveryHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx, `
+		UPDATE users SET totp_enabled = TRUE, totp_last_step = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND totp_secret IS NOT NULL AND totp_enabled = FALSE
+	`, step, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return errors.New("no pending two-factor enrollment")
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DisableTOTP turns TOTP off and deletes the secret and every recovery code
+func (r *MFARepo) DisableTOTP(ctx context.Context, userID int64) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep records step as used. It returns false when the same or a later step was already accepted,
+// which makes every code single use even inside its validity window.
+func (r *MFARepo) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND totp_enabled = TRUE AND totp_last_step < $1
+	`, step, userID)
+	if err != nil {
+		return false, err
+	}
+	return cmd.RowsAffected() == 1, nil
+}
+
+// ReplaceRecoveryCodes invalidates every existing recovery code and stores the new hashes
+func (r *MFARepo) ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns false when no such code exists.
+func (r *MFARepo) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return cmd.RowsAffected() == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left
+func (r *MFARepo) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64, recoveryHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO user_recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, CURRENT_TIMESTAMP)
+		`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
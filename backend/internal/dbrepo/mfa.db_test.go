@@ -0,0 +1,153 @@
+package dbrepo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/totp"
+)
+
+// testPool connects to the database of VPANEL_TEST_DB_DSN, in a schema of its own holding
+// the tables of migrations, dropped when the test ends. The test is skipped without it.
+func testPool(t *testing.T, migrations ...string) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("VPANEL_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("VPANEL_TEST_DB_DSN is not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("vpanel_test_%d", time.Now().UnixNano())
+
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.Close)
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE") })
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	for _, name := range migrations {
+		sql, err := os.ReadFile(filepath.Join("..", "..", "migrations", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, string(sql)); err != nil {
+			t.Fatalf("migration %s: %v", name, err)
+		}
+	}
+	return pool
+}
+
+func testMFARepo(t *testing.T) (*MFARepo, *pgxpool.Pool, int64) {
+	t.Helper()
+	pool := testPool(t, "001_users.sql", "008_user_totp.sql")
+	sealer, err := totp.NewSealer(bytes.Repeat([]byte{7}, totp.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID int64
+	if err := pool.QueryRow(context.Background(), `
+		INSERT INTO users (name, mobile) VALUES ('test', '0') RETURNING id
+	`).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	return NewMFARepo(pool, sealer), pool, userID
+}
+
+func TestMFASecretEncryptedAtRest(t *testing.T) {
+	repo, pool, userID := testMFARepo(t)
+	ctx := context.Background()
+	secret, _ := totp.GenerateSecret()
+
+	if err := repo.SetPendingTOTPSecret(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := pool.QueryRow(ctx, `SELECT totp_secret FROM users WHERE id = $1`, userID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == secret || !totp.IsSealed(stored) {
+		t.Fatalf("stored secret %q is not encrypted", stored)
+	}
+	got, enabled, err := repo.GetTOTP(ctx, userID)
+	if err != nil || got != secret || enabled {
+		t.Errorf("GetTOTP = %q, %v, %v, want the pending secret", got, enabled, err)
+	}
+
+	// secrets stored before encryption are sealed in place
+	if _, err := pool.Exec(ctx, `UPDATE users SET totp_secret = $1 WHERE id = $2`, secret, userID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.SealPlaintextSecrets(ctx); err != nil || n != 1 {
+		t.Fatalf("SealPlaintextSecrets = %d, %v, want 1", n, err)
+	}
+	if got, _, err := repo.GetTOTP(ctx, userID); err != nil || got != secret {
+		t.Errorf("GetTOTP after sealing = %q, %v", got, err)
+	}
+	if n, err := repo.SealPlaintextSecrets(ctx); err != nil || n != 0 {
+		t.Errorf("SealPlaintextSecrets again = %d, %v, want 0", n, err)
+	}
+}
+
+func TestMFASingleUse(t *testing.T) {
+	repo, _, userID := testMFARepo(t)
+	ctx := context.Background()
+	secret, _ := totp.GenerateSecret()
+	if err := repo.SetPendingTOTPSecret(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.EnableTOTP(ctx, userID, 100, []string{"hash-a", "hash-b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a recovery code signs in once
+	if ok, err := repo.UseRecoveryCode(ctx, userID, "hash-a"); err != nil || !ok {
+		t.Fatalf("first use of a recovery code = %v, %v", ok, err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, userID, "hash-a"); err != nil || ok {
+		t.Errorf("second use of a recovery code = %v, %v, want refused", ok, err)
+	}
+	if ok, _ := repo.UseRecoveryCode(ctx, userID, "hash-unknown"); ok {
+		t.Error("an unknown recovery code was accepted")
+	}
+	if n, err := repo.CountRecoveryCodes(ctx, userID); err != nil || n != 1 {
+		t.Errorf("CountRecoveryCodes = %d, %v, want 1", n, err)
+	}
+
+	// a step is accepted once, and never one older than the last accepted
+	if ok, _ := repo.UseTOTPStep(ctx, userID, 100); ok {
+		t.Error("the step of the enrollment code was accepted again")
+	}
+	if ok, err := repo.UseTOTPStep(ctx, userID, 101); err != nil || !ok {
+		t.Fatalf("a new step = %v, %v", ok, err)
+	}
+	if ok, _ := repo.UseTOTPStep(ctx, userID, 101); ok {
+		t.Error("a step was accepted twice")
+	}
+
+	// replacing the codes invalidates the unused ones
+	if err := repo.ReplaceRecoveryCodes(ctx, userID, []string{"hash-c"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := repo.UseRecoveryCode(ctx, userID, "hash-b"); ok {
+		t.Error("a replaced recovery code was accepted")
+	}
+}
@@ -0,0 +1,243 @@
+package dbrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+// ============================== Nginx Settings Repository ==============================
+type NginxSettingsRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewNginxSettingsRepo(db *pgxpool.Pool) *NginxSettingsRepo {
+	return &NginxSettingsRepo{db: db}
+}
+
+// GetProjectNginxSettings returns the custom nginx rules of a project. A project that has
+// none gets empty settings.
+func (r *NginxSettingsRepo) GetProjectNginxSettings(ctx context.Context, projectID int64) (*models.ProjectNginxSettings, error) {
+	query := `
+		SELECT project_id, redirects, client_max_body_size, snippet, spa_fallback, updated_at
+		FROM project_nginx_settings
+		WHERE project_id = $1
+	`
+	s := &models.ProjectNginxSettings{ProjectID: projectID, Redirects: []models.NginxRedirect{}}
+	var redirects []byte
+	err := r.db.QueryRow(ctx, query, projectID).Scan(
+		&s.ProjectID,
+		&redirects,
+		&s.ClientMaxBodySize,
+		&s.Snippet,
+		&s.SPAFallback,
+		&s.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, r.loadAccess(ctx, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(redirects, &s.Redirects); err != nil {
+		return nil, err
+	}
+	return s, r.loadAccess(ctx, s)
+}
+
+// SaveProjectNginxSettings creates or replaces the custom nginx rules of a project
+func (r *NginxSettingsRepo) SaveProjectNginxSettings(ctx context.Context, s *models.ProjectNginxSettings) error {
+	query := `
+		INSERT INTO project_nginx_settings (project_id, redirects, client_max_body_size, snippet, spa_fallback, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		ON CONFLICT (project_id) DO UPDATE SET
+			redirects = EXCLUDED.redirects,
+			client_max_body_size = EXCLUDED.client_max_body_size,
+			snippet = EXCLUDED.snippet,
+			spa_fallback = EXCLUDED.spa_fallback,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`
+	if s.Redirects == nil {
+		s.Redirects = []models.NginxRedirect{}
+	}
+	redirects, err := json.Marshal(s.Redirects)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRow(ctx, query, s.ProjectID, string(redirects), s.ClientMaxBodySize, s.Snippet, s.SPAFallback).Scan(&s.UpdatedAt)
+}
+
+func (r *NginxSettingsRepo) loadAccess(ctx context.Context, s *models.ProjectNginxSettings) error {
+	rules, err := r.ListAccessRules(ctx, s.ProjectID)
+	if err != nil {
+		return err
+	}
+	s.Access = rules
+	return nil
+}
+
+// ListAccessRules returns the access rules of a project, ordered by path
+func (r *NginxSettingsRepo) ListAccessRules(ctx context.Context, projectID int64) ([]models.AccessRule, error) {
+	query := `
+		SELECT id, project_id, path, basic_auth, allow_list, deny_list, satisfy_any, created_at
+		FROM project_access_rules
+		WHERE project_id = $1
+		ORDER BY path
+	`
+	rows, err := r.db.Query(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.AccessRule{}
+	for rows.Next() {
+		var a models.AccessRule
+		if err := rows.Scan(
+			&a.ID,
+			&a.ProjectID,
+			&a.Path,
+			&a.BasicAuth,
+			&a.Allow,
+			&a.Deny,
+			&a.SatisfyAny,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		rules = append(rules, a)
+	}
+	return rules, rows.Err()
+}
+
+// SaveAccessRule creates the access rule of a path, or replaces the one the path has
+func (r *NginxSettingsRepo) SaveAccessRule(ctx context.Context, a *models.AccessRule) error {
+	query := `
+		INSERT INTO project_access_rules (project_id, path, basic_auth, allow_list, deny_list, satisfy_any)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (project_id, path) DO UPDATE SET
+			basic_auth = EXCLUDED.basic_auth,
+			allow_list = EXCLUDED.allow_list,
+			deny_list = EXCLUDED.deny_list,
+			satisfy_any = EXCLUDED.satisfy_any
+		RETURNING id, created_at
+	`
+	if a.Allow == nil {
+		a.Allow = []string{}
+	}
+	if a.Deny == nil {
+		a.Deny = []string{}
+	}
+	return r.db.QueryRow(ctx, query, a.ProjectID, a.Path, a.BasicAuth, a.Allow, a.Deny, a.SatisfyAny).Scan(&a.ID, &a.CreatedAt)
+}
+
+// DeleteAccessRule removes an access rule of a project
+func (r *NginxSettingsRepo) DeleteAccessRule(ctx context.Context, projectID, ruleID int64) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM project_access_rules WHERE id = $1 AND project_id = $2`, ruleID, projectID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListAccessUsers returns the basic auth users of a project, ordered by username
+func (r *NginxSettingsRepo) ListAccessUsers(ctx context.Context, projectID int64) ([]models.AccessUser, error) {
+	query := `
+		SELECT id, project_id, username, password_hash, created_at
+		FROM project_access_users
+		WHERE project_id = $1
+		ORDER BY username
+	`
+	rows, err := r.db.Query(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.AccessUser{}
+	for rows.Next() {
+		var u models.AccessUser
+		if err := rows.Scan(&u.ID, &u.ProjectID, &u.Username, &u.PasswordHash, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SaveAccessUser creates a basic auth user, or sets the password of an existing one
+func (r *NginxSettingsRepo) SaveAccessUser(ctx context.Context, u *models.AccessUser) error {
+	query := `
+		INSERT INTO project_access_users (project_id, username, password_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (project_id, username) DO UPDATE SET
+			password_hash = EXCLUDED.password_hash
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(ctx, query, u.ProjectID, u.Username, u.PasswordHash).Scan(&u.ID, &u.CreatedAt)
+}
+
+// DeleteAccessUser removes a basic auth user of a project
+func (r *NginxSettingsRepo) DeleteAccessUser(ctx context.Context, projectID int64, username string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM project_access_users WHERE project_id = $1 AND username = $2`, projectID, username)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// GetProjectMaintenance returns the maintenance mode of a project; disabled when it was
+// never set
+func (r *NginxSettingsRepo) GetProjectMaintenance(ctx context.Context, projectID int64) (*models.ProjectMaintenance, error) {
+	query := `
+		SELECT project_id, enabled, retry_after, page_html, allow_ips, updated_at
+		FROM project_maintenance
+		WHERE project_id = $1
+	`
+	m := &models.ProjectMaintenance{ProjectID: projectID, RetryAfter: 3600, AllowIPs: []string{}}
+	err := r.db.QueryRow(ctx, query, projectID).Scan(
+		&m.ProjectID,
+		&m.Enabled,
+		&m.RetryAfter,
+		&m.PageHTML,
+		&m.AllowIPs,
+		&m.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SaveProjectMaintenance creates or replaces the maintenance mode of a project
+func (r *NginxSettingsRepo) SaveProjectMaintenance(ctx context.Context, m *models.ProjectMaintenance) error {
+	query := `
+		INSERT INTO project_maintenance (project_id, enabled, retry_after, page_html, allow_ips, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		ON CONFLICT (project_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			retry_after = EXCLUDED.retry_after,
+			page_html = EXCLUDED.page_html,
+			allow_ips = EXCLUDED.allow_ips,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`
+	if m.AllowIPs == nil {
+		m.AllowIPs = []string{}
+	}
+	return r.db.QueryRow(ctx, query, m.ProjectID, m.Enabled, m.RetryAfter, m.PageHTML, m.AllowIPs).Scan(&m.UpdatedAt)
+}
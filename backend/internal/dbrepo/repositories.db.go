@@ -2,6 +2,7 @@ package dbrepo
 
 import (
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/totp"
 )
 
 // DBRepository contains all individual repositories
@@ -12,10 +13,19 @@ type DBRepository struct {
 	PostgreSQL    *PostgreSQLManagerRepo
 	Domain *DomainRepo
 	ProjectRepo *ProjectRepo
+	Token       *TokenRepo
+	MFA         *MFARepo
+	Login       *LoginRepo
+	APIKey      *APIKeyRepo
+	Audit       *AuditRepo
+	Job         *JobRepo
+	NginxSettings *NginxSettingsRepo
+	Apps          *AppRepo
 }
 
-// NewDBRepository initializes all repositories with a shared connection pool
-func NewDBRepository(db *pgxpool.Pool) *DBRepository {
+// NewDBRepository initializes all repositories with a shared connection pool. sealer
+// encrypts the TOTP secrets the MFA repository stores.
+func NewDBRepository(db *pgxpool.Pool, sealer *totp.Sealer) *DBRepository {
 	return &DBRepository{
 		UserRepo:    NewUserRepo(db),
 		DBRegistry: newDatabaseRegistryRepo(db),
@@ -23,5 +33,13 @@ func NewDBRepository(db *pgxpool.Pool) *DBRepository {
 		PostgreSQL: NewPostgreSQLManagerRepo(),
 		Domain: NewDomainRepo(db),
 		ProjectRepo: NewProjectRepo(db),
+		Token:       NewTokenRepo(db),
+		MFA:         NewMFARepo(db, sealer),
+		Login:       NewLoginRepo(db),
+		APIKey:      NewAPIKeyRepo(db),
+		Audit:       NewAuditRepo(db),
+		Job:         NewJobRepo(db),
+		NginxSettings: NewNginxSettingsRepo(db),
+		Apps:          NewAppRepo(db),
 	}
 }
@@ -0,0 +1,148 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The whole family is revoked before this error is returned.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected, session revoked")
+)
+
+// ============================== Token Repository ==============================
+type TokenRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewTokenRepo(db *pgxpool.Pool) *TokenRepo {
+	return &TokenRepo{db: db}
+}
+
+// CreateRefreshToken stores a new refresh token (hash only)
+func (r *TokenRepo) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(ctx, query, t.UserID, t.FamilyID, t.TokenHash, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+}
+
+// RotateRefreshToken exchanges the token identified by oldHash for next, inside one transaction.
+// next.UserID and next.FamilyID are filled from the old token.
+// Presenting a token that was already rotated revokes the family and returns ErrRefreshTokenReused.
+func (r *TokenRepo) RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var old models.RefreshToken
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, family_id, expires_at, revoked_at, replaced_by
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, oldHash).Scan(&old.ID, &old.UserID, &old.FamilyID, &old.ExpiresAt, &old.RevokedAt, &old.ReplacedBy)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrRefreshTokenInvalid
+		}
+		return err
+	}
+
+	if old.RevokedAt != nil || time.Now().After(old.ExpiresAt) {
+		return ErrRefreshTokenInvalid
+	}
+
+	if old.ReplacedBy != nil {
+		// Reuse of a rotated token: assume it was stolen and kill the family
+		if _, err := tx.Exec(ctx, `
+			UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+			WHERE family_id = $1 AND revoked_at IS NULL
+		`, old.FamilyID); err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+
+	next.UserID = old.UserID
+	next.FamilyID = old.FamilyID
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt).Scan(&next.ID, &next.CreatedAt); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET replaced_by = $1 WHERE id = $2`, next.ID, old.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RevokeFamily revokes every refresh token of a session family
+func (r *TokenRepo) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID)
+	return err
+}
+
+// RevokeUserSessions revokes every refresh token family of a user.
+// Access tokens bound to those families are rejected by IsAccessTokenRevoked.
+func (r *TokenRepo) RevokeUserSessions(ctx context.Context, userID int64) (int64, error) {
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return cmd.RowsAffected(), nil
+}
+
+// RevokeAccessToken adds an access token to the deny list until it expires
+func (r *TokenRepo) RevokeAccessToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO revoked_access_tokens (jti, user_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, userID, expiresAt)
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the access token (jti) or its session family (sid) was revoked
+func (r *TokenRepo) IsAccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1)
+		    OR EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $2 AND revoked_at IS NOT NULL)
+	`, jti, sessionID).Scan(&revoked)
+	return revoked, err
+}
+
+// PurgeExpired deletes refresh tokens and deny-list entries that can no longer be used
+func (r *TokenRepo) PurgeExpired(ctx context.Context) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < CURRENT_TIMESTAMP`)
+	return err
+}
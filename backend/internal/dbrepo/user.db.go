@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -11,6 +12,9 @@ import (
 	"github.com/projuktisheba/vpanel/backend/internal/models"
 )
 
+// ErrLastAdmin is returned when a role or status change would leave no active admin
+var ErrLastAdmin = errors.New("cannot demote or deactivate the last active admin")
+
 // ============================== User Repository ==============================
 type UserRepo struct {
 	db *pgxpool.Pool
@@ -57,14 +61,14 @@ func (r *UserRepo) CreateUser(ctx context.Context, e *models.User) error {
 // GetUserByID fetches a user by ID
 func (r *UserRepo) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
 	query := `
-		SELECT id, name, role, status, mobile, email, password, address, avatar_link, joining_date, created_at, updated_at
+		SELECT id, name, role, status, mobile, email, password, address, avatar_link, joining_date, totp_enabled, locked_until, created_at, updated_at
 		FROM users WHERE id = $1
 	`
 	e := &models.User{}
 	err := r.db.QueryRow(ctx, query, id).Scan(
 		&e.ID, &e.Name, &e.Role, &e.Status, &e.Mobile, &e.Email,
 		&e.Password, &e.Address, &e.AvatarLink, &e.JoiningDate,
-		&e.CreatedAt, &e.UpdatedAt,
+		&e.TOTPEnabled, &e.LockedUntil, &e.CreatedAt, &e.UpdatedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -78,7 +82,7 @@ func (r *UserRepo) GetUserByID(ctx context.Context, id int64) (*models.User, err
 // GetUserByUsername fetches a user by mobile or email
 func (r *UserRepo) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	query := `
-		SELECT id, name, role, status, mobile, email, password, address, avatar_link, joining_date, created_at, updated_at
+		SELECT id, name, role, status, mobile, email, password, address, avatar_link, joining_date, totp_enabled, locked_until, created_at, updated_at
 		FROM users
 		WHERE mobile = $1 OR email = $1
 		LIMIT 1
@@ -87,7 +91,7 @@ func (r *UserRepo) GetUserByUsername(ctx context.Context, username string) (*mod
 	err := r.db.QueryRow(ctx, query, username).Scan(
 		&e.ID, &e.Name, &e.Role, &e.Status, &e.Mobile, &e.Email,
 		&e.Password, &e.Address, &e.AvatarLink, &e.JoiningDate,
-		&e.CreatedAt, &e.UpdatedAt,
+		&e.TOTPEnabled, &e.LockedUntil, &e.CreatedAt, &e.UpdatedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -98,8 +102,19 @@ func (r *UserRepo) GetUserByUsername(ctx context.Context, username string) (*mod
 	return e, nil
 }
 
-// UpdateUser updates user details
+// UpdateUser updates user details.
+// It returns ErrLastAdmin instead of demoting or deactivating the last active admin.
 func (r *UserRepo) UpdateUser(ctx context.Context, e *models.User) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := keepActiveAdmin(ctx, tx, e.ID, e.Role, e.Status); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE users
 		SET 
@@ -116,12 +131,12 @@ func (r *UserRepo) UpdateUser(ctx context.Context, e *models.User) error {
 		RETURNING updated_at
 	`
 
-	row := r.db.QueryRow(ctx, query,
+	row := tx.QueryRow(ctx, query,
 		e.ID, e.Name, e.Role, e.Status, e.Mobile, e.Email, e.Password,
 		e.Address, e.AvatarLink,
 	)
 
-	err := row.Scan(&e.UpdatedAt)
+	err = row.Scan(&e.UpdatedAt)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -143,7 +158,7 @@ func (r *UserRepo) UpdateUser(ctx context.Context, e *models.User) error {
 		return err
 	}
 
-	return nil
+	return tx.Commit(ctx)
 }
 
 // UpdateUserAvatarLink updates only the user's avatar link
@@ -157,21 +172,34 @@ func (r *UserRepo) UpdateUserAvatarLink(ctx context.Context, id int64, avatarLin
 	return err
 }
 
-// UpdateUserStatus updates user role and status
+// UpdateUserStatus updates user role and status.
+// It returns ErrLastAdmin instead of demoting or deactivating the last active admin.
 func (r *UserRepo) UpdateUserStatus(ctx context.Context, id int64, role, status string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := keepActiveAdmin(ctx, tx, id, role, status); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE users
 		SET role=$1, status=$2, updated_at=CURRENT_TIMESTAMP
 		WHERE id=$3
 	`
-	_, err := r.db.Exec(ctx, query, role, status, id)
-	return err
+	if _, err := tx.Exec(ctx, query, role, status, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 // PaginatedUserList returns paginated list of users with optional filters
 func (r *UserRepo) PaginatedUserList(ctx context.Context, page, limit int, role, status, sortBy, sortOrder string) ([]*models.User, int, error) {
 	query := `
-		SELECT id, name, role, status, mobile, email, address, avatar_link, joining_date, created_at, updated_at
+		SELECT id, name, role, status, mobile, email, address, avatar_link, joining_date, totp_enabled, locked_until, created_at, updated_at
 		FROM users
 		WHERE 1=1
 	`
@@ -196,8 +224,8 @@ func (r *UserRepo) PaginatedUserList(ctx context.Context, page, limit int, role,
 		argIdx++
 	}
 
-	// Sorting
-	if sortBy == "" {
+	// Sorting (column names cannot be bound, so only whitelisted columns are accepted)
+	if !slices.Contains([]string{"id", "name", "role", "status", "email", "joining_date", "created_at", "updated_at"}, sortBy) {
 		sortBy = "created_at"
 	}
 	if sortOrder != "ASC" && sortOrder != "DESC" {
@@ -229,7 +257,7 @@ func (r *UserRepo) PaginatedUserList(ctx context.Context, page, limit int, role,
 		var u models.User
 		if err := rows.Scan(
 			&u.ID, &u.Name, &u.Role, &u.Status, &u.Mobile, &u.Email,
-			&u.Address, &u.AvatarLink, &u.JoiningDate, &u.CreatedAt, &u.UpdatedAt,
+			&u.Address, &u.AvatarLink, &u.JoiningDate, &u.TOTPEnabled, &u.LockedUntil, &u.CreatedAt, &u.UpdatedAt,
 		); err != nil {
 			return nil, 0, err
 		}
@@ -238,3 +266,47 @@ func (r *UserRepo) PaginatedUserList(ctx context.Context, page, limit int, role,
 
 	return users, total, nil
 }
+
+// UpdateUserPassword replaces the stored password hash
+func (r *UserRepo) UpdateUserPassword(ctx context.Context, id int64, passwordHash string) error {
+	query := `
+		UPDATE users
+		SET password=$1, updated_at=CURRENT_TIMESTAMP
+		WHERE id=$2
+	`
+	cmd, err := r.db.Exec(ctx, query, passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return errors.New("no user found with the given id")
+	}
+	return nil
+}
+
+// keepActiveAdmin returns ErrLastAdmin when giving user id the new role and status would leave
+// no active admin. The active admin rows stay locked until tx ends, so concurrent demotions
+// are serialized and the second one sees the first.
+func keepActiveAdmin(ctx context.Context, tx pgx.Tx, id int64, role, status string) error {
+	if role == "admin" && status == "active" {
+		return nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM users
+		WHERE role = 'admin' AND status = 'active'
+		FOR UPDATE
+	`)
+	if err != nil {
+		return err
+	}
+	admins, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(admins, id) && len(admins) <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
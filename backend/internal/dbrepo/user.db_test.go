@@ -0,0 +1,75 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func testAdmins(t *testing.T, n int) (*UserRepo, []int64) {
+	t.Helper()
+	pool := testPool(t, "001_users.sql", "006_user_roles.sql")
+	ids := make([]int64, n)
+	for i := range ids {
+		if err := pool.QueryRow(context.Background(), `
+			INSERT INTO users (name, role, mobile) VALUES ('admin', 'admin', $1) RETURNING id
+		`, i).Scan(&ids[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewUserRepo(pool), ids
+}
+
+func TestLastAdminKept(t *testing.T) {
+	repo, ids := testAdmins(t, 1)
+	ctx := context.Background()
+
+	if err := repo.UpdateUserStatus(ctx, ids[0], "admin", "inactive"); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("deactivate last admin: err = %v, want ErrLastAdmin", err)
+	}
+	user, err := repo.GetUserByID(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Role = "viewer"
+	if err := repo.UpdateUser(ctx, user); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("demote last admin: err = %v, want ErrLastAdmin", err)
+	}
+
+	// staying an active admin is always allowed
+	user.Role = "admin"
+	user.Name = "renamed"
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("update last admin: %v", err)
+	}
+}
+
+func TestConcurrentDemotionsKeepAnAdmin(t *testing.T) {
+	repo, ids := testAdmins(t, 2)
+	ctx := context.Background()
+
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer wg.Done()
+			errs[i] = repo.UpdateUserStatus(ctx, id, "viewer", "active")
+		}(i, id)
+	}
+	wg.Wait()
+
+	refused := 0
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrLastAdmin):
+			refused++
+		case err != nil:
+			t.Fatal(err)
+		}
+	}
+	if refused != 1 {
+		t.Fatalf("%d demotions refused, want 1", refused)
+	}
+}
@@ -0,0 +1,278 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/systemd"
+)
+
+// FrameworkApp is the framework of applications (Go, Node.js, ...) the panel runs as a
+// systemd service and serves through an nginx proxy
+const FrameworkApp = "App"
+
+// Local ports handed out to applications, one per project
+const (
+	AppPortMin = 20000
+	AppPortMax = 29999
+)
+
+// ErrNoAppPort is returned by FreeAppPort when every port of the range is taken
+var ErrNoAppPort = errors.New("no free application port left")
+
+// FreeAppPort returns the lowest port of the application range that is not in used and
+// that nothing on the host listens on
+func FreeAppPort(used []int) (int, error) {
+	taken := make(map[int]bool, len(used))
+	for _, port := range used {
+		taken[port] = true
+	}
+	for port := AppPortMin; port <= AppPortMax; port++ {
+		if taken[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		return port, nil
+	}
+	return 0, ErrNoAppPort
+}
+
+// AppUnit returns the service unit of the application of t, filling the working directory
+// left empty with the project directory. The application runs as t.SysUser, the project's
+// own system user; RunAs may only name that account.
+func AppUnit(t Target) (systemd.Unit, error) {
+	if t.App == nil {
+		return systemd.Unit{}, errors.New("the application is not configured; save its command first")
+	}
+	if t.App.RunAs != "" && t.App.RunAs != t.SysUser {
+		return systemd.Unit{}, fmt.Errorf("the application runs as the project's user %s, not %s", t.SysUser, t.App.RunAs)
+	}
+	unit := systemd.Unit{
+		Domain:     t.Domain,
+		Command:    t.App.Command,
+		WorkingDir: t.App.WorkingDir,
+		User:       t.App.RunAs,
+		Env:        t.App.Env,
+		Restart:    t.App.Restart,
+		Port:       t.App.Port,
+	}
+	if unit.WorkingDir == "" {
+		unit.WorkingDir = t.Directory
+	}
+	if unit.User == "" {
+		unit.User = t.SysUser
+	}
+	// one project's application may not run in another's files
+	if rel, err := filepath.Rel(t.Directory, unit.WorkingDir); err != nil || strings.HasPrefix(rel, "..") {
+		return systemd.Unit{}, fmt.Errorf("working directory %s is outside the project directory %s", unit.WorkingDir, t.Directory)
+	}
+	return unit, unit.Validate()
+}
+
+// DeployApp installs the service of the application of t, (re)starts it and serves it on
+// t.Domain through an nginx proxy that forwards WebSocket upgrades.
+// Command output goes to the operation log in ctx.
+func DeployApp(ctx context.Context, t Target) error {
+	unit, err := AppUnit(t)
+	if err != nil {
+		return err
+	}
+
+	// 1) The application owns its files, as the project's own user
+	if err := createProjectUser(ctx, t.Domain); err != nil {
+		return err
+	}
+	if err := syscmd.Privileged(ctx, &broker.ChownProject{Path: t.Directory, Owner: unit.User}); err != nil {
+		return fmt.Errorf("chown project failed: %w", err)
+	}
+
+	// 2) Install and (re)start the service
+	oplog.Printf(ctx, "Installing %s on port %d\n", unit.Name(), unit.Port)
+	if err := syscmd.Privileged(ctx, &broker.WriteAppUnit{
+		Domain:     unit.Domain,
+		Command:    unit.Command,
+		WorkingDir: unit.WorkingDir,
+		User:       unit.User,
+		Env:        unit.Env,
+		Restart:    unit.Restart,
+		Port:       unit.Port,
+	}); err != nil {
+		return fmt.Errorf("install service: %w", err)
+	}
+	if err := syscmd.Privileged(ctx, &broker.ControlApp{Domain: t.Domain, Action: "restart"}); err != nil {
+		return fmt.Errorf("start service: %w", err)
+	}
+
+	// 3) Create nginx config
+	site := nginx.Site{
+		Domain:  t.Domain,
+		Aliases: nginx.WithWWW(t.Domain),
+		Proxy:   &nginx.Proxy{Pass: appAddress(unit.Port, "http://"), WebSocket: true},
+	}
+	// the custom rules of the project (redirects, body size, snippet)
+	site.Customize(t.Settings)
+	// write & enable site, test & reload nginx (rolled back if the test fails)
+	if err := syscmd.Privileged(ctx, &broker.ApplyNginxVhost{Name: nginx.ConfName(t.Domain), Site: site}); err != nil {
+		return fmt.Errorf("apply nginx conf: %w", err)
+	}
+
+	oplog.Printf(ctx, "✅ Application %s deployed\n", t.Domain)
+	return nil
+}
+
+// DeleteApp removes the service, the files and the nginx configuration of an application
+func DeleteApp(ctx context.Context, domain, projectPath string) error {
+	if domain == "" || projectPath == "" {
+		return fmt.Errorf("domain and projectPath are required")
+	}
+
+	if err := syscmd.Privileged(ctx, &broker.RemoveAppUnit{Domain: domain}); err != nil {
+		return fmt.Errorf("remove service: %w", err)
+	}
+
+	removeSiteConfig(ctx, domain)
+	if err := syscmd.Privileged(ctx, &broker.ReloadNginx{}); err != nil {
+		oplog.Println(ctx, "⚠ Nginx test failed after deletion, please check manually")
+	}
+
+	if err := syscmd.Privileged(ctx, &broker.RemoveProject{Path: projectPath}); err != nil {
+		return fmt.Errorf("remove project files: %w", err)
+	}
+	removeProjectUser(ctx, domain)
+	oplog.Println(ctx, "Project deleted successfully:", domain)
+	return nil
+}
+
+// AppService is what systemd says about the service of an application
+type AppService struct {
+	Unit        string `json:"unit"`
+	ActiveState string `json:"activeState"` // active, inactive, failed, ...
+	SubState    string `json:"subState"`    // running, dead, auto-restart, ...
+	MainPID     int    `json:"mainPid"`
+	Since       string `json:"since,omitempty"` // when the service entered its active state
+	Restarts    int    `json:"restarts"`
+	UnitFile    string `json:"unitFile"` // enabled, disabled, or empty when not installed
+}
+
+// AppServiceStatus returns the state of the service of the application of domain
+func AppServiceStatus(ctx context.Context, domain string) (*AppService, error) {
+	name := systemd.UnitName(domain)
+	out, err := syscmd.Query(ctx, "systemctl", "show", name, "--no-pager",
+		"--property=ActiveState,SubState,MainPID,ActiveEnterTimestamp,NRestarts,UnitFileState")
+	if err != nil {
+		return nil, err
+	}
+	st := &AppService{Unit: name}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "ActiveState":
+			st.ActiveState = value
+		case "SubState":
+			st.SubState = value
+		case "MainPID":
+			st.MainPID, _ = strconv.Atoi(value)
+		case "ActiveEnterTimestamp":
+			st.Since = value
+		case "NRestarts":
+			st.Restarts, _ = strconv.Atoi(value)
+		case "UnitFileState":
+			st.UnitFile = value
+		}
+	}
+	return st, nil
+}
+
+// maxAppLogLines bounds the journal lines AppLogs returns
+const maxAppLogLines = 5000
+
+// AppLogs returns the last lines journal lines of the service of the application of
+// domain, only those logged after since when it is set (journalctl's --since syntax,
+// e.g. "1 hour ago" or "2024-05-01 10:00"). journalctl runs as the panel's user, which
+// must be in the systemd-journal group to read the logs of system services.
+func AppLogs(ctx context.Context, domain string, lines int, since string) ([]byte, error) {
+	if lines < 1 || lines > maxAppLogLines {
+		return nil, fmt.Errorf("lines must be between 1 and %d", maxAppLogLines)
+	}
+	args := []string{"-u", systemd.UnitName(domain), "-n", strconv.Itoa(lines), "--no-pager", "-o", "short-iso"}
+	if since != "" {
+		// one argument, so a value starting with - cannot pass as an option
+		args = append(args, "--since="+since)
+	}
+	return syscmd.Query(ctx, "journalctl", args...)
+}
+
+// appAddress returns the local address of an application listening on port
+func appAddress(port int, scheme string) string {
+	return scheme + net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// app is the Deployer of applications uploaded to the project directory
+type app struct{}
+
+func (app) Framework() string { return FrameworkApp }
+
+func (app) Prepare(ctx context.Context, t Target) error {
+	if t.Domain == "" || t.Directory == "" || t.SysUser == "" {
+		return fmt.Errorf("domain, projectPath and sysUser are required")
+	}
+	if !exists(t.Directory) {
+		return fmt.Errorf("%s does not exist; upload the project files first", t.Directory)
+	}
+	_, err := AppUnit(t)
+	return err
+}
+
+func (app) Deploy(ctx context.Context, t Target) error {
+	return DeployApp(ctx, t)
+}
+
+// Suspend answers with the suspension page, then stops the application
+func (app) Suspend(ctx context.Context, t Target) error {
+	if err := SuspendSite(ctx, t.Domain); err != nil {
+		return err
+	}
+	return syscmd.Privileged(ctx, &broker.ControlApp{Domain: t.Domain, Action: "stop"})
+}
+
+// Resume starts the application before serving it again
+func (app) Resume(ctx context.Context, t Target) error {
+	if err := syscmd.Privileged(ctx, &broker.ControlApp{Domain: t.Domain, Action: "start"}); err != nil {
+		return err
+	}
+	return ResumeSite(ctx, t.Domain)
+}
+
+func (app) Delete(ctx context.Context, t Target) error {
+	return DeleteApp(ctx, t.Domain, t.Directory)
+}
+
+func (d app) Status(ctx context.Context, t Target) (*SiteStatus, error) {
+	st := siteStatus(d.Framework(), t.Domain)
+	st.FilesPresent = exists(t.Directory)
+	if t.App != nil {
+		st.Upstream = appAddress(t.App.Port, "")
+		if conn, err := net.DialTimeout("tcp", st.Upstream, time.Second); err == nil {
+			conn.Close()
+			st.UpstreamUp = true
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,117 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	user "github.com/projuktisheba/vpanel/backend/internal/pkg/sysuser"
+)
+
+// ErrUnsupportedFramework is returned by For for a framework without a deployer
+var ErrUnsupportedFramework = errors.New("unsupported framework")
+
+// Target is the project a Deployer works on
+type Target struct {
+	Domain    string
+	Directory string                       // the project's directory, as stored on the project
+	SysUser   string                       // system user the site runs as, owning the project files
+	Settings  *models.ProjectNginxSettings // custom nginx rules of the project; nil for none
+	Database  *models.Database             // the project's database and its user; nil for none
+	App       *models.ProjectApp           // the application of a proxied project; nil for none
+	Python    *models.ProjectPythonApp     // the application of a Python project; nil for none
+}
+
+// TargetFor returns the target of project, owned by its own system user
+func TargetFor(project *models.Project, settings *models.ProjectNginxSettings) Target {
+	return Target{
+		Domain:    project.DomainName,
+		Directory: project.ProjectDirectory,
+		SysUser:   user.ProjectUser(project.DomainName),
+		Settings:  settings,
+	}
+}
+
+// SiteStatus is what the host says about a deployed site, as opposed to the status
+// stored on the project
+type SiteStatus struct {
+	Framework    string `json:"framework"`
+	Enabled      bool   `json:"enabled"`     // sites-enabled serves a vhost for the domain
+	Suspended    bool   `json:"suspended"`   // the vhost served is the suspension block
+	Maintenance  bool   `json:"maintenance"` // maintenance mode is on
+	FilesPresent bool   `json:"filesPresent"`
+	Upstream     string `json:"upstream,omitempty"` // the PHP-FPM socket or application address
+	UpstreamUp   bool   `json:"upstreamUp"`
+}
+
+// Deployer deploys and manages the sites of one framework. Progress and command output
+// of every method go to the operation log in ctx.
+type Deployer interface {
+	// Framework returns the name projects store in projectFramework
+	Framework() string
+	// Prepare checks the project can be deployed (e.g. its files are uploaded) without
+	// changing anything, so a bad request is rejected before a job is queued
+	Prepare(ctx context.Context, t Target) error
+	// Deploy builds or rebuilds the site and its vhost
+	Deploy(ctx context.Context, t Target) error
+	// Suspend replaces the site with a 403 until it is resumed
+	Suspend(ctx context.Context, t Target) error
+	// Resume serves a suspended site again
+	Resume(ctx context.Context, t Target) error
+	// Delete removes the site's files and configuration
+	Delete(ctx context.Context, t Target) error
+	// Status inspects the deployed site
+	Status(ctx context.Context, t Target) (*SiteStatus, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Deployer{}
+)
+
+// Register makes d the deployer of its framework. It panics when the framework already
+// has one, as that is a programming error.
+func Register(d Deployer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[d.Framework()]; dup {
+		panic("deploy: framework registered twice: " + d.Framework())
+	}
+	registry[d.Framework()] = d
+}
+
+// For returns the deployer of framework
+func For(framework string) (Deployer, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	d, ok := registry[framework]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedFramework, framework)
+	}
+	return d, nil
+}
+
+// Frameworks returns the names of the frameworks that can be deployed, sorted
+func Frameworks() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(wordPress{})
+	Register(laravel{})
+	Register(codeIgniter{})
+	Register(symfony{})
+	Register(static{})
+	Register(app{})
+	Register(python{})
+}
@@ -1,18 +1,27 @@
 package deploy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
 )
 
 // DeployLaravelSite deploys a Laravel site with a domain-specific FPM pool, nginx vhost,
 // composer install (with fallback), artisan key:generate + optimize, and permission fixes.
-// Call: DeployLaravelSite("example.com", "/home/samiul/projuktisheba/bin/PHP/example.com", "samiul")
-func DeployLaravelSite(domain, projectPath, sysUser string) error {
+// Command output goes to the operation log in ctx.
+// Call: DeployLaravelSite(ctx, "example.com", utils.GetPHPProjectDirectory("example.com"), user.ProjectUser("example.com"), settings)
+func DeployLaravelSite(ctx context.Context, domain, projectPath, sysUser string, settings *models.ProjectNginxSettings) error {
 	if domain == "" || projectPath == "" || sysUser == "" {
 		return fmt.Errorf("domain, projectPath and sysUser are required")
 	}
@@ -20,47 +29,21 @@ func DeployLaravelSite(domain, projectPath, sysUser string) error {
 	// 1) Detect PHP version (robust, safe default)
 	phpVersion := detectPHPVersionSafe(projectPath) // e.g. "8.2"
 
-	// explicit binary paths to avoid PATH / sudo issues
+	// explicit binary paths to avoid PATH issues
 	phpBin := fmt.Sprintf("/usr/bin/php%s", phpVersion)
 	// domain-specific socket and pool
-	socketPath := fmt.Sprintf("/run/php/php%s-%s-fpm.sock", phpVersion, domain)
-	poolPath := fmt.Sprintf("/etc/php/%s/fpm/pool.d/%s.conf", phpVersion, domain)
-	logPath := fmt.Sprintf("/var/log/php%s-%s-error.log", phpVersion, domain)
-
-	// 2) Create FPM pool file (owned by root via sudo tee)
-	poolContent := fmt.Sprintf(`[%s]
-user = %s
-group = %s
-listen = %s
-listen.owner = www-data
-listen.group = www-data
-listen.mode = 0660
-
-pm = dynamic
-pm.max_children = 10
-pm.start_servers = 3
-pm.min_spare_servers = 2
-pm.max_spare_servers = 6
-
-catch_workers_output = yes
-php_admin_value[error_log] = %s
-php_admin_flag[log_errors] = on
-chdir = /
-`, domain, sysUser, sysUser, socketPath, logPath)
-
-	if err := writeWithSudo(poolPath, []byte(poolContent)); err != nil {
-		return fmt.Errorf("write fpm pool: %w", err)
-	}
+	socketPath := layout.FPMSocket(phpVersion, domain)
 
-	// ensure log file exists and has sane ownership
-	if err := runCmdSudo("touch", logPath); err != nil {
-		return fmt.Errorf("touch log: %w", err)
+	// 2) Create the project's user and an FPM pool (owned by root) running as it, with its error log
+	if err := createProjectUser(ctx, domain); err != nil {
+		return err
+	}
+	if err := syscmd.Privileged(ctx, &broker.WriteFPMPool{PHPVersion: phpVersion, Domain: domain, User: sysUser}); err != nil {
+		return fmt.Errorf("write fpm pool: %w", err)
 	}
-	_ = runCmdSudo("chown", fmt.Sprintf("%s:%s", sysUser, sysUser), logPath)
-	_ = runCmdSudo("chmod", "644", logPath)
 
 	// 3) Restart php-fpm service for that version
-	if err := runCmdSudo("systemctl", "restart", fmt.Sprintf("php%s-fpm", phpVersion)); err != nil {
+	if err := syscmd.Privileged(ctx, &broker.RestartFPM{PHPVersion: phpVersion}); err != nil {
 		return fmt.Errorf("restart php%s-fpm: %w", phpVersion, err)
 	}
 
@@ -68,102 +51,90 @@ chdir = /
 	publicDir := detectPublicDir(projectPath)
 
 	// 5) Create nginx config (use $document_root not $realpath_root)
-	nginxConfPath := fmt.Sprintf("/etc/nginx/sites-available/%s.conf", domain)
-	nginxConf := fmt.Sprintf(`server {
-    listen 80;
-    server_name %s www.%s;
-    root %s;
-    index index.php index.html;
-
-    add_header X-Frame-Options "SAMEORIGIN";
-    add_header X-Content-Type-Options "nosniff";
-
-    charset utf-8;
-
-    location / {
-        try_files $uri $uri/ /index.php?$query_string;
-    }
-
-    location = /favicon.ico { access_log off; log_not_found off; }
-    location = /robots.txt  { access_log off; log_not_found off; }
-
-    error_page 404 /index.php;
-
-    location ~ \.php$ {
-        include snippets/fastcgi-php.conf;
-        fastcgi_pass unix:%s;
-        fastcgi_param SCRIPT_FILENAME $document_root$fastcgi_script_name;
-        fastcgi_buffers 16 16k;
-        fastcgi_buffer_size 32k;
-    }
-
-    location ~ /\.(?!well-known).* {
-        deny all;
-    }
-}
-`, domain, domain, publicDir, socketPath)
-
-	if err := writeWithSudo(nginxConfPath, []byte(nginxConf)); err != nil {
-		return fmt.Errorf("write nginx conf: %w", err)
-	}
-
-	// enable site & test nginx
-	available := nginxConfPath
-	enabled := fmt.Sprintf("/etc/nginx/sites-enabled/%s.conf", domain)
-	if err := runCmdSudo("ln", "-sf", available, enabled); err != nil {
-		return fmt.Errorf("enable nginx site: %w", err)
-	}
-	if err := runCmdSudo("nginx", "-t"); err != nil {
-		return fmt.Errorf("nginx -t failed: %w", err)
-	}
-	if err := runCmdSudo("systemctl", "reload", "nginx"); err != nil {
-		return fmt.Errorf("nginx reload failed: %w", err)
+	site := nginx.Site{
+		Domain:  domain,
+		Aliases: nginx.WithWWW(domain),
+		Root:    publicDir,
+		Charset: "utf-8",
+		Headers: []nginx.Header{
+			{Name: "X-Frame-Options", Value: "SAMEORIGIN"},
+			{Name: "X-Content-Type-Options", Value: "nosniff"},
+		},
+		ErrorPages: []nginx.ErrorPage{{Code: 404, URI: "/index.php"}},
+		QuietFiles: true,
+		DenyHidden: true,
+		PHP:        &nginx.PHP{Socket: socketPath, ScriptFilename: true, Buffers: true},
+	}
+	// the custom rules of the project (redirects, body size, snippet)
+	site.Customize(settings)
+	// write & enable site, test & reload nginx (rolled back if the test fails)
+	if err := syscmd.Privileged(ctx, &broker.ApplyNginxVhost{Name: nginx.ConfName(domain), Site: site}); err != nil {
+		return fmt.Errorf("apply nginx conf: %w", err)
 	}
 
 	// 6) Fix ownership & permissions BEFORE composer (avoid permission issues)
-	// chown project to sysUser (you may prefer www-data or sysUser depending on your setup)
-	if err := runCmdSudo("chown", "-R", fmt.Sprintf("%s:%s", sysUser, sysUser), projectPath); err != nil {
-		// non-fatal but warn
-		return fmt.Errorf("chown project failed: %w", err)
-	}
-	// ensure storage + bootstrap/cache exist, owned and writable
+	// storage + bootstrap/cache must exist for artisan; the chown makes them writable
 	storage := filepath.Join(projectPath, "storage")
 	bootstrapCache := filepath.Join(projectPath, "bootstrap", "cache")
-	_ = runCmdSudo("mkdir", "-p", storage)
-	_ = runCmdSudo("mkdir", "-p", bootstrapCache)
-	if err := runCmdSudo("chown", "-R", fmt.Sprintf("%s:%s", sysUser, sysUser), storage); err != nil {
-		return fmt.Errorf("chown storage: %w", err)
-	}
-	if err := runCmdSudo("chown", "-R", fmt.Sprintf("%s:%s", sysUser, sysUser), bootstrapCache); err != nil {
-		return fmt.Errorf("chown bootstrap cache: %w", err)
-	}
-	if err := runCmdSudo("chmod", "-R", "775", storage); err != nil {
-		return fmt.Errorf("chmod storage: %w", err)
-	}
-	if err := runCmdSudo("chmod", "-R", "775", bootstrapCache); err != nil {
-		return fmt.Errorf("chmod bootstrap cache: %w", err)
+	_ = syscmd.Run(ctx, "mkdir", "-p", storage, bootstrapCache)
+	if err := syscmd.Privileged(ctx, &broker.ChownProject{Path: projectPath, Owner: sysUser}); err != nil {
+		return fmt.Errorf("chown project failed: %w", err)
 	}
 
-	// // 7) Run composer install (install -> fallback update). Run as sysUser via sudo -u for safety.
-	// if err := runComposerAsUser(projectPath, phpBin, sysUser); err != nil {
-	// 	return fmt.Errorf("composer install/update failed: %w", err)
-	// }
+	// 7) Run composer install (install -> fallback update), as sysUser
+	if err := runComposer(ctx, domain, projectPath, phpBin); err != nil {
+		return fmt.Errorf("composer install/update failed: %w", err)
+	}
 
 	// 8) Run artisan key:generate + optimize (as sysUser)
-	if err := runArtisanAsUser(projectPath, phpBin, sysUser); err != nil {
+	if err := runArtisan(ctx, domain, projectPath, phpBin); err != nil {
 		return fmt.Errorf("artisan commands failed: %w", err)
 	}
 
-	// 9) Final permission fix (ensure www-data or sysUser ownership per your policy)
-	// Keep as sysUser here
-	if err := runCmdSudo("chown", "-R", fmt.Sprintf("%s:%s", sysUser, sysUser), projectPath); err != nil {
+	// 9) Final permission fix: the panel's group keeps access to what composer and
+	// artisan created
+	if err := syscmd.Privileged(ctx, &broker.ChownProject{Path: projectPath, Owner: sysUser}); err != nil {
 		return fmt.Errorf("final chown failed: %w", err)
 	}
-	_ = runCmdSudo("chmod", "-R", "755", projectPath)
 
 	return nil
 }
 
+// laravel is the Deployer of Laravel sites uploaded to the project directory
+type laravel struct{}
+
+func (laravel) Framework() string { return "Laravel" }
+
+func (laravel) Prepare(ctx context.Context, t Target) error {
+	if t.Domain == "" || t.Directory == "" || t.SysUser == "" {
+		return fmt.Errorf("domain, projectPath and sysUser are required")
+	}
+	if !exists(filepath.Join(t.Directory, "artisan")) {
+		return fmt.Errorf("%s does not hold a Laravel project (no artisan); upload the project files first", t.Directory)
+	}
+	return nil
+}
+
+func (laravel) Deploy(ctx context.Context, t Target) error {
+	return DeployLaravelSite(ctx, t.Domain, t.Directory, t.SysUser, t.Settings)
+}
+
+func (laravel) Suspend(ctx context.Context, t Target) error { return SuspendSite(ctx, t.Domain) }
+
+func (laravel) Resume(ctx context.Context, t Target) error { return ResumeSite(ctx, t.Domain) }
+
+func (laravel) Delete(ctx context.Context, t Target) error {
+	return DeletePHPSite(ctx, t.Directory, t.SysUser, t.Domain, detectPHPVersionSafe(t.Directory))
+}
+
+func (d laravel) Status(ctx context.Context, t Target) (*SiteStatus, error) {
+	st := siteStatus(d.Framework(), t.Domain)
+	st.FilesPresent = exists(filepath.Join(t.Directory, "artisan"))
+	st.Upstream = layout.FPMSocket(detectPHPVersionSafe(t.Directory), t.Domain)
+	st.UpstreamUp = exists(st.Upstream)
+	return st, nil
+}
+
 // ---------------------- helpers ----------------------
 
 func detectPHPVersionSafe(projectPath string) string {
@@ -202,27 +173,6 @@ func detectPHPVersionSafe(projectPath string) string {
 	return defaultVer
 }
 
-func writeWithSudo(path string, content []byte) error {
-	cmd := exec.Command("sudo", "tee", path)
-	cmd.Stdin = strings.NewReader(string(content))
-	// capture potential error output
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("sudo tee failed: %w: %s", err, string(out))
-	}
-	return nil
-}
-
-func runCmdSudo(name string, args ...string) error {
-	all := append([]string{name}, args...)
-	cmd := exec.Command("sudo", all...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("command sudo %s failed: %w: %s", strings.Join(all, " "), err, string(out))
-	}
-	return nil
-}
-
 func detectPublicDir(projectPath string) string {
 	publicDir := filepath.Join(projectPath, "public")
 	if _, err := os.Stat(publicDir); err == nil {
@@ -231,35 +181,47 @@ func detectPublicDir(projectPath string) string {
 	return projectPath
 }
 
-func runComposerAsUser(projectPath, phpBin, sysUser string) error {
-	// try install first
+// composerPath returns the composer the deployers run: /usr/local/bin/composer, where its
+// installer puts it, or the one in PATH
+func composerPath() string {
 	composerBin := "/usr/local/bin/composer"
 	if _, err := os.Stat(composerBin); err != nil {
-		// fallback to composer in PATH
-		composerBin = "composer"
+		if path, err := exec.LookPath("composer"); err == nil {
+			composerBin = path
+		}
 	}
-	installArgs := []string{phpBin, composerBin, "install", "--no-dev", "--optimize-autoloader", "-n", "--no-interaction"}
-	cmd := exec.Command("sudo", append([]string{"-u", sysUser}, installArgs...)...)
-	cmd.Dir = projectPath
-	out, err := cmd.CombinedOutput()
+	return composerBin
+}
+
+// runComposer installs the dependencies of the project of domain at projectPath,
+// updating them when the lock file does not match composer.json. It runs as the
+// project's user.
+func runComposer(ctx context.Context, domain, projectPath, phpBin string) error {
+	composer := func(action string) error {
+		return runAsProject(ctx, domain, projectPath, nil,
+			phpBin, composerPath(), action, "--no-dev", "--optimize-autoloader", "-n", "--no-interaction")
+	}
+	// try install first
+	oplog.Println(ctx, "Attempting 'composer install'...")
+	err := composer("install")
 	if err == nil {
 		return nil
 	}
-	outStr := string(out)
-	// if composer complains about lock/constraint, try update
-	if strings.Contains(strings.ToLower(outStr), "lock file") || strings.Contains(strings.ToLower(outStr), "constraint") || strings.Contains(strings.ToLower(outStr), "your requirements could not be resolved") {
-		updateArgs := []string{phpBin, composerBin, "update", "--no-dev", "--optimize-autoloader", "-n", "--no-interaction"}
-		cmd2 := exec.Command("sudo", append([]string{"-u", sysUser}, updateArgs...)...)
-		cmd2.Dir = projectPath
-		out2, err2 := cmd2.CombinedOutput()
-		if err2 != nil {
-			return fmt.Errorf("composer update failed: %w: %s", err2, string(out2))
+	// if composer complains about lock/constraint, try update (the error ends with its output)
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "lock file") || strings.Contains(msg, "constraint") || strings.Contains(msg, "your requirements could not be resolved") {
+		oplog.Println(ctx, "Composer.lock out of sync. Falling back to 'composer update'...")
+		if err := composer("update"); err != nil {
+			return fmt.Errorf("composer update failed: %w", err)
 		}
 		return nil
 	}
-	return fmt.Errorf("composer install failed: %s", outStr)
+	return fmt.Errorf("composer install failed: %w", err)
 }
-func runArtisanAsUser(projectPath, phpBin, sysUser string) error {
+
+// runArtisan generates the key and rebuilds the caches of the Laravel project of domain
+// at projectPath, as the project's user
+func runArtisan(ctx context.Context, domain, projectPath, phpBin string) error {
 	artisan := filepath.Join(projectPath, "artisan")
 
 	// Check if artisan exists
@@ -281,13 +243,9 @@ func runArtisanAsUser(projectPath, phpBin, sysUser string) error {
 	}
 
 	for _, args := range commands {
-		cmdArgs := append([]string{"-u", sysUser, phpBin, artisan}, args...)
-		cmd := exec.Command("sudo", cmdArgs...)
-		cmd.Dir = projectPath
-
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("artisan %s failed: %w: %s", strings.Join(args, " "), err, string(out))
+		cmdArgs := append([]string{phpBin, artisan}, args...)
+		if err := runAsProject(ctx, domain, projectPath, nil, cmdArgs...); err != nil {
+			return fmt.Errorf("artisan %s failed: %w", strings.Join(args, " "), err)
 		}
 	}
 
@@ -0,0 +1,150 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	user "github.com/projuktisheba/vpanel/backend/internal/pkg/sysuser"
+)
+
+// sandbox points the layout at a temp directory for the test
+func sandbox(t *testing.T) {
+	t.Helper()
+	prev := layout.Current()
+	l := layout.Default()
+	l.Root = t.TempDir()
+	l.PanelDir = "/srv/panel"
+	layout.Set(l)
+	t.Cleanup(func() { layout.Set(prev) })
+}
+
+// asRoot returns the argv Sudo runs name with args as
+func asRoot(name string, args ...string) []string {
+	c := syscmd.SudoCmd(name, args...)
+	return append([]string{c.Name}, c.Args...)
+}
+
+func TestDeployLaravelSite(t *testing.T) {
+	sandbox(t)
+	dir := layout.Panel("bin", "PHP", "example.com")
+	if err := os.MkdirAll(filepath.Join(dir, "public"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "artisan"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(`{"require": {"php": "^8.3"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	owner := user.ProjectUser("example.com")
+	rec := syscmd.NewRecorder()
+	if err := DeployLaravelSite(syscmd.WithExecutor(context.Background(), rec), "example.com", dir, owner, nil); err != nil {
+		t.Fatalf("DeployLaravelSite: %v", err)
+	}
+
+	pool := layout.FPMPool("8.3", "example.com")
+	errorLog := layout.PHPErrorLog("8.3", "example.com")
+	vhost := layout.SitesAvailable("example.com.conf")
+	storage := filepath.Join(dir, "storage")
+	cache := filepath.Join(dir, "bootstrap", "cache")
+	php := "/usr/bin/php8.3"
+	artisan := filepath.Join(dir, "artisan")
+
+	// the commands in order: what runs as root goes through a broker operation, what runs
+	// the project's code runs as the project's user
+	type step struct {
+		kind string
+		args []string // argv of run steps, the path of write steps, the kind of privileged steps
+	}
+	chown := []step{
+		{syscmd.StepPrivileged, []string{"project.chown"}},
+		{syscmd.StepRun, asRoot("find", dir, "!", "-type", "d", "-links", "+1", "-print", "-quit")},
+		{syscmd.StepRun, asRoot("chown", "-R", owner+":"+strconv.Itoa(os.Getgid()), dir)},
+		{syscmd.StepRun, asRoot("chmod", "-R", "g+rwX", dir)},
+		{syscmd.StepRun, asRoot("find", dir, "-type", "d", "-exec", "chmod", "g+s", "{}", "+")},
+	}
+	asProject := func(command ...string) []step {
+		args := append([]string{"-u", owner, "--", "env", "-i", "HOME=" + dir, "PATH=/usr/local/bin:/usr/bin:/bin"}, command...)
+		return []step{
+			{syscmd.StepPrivileged, []string{"project.run"}},
+			{syscmd.StepRun, asRoot("runuser", args...)},
+		}
+	}
+	want := []step{
+		{syscmd.StepPrivileged, []string{"project.create_user"}},
+		{syscmd.StepRun, asRoot("useradd", "--system", "--user-group", "--no-create-home",
+			"--home-dir", "/nonexistent", "--shell", "/usr/sbin/nologin", "--comment", "example.com", owner)},
+		{syscmd.StepPrivileged, []string{"fpm.write_pool"}},
+		{syscmd.StepWrite, []string{pool}},
+		{syscmd.StepRun, asRoot("touch", errorLog)},
+		{syscmd.StepRun, asRoot("chown", owner+":"+owner, errorLog)},
+		{syscmd.StepRun, asRoot("chmod", "644", errorLog)},
+		{syscmd.StepPrivileged, []string{"fpm.restart"}},
+		{syscmd.StepRun, asRoot("systemctl", "restart", "php8.3-fpm")},
+		{syscmd.StepPrivileged, []string{"nginx.apply_vhost"}},
+		{syscmd.StepWrite, []string{vhost}},
+		{syscmd.StepRun, asRoot("ln", "-sfn", vhost, layout.SitesEnabled("example.com.conf"))},
+		{syscmd.StepRun, asRoot("nginx", "-t")},
+		{syscmd.StepRun, asRoot("systemctl", "reload", "nginx")},
+		{syscmd.StepRun, []string{"mkdir", "-p", storage, cache}},
+	}
+	want = append(want, chown...)
+	want = append(want, asProject(php, composerPath(), "install", "--no-dev", "--optimize-autoloader", "-n", "--no-interaction")...)
+	for _, command := range []string{"key:generate --force", "optimize", "storage:link", "route:clear", "route:cache",
+		"config:clear", "config:cache", "view:clear", "view:cache"} {
+		want = append(want, asProject(append([]string{php, artisan}, strings.Fields(command)...)...)...)
+	}
+	want = append(want, chown...)
+
+	steps := rec.Steps()
+	for i, s := range steps {
+		if i >= len(want) {
+			t.Fatalf("unexpected step %d: %s %q %s", i, s.Kind, s.Args, s.Path)
+		}
+		var got []string
+		switch s.Kind {
+		case syscmd.StepPrivileged:
+			got = []string{s.Command}
+		case syscmd.StepWrite, syscmd.StepWriteUser:
+			got = []string{s.Path}
+		default:
+			got = s.Args
+		}
+		if s.Kind != want[i].kind || !slices.Equal(got, want[i].args) {
+			t.Errorf("step %d = %s %q, want %s %q", i, s.Kind, got, want[i].kind, want[i].args)
+		}
+	}
+	if len(steps) < len(want) {
+		t.Fatalf("%d steps, want %d", len(steps), len(want))
+	}
+
+	// the files written, with the pool run as the project's user behind the vhost
+	files := map[string]string{}
+	for _, s := range steps {
+		if s.Kind == syscmd.StepWrite || s.Kind == syscmd.StepWriteUser {
+			files[s.Path] = s.Content
+		}
+	}
+	if len(files) != 2 {
+		t.Errorf("wrote %d files, want the pool and the vhost", len(files))
+	}
+	socket := layout.FPMSocket("8.3", "example.com")
+	for _, line := range []string{"user = " + owner, "group = " + owner, "listen = " + socket, "php_admin_value[error_log] = " + errorLog} {
+		if !strings.Contains(files[pool], line+"\n") {
+			t.Errorf("pool lacks %q:\n%s", line, files[pool])
+		}
+	}
+	for _, line := range []string{"root " + filepath.Join(dir, "public") + ";", "fastcgi_pass unix:" + socket + ";"} {
+		if !strings.Contains(files[vhost], line) {
+			t.Errorf("vhost lacks %q:\n%s", line, files[vhost])
+		}
+	}
+}
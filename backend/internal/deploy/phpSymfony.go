@@ -0,0 +1,204 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+)
+
+// DeploySymfonySite deploys a Symfony site with a domain-specific FPM pool, an nginx vhost
+// serving public/ through the index.php front controller, a .env.local for production
+// pointing at db, a warmed up prod cache and a writable var/.
+// db, when nil, leaves DATABASE_URL to the project's own .env files.
+// Command output goes to the operation log in ctx.
+func DeploySymfonySite(ctx context.Context, domain, projectPath, sysUser string, db *models.Database, settings *models.ProjectNginxSettings) error {
+	if domain == "" || projectPath == "" || sysUser == "" {
+		return fmt.Errorf("domain, projectPath and sysUser are required")
+	}
+
+	// 1) Detect PHP version from composer.json
+	phpVersion := detectPHPVersionSafe(projectPath)
+	phpBin := fmt.Sprintf("/usr/bin/php%s", phpVersion)
+	socketPath := layout.FPMSocket(phpVersion, domain)
+	oplog.Printf(ctx, "Using PHP %s\n", phpVersion)
+
+	// 2) Create the project's user and an FPM pool (owned by root) running as it, with its error log
+	if err := createProjectUser(ctx, domain); err != nil {
+		return err
+	}
+	if err := syscmd.Privileged(ctx, &broker.WriteFPMPool{PHPVersion: phpVersion, Domain: domain, User: sysUser}); err != nil {
+		return fmt.Errorf("write fpm pool: %w", err)
+	}
+
+	if err := syscmd.Privileged(ctx, &broker.RestartFPM{PHPVersion: phpVersion}); err != nil {
+		return fmt.Errorf("restart php%s-fpm: %w", phpVersion, err)
+	}
+
+	// 3) Create nginx config: everything that is not a file goes to public/index.php
+	site := nginx.Site{
+		Domain:  domain,
+		Aliases: nginx.WithWWW(domain),
+		Root:    filepath.Join(projectPath, "public"),
+		Charset: "utf-8",
+		Headers: []nginx.Header{
+			{Name: "X-Frame-Options", Value: "SAMEORIGIN"},
+			{Name: "X-Content-Type-Options", Value: "nosniff"},
+		},
+		QuietFiles: true,
+		DenyHidden: true,
+		PHP:        &nginx.PHP{Socket: socketPath, FrontController: true, Buffers: true},
+	}
+	// the custom rules of the project (redirects, body size, snippet)
+	site.Customize(settings)
+	// write & enable site, test & reload nginx (rolled back if the test fails)
+	if err := syscmd.Privileged(ctx, &broker.ApplyNginxVhost{Name: nginx.ConfName(domain), Site: site}); err != nil {
+		return fmt.Errorf("apply nginx conf: %w", err)
+	}
+
+	// 4) Write .env.local, read by the cache commands below
+	envPath := filepath.Join(projectPath, ".env.local")
+	existing, _ := os.ReadFile(envPath)
+	env, err := symfonyEnv(existing, db)
+	if err != nil {
+		return err
+	}
+	if err := syscmd.WriteUserFile(ctx, envPath, []byte(env), 0o640); err != nil {
+		return fmt.Errorf("write .env.local: %w", err)
+	}
+
+	// 5) var/ holds the cache and the logs: the chown makes it writable by the pool's user
+	varDir := filepath.Join(projectPath, "var")
+	_ = syscmd.Run(ctx, "mkdir", "-p", filepath.Join(varDir, "cache"), filepath.Join(varDir, "log"))
+	if err := syscmd.Privileged(ctx, &broker.ChownProject{Path: projectPath, Owner: sysUser}); err != nil {
+		return fmt.Errorf("chown project failed: %w", err)
+	}
+
+	// 6) Rebuild the prod cache (as sysUser)
+	if err := runConsole(ctx, domain, projectPath, phpBin); err != nil {
+		return fmt.Errorf("console commands failed: %w", err)
+	}
+
+	oplog.Printf(ctx, "✅ Symfony site %s deployed\n", domain)
+	return nil
+}
+
+// symfonyEnv returns the .env.local of a production Symfony site. The APP_SECRET of the
+// existing file is kept, so sessions and signed URLs survive a redeploy.
+func symfonyEnv(existing []byte, db *models.Database) (string, error) {
+	secret := ""
+	scanner := bufio.NewScanner(bytes.NewReader(existing))
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "APP_SECRET="); ok && v != "" {
+			secret = v
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("generate APP_SECRET: %w", err)
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	var env strings.Builder
+	env.WriteString("# Managed by vpanel. Changes made here are overwritten when the site is redeployed.\n")
+	env.WriteString("APP_ENV=prod\n")
+	env.WriteString("APP_DEBUG=0\n")
+	fmt.Fprintf(&env, "APP_SECRET=%s\n", secret)
+	if db != nil && db.User != nil && db.User.Username != "" {
+		dsn, err := databaseURL(db)
+		if err != nil {
+			return "", err
+		}
+		// single quotes: Symfony does not expand $ in them
+		fmt.Fprintf(&env, "DATABASE_URL='%s'\n", dsn)
+	}
+	return env.String(), nil
+}
+
+// databaseURL returns the Doctrine URL of db on the local database server
+func databaseURL(db *models.Database) (string, error) {
+	u := url.URL{
+		User: url.UserPassword(db.User.Username, db.User.Password),
+		Path: "/" + db.DBName,
+	}
+	switch db.DBType {
+	case "mysql":
+		u.Scheme, u.Host, u.RawQuery = "mysql", "127.0.0.1:3306", "charset=utf8mb4"
+	case "postgresql":
+		u.Scheme, u.Host, u.RawQuery = "postgresql", "127.0.0.1:5432", "charset=utf8"
+	default:
+		return "", fmt.Errorf("unsupported database type %q", db.DBType)
+	}
+	// ' is left as is in the user info but would end the quoted value
+	return strings.ReplaceAll(u.String(), "'", "%27"), nil
+}
+
+// runConsole rebuilds the prod cache of the Symfony project of domain at projectPath, as
+// the project's user
+func runConsole(ctx context.Context, domain, projectPath, phpBin string) error {
+	console := filepath.Join(projectPath, "bin", "console")
+	commands := [][]string{
+		{"cache:clear", "--env=prod", "--no-debug"},
+		{"cache:warmup", "--env=prod", "--no-debug"},
+	}
+	for _, args := range commands {
+		cmdArgs := append([]string{phpBin, console}, args...)
+		if err := runAsProject(ctx, domain, projectPath, nil, cmdArgs...); err != nil {
+			return fmt.Errorf("console %s failed: %w", strings.Join(args, " "), err)
+		}
+	}
+	return nil
+}
+
+// symfony is the Deployer of Symfony sites uploaded, with their vendor directory, to the
+// project directory
+type symfony struct{}
+
+func (symfony) Framework() string { return "Symfony" }
+
+func (symfony) Prepare(ctx context.Context, t Target) error {
+	if t.Domain == "" || t.Directory == "" || t.SysUser == "" {
+		return fmt.Errorf("domain, projectPath and sysUser are required")
+	}
+	for _, f := range []string{filepath.Join("bin", "console"), filepath.Join("public", "index.php")} {
+		if !exists(filepath.Join(t.Directory, f)) {
+			return fmt.Errorf("%s does not hold a Symfony project (no %s); upload the project files first", t.Directory, f)
+		}
+	}
+	return nil
+}
+
+func (symfony) Deploy(ctx context.Context, t Target) error {
+	return DeploySymfonySite(ctx, t.Domain, t.Directory, t.SysUser, t.Database, t.Settings)
+}
+
+func (symfony) Suspend(ctx context.Context, t Target) error { return SuspendSite(ctx, t.Domain) }
+
+func (symfony) Resume(ctx context.Context, t Target) error { return ResumeSite(ctx, t.Domain) }
+
+func (symfony) Delete(ctx context.Context, t Target) error {
+	return DeletePHPSite(ctx, t.Directory, t.SysUser, t.Domain, detectPHPVersionSafe(t.Directory))
+}
+
+func (d symfony) Status(ctx context.Context, t Target) (*SiteStatus, error) {
+	st := siteStatus(d.Framework(), t.Domain)
+	st.FilesPresent = exists(filepath.Join(t.Directory, "bin", "console"))
+	st.Upstream = layout.FPMSocket(detectPHPVersionSafe(t.Directory), t.Domain)
+	st.UpstreamUp = exists(st.Upstream)
+	return st, nil
+}
@@ -0,0 +1,263 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/systemd"
+)
+
+// FrameworkPython is the framework of Python web applications (Django, Flask, FastAPI, ...)
+// run from a virtualenv of the project as a systemd service on a unix socket
+const FrameworkPython = "Python"
+
+// Servers a Python application runs under
+const (
+	PythonServerGunicorn = "gunicorn" // WSGI, e.g. Django or Flask
+	PythonServerUvicorn  = "uvicorn"  // ASGI, e.g. FastAPI
+)
+
+const (
+	// defaultPythonWorkers is the number of worker processes when none is set
+	defaultPythonWorkers = 2
+	// maxPythonWorkers bounds the worker processes of an application
+	maxPythonWorkers = 32
+	// pythonVenv is the folder of the project holding its virtualenv
+	pythonVenv = ".venv"
+)
+
+// pythonModulePattern matches the callable of an application, e.g. mysite.wsgi:application
+var pythonModulePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*:[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PythonUnit returns the service unit of the Python application of t, run as t.SysUser
+// from the virtualenv of the project
+func PythonUnit(t Target) (systemd.Unit, error) {
+	py := t.Python
+	if py == nil {
+		return systemd.Unit{}, errors.New("the Python application is not configured; save its module first")
+	}
+	if !pythonModulePattern.MatchString(py.Module) {
+		return systemd.Unit{}, fmt.Errorf("invalid module %q (use package.module:callable, e.g. mysite.wsgi:application)", py.Module)
+	}
+	workers := py.Workers
+	if workers == 0 {
+		workers = defaultPythonWorkers
+	}
+	if workers < 1 || workers > maxPythonWorkers {
+		return systemd.Unit{}, fmt.Errorf("workers must be between 1 and %d", maxPythonWorkers)
+	}
+	if _, err := pythonStaticDir(t); err != nil {
+		return systemd.Unit{}, err
+	}
+
+	socket := systemd.SocketPath(t.Domain)
+	bin := filepath.Join(t.Directory, pythonVenv, "bin")
+	// the socket is only reachable by local processes, so the headers set by nginx are trusted
+	var command []string
+	switch py.Server {
+	case PythonServerGunicorn:
+		command = []string{filepath.Join(bin, "gunicorn"), "--bind", "unix:" + socket,
+			"--workers", strconv.Itoa(workers), "--forwarded-allow-ips=*", "--access-logfile", "-", py.Module}
+	case PythonServerUvicorn:
+		command = []string{filepath.Join(bin, "uvicorn"), "--uds", socket,
+			"--workers", strconv.Itoa(workers), "--proxy-headers", "--forwarded-allow-ips=*", py.Module}
+	default:
+		return systemd.Unit{}, fmt.Errorf("invalid server %q (use %s or %s)", py.Server, PythonServerGunicorn, PythonServerUvicorn)
+	}
+
+	env := make(map[string]string, len(py.Env)+1)
+	env["PYTHONUNBUFFERED"] = "1" // print() reaches the journal right away
+	for name, value := range py.Env {
+		env[name] = value
+	}
+	unit := systemd.Unit{
+		Domain:     t.Domain,
+		Command:    strings.Join(command, " "),
+		WorkingDir: t.Directory,
+		User:       t.SysUser,
+		Env:        env,
+		Socket:     true,
+	}
+	return unit, unit.Validate()
+}
+
+// pythonStaticDir returns the folder nginx serves at the static URL of the application of
+// t, empty when it has none
+func pythonStaticDir(t Target) (string, error) {
+	py := t.Python
+	if py.StaticURL == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(py.StaticURL, "/") || !strings.HasSuffix(py.StaticURL, "/") || py.StaticURL == "/" {
+		return "", fmt.Errorf("invalid static URL %q (e.g. /static/)", py.StaticURL)
+	}
+	if py.StaticDir == "" || filepath.IsAbs(py.StaticDir) {
+		return "", fmt.Errorf("invalid static folder %q (relative to the project, e.g. staticfiles)", py.StaticDir)
+	}
+	dir := filepath.Join(t.Directory, py.StaticDir)
+	// nginx may not serve the files of another project, nor the virtualenv
+	rel, err := filepath.Rel(t.Directory, dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") ||
+		rel == pythonVenv || strings.HasPrefix(rel, pythonVenv+string(filepath.Separator)) {
+		return "", fmt.Errorf("static folder %s must be inside the project directory", py.StaticDir)
+	}
+	return dir, nil
+}
+
+// DeployPythonApp installs the requirements of the Python application of t in its
+// virtualenv, runs the Django management commands asked for, (re)starts its service and
+// serves it on t.Domain through nginx, with the static folder served by nginx itself.
+// Command output goes to the operation log in ctx.
+func DeployPythonApp(ctx context.Context, t Target) error {
+	unit, err := PythonUnit(t)
+	if err != nil {
+		return err
+	}
+	py := t.Python
+	venv := filepath.Join(t.Directory, pythonVenv)
+	python := filepath.Join(venv, "bin", "python")
+
+	// 1) The project belongs to the user the application runs as, the project's own
+	if err := createProjectUser(ctx, t.Domain); err != nil {
+		return err
+	}
+	if err := syscmd.Privileged(ctx, &broker.ChownProject{Path: t.Directory, Owner: t.SysUser}); err != nil {
+		return fmt.Errorf("chown project failed: %w", err)
+	}
+
+	// 2) Create the virtualenv once, then install the requirements and the server in it
+	if !exists(python) {
+		oplog.Printf(ctx, "Creating the virtualenv %s\n", venv)
+		if err := runPython(ctx, t, nil, "/usr/bin/python3", "-m", "venv", venv); err != nil {
+			return err
+		}
+	}
+	pip := []string{"-m", "pip", "install", "--no-input", "--disable-pip-version-check", "-r", "requirements.txt", py.Server}
+	if err := runPython(ctx, t, nil, python, pip...); err != nil {
+		return err
+	}
+
+	// 3) Django management commands, with the environment of the service
+	if py.Migrate {
+		if err := runPython(ctx, t, unit.Env, python, "manage.py", "migrate", "--noinput"); err != nil {
+			return err
+		}
+	}
+	if py.CollectStatic {
+		if err := runPython(ctx, t, unit.Env, python, "manage.py", "collectstatic", "--noinput"); err != nil {
+			return err
+		}
+	}
+
+	// 4) Install and (re)start the service
+	oplog.Printf(ctx, "Installing %s on %s\n", unit.Name(), systemd.SocketPath(t.Domain))
+	if err := syscmd.Privileged(ctx, &broker.WriteAppUnit{
+		Domain:     unit.Domain,
+		Command:    unit.Command,
+		WorkingDir: unit.WorkingDir,
+		User:       unit.User,
+		Env:        unit.Env,
+		Socket:     true,
+	}); err != nil {
+		return fmt.Errorf("install service: %w", err)
+	}
+	if err := syscmd.Privileged(ctx, &broker.ControlApp{Domain: t.Domain, Action: "restart"}); err != nil {
+		return fmt.Errorf("start service: %w", err)
+	}
+
+	// 5) Create nginx config: the static folder is served by nginx, the rest by the application
+	site := nginx.Site{
+		Domain:  t.Domain,
+		Aliases: nginx.WithWWW(t.Domain),
+		Proxy: &nginx.Proxy{
+			Pass:      "http://unix:" + systemd.SocketPath(t.Domain),
+			WebSocket: py.Server == PythonServerUvicorn,
+		},
+	}
+	if dir, _ := pythonStaticDir(t); dir != "" {
+		site.StaticDirs = []nginx.StaticDir{{Path: py.StaticURL, Dir: dir}}
+	}
+	// the custom rules of the project (redirects, body size, snippet)
+	site.Customize(t.Settings)
+	// write & enable site, test & reload nginx (rolled back if the test fails)
+	if err := syscmd.Privileged(ctx, &broker.ApplyNginxVhost{Name: nginx.ConfName(t.Domain), Site: site}); err != nil {
+		return fmt.Errorf("apply nginx conf: %w", err)
+	}
+
+	oplog.Printf(ctx, "✅ Python application %s deployed\n", t.Domain)
+	return nil
+}
+
+// runPython runs name, an absolute path, with args in the project directory of t, with env
+// added to its environment. It runs as t.SysUser, the project's user.
+func runPython(ctx context.Context, t Target, env map[string]string, name string, args ...string) error {
+	names := make([]string, 0, len(env))
+	for n := range env {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	vars := make([]string, 0, len(names))
+	for _, n := range names {
+		vars = append(vars, n+"="+env[n])
+	}
+	if err := runAsProject(ctx, t.Domain, t.Directory, vars, append([]string{name}, args...)...); err != nil {
+		return fmt.Errorf("%s %s failed: %w", filepath.Base(name), strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// python is the Deployer of Python applications uploaded, with their requirements.txt,
+// to the project directory
+type python struct{}
+
+func (python) Framework() string { return FrameworkPython }
+
+func (python) Prepare(ctx context.Context, t Target) error {
+	if t.Domain == "" || t.Directory == "" || t.SysUser == "" {
+		return fmt.Errorf("domain, projectPath and sysUser are required")
+	}
+	if !exists(filepath.Join(t.Directory, "requirements.txt")) {
+		return fmt.Errorf("%s has no requirements.txt; upload the project files first", t.Directory)
+	}
+	if t.Python != nil && (t.Python.Migrate || t.Python.CollectStatic) && !exists(filepath.Join(t.Directory, "manage.py")) {
+		return fmt.Errorf("%s has no manage.py to run migrate or collectstatic with", t.Directory)
+	}
+	_, err := PythonUnit(t)
+	return err
+}
+
+func (python) Deploy(ctx context.Context, t Target) error {
+	return DeployPythonApp(ctx, t)
+}
+
+// Suspend and Resume stop and start the service like those of other applications
+func (python) Suspend(ctx context.Context, t Target) error { return app{}.Suspend(ctx, t) }
+
+func (python) Resume(ctx context.Context, t Target) error { return app{}.Resume(ctx, t) }
+
+func (python) Delete(ctx context.Context, t Target) error {
+	return DeleteApp(ctx, t.Domain, t.Directory)
+}
+
+func (d python) Status(ctx context.Context, t Target) (*SiteStatus, error) {
+	st := siteStatus(d.Framework(), t.Domain)
+	st.FilesPresent = exists(filepath.Join(t.Directory, "requirements.txt"))
+	st.Upstream = systemd.SocketPath(t.Domain)
+	if conn, err := net.DialTimeout("unix", st.Upstream, time.Second); err == nil {
+		conn.Close()
+		st.UpstreamUp = true
+	}
+	return st, nil
+}
@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+)
+
+// The nginx side of a site is the same whatever its framework: these helpers serve the
+// Suspend, Resume, Delete and Status methods of every Deployer.
+
+// SuspendSite creates a temporary Nginx block that includes the necessary SSL paths
+// extracted from the original configuration file to pass the 'nginx -t' test.
+func SuspendSite(ctx context.Context, domain string) error {
+	confName := nginx.ConfName(domain)
+	originalConfPath := layout.SitesAvailable(confName)
+	suspendedConfName := nginx.SuspendedConfName(domain)
+
+	// --- 1. Extract SSL Configuration from the Original File ---
+	// Command: grep -E 'ssl_certificate|ssl_certificate_key' /path/to/original.conf
+	// (sites-available is readable by everyone)
+
+	oplog.Printf(ctx, "Extracting SSL paths from: %s\n", originalConfPath)
+
+	// Only stderr/err is expected on failure; the error carries it.
+	output, err := syscmd.Query(ctx, "grep", "-E", "ssl_certificate|ssl_certificate_key", originalConfPath)
+
+	var sslCert *nginx.SSL
+	if err != nil {
+		// If grep fails (e.g., file not found, or command fails), we assume the original site
+		// didn't have an SSL block and proceed without SSL lines.
+		// However, since we know this site uses SSL, we return a detailed error.
+		if strings.Contains(err.Error(), "No such file or directory") {
+			return fmt.Errorf("original Nginx config %s not found", originalConfPath)
+		}
+		// If grep succeeds, output contains the lines. If it fails due to a syntax error
+		// or permission error, we catch it here.
+		oplog.Printf(ctx, "Warning: Failed to grep SSL block, assuming plain HTTP suspend. Error: %v\n", err)
+	} else {
+		// If grep succeeds, the output contains the necessary lines.
+		sslCert = nginx.SSLFromConfig(output)
+	}
+
+	// --- 2. Define the Suspension Block Content ---
+	// We listen on 443 with the extracted SSL paths, when the site has them.
+	block := nginx.Site{
+		Domain:  domain,
+		Aliases: nginx.WithWWW(domain),
+		SSL:     sslCert,
+		// Explicitly return 403 (Forbidden) to prevent any unwanted redirects.
+		Return: &nginx.Return{Code: 403, Text: "Site has been temporarily suspended."},
+	}
+
+	// --- Step A: Write the suspension block, point the site's symlink at it, then test and
+	// reload Nginx. If the test fails the active site config is linked again. ---
+	if err := syscmd.Privileged(ctx, &broker.ApplyNginxVhost{Name: suspendedConfName, LinkName: confName, Site: block}); err != nil {
+		return fmt.Errorf("failed to apply suspended block: %w", err)
+	}
+
+	return nil
+}
+
+// ResumeSite links the active site config again in place of the suspension block and
+// reloads Nginx, in one step of the broker: the suspension block is only removed once
+// Nginx serves the site again.
+func ResumeSite(ctx context.Context, domain string) error {
+	oplog.Printf(ctx, "Restoring the site config of %s\n", domain)
+	if err := syscmd.Privileged(ctx, &broker.ResumeNginxSite{Domain: strings.ToLower(domain)}); err != nil {
+		return fmt.Errorf("failed to resume the site: %w", err)
+	}
+	return nil
+}
+
+// removeSiteConfig removes the vhost of domain under its current and earlier names,
+// its suspension block, basic auth users and maintenance page. nginx is not reloaded.
+func removeSiteConfig(ctx context.Context, domain string) {
+	if err := syscmd.Privileged(ctx, &broker.RemoveSiteConfig{Domain: strings.ToLower(domain)}); err != nil {
+		oplog.Printf(ctx, "⚠ Failed to remove the nginx config of %s: %v\n", domain, err)
+	}
+}
+
+// createProjectUser creates the system user the site of domain runs as, unless it exists
+func createProjectUser(ctx context.Context, domain string) error {
+	if err := syscmd.Privileged(ctx, &broker.CreateProjectUser{Domain: strings.ToLower(domain)}); err != nil {
+		return fmt.Errorf("create the project's user: %w", err)
+	}
+	return nil
+}
+
+// removeProjectUser removes the system user of the site of domain, once nothing runs as it
+func removeProjectUser(ctx context.Context, domain string) {
+	if err := syscmd.Privileged(ctx, &broker.RemoveProjectUser{Domain: strings.ToLower(domain)}); err != nil {
+		oplog.Printf(ctx, "⚠ Failed to remove the user of %s: %v\n", domain, err)
+	}
+}
+
+// runAsProject runs command, whose program is an absolute path, in dir as the system user
+// of the site of domain. Its environment holds HOME (dir), PATH and env (NAME=value) only.
+func runAsProject(ctx context.Context, domain, dir string, env []string, command ...string) error {
+	return syscmd.Privileged(ctx, &broker.RunProjectCommand{Domain: strings.ToLower(domain), Dir: dir, Command: command, Env: env})
+}
+
+// siteStatus returns what nginx serves for domain; the deployer fills in the rest
+func siteStatus(framework, domain string) *SiteStatus {
+	st := &SiteStatus{Framework: framework}
+	link := layout.SitesEnabled(nginx.ConfName(domain))
+	if _, err := os.Stat(link); err == nil {
+		st.Enabled = true
+		if target, err := os.Readlink(link); err == nil {
+			st.Suspended = filepath.Base(target) == nginx.SuspendedConfName(domain)
+		}
+	}
+	st.Maintenance = exists(layout.Maintenance(strings.ToLower(domain), nginx.MaintenanceConfName))
+	return st
+}
+
+// exists reports whether path exists
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
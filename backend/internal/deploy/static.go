@@ -0,0 +1,133 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+)
+
+// FrameworkStatic is the framework of sites made of files only, e.g. a React or Vue build.
+// They need no database.
+const FrameworkStatic = "Static"
+
+// staticBuildDirs are the folders build tools write to, looked in when a zip holds the
+// build folder instead of its files
+var staticBuildDirs = []string{"dist", "build", "public", "out"}
+
+// staticRoot returns the folder of projectPath holding index.html
+func staticRoot(projectPath string) (string, error) {
+	if exists(filepath.Join(projectPath, "index.html")) {
+		return projectPath, nil
+	}
+	for _, dir := range staticBuildDirs {
+		root := filepath.Join(projectPath, dir)
+		if exists(filepath.Join(root, "index.html")) {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("no index.html in %s or its %v folders; upload the build first", projectPath, staticBuildDirs)
+}
+
+// DeployStaticSite serves the files uploaded to projectPath on domain, compressed and with
+// hashed assets cached for a year. No PHP-FPM pool is created.
+// Command output goes to the operation log in ctx.
+func DeployStaticSite(ctx context.Context, domain, projectPath, sysUser string, settings *models.ProjectNginxSettings) error {
+	if domain == "" || projectPath == "" || sysUser == "" {
+		return fmt.Errorf("domain, projectPath and sysUser are required")
+	}
+
+	root, err := staticRoot(projectPath)
+	if err != nil {
+		return err
+	}
+	oplog.Printf(ctx, "Serving %s\n", root)
+
+	// 1) nginx reads the files: owned by sysUser, the project's user, readable by everyone
+	if err := createProjectUser(ctx, domain); err != nil {
+		return err
+	}
+	if err := syscmd.Privileged(ctx, &broker.ChownProject{Path: projectPath, Owner: sysUser}); err != nil {
+		return fmt.Errorf("chown project failed: %w", err)
+	}
+	if err := runAsProject(ctx, domain, projectPath, nil, "/bin/chmod", "-R", "u+rwX,go+rX", projectPath); err != nil {
+		return fmt.Errorf("chmod project: %w", err)
+	}
+
+	// 2) Create nginx config
+	site := nginx.Site{
+		Domain:      domain,
+		Aliases:     nginx.WithWWW(domain),
+		Root:        root,
+		Charset:     "utf-8",
+		QuietFiles:  true,
+		DenyHidden:  true,
+		Gzip:        true,
+		CacheAssets: true,
+	}
+	// the custom rules of the project (redirects, body size, snippet, SPA fallback)
+	site.Customize(settings)
+	// write & enable site, test & reload nginx (rolled back if the test fails)
+	if err := syscmd.Privileged(ctx, &broker.ApplyNginxVhost{Name: nginx.ConfName(domain), Site: site}); err != nil {
+		return fmt.Errorf("apply nginx conf: %w", err)
+	}
+
+	oplog.Printf(ctx, "✅ Static site %s deployed\n", domain)
+	return nil
+}
+
+// DeleteStaticSite removes the files and the nginx configuration of a static site
+func DeleteStaticSite(ctx context.Context, domain, projectPath string) error {
+	if domain == "" || projectPath == "" {
+		return fmt.Errorf("domain and projectPath are required")
+	}
+
+	removeSiteConfig(ctx, domain)
+	if err := syscmd.Privileged(ctx, &broker.ReloadNginx{}); err != nil {
+		oplog.Println(ctx, "⚠ Nginx test failed after deletion, please check manually")
+	}
+
+	if err := syscmd.Privileged(ctx, &broker.RemoveProject{Path: projectPath}); err != nil {
+		return fmt.Errorf("remove project files: %w", err)
+	}
+	removeProjectUser(ctx, domain)
+	oplog.Println(ctx, "Project deleted successfully:", domain)
+	return nil
+}
+
+// static is the Deployer of static sites uploaded to the project directory
+type static struct{}
+
+func (static) Framework() string { return FrameworkStatic }
+
+func (static) Prepare(ctx context.Context, t Target) error {
+	if t.Domain == "" || t.Directory == "" || t.SysUser == "" {
+		return fmt.Errorf("domain, projectPath and sysUser are required")
+	}
+	_, err := staticRoot(t.Directory)
+	return err
+}
+
+func (static) Deploy(ctx context.Context, t Target) error {
+	return DeployStaticSite(ctx, t.Domain, t.Directory, t.SysUser, t.Settings)
+}
+
+func (static) Suspend(ctx context.Context, t Target) error { return SuspendSite(ctx, t.Domain) }
+
+func (static) Resume(ctx context.Context, t Target) error { return ResumeSite(ctx, t.Domain) }
+
+func (static) Delete(ctx context.Context, t Target) error {
+	return DeleteStaticSite(ctx, t.Domain, t.Directory)
+}
+
+func (d static) Status(ctx context.Context, t Target) (*SiteStatus, error) {
+	st := siteStatus(d.Framework(), t.Domain)
+	_, err := staticRoot(t.Directory)
+	st.FilesPresent = err == nil
+	return st, nil
+}
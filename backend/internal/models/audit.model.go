@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit outcomes
+const (
+	AuditOutcomeSuccess = "success" // 2xx/3xx
+	AuditOutcomeFailure = "failure" // 4xx (except 401/403) and 5xx
+	AuditOutcomeDenied  = "denied"  // 401/403
+)
+
+// AuditEvent records one mutating API request
+type AuditEvent struct {
+	ID           int64           `json:"id"`
+	ActorUserID  *int64          `json:"actorUserId,omitempty"`
+	ActorName    string          `json:"actorName"`
+	ActorRole    string          `json:"actorRole"`
+	APIKeyID     *int64          `json:"apiKeyId,omitempty"`
+	IPAddress    string          `json:"ipAddress"`
+	UserAgent    string          `json:"userAgent"`
+	Action       string          `json:"action"`
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	Target       string          `json:"target"`
+	Params       json.RawMessage `json:"params"`
+	StatusCode   int             `json:"statusCode"`
+	Outcome      string          `json:"outcome"`
+	ErrorMessage string          `json:"errorMessage,omitempty"`
+	DurationMs   int64           `json:"durationMs"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+// AuditFilter narrows an audit event query. Zero values are ignored.
+type AuditFilter struct {
+	ActorUserID int64
+	Action      string // exact action, or a prefix ending in "." (e.g. "wordpress.")
+	Target      string
+	Outcome     string
+	From        time.Time
+	To          time.Time
+	Page        int
+	Limit       int
+}
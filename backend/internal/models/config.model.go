@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"net"
+	"time"
+)
 
 // Response is the type for response
 type Response struct {
@@ -18,10 +21,22 @@ type JWT struct {
 	Audience  string    `json:"aud"`
 	ExpiresAt int64     `json:"exp"`
 	IssuedAt  int64     `json:"iat"`
+	TokenID   string    `json:"jti"` // unique per access token, used for revocation
+	SessionID string    `json:"sid"` // refresh token family the access token belongs to
+	Purpose   string    `json:"purpose,omitempty"` // set on limited tokens (e.g. "mfa" challenge); empty for access tokens
+	Target    string    `json:"target,omitempty"`  // what a limited token is bound to (the job ID of a log ticket)
+	APIKeyID  int64     `json:"apiKeyId,omitempty"` // set when the request authenticated with an API key
+	Scopes    []string  `json:"scopes,omitempty"`   // permissions the API key carries
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Purposes of limited tokens that must never be accepted as access tokens
+const (
+	TokenPurposeMFA    = "mfa"     // issued by Signin, exchanged for tokens at /verify-totp
+	TokenPurposeJobLog = "job_log" // issued by /jobs/log/ticket, redeemed once by /jobs/log/stream
+)
+
 type JWTConfig struct {
 	SecretKey string
 	Issuer    string
@@ -38,11 +53,49 @@ type DBConfig struct {
 	PostgreSQLRootDSN string
 }
 
+// SecurityConfig controls sign-in throttling and how the client IP is determined
+type SecurityConfig struct {
+	TrustedProxies   []*net.IPNet  // reverse proxies allowed to set X-Forwarded-For
+	MaxLoginFailures int           // consecutive failed passwords before the account is locked
+	LockoutDuration  time.Duration // how long a locked account stays locked
+	TOTPKey          Secret        // encrypts the TOTP secrets stored in the database
+}
+
+// Secret is key material; it prints masked so logging the configuration cannot leak it
+type Secret []byte
+
+func (Secret) String() string { return "********" }
+
+// LayoutConfig says where the panel finds and writes host files. Every path is prefixed
+// with Root, so the panel can run against a temp directory instead of the real system.
+type LayoutConfig struct {
+	Root                string // sandbox root; empty on a real server
+	NginxSitesAvailable string
+	NginxSitesEnabled   string
+	NginxHtpasswdDir    string // basic auth users of the sites, one file per domain
+	NginxMaintenanceDir string // maintenance mode snippet and page of the sites, one directory per domain
+	SystemdDir          string // service units of the applications the panel runs
+	PHPConfDir          string // per-version PHP config, <dir>/<version>/fpm/pool.d holds the FPM pools
+	PHPRunDir           string // PHP-FPM sockets
+	RunDir              string // where systemd creates the runtime directories of the application services
+	LogDir              string // per-site PHP error logs
+	LetsEncryptDir      string
+	SSLDir              string
+	HomeDir             string // parent of the system users' home directories
+	PanelDir            string // projects, templates and uploads of the panel
+	DataDir             string // uploaded images and job output logs; relative to the working directory unless absolute
+	TempDir             string
+}
+
 type Config struct {
-	Host  string
-	Port  int64
-	Env   string
-	Owner string
-	JWT   JWTConfig
-	DB    DBConfig
+	Host         string
+	Port         int64
+	Env          string
+	Owner        string
+	JWT          JWTConfig
+	DB           DBConfig
+	Security     SecurityConfig
+	Layout       LayoutConfig
+	BrokerSocket string // privilege broker socket
+	SudoFallback bool   // run privileged operations through sudo instead of the broker
 }
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job statuses
+const (
+	JobStatusQueued      = "queued"
+	JobStatusRunning     = "running"
+	JobStatusSucceeded   = "succeeded"
+	JobStatusFailed      = "failed"
+	JobStatusInterrupted = "interrupted" // the panel stopped while the job was running
+	JobStatusCancelled   = "cancelled"
+)
+
+// Job types
+const (
+	JobTypeWordPressDeploy = "wordpress.deploy"
+	JobTypePHPDeploy       = "php.deploy"
+	JobTypeSSLIssue        = "ssl.issue"
+)
+
+// Job is a long operation (deployment, certificate issuance) run by a background worker
+type Job struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Status      string          `json:"status"`
+	Target      string          `json:"target"` // domain or project the job works on
+	Payload     json.RawMessage `json:"payload"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	RequestedBy *int64          `json:"requestedBy,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	WorkerID    string          `json:"workerId,omitempty"`
+	StartedAt   *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// JobFilter narrows a job listing. Zero values are ignored.
+type JobFilter struct {
+	Status string
+	Type   string
+	Target string
+	Page   int
+	Limit  int
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// NginxRedirect answers the requests of a path with a redirect
+type NginxRedirect struct {
+	From  string `json:"from"`  // exact path, or a regular expression when Regex is set
+	To    string `json:"to"`    // URL or path; may use the $1.. captures of a regular expression
+	Code  int    `json:"code"`  // 301, 302, 307 or 308
+	Regex bool   `json:"regex"` // match From as a case-sensitive regular expression
+}
+
+// ProjectNginxSettings holds the custom nginx rules of a project, rendered into its
+// vhost on every deploy
+type ProjectNginxSettings struct {
+	ProjectID         int64           `json:"projectId"`
+	Redirects         []NginxRedirect `json:"redirects"`
+	ClientMaxBodySize string          `json:"clientMaxBodySize"` // overrides the framework default, e.g. 512M
+	Snippet           string          `json:"snippet"`           // extra directives inside the server block
+	SPAFallback       bool            `json:"spaFallback"`       // static sites: serve /index.html for paths that are not files
+	UpdatedAt         time.Time       `json:"updatedAt"`
+
+	Access []AccessRule `json:"-"` // managed through the access endpoints
+}
+
+// AccessRule restricts who may reach a path of a project's site, by basic auth, client
+// address or both
+type AccessRule struct {
+	ID         int64     `json:"id"`
+	ProjectID  int64     `json:"projectId"`
+	Path       string    `json:"path"`       // "/" covers the whole site
+	BasicAuth  bool      `json:"basicAuth"`  // ask for one of the project's access users
+	Allow      []string  `json:"allow"`      // addresses or CIDRs; when set, every other address is denied
+	Deny       []string  `json:"deny"`       // addresses or CIDRs, checked before Allow
+	SatisfyAny bool      `json:"satisfyAny"` // an allowed address or a valid password is enough
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// AccessUser is a basic auth user of a project's site
+type AccessUser struct {
+	ID           int64     `json:"id"`
+	ProjectID    int64     `json:"projectId"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"` // bcrypt
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// ProjectMaintenance is the maintenance mode of a project's site
+type ProjectMaintenance struct {
+	ProjectID  int64     `json:"projectId"`
+	Enabled    bool      `json:"enabled"`
+	RetryAfter int       `json:"retryAfter"` // seconds, sent in the Retry-After header
+	PageHTML   string    `json:"pageHtml"`   // the page served with the 503; the panel's default when empty
+	AllowIPs   []string  `json:"allowIps"`   // client addresses that see the site instead
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
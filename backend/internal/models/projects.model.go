@@ -30,3 +30,31 @@ type Project struct {
 	DomainInfo       *Domain   `json:"domainInfo"`
 	DatabaseInfo     *Database `json:"databaseInfo"`
 }
+
+// ProjectApp is the application of a proxied project: a command the panel runs as a
+// systemd service, listening on Port behind the site's vhost
+type ProjectApp struct {
+	ProjectID  int64             `json:"projectId"`
+	Command    string            `json:"command"`    // e.g. /usr/bin/node server.js
+	WorkingDir string            `json:"workingDir"` // the project directory when empty
+	Env        map[string]string `json:"env"`        // PORT is added by the panel
+	Restart    string            `json:"restart"`    // always, on-failure or no
+	RunAs      string            `json:"runAs"`      // system user; must be the project's user, used when empty
+	Port       int               `json:"port"`       // allocated by the panel
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
+
+// ProjectPythonApp is the application of a Python project: a WSGI or ASGI callable run
+// by gunicorn or uvicorn from a virtualenv in the project directory, on a unix socket
+type ProjectPythonApp struct {
+	ProjectID     int64             `json:"projectId"`
+	Module        string            `json:"module"`        // e.g. mysite.wsgi:application or main:app
+	Server        string            `json:"server"`        // gunicorn (WSGI) or uvicorn (ASGI)
+	Workers       int               `json:"workers"`       // worker processes; 2 when 0
+	Env           map[string]string `json:"env"`           // SOCKET is added by the panel
+	StaticURL     string            `json:"staticUrl"`     // e.g. /static/; none when empty
+	StaticDir     string            `json:"staticDir"`     // served at StaticURL, relative to the project directory
+	Migrate       bool              `json:"migrate"`       // run manage.py migrate on deploy
+	CollectStatic bool              `json:"collectStatic"` // run manage.py collectstatic on deploy
+	UpdatedAt     time.Time         `json:"updatedAt"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// RefreshToken is a single link in a rotating refresh token family.
+// Every sign-in starts a new family; each refresh replaces the current link.
+type RefreshToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"userId"`
+	FamilyID   string     `json:"familyId"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	ReplacedBy *int64     `json:"replacedBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Reasons recorded with a failed sign-in attempt
+const (
+	LoginReasonUnknownUser     = "unknown_user"
+	LoginReasonInvalidPassword = "invalid_password"
+	LoginReasonLocked          = "locked"
+	LoginReasonInactive        = "inactive"
+	LoginReasonInvalidTOTP     = "invalid_totp"
+)
+
+// LoginAttempt is one recorded sign-in attempt
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	UserID    *int64    `json:"userId,omitempty"`
+	IPAddress string    `json:"ipAddress"`
+	UserAgent string    `json:"userAgent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIKeyPrefix starts every API key so it can be told apart from a JWT in the Authorization header
+const APIKeyPrefix = "vpk_"
+
+// APIKey lets automation call the API on behalf of a panel user with a reduced set of permissions
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the key, for display
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowedIps"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP *string    `json:"lastUsedIp,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+
+	// Owner details, filled when the key is looked up for authentication
+	OwnerName   string `json:"-"`
+	OwnerEmail  string `json:"-"`
+	OwnerRole   string `json:"-"`
+	OwnerStatus string `json:"-"`
+}
@@ -2,18 +2,25 @@ package models
 
 import "time"
 
+const (
+	UserStatusActive   = "active"
+	UserStatusInactive = "inactive"
+)
+
 // User represents a user in the system.
 type User struct {
-	ID           int64     `json:"id"`
-	Name         string    `json:"name"`
-	Role         string    `json:"role"`
-	Status       string    `json:"status"`
-	Mobile       string    `json:"mobile"`
-	Email        string    `json:"email"`
-	Password     string    `json:"-"` // don't expose passwords in JSON
-	JoiningDate  time.Time `json:"joiningDate"`
-	Address      string    `json:"address"`
-	AvatarLink   string    `json:"avatarLink,omitempty"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"`
+	Mobile      string     `json:"mobile"`
+	Email       string     `json:"email"`
+	Password    string     `json:"-"` // don't expose passwords in JSON
+	JoiningDate time.Time  `json:"joiningDate"`
+	Address     string     `json:"address"`
+	AvatarLink  string     `json:"avatarLink,omitempty"`
+	TOTPEnabled bool       `json:"totpEnabled"`           // two-factor sign-in required
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"` // set after too many failed sign-ins
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
 }
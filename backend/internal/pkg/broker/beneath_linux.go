@@ -0,0 +1,225 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Flags of the *at system calls that package syscall does not define
+const (
+	atSymlinkNoFollow = 0x100
+	atRemoveDir       = 0x200
+)
+
+// chownBeneath recursively gives path, inside one of roots, to uid:gid (see chownTree).
+// Every component
+// is opened relative to the previous one without following symlinks, and the tree is
+// changed through those descriptors, so a symlink swapped into the path after the
+// broker checked it cannot point the operation at another file.
+func chownBeneath(roots []string, path string, uid, gid int) error {
+	parent, name, err := openParentBeneath(roots, path)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	dir, err := openDirAt(parent, name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return chownTree(dir, uid, gid)
+}
+
+// removeBeneath removes path, inside one of roots, with everything in it, like
+// chownBeneath never following a symlink. A path that does not exist is not an error.
+func removeBeneath(roots []string, path string) error {
+	parent, name, err := openParentBeneath(roots, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	return removeTree(parent, name)
+}
+
+// openParentBeneath opens the folder holding path, one component at a time from the
+// root containing it, and returns it with the last component of path
+func openParentBeneath(roots []string, path string) (*os.File, string, error) {
+	root, rel, ok := rootOf(roots, path)
+	if !ok {
+		return nil, "", fmt.Errorf("%s is outside the project directories", path)
+	}
+	// the roots are set by the administrator; only what is below them is walked
+	dir, err := os.Open(root)
+	if err != nil {
+		return nil, "", err
+	}
+	elems := strings.Split(rel, string(filepath.Separator))
+	for _, elem := range elems[:len(elems)-1] {
+		next, err := openDirAt(dir, elem)
+		dir.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		dir = next
+	}
+	return dir, elems[len(elems)-1], nil
+}
+
+// openDirAt opens the folder name of dir, failing when name is a symlink
+func openDirAt(dir *os.File, name string) (*os.File, error) {
+	path := filepath.Join(dir.Name(), name)
+	fd, err := syscall.Openat(int(dir.Fd()), name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// chownTree gives dir and everything in it to uid:gid with group read and write access
+// (see ChownProject). Files are changed through a descriptor opened without following
+// symlinks; one with several hard links is refused, as its other names may be outside
+// the project.
+func chownTree(dir *os.File, uid, gid int) error {
+	if err := fchown(dir, uid, gid, groupDirMode); err != nil {
+		return err
+	}
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var err error
+		switch {
+		case e.IsDir():
+			var sub *os.File
+			if sub, err = openDirAt(dir, e.Name()); err == nil {
+				err = chownTree(sub, uid, gid)
+				sub.Close()
+			}
+		case e.Type()&os.ModeSymlink != 0:
+			err = lchownAt(dir, e.Name(), uid, gid)
+		default:
+			err = chownFileAt(dir, e.Name(), uid, gid)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The permission bits chownTree adds: the panel's group reads and writes the files and
+// the files created in a folder get its group
+const (
+	groupFileMode = 0o060
+	groupDirMode  = 0o2070
+)
+
+// chownFileAt gives the entry name of dir, which is not a folder, to uid:gid
+func chownFileAt(dir *os.File, name string, uid, gid int) error {
+	path := filepath.Join(dir.Name(), name)
+	fd, err := syscall.Openat(int(dir.Fd()), name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	switch {
+	case errors.Is(err, syscall.ELOOP):
+		// replaced by a symlink since the folder was read
+		return lchownAt(dir, name, uid, gid)
+	case errors.Is(err, syscall.ENXIO):
+		// a socket, which cannot be opened nor hard linked to
+		return lchownAt(dir, name, uid, gid)
+	case err != nil:
+		return &os.PathError{Op: "openat", Path: path, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), path)
+	defer f.Close()
+	var mode uint32
+	if st, err := statFd(f); err != nil {
+		return err
+	} else if st.Nlink > 1 {
+		return fmt.Errorf("%s has %d hard links", path, st.Nlink)
+	} else if st.Mode&syscall.S_IFMT == syscall.S_IFREG {
+		mode = groupFileMode
+	}
+	return fchown(f, uid, gid, mode)
+}
+
+// fchown gives f to uid:gid and adds the permission bits add to its mode
+func fchown(f *os.File, uid, gid int, add uint32) error {
+	if err := f.Chown(uid, gid); err != nil {
+		return err
+	}
+	if add == 0 {
+		return nil
+	}
+	st, err := statFd(f)
+	if err != nil {
+		return err
+	}
+	// chown cleared the setgid bit of the folder, so it is set again from here
+	if err := syscall.Fchmod(int(f.Fd()), st.Mode&0o7777|add); err != nil {
+		return &os.PathError{Op: "fchmod", Path: f.Name(), Err: err}
+	}
+	return nil
+}
+
+// lchownAt gives the entry name of dir to uid:gid, a symlink itself rather than what it
+// points to
+func lchownAt(dir *os.File, name string, uid, gid int) error {
+	if err := syscall.Fchownat(int(dir.Fd()), name, uid, gid, atSymlinkNoFollow); err != nil {
+		return &os.PathError{Op: "fchownat", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	return nil
+}
+
+func statFd(f *os.File) (*syscall.Stat_t, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return nil, &os.PathError{Op: "fstat", Path: f.Name(), Err: err}
+	}
+	return &st, nil
+}
+
+// removeTree removes the entry name of parent: a folder with everything in it, or the
+// file or symlink itself
+func removeTree(parent *os.File, name string) error {
+	dir, err := openDirAt(parent, name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case errors.Is(err, syscall.ENOTDIR), errors.Is(err, syscall.ELOOP):
+		return unlinkAt(parent, name, 0)
+	case err != nil:
+		return err
+	}
+	entries, err := dir.ReadDir(-1)
+	if err == nil {
+		for _, e := range entries {
+			if err = removeTree(dir, e.Name()); err != nil {
+				break
+			}
+		}
+	}
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	return unlinkAt(parent, name, atRemoveDir)
+}
+
+func unlinkAt(dir *os.File, name string, flags int) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_UNLINKAT, dir.Fd(), uintptr(unsafe.Pointer(p)), uintptr(flags)); errno != 0 {
+		return &os.PathError{Op: "unlinkat", Path: filepath.Join(dir.Name(), name), Err: errno}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tree creates the files of a project under a root in a temp directory, with a file
+// outside the root its symlinks point at
+func tree(t *testing.T) (root, project, outside string) {
+	t.Helper()
+	dir := t.TempDir()
+	root = filepath.Join(dir, "root")
+	project = filepath.Join(root, "example.com")
+	outside = filepath.Join(dir, "secret")
+	if err := os.MkdirAll(filepath.Join(project, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{outside, filepath.Join(project, "index.php"), filepath.Join(project, "sub", "app.php")} {
+		if err := os.WriteFile(f, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(project, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return root, project, outside
+}
+
+func mode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Mode()
+}
+
+func TestChownBeneath(t *testing.T) {
+	root, project, outside := tree(t)
+	if err := chownBeneath([]string{root}, project, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{project, filepath.Join(project, "sub")} {
+		if m := mode(t, d); m&os.ModeSetgid == 0 || m.Perm()&0o070 != 0o070 {
+			t.Errorf("%s mode = %s, want g+rwxs", d, m)
+		}
+	}
+	for _, f := range []string{filepath.Join(project, "index.php"), filepath.Join(project, "sub", "app.php")} {
+		if m := mode(t, f); m.Perm() != 0o660 {
+			t.Errorf("%s mode = %s, want -rw-rw----", f, m)
+		}
+	}
+	// the symlink is not followed
+	if m := mode(t, outside); m.Perm() != 0o600 {
+		t.Errorf("%s mode = %s, changed through the symlink", outside, m)
+	}
+}
+
+func TestChownBeneathRefusesHardLinks(t *testing.T) {
+	root, project, outside := tree(t)
+	if err := os.Link(outside, filepath.Join(project, "sub", "hardlink")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	err := chownBeneath([]string{root}, project, os.Getuid(), os.Getgid())
+	if err == nil || !strings.Contains(err.Error(), "hard links") {
+		t.Fatalf("chownBeneath = %v, want a hard link error", err)
+	}
+	if m := mode(t, outside); m.Perm() != 0o600 {
+		t.Errorf("%s mode = %s, changed through the hard link", outside, m)
+	}
+}
+
+func TestBeneathRejectsPathsLeavingTheRoot(t *testing.T) {
+	root, project, _ := tree(t)
+	// a symlink in the path, as a site could create in its own folder
+	if err := os.Symlink(filepath.Dir(root), filepath.Join(project, "up")); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{
+		root,
+		filepath.Dir(root),
+		filepath.Join(project, "up", "root"),
+		filepath.Join(project, "link"),
+	}
+	for _, path := range paths {
+		if err := chownBeneath([]string{root}, path, os.Getuid(), os.Getgid()); err == nil {
+			t.Errorf("chownBeneath(%s) = nil, want an error", path)
+		}
+	}
+	for _, path := range paths[:3] {
+		if err := removeBeneath([]string{root}, path); err == nil {
+			t.Errorf("removeBeneath(%s) = nil, want an error", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(project, "index.php")); err != nil {
+		t.Errorf("the project was changed: %v", err)
+	}
+}
+
+func TestRemoveBeneath(t *testing.T) {
+	root, project, outside := tree(t)
+	if err := removeBeneath([]string{root}, project); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(project); !os.IsNotExist(err) {
+		t.Errorf("%s still exists: %v", project, err)
+	}
+	// what the symlink pointed at is kept
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("%s was removed through the symlink: %v", outside, err)
+	}
+	// removing it again is not an error
+	if err := removeBeneath([]string{root}, project); err != nil {
+		t.Errorf("removeBeneath of a missing path = %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package broker
+
+import "errors"
+
+// chownBeneath is only implemented on Linux, the one platform the panel manages
+func chownBeneath(roots []string, path string, uid, gid int) error {
+	return errors.New("chown beneath a root is not supported on this platform")
+}
+
+// removeBeneath is only implemented on Linux, the one platform the panel manages
+func removeBeneath(roots []string, path string) error {
+	return errors.New("remove beneath a root is not supported on this platform")
+}
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+)
+
+// DefaultSocket is where the broker listens unless configured otherwise
+const DefaultSocket = "/run/vpanel/broker.sock"
+
+// request is one operation sent to the broker, as a single JSON line
+type request struct {
+	Kind string          `json:"kind"`
+	Op   json.RawMessage `json:"op"`
+}
+
+// response is the broker's answer to a request
+type response struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Output string `json:"output,omitempty"` // what the commands of the operation printed
+
+	Nginx *nginx.TestError `json:"nginx,omitempty"` // set when nginx rejected the configuration
+}
+
+// Client sends operations to the broker
+type Client struct {
+	Socket string
+}
+
+// Do sends op to the broker and waits for it to finish. What the operation printed
+// goes to the operation log in ctx.
+func (c *Client) Do(ctx context.Context, op syscmd.Op) error {
+	if err := op.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", op.Kind(), err)
+	}
+	raw, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.Socket)
+	if err != nil {
+		return fmt.Errorf("privilege broker unavailable: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// unblock the read below when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	oplog.Printf(ctx, "broker: %s", op.Kind())
+	if err := json.NewEncoder(conn).Encode(request{Kind: op.Kind(), Op: raw}); err != nil {
+		return fmt.Errorf("privilege broker: %w", err)
+	}
+
+	var resp response
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("privilege broker: %w", err)
+	}
+	writeOutput(ctx, resp.Output)
+	if resp.Nginx != nil {
+		return fmt.Errorf("%s: %w", op.Kind(), resp.Nginx)
+	}
+	if !resp.OK {
+		return fmt.Errorf("%s: %s", op.Kind(), resp.Error)
+	}
+	return nil
+}
+
+// writeOutput copies what the broker ran into the operation log in ctx
+func writeOutput(ctx context.Context, output string) {
+	if output == "" {
+		return
+	}
+	l := oplog.FromContext(ctx)
+	if l == nil {
+		os.Stdout.WriteString(output)
+		return
+	}
+	w := l.Writer(oplog.StreamStdout)
+	w.Write([]byte(output))
+	w.Flush()
+}
+
+// Executor runs commands like syscmd.Real but sends privileged operations to the broker,
+// so the panel itself does not need sudo for them
+type Executor struct {
+	syscmd.Real
+	Client *Client
+}
+
+// NewExecutor returns an Executor using the broker listening on socket
+func NewExecutor(socket string) *Executor {
+	return &Executor{Client: &Client{Socket: socket}}
+}
+
+func (e *Executor) Privileged(ctx context.Context, op syscmd.Op) error {
+	return e.Client.Do(ctx, op)
+}
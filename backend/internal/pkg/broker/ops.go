@@ -0,0 +1,982 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/fpm"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/systemd"
+	sysuser "github.com/projuktisheba/vpanel/backend/internal/pkg/sysuser"
+)
+
+// maxConfigSize bounds the htpasswd files and maintenance pages an operation may write
+const maxConfigSize = 256 << 10
+
+var (
+	// configNamePattern matches nginx vhost file names (no slashes, no "..")
+	configNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*(\.[A-Za-z0-9_-]+)*$`)
+	// domainPattern matches a host name of dot separated labels
+	domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	// emailPattern is deliberately plain: certbot validates the address itself
+	emailPattern = regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
+	// phpVersionPattern matches major.minor PHP versions such as 8.2
+	phpVersionPattern = regexp.MustCompile(`^[5-9]\.[0-9]$`)
+	// accountPattern matches system user and group names
+	accountPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+)
+
+// newOp returns an empty operation of kind, for decoding a request
+func newOp(kind string) (syscmd.Op, bool) {
+	switch kind {
+	case KindEnableNginxSite:
+		return &EnableNginxSite{}, true
+	case KindApplyNginxVhost:
+		return &ApplyNginxVhost{}, true
+	case KindTestNginxVhost:
+		return &TestNginxVhost{}, true
+	case KindReloadNginx:
+		return &ReloadNginx{}, true
+	case KindResumeNginxSite:
+		return &ResumeNginxSite{}, true
+	case KindRemoveSiteConfig:
+		return &RemoveSiteConfig{}, true
+	case KindWriteHtpasswd:
+		return &WriteHtpasswd{}, true
+	case KindSetMaintenance:
+		return &SetMaintenance{}, true
+	case KindWriteFPMPool:
+		return &WriteFPMPool{}, true
+	case KindRestartFPM:
+		return &RestartFPM{}, true
+	case KindRemoveFPMPool:
+		return &RemoveFPMPool{}, true
+	case KindChownProject:
+		return &ChownProject{}, true
+	case KindRemoveProject:
+		return &RemoveProject{}, true
+	case KindIssueCertificate:
+		return &IssueCertificate{}, true
+	case KindDeleteCertificate:
+		return &DeleteCertificate{}, true
+	case KindInstallPackages:
+		return &InstallPackages{}, true
+	case KindCreateProjectUser:
+		return &CreateProjectUser{}, true
+	case KindRemoveProjectUser:
+		return &RemoveProjectUser{}, true
+	case KindRunProjectCommand:
+		return &RunProjectCommand{}, true
+	case KindWriteAppUnit:
+		return &WriteAppUnit{}, true
+	case KindControlApp:
+		return &ControlApp{}, true
+	case KindRemoveAppUnit:
+		return &RemoveAppUnit{}, true
+	}
+	return nil, false
+}
+
+// Kinds of operations
+const (
+	KindEnableNginxSite   = "nginx.enable_site"
+	KindApplyNginxVhost   = "nginx.apply_vhost"
+	KindTestNginxVhost    = "nginx.test_vhost"
+	KindReloadNginx       = "nginx.reload"
+	KindResumeNginxSite   = "nginx.resume_site"
+	KindRemoveSiteConfig  = "nginx.remove_site"
+	KindWriteHtpasswd     = "nginx.write_htpasswd"
+	KindSetMaintenance    = "nginx.set_maintenance"
+	KindWriteFPMPool      = "fpm.write_pool"
+	KindRestartFPM        = "fpm.restart"
+	KindRemoveFPMPool     = "fpm.remove_pool"
+	KindChownProject      = "project.chown"
+	KindRemoveProject     = "project.remove"
+	KindIssueCertificate  = "certbot.issue"
+	KindDeleteCertificate = "certbot.delete"
+	KindInstallPackages   = "apt.install"
+	KindCreateProjectUser = "project.create_user"
+	KindRemoveProjectUser = "project.remove_user"
+	KindRunProjectCommand = "project.run"
+	KindWriteAppUnit      = "app.write_unit"
+	KindControlApp        = "app.control"
+	KindRemoveAppUnit     = "app.remove_unit"
+)
+
+// ==================== nginx ====================
+
+// EnableNginxSite links sites-available/Name into sites-enabled as LinkName
+// (Name when empty), replacing what was linked there
+type EnableNginxSite struct {
+	Name     string `json:"name"`
+	LinkName string `json:"linkName,omitempty"`
+}
+
+func (op *EnableNginxSite) Kind() string { return KindEnableNginxSite }
+
+func (op *EnableNginxSite) Validate() error {
+	if err := validateConfigName(op.Name); err != nil {
+		return err
+	}
+	if op.LinkName != "" {
+		return validateConfigName(op.LinkName)
+	}
+	return nil
+}
+
+func (op *EnableNginxSite) Apply(ctx context.Context) error {
+	return syscmd.Sudo(ctx, "ln", "-sfn", layout.SitesAvailable(op.Name), op.linkPath())
+}
+
+func (op *EnableNginxSite) linkPath() string {
+	if op.LinkName == "" {
+		return layout.SitesEnabled(op.Name)
+	}
+	return layout.SitesEnabled(op.LinkName)
+}
+
+// ApplyNginxVhost renders Site into sites-available/Name, links it into sites-enabled as
+// LinkName (Name when empty) and reloads nginx. The broker renders the vhost itself, so
+// only what nginx.Site allows can be installed. When nginx -t rejects the result, the
+// previous vhost and link are put back and the error is a *nginx.TestError.
+type ApplyNginxVhost struct {
+	Name     string     `json:"name"`
+	LinkName string     `json:"linkName,omitempty"`
+	Site     nginx.Site `json:"site"`
+}
+
+func (op *ApplyNginxVhost) Kind() string { return KindApplyNginxVhost }
+
+func (op *ApplyNginxVhost) Validate() error {
+	if err := (&EnableNginxSite{Name: op.Name, LinkName: op.LinkName}).Validate(); err != nil {
+		return err
+	}
+	return op.Site.Validate()
+}
+
+func (op *ApplyNginxVhost) Apply(ctx context.Context) error {
+	content, err := nginx.Render(op.Site)
+	if err != nil {
+		return err
+	}
+	enable := &EnableNginxSite{Name: op.Name, LinkName: op.LinkName}
+	vhost := layout.SitesAvailable(op.Name)
+	return nginxTransaction(ctx, []string{vhost, enable.linkPath()}, func() error {
+		if err := syscmd.WriteFile(ctx, vhost, content); err != nil {
+			return err
+		}
+		return enable.Apply(ctx)
+	})
+}
+
+// testVhostName is the sites-enabled entry TestNginxVhost writes its vhost to
+const testVhostName = "vpanel-test-vhost.conf"
+
+// TestNginxVhost checks that nginx accepts Site as one more enabled vhost, without
+// reloading nginx. The vhost is removed again whatever the outcome; a rejection is a
+// *nginx.TestError.
+type TestNginxVhost struct {
+	Site nginx.Site `json:"site"`
+}
+
+func (op *TestNginxVhost) Kind() string { return KindTestNginxVhost }
+
+func (op *TestNginxVhost) Validate() error { return op.Site.Validate() }
+
+func (op *TestNginxVhost) Apply(ctx context.Context) error {
+	content, err := nginx.Render(op.Site)
+	if err != nil {
+		return err
+	}
+	nginxMu.Lock()
+	defer nginxMu.Unlock()
+	path := layout.SitesEnabled(testVhostName)
+	if err := syscmd.WriteFile(ctx, path, content); err != nil {
+		return err
+	}
+	// removed even when ctx is cancelled, so it never reaches a later reload
+	defer syscmd.Sudo(context.WithoutCancel(ctx), "rm", "-f", path)
+	return testNginx(ctx)
+}
+
+// ReloadNginx tests the nginx configuration and reloads nginx when it passes
+type ReloadNginx struct{}
+
+func (op *ReloadNginx) Kind() string { return KindReloadNginx }
+
+func (op *ReloadNginx) Validate() error { return nil }
+
+func (op *ReloadNginx) Apply(ctx context.Context) error {
+	nginxMu.Lock()
+	defer nginxMu.Unlock()
+	if err := testNginx(ctx); err != nil {
+		return err
+	}
+	if err := syscmd.Sudo(ctx, "systemctl", "reload", "nginx"); err != nil {
+		return fmt.Errorf("nginx reload failed: %w", err)
+	}
+	return nil
+}
+
+// ResumeNginxSite links the vhost of Domain into sites-enabled again in place of its
+// suspension block and reloads nginx. The suspension block is only removed once nginx
+// runs the site again: when the test fails the link is put back, so the site stays
+// suspended rather than pointing at nothing.
+type ResumeNginxSite struct {
+	Domain string `json:"domain"`
+}
+
+func (op *ResumeNginxSite) Kind() string { return KindResumeNginxSite }
+
+func (op *ResumeNginxSite) Validate() error {
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	return nil
+}
+
+func (op *ResumeNginxSite) Apply(ctx context.Context) error {
+	enable := &EnableNginxSite{Name: nginx.ConfName(op.Domain)}
+	if _, err := os.Stat(layout.SitesAvailable(enable.Name)); err != nil {
+		return fmt.Errorf("the vhost of %s is missing, redeploy the site: %w", op.Domain, err)
+	}
+	if err := nginxTransaction(ctx, []string{enable.linkPath()}, func() error {
+		return enable.Apply(ctx)
+	}); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "rm", "-f", layout.SitesAvailable(nginx.SuspendedConfName(op.Domain)))
+}
+
+// RemoveSiteConfig removes the vhost of Domain under its current and earlier names, its
+// suspension block, basic auth users and maintenance page. nginx is not reloaded.
+type RemoveSiteConfig struct {
+	Domain string `json:"domain"`
+}
+
+func (op *RemoveSiteConfig) Kind() string { return KindRemoveSiteConfig }
+
+func (op *RemoveSiteConfig) Validate() error {
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	return nil
+}
+
+func (op *RemoveSiteConfig) Apply(ctx context.Context) error {
+	var paths []string
+	for _, name := range append([]string{nginx.ConfName(op.Domain), nginx.SuspendedConfName(op.Domain)}, nginx.LegacyConfNames(op.Domain)...) {
+		paths = append(paths, layout.SitesAvailable(name), layout.SitesEnabled(name))
+	}
+	paths = append(paths, layout.Htpasswd(op.Domain))
+	if err := syscmd.Sudo(ctx, "rm", append([]string{"-f"}, paths...)...); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "rm", "-rf", layout.Maintenance(op.Domain))
+}
+
+// nginxGroup is the group nginx's workers run as; they read the htpasswd files
+const nginxGroup = "www-data"
+
+// WriteHtpasswd writes the basic auth users of Domain, one "name:bcrypt hash" line each.
+// Empty Content removes the file. nginx reads it on every request, so no reload is needed.
+type WriteHtpasswd struct {
+	Domain  string `json:"domain"`
+	Content string `json:"content"`
+}
+
+func (op *WriteHtpasswd) Kind() string { return KindWriteHtpasswd }
+
+func (op *WriteHtpasswd) Validate() error {
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	if op.Content == "" {
+		return nil
+	}
+	if len(op.Content) > maxConfigSize {
+		return fmt.Errorf("htpasswd content is larger than %d bytes", maxConfigSize)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(op.Content, "\n"), "\n") {
+		name, hash, _ := strings.Cut(line, ":")
+		if err := nginx.ValidateAccessUser(models.AccessUser{Username: name, PasswordHash: hash}); err != nil {
+			return fmt.Errorf("htpasswd: %w", err)
+		}
+	}
+	return nil
+}
+
+func (op *WriteHtpasswd) Apply(ctx context.Context) error {
+	path := layout.Htpasswd(op.Domain)
+	if op.Content == "" {
+		return syscmd.Sudo(ctx, "rm", "-f", path)
+	}
+	if err := syscmd.Sudo(ctx, "mkdir", "-p", filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := syscmd.WriteFile(ctx, path, []byte(op.Content)); err != nil {
+		return err
+	}
+	if err := syscmd.Sudo(ctx, "chown", "root:"+nginxGroup, path); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "chmod", "640", path)
+}
+
+// SetMaintenance switches the maintenance mode of Domain on or off and reloads nginx.
+// On, it writes Page and the snippet the site's vhost includes; off, it removes the
+// snippet. When nginx -t rejects the result, the previous files are put back.
+type SetMaintenance struct {
+	Domain     string   `json:"domain"`
+	Enabled    bool     `json:"enabled"`
+	RetryAfter int      `json:"retryAfter,omitempty"`
+	AllowIPs   []string `json:"allowIps,omitempty"`
+	Page       string   `json:"page,omitempty"`
+}
+
+func (op *SetMaintenance) Kind() string { return KindSetMaintenance }
+
+func (op *SetMaintenance) Validate() error {
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	if !op.Enabled {
+		return nil
+	}
+	if err := op.maintenance().Validate(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(op.Page) == "" {
+		return errors.New("maintenance page is empty")
+	}
+	if len(op.Page) > maxConfigSize {
+		return fmt.Errorf("maintenance page is larger than %d bytes", maxConfigSize)
+	}
+	return nil
+}
+
+func (op *SetMaintenance) Apply(ctx context.Context) error {
+	conf := layout.Maintenance(op.Domain, nginx.MaintenanceConfName)
+	page := layout.Maintenance(op.Domain, nginx.MaintenancePageName)
+	return nginxTransaction(ctx, []string{page, conf}, func() error {
+		if !op.Enabled {
+			return syscmd.Sudo(ctx, "rm", "-f", conf)
+		}
+		snippet, err := nginx.RenderMaintenance(op.maintenance())
+		if err != nil {
+			return err
+		}
+		if err := syscmd.Sudo(ctx, "mkdir", "-p", layout.Maintenance(op.Domain)); err != nil {
+			return err
+		}
+		if err := syscmd.WriteFile(ctx, page, []byte(op.Page)); err != nil {
+			return err
+		}
+		return syscmd.WriteFile(ctx, conf, snippet)
+	})
+}
+
+func (op *SetMaintenance) maintenance() nginx.Maintenance {
+	return nginx.Maintenance{Domain: op.Domain, RetryAfter: op.RetryAfter, AllowIPs: op.AllowIPs}
+}
+
+// ==================== PHP-FPM ====================
+
+// WriteFPMPool renders the pool of Domain on PHP PHPVersion, run as User, into its
+// pool.d folder and creates its error log, owned by User. The broker renders the pool
+// itself, so only what fpm.Pool allows can be installed.
+type WriteFPMPool struct {
+	PHPVersion string `json:"phpVersion"`
+	Domain     string `json:"domain"`
+	User       string `json:"user"`
+}
+
+func (op *WriteFPMPool) Kind() string { return KindWriteFPMPool }
+
+func (op *WriteFPMPool) Validate() error {
+	if err := op.pool().Validate(); err != nil {
+		return err
+	}
+	return validateProjectUser(op.Domain, op.User)
+}
+
+func (op *WriteFPMPool) Apply(ctx context.Context) error {
+	pool := op.pool()
+	content, err := fpm.Render(pool)
+	if err != nil {
+		return err
+	}
+	if err := syscmd.WriteFile(ctx, pool.Path(), content); err != nil {
+		return err
+	}
+	// the workers write the log as User
+	if err := syscmd.Sudo(ctx, "touch", pool.ErrorLog()); err != nil {
+		return err
+	}
+	if err := syscmd.Sudo(ctx, "chown", op.User+":"+op.User, pool.ErrorLog()); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "chmod", "644", pool.ErrorLog())
+}
+
+// Accounts returns the account the pool runs as, for the broker's account check
+func (op *WriteFPMPool) Accounts() []string {
+	return []string{op.User}
+}
+
+func (op *WriteFPMPool) pool() fpm.Pool {
+	return fpm.Pool{PHPVersion: op.PHPVersion, Domain: op.Domain, User: op.User}
+}
+
+// RestartFPM restarts the PHP-FPM service of PHPVersion
+type RestartFPM struct {
+	PHPVersion string `json:"phpVersion"`
+}
+
+func (op *RestartFPM) Kind() string { return KindRestartFPM }
+
+func (op *RestartFPM) Validate() error {
+	if !phpVersionPattern.MatchString(op.PHPVersion) {
+		return fmt.Errorf("invalid PHP version %q", op.PHPVersion)
+	}
+	return nil
+}
+
+func (op *RestartFPM) Apply(ctx context.Context) error {
+	return syscmd.Sudo(ctx, "systemctl", "restart", fmt.Sprintf("php%s-fpm", op.PHPVersion))
+}
+
+// RemoveFPMPool removes the pool of Domain on PHP PHPVersion and its error log, then
+// reloads that PHP-FPM, which stops the workers of the pool. The other pools keep running.
+type RemoveFPMPool struct {
+	PHPVersion string `json:"phpVersion"`
+	Domain     string `json:"domain"`
+}
+
+func (op *RemoveFPMPool) Kind() string { return KindRemoveFPMPool }
+
+func (op *RemoveFPMPool) Validate() error {
+	if !phpVersionPattern.MatchString(op.PHPVersion) {
+		return fmt.Errorf("invalid PHP version %q", op.PHPVersion)
+	}
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	return nil
+}
+
+func (op *RemoveFPMPool) Apply(ctx context.Context) error {
+	pool := fpm.Pool{PHPVersion: op.PHPVersion, Domain: op.Domain}
+	if err := syscmd.Sudo(ctx, "rm", "-f", pool.Path()); err != nil {
+		return err
+	}
+	if err := syscmd.Sudo(ctx, "systemctl", "reload", fmt.Sprintf("php%s-fpm", op.PHPVersion)); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "rm", "-f", pool.ErrorLog())
+}
+
+// ==================== Projects ====================
+
+// ChownProject recursively gives Path to Owner, with the panel's group keeping read and
+// write access so new files can still be uploaded: files get g+rw, folders g+rwxs. A file
+// with several hard links is refused, as it could be a file outside the project.
+// The broker only accepts paths inside its project roots.
+type ChownProject struct {
+	Path  string `json:"path"`
+	Owner string `json:"owner"`
+}
+
+func (op *ChownProject) Kind() string { return KindChownProject }
+
+func (op *ChownProject) Validate() error {
+	if !filepath.IsAbs(op.Path) || filepath.Clean(op.Path) != op.Path || op.Path == "/" {
+		return fmt.Errorf("invalid project path %q", op.Path)
+	}
+	if !accountPattern.MatchString(op.Owner) {
+		return fmt.Errorf("invalid owner %q", op.Owner)
+	}
+	return nil
+}
+
+func (op *ChownProject) Apply(ctx context.Context) error {
+	sc, ok := projectScope(ctx)
+	if !ok {
+		// this process is the panel: its group keeps access
+		return chownWithSudo(ctx, op.Path, op.Owner, os.Getgid())
+	}
+	u, err := user.Lookup(op.Owner)
+	if err != nil {
+		return err
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	return chownBeneath(sc.roots, op.Path, uid, sc.panelGID)
+}
+
+// chownWithSudo is ChownProject outside the broker, with find, chown and chmod, none of
+// which follows a symlink inside the tree
+func chownWithSudo(ctx context.Context, path, owner string, gid int) error {
+	out, err := syscmd.FromContext(ctx).Run(ctx, syscmd.SudoCmd("find", path, "!", "-type", "d", "-links", "+1", "-print", "-quit"))
+	if err != nil {
+		return err
+	}
+	if linked := strings.TrimSpace(string(out)); linked != "" {
+		return fmt.Errorf("%s has several hard links", linked)
+	}
+	if err := syscmd.Sudo(ctx, "chown", "-R", owner+":"+strconv.Itoa(gid), path); err != nil {
+		return err
+	}
+	if err := syscmd.Sudo(ctx, "chmod", "-R", "g+rwX", path); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "find", path, "-type", "d", "-exec", "chmod", "g+s", "{}", "+")
+}
+
+// Paths returns the paths the operation changes, for the broker's project root check
+func (op *ChownProject) Paths() []string {
+	return []string{op.Path}
+}
+
+// Accounts returns the account the project is given to, for the broker's account check.
+// nginx's user is also accepted: the files of a WordPress site belong to its workers.
+func (op *ChownProject) Accounts() []string {
+	if op.Owner == nginxGroup {
+		return nil
+	}
+	return []string{op.Owner}
+}
+
+// RemoveProject removes the directory Path of a project with everything in it.
+// The broker only accepts paths inside its project roots.
+type RemoveProject struct {
+	Path string `json:"path"`
+}
+
+func (op *RemoveProject) Kind() string { return KindRemoveProject }
+
+func (op *RemoveProject) Validate() error {
+	if !filepath.IsAbs(op.Path) || filepath.Clean(op.Path) != op.Path || op.Path == "/" {
+		return fmt.Errorf("invalid project path %q", op.Path)
+	}
+	return nil
+}
+
+func (op *RemoveProject) Apply(ctx context.Context) error {
+	sc, ok := projectScope(ctx)
+	if !ok {
+		return syscmd.Sudo(ctx, "rm", "-rf", op.Path)
+	}
+	return removeBeneath(sc.roots, op.Path)
+}
+
+// Paths returns the paths the operation changes, for the broker's project root check
+func (op *RemoveProject) Paths() []string {
+	return []string{op.Path}
+}
+
+// CreateProjectUser creates the system user the project of Domain runs as (see
+// sysuser.ProjectUser), with a group of its own, no home and no login shell. A user that
+// already exists is kept.
+type CreateProjectUser struct {
+	Domain string `json:"domain"`
+}
+
+func (op *CreateProjectUser) Kind() string { return KindCreateProjectUser }
+
+func (op *CreateProjectUser) Validate() error {
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	return nil
+}
+
+func (op *CreateProjectUser) Apply(ctx context.Context) error {
+	name := sysuser.ProjectUser(op.Domain)
+	if _, err := user.Lookup(name); err == nil {
+		return nil
+	}
+	return syscmd.Sudo(ctx, "useradd", "--system", "--user-group", "--no-create-home",
+		"--home-dir", "/nonexistent", "--shell", "/usr/sbin/nologin", "--comment", op.Domain, name)
+}
+
+// Accounts returns the account created, for the broker's account check
+func (op *CreateProjectUser) Accounts() []string {
+	return []string{sysuser.ProjectUser(op.Domain)}
+}
+
+// RemoveProjectUser removes the system user of the project of Domain and its group.
+// A user that does not exist is not an error.
+type RemoveProjectUser struct {
+	Domain string `json:"domain"`
+}
+
+func (op *RemoveProjectUser) Kind() string { return KindRemoveProjectUser }
+
+func (op *RemoveProjectUser) Validate() error {
+	return (&CreateProjectUser{Domain: op.Domain}).Validate()
+}
+
+func (op *RemoveProjectUser) Apply(ctx context.Context) error {
+	name := sysuser.ProjectUser(op.Domain)
+	if _, err := user.Lookup(name); err != nil {
+		return nil
+	}
+	return syscmd.Sudo(ctx, "userdel", name)
+}
+
+// Accounts returns the account removed, for the broker's account check
+func (op *RemoveProjectUser) Accounts() []string {
+	return []string{sysuser.ProjectUser(op.Domain)}
+}
+
+// projectPATH is the PATH of the commands RunProjectCommand runs
+const projectPATH = "/usr/local/bin:/usr/bin:/bin"
+
+// maxCommandArgs bounds the arguments of a command RunProjectCommand runs
+const maxCommandArgs = 64
+
+// envNamePattern matches the names of environment variables
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RunProjectCommand runs Command in Dir as the system user of the project of Domain, with
+// an environment of only HOME (Dir), PATH and Env. The deployers build the projects with
+// it (composer, artisan, pip, ...), so the code of a project never runs as the panel.
+// Command[0] is an absolute path; on failure the error ends with what the command printed.
+type RunProjectCommand struct {
+	Domain  string   `json:"domain"`
+	Dir     string   `json:"dir"`
+	Command []string `json:"command"`
+	Env     []string `json:"env,omitempty"` // NAME=value
+}
+
+func (op *RunProjectCommand) Kind() string { return KindRunProjectCommand }
+
+func (op *RunProjectCommand) Validate() error {
+	if err := (&CreateProjectUser{Domain: op.Domain}).Validate(); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(op.Dir) || filepath.Clean(op.Dir) != op.Dir || op.Dir == "/" {
+		return fmt.Errorf("invalid project path %q", op.Dir)
+	}
+	if len(op.Command) == 0 || len(op.Command) > maxCommandArgs {
+		return fmt.Errorf("a command has 1 to %d arguments", maxCommandArgs)
+	}
+	if !filepath.IsAbs(op.Command[0]) || filepath.Clean(op.Command[0]) != op.Command[0] {
+		return fmt.Errorf("invalid program %q (use an absolute path)", op.Command[0])
+	}
+	for _, arg := range op.Command {
+		if strings.ContainsRune(arg, 0) {
+			return errors.New("a command argument holds a NUL byte")
+		}
+	}
+	for _, v := range op.Env {
+		name, _, ok := strings.Cut(v, "=")
+		if !ok || !envNamePattern.MatchString(name) || strings.ContainsRune(v, 0) {
+			return fmt.Errorf("invalid environment variable %q", name)
+		}
+	}
+	return nil
+}
+
+func (op *RunProjectCommand) Apply(ctx context.Context) error {
+	args := []string{"-u", sysuser.ProjectUser(op.Domain), "--", "env", "-i", "HOME=" + op.Dir, "PATH=" + projectPATH}
+	args = append(append(args, op.Env...), op.Command...)
+	cmd := syscmd.SudoCmd("runuser", args...)
+	cmd.Dir = op.Dir
+	out, err := syscmd.FromContext(ctx).Run(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", filepath.Base(op.Command[0]), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Paths returns the folder the command runs in, for the broker's project root check
+func (op *RunProjectCommand) Paths() []string {
+	return []string{op.Dir}
+}
+
+// Accounts returns the account the command runs as, for the broker's account check
+func (op *RunProjectCommand) Accounts() []string {
+	return []string{sysuser.ProjectUser(op.Domain)}
+}
+
+// ==================== Certificates ====================
+
+// IssueCertificate runs certbot for Domain. With Nginx it uses the nginx plugin and also
+// covers www.Domain; otherwise it obtains the certificate with the standalone server.
+type IssueCertificate struct {
+	Domain string `json:"domain"`
+	Email  string `json:"email"`
+	Nginx  bool   `json:"nginx"`
+}
+
+func (op *IssueCertificate) Kind() string { return KindIssueCertificate }
+
+func (op *IssueCertificate) Validate() error {
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	if !emailPattern.MatchString(op.Email) {
+		return fmt.Errorf("invalid email %q", op.Email)
+	}
+	return nil
+}
+
+func (op *IssueCertificate) Apply(ctx context.Context) error {
+	args := []string{
+		"certonly",
+		"--standalone",
+		"--non-interactive",
+		"--agree-tos",
+		"--email", op.Email,
+		"-d", op.Domain,
+	}
+	if op.Nginx {
+		args = []string{
+			"--nginx",
+			"-d", op.Domain,
+			"-d", "www." + op.Domain,
+			"--non-interactive",
+			"--agree-tos",
+			"--email", op.Email,
+		}
+	}
+	return syscmd.Sudo(ctx, "certbot", args...)
+}
+
+// DeleteCertificate deletes the certificate of Domain, with its renewal configuration.
+// A domain without a certificate is not an error.
+type DeleteCertificate struct {
+	Domain string `json:"domain"`
+}
+
+func (op *DeleteCertificate) Kind() string { return KindDeleteCertificate }
+
+func (op *DeleteCertificate) Validate() error {
+	if len(op.Domain) > 253 || !domainPattern.MatchString(op.Domain) {
+		return fmt.Errorf("invalid domain %q", op.Domain)
+	}
+	return nil
+}
+
+func (op *DeleteCertificate) Apply(ctx context.Context) error {
+	if _, err := os.Stat(layout.LetsEncrypt("renewal", op.Domain+".conf")); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return syscmd.Sudo(ctx, "certbot", "delete", "--non-interactive", "--cert-name", op.Domain)
+}
+
+// ==================== Packages ====================
+
+// aptAttempts bounds the tries of an apt-get command while another one holds its lock
+const aptAttempts = 5
+
+// phpRepository is the PPA providing every supported PHP version
+const phpRepository = "ppa:ondrej/php"
+
+// packages are the packages InstallPackages may install, besides the PHP extensions
+var packages = map[string]bool{
+	"nginx": true, "mysql-client": true, "unzip": true, "zip": true, "wget": true, "curl": true,
+	"acl": true, "software-properties-common": true, "certbot": true, "python3-certbot-nginx": true,
+}
+
+// phpPackagePattern matches the PHP packages InstallPackages may install, of the default
+// (php-gd) or a given (php8.2-gd) PHP version
+var phpPackagePattern = regexp.MustCompile(`^php([5-9]\.[0-9])?-(fpm|cli|common|mysql|pgsql|xml|mbstring|curl|intl|gd|bcmath|zip|soap)$`)
+
+// InstallPackages installs Packages with apt-get after updating the package lists. With
+// PHPRepository the PPA providing every PHP version is added first. Only the packages
+// the deployers need are accepted.
+type InstallPackages struct {
+	Packages      []string `json:"packages"`
+	PHPRepository bool     `json:"phpRepository,omitempty"`
+}
+
+func (op *InstallPackages) Kind() string { return KindInstallPackages }
+
+func (op *InstallPackages) Validate() error {
+	if len(op.Packages) == 0 {
+		return errors.New("no package to install")
+	}
+	for _, p := range op.Packages {
+		if !packages[p] && !phpPackagePattern.MatchString(p) {
+			return fmt.Errorf("package %q is not allowed", p)
+		}
+	}
+	return nil
+}
+
+func (op *InstallPackages) Apply(ctx context.Context) error {
+	if op.PHPRepository {
+		if err := aptGet(ctx, "install", "-y", "software-properties-common"); err != nil {
+			return err
+		}
+		if err := syscmd.Sudo(ctx, "add-apt-repository", "-y", phpRepository); err != nil {
+			return err
+		}
+	}
+	if err := aptGet(ctx, "update", "-y"); err != nil {
+		return err
+	}
+	return aptGet(ctx, append([]string{"install", "-y"}, op.Packages...)...)
+}
+
+// aptGet runs apt-get with args, trying again while another apt-get holds its lock
+func aptGet(ctx context.Context, args ...string) error {
+	var err error
+	for i := 0; i < aptAttempts; i++ {
+		var out []byte
+		out, err = syscmd.FromContext(ctx).Run(ctx, syscmd.SudoCmd("apt-get", args...))
+		if err == nil || !strings.Contains(string(out), "lock") {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("apt-get %s failed: %w", args[0], err)
+	}
+	return nil
+}
+
+// ==================== Applications ====================
+
+// WriteAppUnit installs and enables the service of the application of Domain. The broker
+// renders the unit itself, so only what systemd.Unit allows can be installed: the
+// application runs as its project's user and WorkingDir must be inside the project roots.
+// The service is not (re)started; see ControlApp.
+type WriteAppUnit struct {
+	Domain     string            `json:"domain"`
+	Command    string            `json:"command"`
+	WorkingDir string            `json:"workingDir"`
+	User       string            `json:"user"`
+	Env        map[string]string `json:"env,omitempty"`
+	Restart    string            `json:"restart,omitempty"`
+	Port       int               `json:"port,omitempty"`
+	Socket     bool              `json:"socket,omitempty"` // listen on systemd.SocketPath(Domain) instead of Port
+}
+
+func (op *WriteAppUnit) Kind() string { return KindWriteAppUnit }
+
+func (op *WriteAppUnit) Validate() error {
+	if err := op.unit().Validate(); err != nil {
+		return err
+	}
+	return validateProjectUser(op.Domain, op.User)
+}
+
+func (op *WriteAppUnit) Apply(ctx context.Context) error {
+	unit := op.unit()
+	content, err := systemd.Render(unit)
+	if err != nil {
+		return err
+	}
+	if err := syscmd.WriteFile(ctx, layout.SystemdUnit(unit.Name()), content); err != nil {
+		return err
+	}
+	if err := syscmd.Sudo(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "systemctl", "enable", unit.Name())
+}
+
+// Paths returns the paths the operation changes, for the broker's project root check
+func (op *WriteAppUnit) Paths() []string {
+	return []string{op.WorkingDir}
+}
+
+// Accounts returns the account the application runs as, for the broker's account check
+func (op *WriteAppUnit) Accounts() []string {
+	return []string{op.User}
+}
+
+func (op *WriteAppUnit) unit() systemd.Unit {
+	return systemd.Unit{
+		Domain:     op.Domain,
+		Command:    op.Command,
+		WorkingDir: op.WorkingDir,
+		User:       op.User,
+		Env:        op.Env,
+		Restart:    op.Restart,
+		Port:       op.Port,
+		Socket:     op.Socket,
+	}
+}
+
+// appActions are the systemctl commands ControlApp runs
+var appActions = map[string]bool{"start": true, "stop": true, "restart": true}
+
+// ControlApp starts, stops or restarts the service of the application of Domain
+type ControlApp struct {
+	Domain string `json:"domain"`
+	Action string `json:"action"`
+}
+
+func (op *ControlApp) Kind() string { return KindControlApp }
+
+func (op *ControlApp) Validate() error {
+	if err := systemd.ValidateDomain(op.Domain); err != nil {
+		return err
+	}
+	if !appActions[op.Action] {
+		return fmt.Errorf("invalid action %q (use start, stop or restart)", op.Action)
+	}
+	return nil
+}
+
+func (op *ControlApp) Apply(ctx context.Context) error {
+	return syscmd.Sudo(ctx, "systemctl", op.Action, systemd.UnitName(op.Domain))
+}
+
+// RemoveAppUnit stops, disables and removes the service of the application of Domain.
+// A service that is not installed is not an error.
+type RemoveAppUnit struct {
+	Domain string `json:"domain"`
+}
+
+func (op *RemoveAppUnit) Kind() string { return KindRemoveAppUnit }
+
+func (op *RemoveAppUnit) Validate() error {
+	return systemd.ValidateDomain(op.Domain)
+}
+
+func (op *RemoveAppUnit) Apply(ctx context.Context) error {
+	name := systemd.UnitName(op.Domain)
+	// fails when the unit is already gone, which is what is wanted
+	_ = syscmd.Sudo(ctx, "systemctl", "disable", "--now", name)
+	if err := syscmd.Sudo(ctx, "rm", "-f", layout.SystemdUnit(name)); err != nil {
+		return err
+	}
+	return syscmd.Sudo(ctx, "systemctl", "daemon-reload")
+}
+
+// ==================== validation ====================
+
+// validateProjectUser checks that a site of domain runs as its own project's user
+func validateProjectUser(domain, name string) error {
+	if want := sysuser.ProjectUser(domain); name != want {
+		return fmt.Errorf("the site of %s runs as its project's user %s, not %q", domain, want, name)
+	}
+	return nil
+}
+
+func validateConfigName(name string) error {
+	if len(name) > 200 || !configNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid config name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	user "github.com/projuktisheba/vpanel/backend/internal/pkg/sysuser"
+)
+
+func TestOpValidate(t *testing.T) {
+	owner := user.ProjectUser("example.com")
+	tests := []struct {
+		name string
+		op   syscmd.Op
+		ok   bool
+	}{
+		{"enable site", &EnableNginxSite{Name: "example.com.conf"}, true},
+		{"enable site outside sites-available", &EnableNginxSite{Name: "../nginx.conf"}, false},
+		{"enable site with a bad link", &EnableNginxSite{Name: "example.com.conf", LinkName: "a/b"}, false},
+		{"resume", &ResumeNginxSite{Domain: "example.com"}, true},
+		{"resume an upper case domain", &ResumeNginxSite{Domain: "Example.com"}, false},
+		{"htpasswd", &WriteHtpasswd{Domain: "example.com", Content: "alice:$2y$10$" + strings.Repeat("a", 53) + "\n"}, true},
+		{"htpasswd removal", &WriteHtpasswd{Domain: "example.com"}, true},
+		{"htpasswd with a plain password", &WriteHtpasswd{Domain: "example.com", Content: "alice:secret"}, false},
+		{"htpasswd with a config line", &WriteHtpasswd{Domain: "example.com", Content: "alice\ninclude /etc/shadow"}, false},
+		{"maintenance off", &SetMaintenance{Domain: "example.com"}, true},
+		{"maintenance without a page", &SetMaintenance{Domain: "example.com", Enabled: true}, false},
+		{"pool", &WriteFPMPool{PHPVersion: "8.3", Domain: "example.com", User: owner}, true},
+		{"pool of the panel's user", &WriteFPMPool{PHPVersion: "8.3", Domain: "example.com", User: "panel"}, false},
+		{"pool of another project's user", &WriteFPMPool{PHPVersion: "8.3", Domain: "example.com", User: user.ProjectUser("example.org")}, false},
+		{"pool of a bad PHP version", &WriteFPMPool{PHPVersion: "8", Domain: "example.com", User: owner}, false},
+		{"restart fpm", &RestartFPM{PHPVersion: "8.3"}, true},
+		{"restart fpm of a command", &RestartFPM{PHPVersion: "8.3; reboot"}, false},
+		{"chown", &ChownProject{Path: "/srv/panel/bin/PHP/example.com", Owner: owner}, true},
+		{"chown a relative path", &ChownProject{Path: "srv/example.com", Owner: owner}, false},
+		{"chown an unclean path", &ChownProject{Path: "/srv/panel/bin/../../etc", Owner: owner}, false},
+		{"chown /", &ChownProject{Path: "/", Owner: owner}, false},
+		{"chown to a bad name", &ChownProject{Path: "/srv/example.com", Owner: "Root:root"}, false},
+		{"remove", &RemoveProject{Path: "/srv/panel/bin/PHP/example.com"}, true},
+		{"remove /", &RemoveProject{Path: "/"}, false},
+		{"create user", &CreateProjectUser{Domain: "example.com"}, true},
+		{"create user of a bad domain", &CreateProjectUser{Domain: "-example.com"}, false},
+		{"remove user of a bad domain", &RemoveProjectUser{Domain: "example.com;"}, false},
+		{"run", &RunProjectCommand{Domain: "example.com", Dir: "/srv/example.com", Command: []string{"/usr/bin/php8.3", "artisan", "optimize"}, Env: []string{"APP_ENV=prod"}}, true},
+		{"run a program from PATH", &RunProjectCommand{Domain: "example.com", Dir: "/srv/example.com", Command: []string{"php", "artisan"}}, false},
+		{"run nothing", &RunProjectCommand{Domain: "example.com", Dir: "/srv/example.com"}, false},
+		{"run in a relative folder", &RunProjectCommand{Domain: "example.com", Dir: "example.com", Command: []string{"/bin/true"}}, false},
+		{"run with a NUL byte", &RunProjectCommand{Domain: "example.com", Dir: "/srv/example.com", Command: []string{"/bin/echo", "a\x00b"}}, false},
+		{"run with a bad variable", &RunProjectCommand{Domain: "example.com", Dir: "/srv/example.com", Command: []string{"/bin/true"}, Env: []string{"LD-PRELOAD=x"}}, false},
+		{"run with a variable without value", &RunProjectCommand{Domain: "example.com", Dir: "/srv/example.com", Command: []string{"/bin/true"}, Env: []string{"PATH"}}, false},
+		{"certificate", &IssueCertificate{Domain: "example.com", Email: "admin@example.com"}, true},
+		{"certificate with a flag as email", &IssueCertificate{Domain: "example.com", Email: "--dry-run"}, false},
+		{"delete certificate of a path", &DeleteCertificate{Domain: "../example.com"}, false},
+		{"install", &InstallPackages{Packages: []string{"nginx", "php8.3-fpm", "php-gd"}}, true},
+		{"install nothing", &InstallPackages{}, false},
+		{"install another package", &InstallPackages{Packages: []string{"netcat-openbsd"}}, false},
+		{"install an option", &InstallPackages{Packages: []string{"-o=APT::Get::AllowUnauthenticated=1"}}, false},
+		{"unit", &WriteAppUnit{Domain: "example.com", Command: "/usr/bin/node server.js", WorkingDir: "/srv/example.com", User: owner, Port: 20000}, true},
+		{"unit as root", &WriteAppUnit{Domain: "example.com", Command: "/usr/bin/node server.js", WorkingDir: "/srv/example.com", User: "root", Port: 20000}, false},
+		{"unit of another project's user", &WriteAppUnit{Domain: "example.com", Command: "/usr/bin/node server.js", WorkingDir: "/srv/example.com", User: user.ProjectUser("example.org"), Port: 20000}, false},
+		{"control", &ControlApp{Domain: "example.com", Action: "restart"}, true},
+		{"control with another action", &ControlApp{Domain: "example.com", Action: "mask"}, false},
+		{"remove unit", &RemoveAppUnit{Domain: "example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("Validate() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestNewOpKnowsEveryKind(t *testing.T) {
+	for _, kind := range []string{
+		KindEnableNginxSite, KindApplyNginxVhost, KindTestNginxVhost, KindReloadNginx, KindResumeNginxSite,
+		KindRemoveSiteConfig, KindWriteHtpasswd, KindSetMaintenance, KindWriteFPMPool, KindRestartFPM,
+		KindRemoveFPMPool, KindChownProject, KindRemoveProject, KindCreateProjectUser, KindRemoveProjectUser,
+		KindRunProjectCommand, KindIssueCertificate, KindDeleteCertificate, KindInstallPackages,
+		KindWriteAppUnit, KindControlApp, KindRemoveAppUnit,
+	} {
+		op, ok := newOp(kind)
+		if !ok {
+			t.Errorf("newOp(%q) is unknown", kind)
+			continue
+		}
+		if op.Kind() != kind {
+			t.Errorf("newOp(%q).Kind() = %q", kind, op.Kind())
+		}
+	}
+	if _, ok := newOp("shell.run"); ok {
+		t.Error(`newOp("shell.run") is known`)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package broker
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID is only implemented on Linux, the one platform the panel manages
+func peerUID(conn *net.UnixConn) (int, error) {
+	return -1, errors.New("peer credentials are not supported on this platform")
+}
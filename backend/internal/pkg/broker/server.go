@@ -0,0 +1,276 @@
+// Package broker lets the panel run without blanket sudo. A small helper running as root
+// (cmd/vpanel-broker) listens on a Unix socket and performs a fixed set of typed
+// operations (apply an nginx vhost, reload nginx, write an FPM pool, chown or remove a
+// project, run a build command as a project's user, issue a certificate, install
+// packages), validating every field before it runs anything. Everything else the panel
+// does runs as its own user; the sites themselves run as their project's user.
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	sysuser "github.com/projuktisheba/vpanel/backend/internal/pkg/sysuser"
+)
+
+// maxRequestSize bounds one request line
+const maxRequestSize = 1 << 20
+
+// opTimeout bounds one operation; certbot is the slowest
+const opTimeout = 10 * time.Minute
+
+// Server performs operations sent by the panel
+type Server struct {
+	Socket       string
+	PanelUID     int      // the only unprivileged user allowed to connect
+	PanelGID     int      // the panel's group, which keeps access to the files of projects
+	PanelUser    string   // the name of PanelUID, which no operation runs as or gives files to
+	ProjectRoots []string // directories project operations may touch
+
+	mu       sync.Mutex // one operation at a time, so nginx changes never interleave
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func NewServer(socket string, panelUID, panelGID int, panelUser string, projectRoots []string, infoLog, errorLog *log.Logger) *Server {
+	return &Server{
+		Socket:       socket,
+		PanelUID:     panelUID,
+		PanelGID:     panelGID,
+		PanelUser:    panelUser,
+		ProjectRoots: projectRoots,
+		infoLog:      infoLog,
+		errorLog:     errorLog,
+	}
+}
+
+// ListenAndServe listens on the socket, owned by the panel user with mode 0600, and
+// serves connections until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.Socket), 0755); err != nil {
+		return err
+	}
+	// a socket left behind by a previous run would make Listen fail
+	if err := os.Remove(s.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ln, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	if err := os.Chmod(s.Socket, 0600); err != nil {
+		return err
+	}
+	if err := os.Chown(s.Socket, s.PanelUID, -1); err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	s.infoLog.Printf("Broker: listening on %s for uid %d", s.Socket, s.PanelUID)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.errorLog.Println("ERROR_01_ListenAndServe:", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go s.serve(ctx, conn.(*net.UnixConn))
+	}
+}
+
+// serve handles the single request of a connection
+func (s *Server) serve(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+
+	uid, err := peerUID(conn)
+	if err != nil {
+		s.errorLog.Println("ERROR_01_serve: peer credentials:", err)
+		return
+	}
+	if uid != s.PanelUID && uid != 0 {
+		s.errorLog.Printf("Broker: rejected connection from uid %d", uid)
+		s.reply(conn, response{Error: "permission denied"})
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(io.LimitReader(conn, maxRequestSize)).Decode(&req); err != nil {
+		s.reply(conn, response{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	s.reply(conn, s.do(ctx, req))
+}
+
+func (s *Server) do(ctx context.Context, req request) response {
+	op, ok := newOp(req.Kind)
+	if !ok {
+		return response{Error: fmt.Sprintf("unknown operation %q", req.Kind)}
+	}
+	dec := json.NewDecoder(bytes.NewReader(req.Op))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(op); err != nil {
+		return response{Error: fmt.Sprintf("invalid %s: %v", req.Kind, err)}
+	}
+	if err := op.Validate(); err != nil {
+		return response{Error: err.Error()}
+	}
+	if err := s.checkPaths(op); err != nil {
+		return response{Error: err.Error()}
+	}
+	if err := s.checkAccounts(op); err != nil {
+		return response{Error: err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	out := &capture{}
+	start := time.Now()
+	err := op.Apply(syscmd.WithExecutor(withScope(ctx, scope{roots: s.ProjectRoots, panelGID: s.PanelGID}), out))
+	if err != nil {
+		s.errorLog.Printf("Broker: %s failed after %s: %v", req.Kind, time.Since(start).Round(time.Millisecond), err)
+		resp := response{Error: err.Error(), Output: out.String()}
+		errors.As(err, &resp.Nginx)
+		return resp
+	}
+	s.infoLog.Printf("Broker: %s done in %s", req.Kind, time.Since(start).Round(time.Millisecond))
+	return response{OK: true, Output: out.String()}
+}
+
+// checkPaths rejects operations on paths outside the project roots. It only gives an early
+// error: the operations resolve their paths again from the roots when they run.
+func (s *Server) checkPaths(op syscmd.Op) error {
+	p, ok := op.(interface{ Paths() []string })
+	if !ok {
+		return nil
+	}
+	for _, path := range p.Paths() {
+		if !s.inProjectRoots(path) {
+			return fmt.Errorf("%s is outside the project directories", path)
+		}
+	}
+	return nil
+}
+
+// checkAccounts rejects operations running something as, or giving files to, an account
+// other than a project's (see sysuser.ProjectUser): never root, nor the panel's own user,
+// whose files and sudo rights a compromised site would otherwise get, nor any other
+// account of the host (a database, ...)
+func (s *Server) checkAccounts(op syscmd.Op) error {
+	a, ok := op.(interface{ Accounts() []string })
+	if !ok {
+		return nil
+	}
+	for _, account := range a.Accounts() {
+		switch {
+		case account == "root" || account == s.PanelUser:
+			return fmt.Errorf("operations may not run as or give files to %s", account)
+		case !sysuser.IsProjectUser(account):
+			return fmt.Errorf("account %q is not a project's user", account)
+		}
+		// only root can give a project's name to uid 0
+		if u, err := user.Lookup(account); err == nil && (u.Uid == "0" || u.Uid == strconv.Itoa(s.PanelUID)) {
+			return fmt.Errorf("account %s has the uid %s", account, u.Uid)
+		}
+	}
+	return nil
+}
+
+func (s *Server) inProjectRoots(path string) bool {
+	_, _, ok := rootOf(s.ProjectRoots, path)
+	return ok
+}
+
+// rootOf returns the root of roots path is below, with path relative to it. Paths are
+// compared as written: the operations open them from the root one component at a time
+// without following symlinks (see chownBeneath), so a link cannot point them elsewhere.
+func rootOf(roots []string, path string) (root, rel string, ok bool) {
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return root, rel, true
+		}
+	}
+	return "", "", false
+}
+
+// scope is what the operations changing projects may touch in the broker
+type scope struct {
+	roots    []string // the project roots
+	panelGID int      // the panel's group, given the files of projects
+}
+
+type scopeKey struct{}
+
+// withScope returns ctx carrying the scope of the broker, for the operations changing
+// projects
+func withScope(ctx context.Context, sc scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, sc)
+}
+
+// projectScope returns the scope in ctx; there is none outside the broker, where the
+// operations run through the executor (sudo or a dry run)
+func projectScope(ctx context.Context) (scope, bool) {
+	sc, ok := ctx.Value(scopeKey{}).(scope)
+	return sc, ok
+}
+
+func (s *Server) reply(conn net.Conn, resp response) {
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.errorLog.Println("ERROR_01_reply:", err)
+	}
+}
+
+// capture is the executor operations run with in the broker: the real one, with
+// everything the commands print collected for the response
+type capture struct {
+	syscmd.Real
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (c *capture) Run(ctx context.Context, cmd syscmd.Cmd) ([]byte, error) {
+	out, err := c.Real.Run(ctx, cmd)
+	c.mu.Lock()
+	fmt.Fprintf(&c.out, "$ %s\n", cmd)
+	c.out.Write(out)
+	c.mu.Unlock()
+	return out, err
+}
+
+// Privileged is not reachable from an operation; nested operations run in place
+func (c *capture) Privileged(ctx context.Context, op syscmd.Op) error {
+	if err := op.Validate(); err != nil {
+		return err
+	}
+	return op.Apply(ctx)
+}
+
+func (c *capture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.String()
+}
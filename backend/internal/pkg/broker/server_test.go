@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+	user "github.com/projuktisheba/vpanel/backend/internal/pkg/sysuser"
+)
+
+func TestRootOf(t *testing.T) {
+	roots := []string{"/srv/panel/bin", "/var/www"}
+	tests := []struct {
+		path string
+		root string
+		rel  string
+		ok   bool
+	}{
+		{"/srv/panel/bin/PHP/example.com", "/srv/panel/bin", "PHP/example.com", true},
+		{"/var/www/example.com", "/var/www", "example.com", true},
+		{"/srv/panel/bin", "", "", false},
+		{"/srv/panel", "", "", false},
+		{"/srv/panel/binaries/example.com", "", "", false},
+		{"/srv/panel/bin/../../../etc", "", "", false},
+		{"/etc/nginx", "", "", false},
+		{"relative/example.com", "", "", false},
+	}
+	for _, tt := range tests {
+		root, rel, ok := rootOf(roots, tt.path)
+		if root != tt.root || rel != tt.rel || ok != tt.ok {
+			t.Errorf("rootOf(%q) = %q, %q, %v, want %q, %q, %v", tt.path, root, rel, ok, tt.root, tt.rel, tt.ok)
+		}
+	}
+}
+
+func testServer() *Server {
+	return &Server{PanelUID: 1000, PanelUser: "panel", ProjectRoots: []string{"/srv/panel/bin"}}
+}
+
+func TestCheckPaths(t *testing.T) {
+	owner := user.ProjectUser("example.com")
+	tests := []struct {
+		name string
+		op   syscmd.Op
+		ok   bool
+	}{
+		{"chown a project", &ChownProject{Path: "/srv/panel/bin/PHP/example.com", Owner: owner}, true},
+		{"chown the root", &ChownProject{Path: "/srv/panel/bin", Owner: owner}, false},
+		{"chown /etc", &ChownProject{Path: "/etc", Owner: owner}, false},
+		{"remove a project", &RemoveProject{Path: "/srv/panel/bin/PHP/example.com"}, true},
+		{"remove the panel", &RemoveProject{Path: "/srv/panel"}, false},
+		{"run in a project", &RunProjectCommand{Domain: "example.com", Dir: "/srv/panel/bin/PHP/example.com", Command: []string{"/bin/true"}}, true},
+		{"run in /root", &RunProjectCommand{Domain: "example.com", Dir: "/root", Command: []string{"/bin/true"}}, false},
+		{"unit in a project", &WriteAppUnit{WorkingDir: "/srv/panel/bin/App/example.com"}, true},
+		{"unit in /", &WriteAppUnit{WorkingDir: "/usr"}, false},
+		{"operation without paths", &ReloadNginx{}, true},
+	}
+	s := testServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkPaths(tt.op)
+			if tt.ok && err != nil {
+				t.Errorf("checkPaths() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("checkPaths() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestCheckAccounts(t *testing.T) {
+	owner := user.ProjectUser("example.com")
+	tests := []struct {
+		name string
+		op   syscmd.Op
+		ok   bool
+	}{
+		{"project's user", &ChownProject{Path: "/srv/panel/bin/PHP/example.com", Owner: owner}, true},
+		{"nginx's user", &ChownProject{Path: "/srv/panel/bin/Wordpress/example.com", Owner: "www-data"}, true},
+		{"root", &ChownProject{Path: "/srv/panel/bin/PHP/example.com", Owner: "root"}, false},
+		{"panel's user", &ChownProject{Path: "/srv/panel/bin/PHP/example.com", Owner: "panel"}, false},
+		{"another account", &ChownProject{Path: "/srv/panel/bin/PHP/example.com", Owner: "mysql"}, false},
+		{"look-alike", &ChownProject{Path: "/srv/panel/bin/PHP/example.com", Owner: "vp-root"}, false},
+		{"pool as root", &WriteFPMPool{PHPVersion: "8.3", Domain: "example.com", User: "root"}, false},
+		{"pool as the panel", &WriteFPMPool{PHPVersion: "8.3", Domain: "example.com", User: "panel"}, false},
+		{"unit as the project", &WriteAppUnit{Domain: "example.com", User: owner}, true},
+		{"unit as the panel", &WriteAppUnit{Domain: "example.com", User: "panel"}, false},
+		{"run as the project", &RunProjectCommand{Domain: "example.com"}, true},
+		{"create the project's user", &CreateProjectUser{Domain: "example.com"}, true},
+		{"operation without accounts", &ReloadNginx{}, true},
+	}
+	s := testServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkAccounts(tt.op)
+			if tt.ok && err != nil {
+				t.Errorf("checkAccounts() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("checkAccounts() = nil, want an error")
+			}
+		})
+	}
+}
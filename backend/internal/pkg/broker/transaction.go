@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+)
+
+// snapshot is the state of one nginx file or symlink before a change
+type snapshot struct {
+	path    string
+	exists  bool
+	target  string // for a symlink
+	content []byte // for a regular file
+}
+
+func takeSnapshot(path string) (snapshot, error) {
+	s := snapshot{path: path}
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	s.exists = true
+	if fi.Mode()&os.ModeSymlink != 0 {
+		s.target, err = os.Readlink(path)
+		return s, err
+	}
+	s.content, err = os.ReadFile(path)
+	return s, err
+}
+
+// restore puts the path back the way it was when the snapshot was taken
+func (s snapshot) restore(ctx context.Context) error {
+	switch {
+	case !s.exists:
+		return syscmd.Sudo(ctx, "rm", "-f", s.path)
+	case s.target != "":
+		return syscmd.Sudo(ctx, "ln", "-sfn", s.target, s.path)
+	}
+	return syscmd.WriteFile(ctx, s.path, s.content)
+}
+
+// nginxMu serializes the changes and tests of the nginx configuration in this process.
+// The broker runs one operation at a time, but without it the operations run in the panel
+// (see syscmd.SudoFallback), from the job workers and the handlers at once: a test could
+// see, or a rollback undo, another site's half-made change.
+var nginxMu sync.Mutex
+
+// nginxTransaction snapshots paths, runs change, tests the resulting configuration and
+// reloads nginx. When the change or the test fails, paths are restored, so a bad vhost
+// never stays enabled and blocks the reloads of every other site.
+func nginxTransaction(ctx context.Context, paths []string, change func() error) error {
+	nginxMu.Lock()
+	defer nginxMu.Unlock()
+
+	snapshots := make([]snapshot, 0, len(paths))
+	for _, path := range paths {
+		s, err := takeSnapshot(path)
+		if err != nil {
+			return fmt.Errorf("snapshot %s: %w", path, err)
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	err := change()
+	if err == nil {
+		err = testNginx(ctx)
+	}
+	if err == nil {
+		if err := syscmd.Sudo(ctx, "systemctl", "reload", "nginx"); err != nil {
+			return fmt.Errorf("nginx reload failed: %w", err)
+		}
+		return nil
+	}
+
+	oplog.Printf(ctx, "Restoring the previous nginx configuration: %v", err)
+	var rollbackErrs []error
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if rerr := snapshots[i].restore(ctx); rerr != nil {
+			rollbackErrs = append(rollbackErrs, fmt.Errorf("restore %s: %w", snapshots[i].path, rerr))
+		}
+	}
+	if len(rollbackErrs) > 0 {
+		return errors.Join(append([]error{err, errors.New("rollback failed")}, rollbackErrs...)...)
+	}
+	var te *nginx.TestError
+	if errors.As(err, &te) {
+		te.RolledBack = true
+	}
+	return err
+}
+
+// testNginx runs nginx -t; its failure is a *nginx.TestError
+func testNginx(ctx context.Context) error {
+	out, err := syscmd.FromContext(ctx).Run(ctx, syscmd.SudoCmd("nginx", "-t"))
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return nginx.ParseTestError(out)
+}
@@ -0,0 +1,199 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
+)
+
+// fakeHost performs the file commands of an nginx transaction on the real filesystem and
+// answers nginx -t with testOutput, failing when it is set
+type fakeHost struct {
+	syscmd.Real
+	testOutput string
+
+	mu      sync.Mutex
+	reloads int
+}
+
+func (h *fakeHost) Run(ctx context.Context, c syscmd.Cmd) ([]byte, error) {
+	argv := append([]string{c.Name}, c.Args...)
+	if argv[0] == "sudo" {
+		argv = argv[1:]
+	}
+	switch argv[0] {
+	case "nginx":
+		if h.testOutput != "" {
+			return []byte(h.testOutput), errors.New("exit status 1")
+		}
+	case "systemctl":
+		h.mu.Lock()
+		h.reloads++
+		h.mu.Unlock()
+	case "rm": // rm -f path
+		if err := os.Remove(argv[2]); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	case "ln": // ln -sfn target link
+		os.Remove(argv[3])
+		return nil, os.Symlink(argv[2], argv[3])
+	}
+	return nil, nil
+}
+
+func (h *fakeHost) WriteFile(ctx context.Context, path string, data []byte) error {
+	return os.WriteFile(path, data, 0o644)
+}
+
+// sites returns a vhost with content "old" linked into an enabled folder
+func sites(t *testing.T) (vhost, link string) {
+	t.Helper()
+	dir := t.TempDir()
+	vhost = filepath.Join(dir, "example.com.conf")
+	link = filepath.Join(dir, "enabled.conf")
+	if err := os.WriteFile(vhost, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(vhost, link); err != nil {
+		t.Fatal(err)
+	}
+	return vhost, link
+}
+
+// change writes "new" into vhost, points link at target and creates extra
+func change(ctx context.Context, vhost, link, target, extra string) func() error {
+	return func() error {
+		if err := syscmd.WriteFile(ctx, vhost, []byte("new")); err != nil {
+			return err
+		}
+		if err := syscmd.WriteFile(ctx, extra, []byte("new")); err != nil {
+			return err
+		}
+		return syscmd.Sudo(ctx, "ln", "-sfn", target, link)
+	}
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestNginxTransactionCommits(t *testing.T) {
+	vhost, link := sites(t)
+	extra := filepath.Join(filepath.Dir(vhost), "extra.conf")
+	host := &fakeHost{}
+	ctx := syscmd.WithExecutor(context.Background(), host)
+
+	if err := nginxTransaction(ctx, []string{vhost, link, extra}, change(ctx, vhost, link, extra, extra)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, vhost); got != "new" {
+		t.Errorf("vhost = %q, want new", got)
+	}
+	if target, _ := os.Readlink(link); target != extra {
+		t.Errorf("link -> %s, want %s", target, extra)
+	}
+	if host.reloads != 1 {
+		t.Errorf("%d reloads, want 1", host.reloads)
+	}
+}
+
+func TestNginxTransactionRollsBack(t *testing.T) {
+	vhost, link := sites(t)
+	extra := filepath.Join(filepath.Dir(vhost), "extra.conf")
+	host := &fakeHost{testOutput: "nginx: [emerg] unknown directive \"bogus\" in " + vhost + ":3\n" +
+		"nginx: configuration file /etc/nginx/nginx.conf test failed\n"}
+	ctx := syscmd.WithExecutor(context.Background(), host)
+
+	err := nginxTransaction(ctx, []string{vhost, link, extra}, change(ctx, vhost, link, extra, extra))
+	var te *nginx.TestError
+	if !errors.As(err, &te) {
+		t.Fatalf("err = %v, want a *nginx.TestError", err)
+	}
+	if !te.RolledBack || te.File != vhost || te.Line != 3 {
+		t.Errorf("TestError = %+v", te)
+	}
+
+	// everything is back: the content, the link target, and no file that did not exist
+	if got := read(t, vhost); got != "old" {
+		t.Errorf("vhost = %q, want old", got)
+	}
+	if target, _ := os.Readlink(link); target != vhost {
+		t.Errorf("link -> %s, want %s", target, vhost)
+	}
+	if _, err := os.Lstat(extra); !os.IsNotExist(err) {
+		t.Errorf("%s was left behind: %v", extra, err)
+	}
+	if host.reloads != 0 {
+		t.Errorf("%d reloads, want none", host.reloads)
+	}
+}
+
+func TestNginxTransactionRollsBackFailedChange(t *testing.T) {
+	vhost, link := sites(t)
+	host := &fakeHost{}
+	ctx := syscmd.WithExecutor(context.Background(), host)
+	failure := errors.New("render failed")
+
+	err := nginxTransaction(ctx, []string{vhost, link}, func() error {
+		if err := syscmd.WriteFile(ctx, vhost, []byte("half")); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+	if got := read(t, vhost); got != "old" {
+		t.Errorf("vhost = %q, want old", got)
+	}
+	if host.reloads != 0 {
+		t.Errorf("%d reloads, want none", host.reloads)
+	}
+}
+
+func TestNginxTransactionsDoNotInterleave(t *testing.T) {
+	host := &fakeHost{}
+	ctx := syscmd.WithExecutor(context.Background(), host)
+
+	var (
+		mu           sync.Mutex
+		active, most int
+		wg           sync.WaitGroup
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := nginxTransaction(ctx, nil, func() error {
+				mu.Lock()
+				active++
+				most = max(most, active)
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				active--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Errorf("%d transactions ran at once, want 1", most)
+	}
+}
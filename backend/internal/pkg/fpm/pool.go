@@ -0,0 +1,80 @@
+// Package fpm renders the PHP-FPM pools of the PHP sites the panel hosts. Pools are
+// rendered from fields, never taken as text, so the broker can check what it installs:
+// a pool never runs as root and listens on the socket nginx expects.
+package fpm
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
+
+var (
+	// domainPattern matches a host name of dot separated labels
+	domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	// accountPattern matches system user names
+	accountPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	// versionPattern matches major.minor PHP versions such as 8.2
+	versionPattern = regexp.MustCompile(`^[5-9]\.[0-9]$`)
+)
+
+// Pool describes the PHP-FPM pool of a site
+type Pool struct {
+	PHPVersion string // e.g. 8.2
+	Domain     string
+	User       string // the account the workers run as; never root
+}
+
+// Path returns the file of the pool in the pool.d folder of its PHP version
+func (p Pool) Path() string {
+	return layout.FPMPool(p.PHPVersion, p.Domain)
+}
+
+// Socket returns the unix socket the pool listens on
+func (p Pool) Socket() string {
+	return layout.FPMSocket(p.PHPVersion, p.Domain)
+}
+
+// ErrorLog returns the file PHP errors of the pool are written to
+func (p Pool) ErrorLog() string {
+	return layout.PHPErrorLog(p.PHPVersion, p.Domain)
+}
+
+// Validate checks the pool can be rendered into the pool file of a single site
+func (p Pool) Validate() error {
+	if !versionPattern.MatchString(p.PHPVersion) {
+		return fmt.Errorf("invalid PHP version %q", p.PHPVersion)
+	}
+	if len(p.Domain) > 253 || !domainPattern.MatchString(p.Domain) {
+		return fmt.Errorf("invalid domain %q", p.Domain)
+	}
+	if !accountPattern.MatchString(p.User) {
+		return fmt.Errorf("invalid user %q", p.User)
+	}
+	if p.User == "root" {
+		return errors.New("a PHP pool cannot run as root")
+	}
+	return nil
+}
+
+// Render returns the pool file of p
+func Render(p Pool) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "pool.conf.tmpl", p); err != nil {
+		return nil, fmt.Errorf("render pool of %s: %w", p.Domain, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,154 @@
+// Package layout resolves the host paths the panel reads and writes (nginx vhosts, PHP-FPM
+// pools and sockets, certificates, project directories) from the configured layout, with
+// its sandbox root applied.
+package layout
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+)
+
+var (
+	mu      sync.RWMutex
+	current = Default()
+)
+
+// Default returns the layout of a Debian/Ubuntu server, with the panel directory in the
+// home directory of the user running the panel
+func Default() models.LayoutConfig {
+	panelDir := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		panelDir = filepath.Join(home, "projuktisheba")
+	}
+	return models.LayoutConfig{
+		NginxSitesAvailable: "/etc/nginx/sites-available",
+		NginxSitesEnabled:   "/etc/nginx/sites-enabled",
+		NginxHtpasswdDir:    "/etc/nginx/htpasswd",
+		NginxMaintenanceDir: "/etc/nginx/maintenance",
+		SystemdDir:          "/etc/systemd/system",
+		PHPConfDir:          "/etc/php",
+		PHPRunDir:           "/run/php",
+		RunDir:              "/run",
+		LogDir:              "/var/log",
+		LetsEncryptDir:      "/etc/letsencrypt",
+		SSLDir:              "/etc/ssl",
+		HomeDir:             "/home",
+		PanelDir:            panelDir,
+		DataDir:             "data",
+		TempDir:             "/tmp",
+	}
+}
+
+// Set replaces the layout; it is called once at startup with the loaded configuration
+func Set(l models.LayoutConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = l
+}
+
+// Current returns the layout in use
+func Current() models.LayoutConfig {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Path prefixes p with the sandbox root
+func Path(p string) string {
+	root := Current().Root
+	if root == "" {
+		return p
+	}
+	return filepath.Join(root, p)
+}
+
+// SitesAvailable returns the path of the nginx vhost name (the directory when name is empty)
+func SitesAvailable(name string) string {
+	return Path(filepath.Join(Current().NginxSitesAvailable, name))
+}
+
+// SitesEnabled returns the path of the enabled nginx vhost name (the directory when name is empty)
+func SitesEnabled(name string) string {
+	return Path(filepath.Join(Current().NginxSitesEnabled, name))
+}
+
+// Htpasswd returns the basic auth user file of domain
+func Htpasswd(domain string) string {
+	return Path(filepath.Join(Current().NginxHtpasswdDir, domain))
+}
+
+// Maintenance returns a path inside the maintenance directory of domain (the directory
+// itself when elem is empty)
+func Maintenance(domain string, elem ...string) string {
+	return Path(filepath.Join(append([]string{Current().NginxMaintenanceDir, domain}, elem...)...))
+}
+
+// SystemdUnit returns the path of the service unit name
+func SystemdUnit(name string) string {
+	return Path(filepath.Join(Current().SystemdDir, name))
+}
+
+// PHPConfDir returns the directory holding one config directory per installed PHP version
+func PHPConfDir() string {
+	return Path(Current().PHPConfDir)
+}
+
+// FPMPool returns the path of the PHP-FPM pool name of PHP version
+func FPMPool(version, name string) string {
+	return Path(filepath.Join(Current().PHPConfDir, version, "fpm", "pool.d", name+".conf"))
+}
+
+// PHPRunDir returns the directory holding the PHP-FPM sockets
+func PHPRunDir() string {
+	return Path(Current().PHPRunDir)
+}
+
+// FPMSocket returns the socket of the PHP-FPM pool of domain
+func FPMSocket(version, domain string) string {
+	return Path(filepath.Join(Current().PHPRunDir, fmt.Sprintf("php%s-%s-fpm.sock", version, domain)))
+}
+
+// Run returns a path inside the directory holding the runtime directories of services,
+// e.g. Run("vpanel-app-example.com", "app.sock")
+func Run(elem ...string) string {
+	return Path(filepath.Join(append([]string{Current().RunDir}, elem...)...))
+}
+
+// PHPErrorLog returns the PHP error log of domain
+func PHPErrorLog(version, domain string) string {
+	return Path(filepath.Join(Current().LogDir, fmt.Sprintf("php%s-%s-error.log", version, domain)))
+}
+
+// LetsEncrypt returns a path inside the Let's Encrypt directory, e.g. LetsEncrypt("live", domain)
+func LetsEncrypt(elem ...string) string {
+	return Path(filepath.Join(append([]string{Current().LetsEncryptDir}, elem...)...))
+}
+
+// SSL returns a path inside the system SSL directory
+func SSL(elem ...string) string {
+	return Path(filepath.Join(append([]string{Current().SSLDir}, elem...)...))
+}
+
+// UserHome returns the home directory of the system user username
+func UserHome(username string) string {
+	return Path(filepath.Join(Current().HomeDir, username))
+}
+
+// Panel returns a path inside the panel directory, e.g. Panel("bin", "PHP")
+func Panel(elem ...string) string {
+	return Path(filepath.Join(append([]string{Current().PanelDir}, elem...)...))
+}
+
+// Data returns a path inside the data directory of the panel, e.g. Data("images")
+func Data(elem ...string) string {
+	return Path(filepath.Join(append([]string{Current().DataDir}, elem...)...))
+}
+
+// Temp returns a path inside the temp directory
+func Temp(elem ...string) string {
+	return Path(filepath.Join(append([]string{Current().TempDir}, elem...)...))
+}
@@ -0,0 +1,148 @@
+// Package loginguard throttles password guessing in memory.
+// Failures are counted per username and per client IP; after a few free attempts each further
+// failure doubles the wait before the next attempt is accepted. Persistent account lockout is
+// handled separately by the user repository so it survives restarts.
+package loginguard
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy controls how quickly a key is throttled
+type Policy struct {
+	FreeAttempts int           // failures allowed before any delay
+	BaseDelay    time.Duration // delay after the first throttled failure, doubled on each further one
+	MaxDelay     time.Duration // upper bound for the delay
+	Forget       time.Duration // a key with no failure for this long starts from zero again
+}
+
+var (
+	// DefaultUserPolicy applies to a single username
+	DefaultUserPolicy = Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Forget: time.Hour}
+	// DefaultIPPolicy applies to a client IP, which may legitimately serve several users (office NAT)
+	DefaultIPPolicy = Policy{FreeAttempts: 10, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Forget: time.Hour}
+)
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	blockedTill time.Time
+}
+
+// Guard tracks failures. The zero value is not usable; create one with New.
+type Guard struct {
+	mu         sync.Mutex
+	userPolicy Policy
+	ipPolicy   Policy
+	users      map[string]*entry
+	ips        map[string]*entry
+	lastPurge  time.Time
+	now        func() time.Time
+}
+
+func New(userPolicy, ipPolicy Policy) *Guard {
+	return &Guard{
+		userPolicy: userPolicy,
+		ipPolicy:   ipPolicy,
+		users:      make(map[string]*entry),
+		ips:        make(map[string]*entry),
+		now:        time.Now,
+	}
+}
+
+// Wait returns how long the caller must wait before username may be tried from ip. Zero means go ahead.
+func (g *Guard) Wait(username, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var wait time.Duration
+	if e := g.users[normalize(username)]; e != nil && e.blockedTill.After(now) {
+		wait = e.blockedTill.Sub(now)
+	}
+	if e := g.ips[ip]; e != nil && e.blockedTill.After(now) && e.blockedTill.Sub(now) > wait {
+		wait = e.blockedTill.Sub(now)
+	}
+	return wait
+}
+
+// Fail records a failed attempt for username from ip
+func (g *Guard) Fail(username, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.purge(now)
+	fail(g.users, normalize(username), g.userPolicy, now)
+	if ip != "" {
+		fail(g.ips, ip, g.ipPolicy, now)
+	}
+}
+
+// Succeed clears the username's failures. The IP counter is left alone so an attacker
+// cannot reset it by interleaving sign-ins to an account they own.
+func (g *Guard) Succeed(username string) {
+	g.Clear(username)
+}
+
+// Clear forgets the failures of the given usernames (e.g. after an administrator unlocks an account)
+func (g *Guard) Clear(usernames ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, u := range usernames {
+		delete(g.users, normalize(u))
+	}
+}
+
+func fail(m map[string]*entry, key string, p Policy, now time.Time) {
+	e := m[key]
+	if e == nil || now.Sub(e.lastFailure) > p.Forget {
+		e = &entry{}
+		m[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	if over := e.failures - p.FreeAttempts; over > 0 {
+		e.blockedTill = now.Add(delay(p, over))
+	}
+}
+
+// delay returns BaseDelay * 2^(n-1), capped at MaxDelay
+func delay(p Policy, n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// purge drops idle entries so the maps cannot grow without bound under a spray of usernames.
+// It runs at most once a minute.
+func (g *Guard) purge(now time.Time) {
+	if now.Sub(g.lastPurge) < time.Minute {
+		return
+	}
+	g.lastPurge = now
+	for k, e := range g.users {
+		if now.Sub(e.lastFailure) > g.userPolicy.Forget && !e.blockedTill.After(now) {
+			delete(g.users, k)
+		}
+	}
+	for k, e := range g.ips {
+		if now.Sub(e.lastFailure) > g.ipPolicy.Forget && !e.blockedTill.After(now) {
+			delete(g.ips, k)
+		}
+	}
+}
+
+func normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,151 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+const (
+	// maxAccessRules bounds the access rules of one site
+	maxAccessRules = 50
+	// maxAccessAddresses bounds the allow and deny lists of one rule
+	maxAccessAddresses = 200
+)
+
+var (
+	// accessUserPattern matches a basic auth user name; ':' separates it from the hash
+	accessUserPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+	// bcryptPattern matches a bcrypt hash, the only scheme written to htpasswd files
+	bcryptPattern = regexp.MustCompile(`^\$2[aby]\$[0-9]{2}\$[./A-Za-z0-9]{53}$`)
+)
+
+// Access restricts who may reach Path. Denied addresses are checked first, then allowed
+// ones; when Allow is set every other address is denied.
+type Access struct {
+	Path       string // "/" for the whole site
+	AuthFile   string // htpasswd file asked for; no basic auth when empty
+	Allow      []string
+	Deny       []string
+	SatisfyAny bool // an allowed address or a valid password is enough, instead of both
+}
+
+// SiteAccess returns the rule covering the whole site, or nil
+func (s Site) SiteAccess() *Access {
+	for i := range s.Access {
+		if s.Access[i].Path == "/" {
+			return &s.Access[i]
+		}
+	}
+	return nil
+}
+
+// PathAccess returns the rules scoped to a path below the site root
+func (s Site) PathAccess() []Access {
+	var scoped []Access
+	for _, a := range s.Access {
+		if a.Path != "/" {
+			scoped = append(scoped, a)
+		}
+	}
+	return scoped
+}
+
+// ValidateAccessRule checks an access rule can be rendered into a vhost
+func ValidateAccessRule(r models.AccessRule) error {
+	if !r.BasicAuth && len(r.Allow) == 0 && len(r.Deny) == 0 {
+		return errors.New("the rule restricts nothing: enable basic auth or list addresses")
+	}
+	return validateAccess(Access{Path: r.Path, Allow: r.Allow, Deny: r.Deny, SatisfyAny: r.SatisfyAny})
+}
+
+func validateAccess(a Access) error {
+	if !strings.HasPrefix(a.Path, "/") || strings.Contains(a.Path, "//") {
+		return fmt.Errorf("path %q must start with a single /", a.Path)
+	}
+	if err := checkValue("access path", a.Path); err != nil {
+		return err
+	}
+	if err := checkValue("htpasswd file", a.AuthFile); err != nil {
+		return err
+	}
+	if len(a.Allow)+len(a.Deny) > maxAccessAddresses {
+		return fmt.Errorf("at most %d addresses are allowed per rule", maxAccessAddresses)
+	}
+	for _, addr := range append(append([]string{}, a.Deny...), a.Allow...) {
+		if err := checkAddress(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateAccessList(rules []Access) error {
+	if len(rules) > maxAccessRules {
+		return fmt.Errorf("at most %d access rules are allowed", maxAccessRules)
+	}
+	seen := map[string]bool{}
+	for _, a := range rules {
+		if seen[a.Path] {
+			return fmt.Errorf("path %q has more than one access rule", a.Path)
+		}
+		seen[a.Path] = true
+		if err := validateAccess(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAddress accepts an IP address or a CIDR
+func checkAddress(addr string) error {
+	if net.ParseIP(addr) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(addr); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q (use an IP address or a CIDR such as 10.0.0.0/8)", addr)
+}
+
+// ValidateAccessUser checks the user name and hash can be written to an htpasswd file
+func ValidateAccessUser(u models.AccessUser) error {
+	if !accessUserPattern.MatchString(u.Username) {
+		return fmt.Errorf("invalid username %q (letters, digits, '.', '_' and '-', at most 64)", u.Username)
+	}
+	if !bcryptPattern.MatchString(u.PasswordHash) {
+		return errors.New("password hash is not bcrypt")
+	}
+	return nil
+}
+
+// Htpasswd returns the htpasswd file of users. nginx checks bcrypt hashes through the
+// system's crypt(3), which supports them on current Debian and Ubuntu releases.
+func Htpasswd(users []models.AccessUser) ([]byte, error) {
+	var b strings.Builder
+	for _, u := range users {
+		if err := ValidateAccessUser(u); err != nil {
+			return nil, err
+		}
+		b.WriteString(u.Username + ":" + u.PasswordHash + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// accessFromRules converts the access rules of a project for the site of domain
+func accessFromRules(domain string, rules []models.AccessRule) []Access {
+	var access []Access
+	for _, r := range rules {
+		a := Access{Path: r.Path, Allow: r.Allow, Deny: r.Deny, SatisfyAny: r.SatisfyAny}
+		if r.BasicAuth {
+			a.AuthFile = layout.Htpasswd(strings.ToLower(domain))
+		}
+		access = append(access, a)
+	}
+	return access
+}
@@ -0,0 +1,146 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+const (
+	// maxRedirects bounds the redirect rules of one site
+	maxRedirects = 200
+	// maxSnippetSize bounds the custom snippet of one site
+	maxSnippetSize = 16 << 10
+)
+
+// redirectCodes are the status codes a redirect may use
+var redirectCodes = map[int]bool{301: true, 302: true, 307: true, 308: true}
+
+// snippetDirectives are the directives a custom snippet may use. Anything that reads or
+// writes files outside the site (include, root, alias, logs, temp paths), loads code, or
+// changes the server itself is left out: nginx's master process runs as root. So are the
+// proxy_* and fastcgi_* directives: a snippet could hand requests to the PHP pool of
+// another site or to a service only listening locally.
+var snippetDirectives = map[string]bool{
+	"location": true, "if": true, "limit_except": true,
+	"return": true, "rewrite": true, "set": true, "break": true, "internal": true,
+	"try_files": true, "index": true, "error_page": true, "expires": true, "etag": true,
+	"add_header": true, "more_set_headers": true, "default_type": true, "charset": true,
+	"allow": true, "deny": true, "autoindex": true, "gzip": true, "gzip_types": true,
+	"client_max_body_size": true, "client_body_timeout": true, "keepalive_timeout": true,
+	"send_timeout": true, "log_not_found": true, "sendfile": true, "tcp_nopush": true,
+	"limit_rate": true, "limit_rate_after": true, "ssi": true, "sub_filter": true,
+	"sub_filter_once": true, "sub_filter_types": true,
+}
+
+// snippetBlocks are the snippet directives that take a block
+var snippetBlocks = map[string]bool{"location": true, "if": true, "limit_except": true}
+
+// ValidateSettings checks the custom rules of a project can be rendered into its vhost.
+// It does not run nginx; the caller tests the rendered result before saving.
+func ValidateSettings(s *models.ProjectNginxSettings) error {
+	if len(s.Redirects) > maxRedirects {
+		return fmt.Errorf("at most %d redirects are allowed", maxRedirects)
+	}
+	for i, r := range s.Redirects {
+		if err := validateRedirect(r); err != nil {
+			return fmt.Errorf("redirect %d: %w", i+1, err)
+		}
+	}
+	if s.ClientMaxBodySize != "" && !sizePattern.MatchString(s.ClientMaxBodySize) {
+		return fmt.Errorf("invalid client_max_body_size %q", s.ClientMaxBodySize)
+	}
+	return validateSnippet(s.Snippet)
+}
+
+// Customize adds the custom rules of a project to the site. Nil settings leave it as is.
+func (s *Site) Customize(settings *models.ProjectNginxSettings) {
+	if settings == nil {
+		return
+	}
+	s.Redirects = settings.Redirects
+	s.Snippet = settings.Snippet
+	s.Access = accessFromRules(s.Domain, settings.Access)
+	if settings.ClientMaxBodySize != "" {
+		s.MaxBodySize = settings.ClientMaxBodySize
+	}
+	// single page applications route in the browser: unknown paths get the app
+	if settings.SPAFallback && s.Upstream() == UpstreamStatic {
+		s.TryFiles = "$uri $uri/ /index.html"
+	}
+}
+
+func validateRedirect(r models.NginxRedirect) error {
+	if r.Code != 0 && !redirectCodes[r.Code] {
+		return fmt.Errorf("invalid code %d (use 301, 302, 307 or 308)", r.Code)
+	}
+	if r.Regex {
+		if r.From == "" || strings.ContainsAny(r.From, " \t\r\n;{}\"'#") {
+			return fmt.Errorf("invalid pattern %q (spaces, quotes, ';', '#' and braces are not allowed)", r.From)
+		}
+		if _, err := regexp.Compile(r.From); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", r.From, err)
+		}
+	} else if !strings.HasPrefix(r.From, "/") {
+		return fmt.Errorf("from %q must be a path starting with /", r.From)
+	} else if err := checkValue("from", r.From); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(r.To, "/") && !strings.HasPrefix(r.To, "http://") && !strings.HasPrefix(r.To, "https://") {
+		return fmt.Errorf("to %q must be a path or an http(s) URL", r.To)
+	}
+	return checkValue("to", r.To)
+}
+
+func validateSnippet(snippet string) error {
+	if strings.TrimSpace(snippet) == "" {
+		return nil
+	}
+	if len(snippet) > maxSnippetSize {
+		return fmt.Errorf("snippet is larger than %d bytes", maxSnippetSize)
+	}
+	dirs, err := Parse([]byte(snippet))
+	if err != nil {
+		return fmt.Errorf("snippet: %w", err)
+	}
+	var bad error
+	walk(dirs, func(d Directive) {
+		if bad != nil {
+			return
+		}
+		if !snippetDirectives[d.Name] {
+			bad = fmt.Errorf("snippet line %d: directive %q is not allowed", d.Line, d.Name)
+		} else if d.Block != nil && !snippetBlocks[d.Name] {
+			bad = fmt.Errorf("snippet line %d: %q cannot take a block", d.Line, d.Name)
+		}
+	})
+	return bad
+}
+
+// indent prefixes every non-empty line of text with prefix
+func indent(prefix, text string) string {
+	lines := strings.Split(strings.Trim(text, "\r\n"), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line != "" {
+			line = prefix + line
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// testServerName is the server name of TestSite; the .invalid TLD never resolves
+const testServerName = "vpanel-test.invalid"
+
+// TestSite returns a throwaway static site carrying settings, for checking with nginx -t
+// that nginx accepts them before they are saved
+func TestSite(settings *models.ProjectNginxSettings) Site {
+	s := Site{Domain: testServerName, Root: layout.Temp()}
+	s.Customize(settings)
+	return s
+}
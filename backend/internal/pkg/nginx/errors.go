@@ -0,0 +1,56 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// testErrorPattern matches the error lines of nginx -t, e.g.
+//
+//	nginx: [emerg] unknown directive "foo" in /etc/nginx/sites-enabled/example.com.conf:12
+var testErrorPattern = regexp.MustCompile(`nginx: \[(?:emerg|alert|crit|error)\] (.*?)(?: in (\S+):(\d+))?$`)
+
+// TestError is a configuration error reported by nginx -t
+type TestError struct {
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Message    string `json:"message"`
+	RolledBack bool   `json:"rolledBack"` // the previous configuration was restored
+}
+
+func (e *TestError) Error() string {
+	msg := "nginx configuration test failed: " + e.Message
+	if e.File != "" {
+		msg += fmt.Sprintf(" (%s:%d)", e.File, e.Line)
+	}
+	if e.RolledBack {
+		msg += "; the previous configuration was restored"
+	}
+	return msg
+}
+
+// ParseTestError returns the first error in the output of a failed nginx -t. When the
+// output has no error line it falls back to the last line printed.
+func ParseTestError(output []byte) *TestError {
+	var last string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		last = line
+		m := testErrorPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		te := &TestError{Message: m[1], File: m[2]}
+		te.Line, _ = strconv.Atoi(m[3])
+		return te
+	}
+	if last == "" {
+		last = "nginx -t failed"
+	}
+	return &TestError{Message: last}
+}
@@ -0,0 +1,64 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+// EnabledConfig is one entry of sites-enabled
+type EnabledConfig struct {
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	Target     string   `json:"target,omitempty"` // what the entry links to, for a symlink
+	Broken     bool     `json:"broken"`           // a symlink to a file that does not exist
+	ParseError string   `json:"parseError,omitempty"`
+	Servers    []Server `json:"servers"`
+}
+
+// ScanEnabled reads and parses every entry of sites-enabled, sorted by name. An entry that
+// cannot be read or parsed is reported with its error rather than failing the scan.
+func ScanEnabled() ([]EnabledConfig, error) {
+	dir := layout.SitesEnabled("")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []EnabledConfig{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		c := EnabledConfig{Name: e.Name(), Path: filepath.Join(dir, e.Name()), Servers: []Server{}}
+		if e.Type()&os.ModeSymlink != 0 {
+			c.Target, _ = os.Readlink(c.Path)
+			if _, err := os.Stat(c.Path); err != nil {
+				c.Broken = os.IsNotExist(err)
+				if !c.Broken {
+					c.ParseError = err.Error()
+				}
+				configs = append(configs, c)
+				continue
+			}
+		}
+
+		conf, err := os.ReadFile(c.Path)
+		if err != nil {
+			c.ParseError = err.Error()
+			configs = append(configs, c)
+			continue
+		}
+		servers, err := ParseServers(conf)
+		if err != nil {
+			c.ParseError = err.Error()
+		} else if servers != nil {
+			c.Servers = servers
+		}
+		configs = append(configs, c)
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return configs, nil
+}
@@ -0,0 +1,104 @@
+package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+// Files of the maintenance directory of a site
+const (
+	MaintenanceConfName = "maintenance.conf" // included by the vhost while maintenance is on
+	MaintenancePageName = "index.html"       // served with the 503
+)
+
+const (
+	// maxMaintenanceIPs bounds the addresses that bypass maintenance mode
+	maxMaintenanceIPs = 100
+	// maxRetryAfter bounds the Retry-After of maintenance mode to a week
+	maxRetryAfter = 7 * 24 * 3600
+)
+
+// Maintenance describes the maintenance mode of a site: every request is answered with
+// 503, Retry-After and the site's maintenance page, except those from AllowIPs
+type Maintenance struct {
+	Domain     string
+	RetryAfter int      // seconds
+	AllowIPs   []string // single addresses; nginx compares them with $remote_addr
+}
+
+// Validate checks the maintenance mode can be rendered
+func (m Maintenance) Validate() error {
+	if len(m.Domain) > 253 || !hostPattern.MatchString(m.Domain) {
+		return fmt.Errorf("invalid server name %q", m.Domain)
+	}
+	if m.RetryAfter < 1 || m.RetryAfter > maxRetryAfter {
+		return fmt.Errorf("retry after must be between 1 and %d seconds", maxRetryAfter)
+	}
+	if len(m.AllowIPs) > maxMaintenanceIPs {
+		return fmt.Errorf("at most %d addresses may bypass maintenance mode", maxMaintenanceIPs)
+	}
+	for _, ip := range m.AllowIPs {
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.String() != ip {
+			return fmt.Errorf("invalid address %q (use a single IP address in canonical form)", ip)
+		}
+	}
+	return nil
+}
+
+// RenderMaintenance returns the snippet the vhost of m.Domain includes while it is in
+// maintenance mode
+func RenderMaintenance(m Maintenance) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	data := struct {
+		Maintenance
+		PageDir  string
+		PageName string
+	}{m, layout.Maintenance(strings.ToLower(m.Domain)), MaintenancePageName}
+	if err := templates.ExecuteTemplate(&buf, "maintenance.conf.tmpl", data); err != nil {
+		return nil, fmt.Errorf("render maintenance of %s: %w", m.Domain, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DefaultMaintenancePage returns the page served when a project has not set its own
+func DefaultMaintenancePage(domain string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "maintenance.html.tmpl", domain); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MaintenanceInclude returns the include pattern of the maintenance snippet of domain.
+// It matches nothing while maintenance is off, which nginx accepts.
+func MaintenanceInclude(domain string) string {
+	return layout.Maintenance(strings.ToLower(domain), "*.conf")
+}
+
+// MaintenanceInclude returns the include pattern of the site's maintenance snippet
+func (s Site) MaintenanceInclude() string {
+	return MaintenanceInclude(s.Domain)
+}
+
+// HasMaintenanceHook reports whether the vhost conf includes the maintenance snippet of
+// domain; vhosts rendered before maintenance mode existed need a redeploy first
+func HasMaintenanceHook(conf []byte, domain string) bool {
+	dirs, err := Parse(conf)
+	if err != nil {
+		return false
+	}
+	found := false
+	walk(dirs, func(d Directive) {
+		if d.Name == "include" && firstArg(d) == MaintenanceInclude(domain) {
+			found = true
+		}
+	})
+	return found
+}
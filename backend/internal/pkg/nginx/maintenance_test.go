@@ -0,0 +1,110 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderMaintenanceGolden(t *testing.T) {
+	got, err := RenderMaintenance(Maintenance{
+		Domain:     "example.com",
+		RetryAfter: 3600,
+		AllowIPs:   []string{"203.0.113.7", "2001:db8::1"},
+	})
+	if err != nil {
+		t.Fatalf("RenderMaintenance: %v", err)
+	}
+	golden := filepath.Join("testdata", "maintenance.conf")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("RenderMaintenance differs from %s:\n%s", golden, got)
+	}
+}
+
+func TestRenderMaintenanceRejects(t *testing.T) {
+	base := Maintenance{Domain: "example.com", RetryAfter: 3600}
+	many := make([]string, maxMaintenanceIPs+1)
+	for i := range many {
+		many[i] = "10.0.0.1"
+	}
+	tests := map[string]func(m *Maintenance){
+		"domain":                 func(m *Maintenance) { m.Domain = "example.com;" },
+		"no domain":              func(m *Maintenance) { m.Domain = "" },
+		"uppercase domain":       func(m *Maintenance) { m.Domain = "Example.com" },
+		"no retry after":         func(m *Maintenance) { m.RetryAfter = 0 },
+		"negative retry after":   func(m *Maintenance) { m.RetryAfter = -1 },
+		"retry after over limit": func(m *Maintenance) { m.RetryAfter = maxRetryAfter + 1 },
+		"network":                func(m *Maintenance) { m.AllowIPs = []string{"10.0.0.0/8"} },
+		"host name":              func(m *Maintenance) { m.AllowIPs = []string{"office.example.com"} },
+		"leading zeros":          func(m *Maintenance) { m.AllowIPs = []string{"010.000.000.001"} },
+		"uppercase IPv6":         func(m *Maintenance) { m.AllowIPs = []string{"2001:DB8::1"} },
+		"uncompressed IPv6":      func(m *Maintenance) { m.AllowIPs = []string{"2001:db8:0:0:0:0:0:1"} },
+		"IPv4-mapped IPv6":       func(m *Maintenance) { m.AllowIPs = []string{"::ffff:10.0.0.1"} },
+		"address with a space":   func(m *Maintenance) { m.AllowIPs = []string{" 10.0.0.1"} },
+		"address ending a line":  func(m *Maintenance) { m.AllowIPs = []string{"10.0.0.1;\nreturn 200"} },
+		"too many addresses":     func(m *Maintenance) { m.AllowIPs = many },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := base
+			change(&m)
+			if _, err := RenderMaintenance(m); err == nil {
+				t.Error("RenderMaintenance accepted the maintenance mode")
+			}
+		})
+	}
+
+	// the bounds themselves are allowed
+	for _, retryAfter := range []int{1, maxRetryAfter} {
+		if _, err := RenderMaintenance(Maintenance{Domain: "example.com", RetryAfter: retryAfter}); err != nil {
+			t.Errorf("retry after %d: %v", retryAfter, err)
+		}
+	}
+}
+
+func TestHasMaintenanceHook(t *testing.T) {
+	conf, err := Render(goldenSites["static"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasMaintenanceHook(conf, "example.com") {
+		t.Error("rendered vhost has no maintenance hook")
+	}
+	if !HasMaintenanceHook(conf, "Example.com") {
+		t.Error("hook not found for the domain in another case")
+	}
+	if HasMaintenanceHook(conf, "other.com") {
+		t.Error("hook of example.com taken for other.com")
+	}
+
+	include := MaintenanceInclude("example.com")
+	tests := map[string]string{
+		"no include":        "server { listen 80; server_name example.com; }",
+		"commented out":     "server {\n  # include " + include + ";\n}",
+		"other include":     "server { include /etc/nginx/snippets/ssl.conf; }",
+		"malformed":         "server { include " + include + ";",
+		"include as string": "server { return 200 \"include " + include + "\"; }",
+	}
+	for name, conf := range tests {
+		if HasMaintenanceHook([]byte(conf), "example.com") {
+			t.Errorf("%s: hook found", name)
+		}
+	}
+	if !HasMaintenanceHook([]byte("server { location / { include "+include+"; } }"), "example.com") {
+		t.Error("hook in a nested block not found")
+	}
+	if !strings.HasSuffix(include, filepath.Join("example.com", "*.conf")) {
+		t.Errorf("include = %s", include)
+	}
+}
@@ -0,0 +1,219 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Directive is one directive of an nginx configuration, with the directives of its block
+type Directive struct {
+	Name  string
+	Args  []string
+	Line  int
+	Block []Directive // nil for a simple directive
+}
+
+// Server is what the inventory extracts from one server block
+type Server struct {
+	Line           int      `json:"line"`
+	Names          []string `json:"serverNames"`
+	Listen         []string `json:"listen"`
+	Root           string   `json:"root,omitempty"`
+	SSLCertificate string   `json:"sslCertificate,omitempty"`
+	FastCGIPass    []string `json:"fastcgiPass,omitempty"`
+	ProxyPass      []string `json:"proxyPass,omitempty"`
+}
+
+// Parse parses an nginx configuration file into its directives. It understands the
+// syntax (blocks, quoting, comments) but not the meaning of directives, and does not
+// follow includes.
+func Parse(conf []byte) ([]Directive, error) {
+	toks, err := tokenize(string(conf))
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	dirs, err := p.block(false)
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// ParseServers returns the server blocks of an nginx configuration file
+func ParseServers(conf []byte) ([]Server, error) {
+	dirs, err := Parse(conf)
+	if err != nil {
+		return nil, err
+	}
+	var servers []Server
+	for _, d := range dirs {
+		if d.Name != "server" || d.Block == nil {
+			continue
+		}
+		s := Server{Line: d.Line}
+		for _, sd := range d.Block {
+			switch sd.Name {
+			case "server_name":
+				s.Names = append(s.Names, sd.Args...)
+			case "listen":
+				s.Listen = append(s.Listen, strings.Join(sd.Args, " "))
+			case "root":
+				s.Root = firstArg(sd)
+			case "ssl_certificate":
+				s.SSLCertificate = firstArg(sd)
+			}
+		}
+		walk(d.Block, func(d Directive) {
+			switch d.Name {
+			case "fastcgi_pass":
+				s.FastCGIPass = appendUnique(s.FastCGIPass, firstArg(d))
+			case "proxy_pass":
+				s.ProxyPass = appendUnique(s.ProxyPass, firstArg(d))
+			}
+		})
+		if len(s.Listen) == 0 {
+			s.Listen = []string{"80"} // nginx's default when running as root
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+// walk calls fn for every directive in dirs and, depth first, in their blocks
+func walk(dirs []Directive, fn func(Directive)) {
+	for _, d := range dirs {
+		fn(d)
+		walk(d.Block, fn)
+	}
+}
+
+func firstArg(d Directive) string {
+	if len(d.Args) == 0 {
+		return ""
+	}
+	return d.Args[0]
+}
+
+func appendUnique(list []string, v string) []string {
+	if v == "" {
+		return list
+	}
+	for _, item := range list {
+		if item == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// ==================== tokenizer ====================
+
+type token struct {
+	text   string
+	line   int
+	quoted bool // a quoted string, never one of { } ;
+}
+
+func tokenize(conf string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(conf); {
+		c := conf[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(conf) && conf[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			toks = append(toks, token{text: string(c), line: line})
+			i++
+		case c == '"' || c == '\'':
+			start := line
+			var b strings.Builder
+			i++
+			for ; i < len(conf) && conf[i] != c; i++ {
+				if conf[i] == '\\' && i+1 < len(conf) {
+					i++
+				}
+				if conf[i] == '\n' {
+					line++
+				}
+				b.WriteByte(conf[i])
+			}
+			if i >= len(conf) {
+				return nil, fmt.Errorf("line %d: unterminated string", start)
+			}
+			i++
+			toks = append(toks, token{text: b.String(), line: start, quoted: true})
+		default:
+			start := i
+			for i < len(conf) && !strings.ContainsRune(" \t\r\n{};", rune(conf[i])) {
+				i++
+			}
+			toks = append(toks, token{text: conf[start:i], line: line})
+		}
+	}
+	return toks, nil
+}
+
+// ==================== parser ====================
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// block parses directives up to the closing brace of the current block, or the end of
+// the file at the top level
+func (p *parser) block(nested bool) ([]Directive, error) {
+	dirs := []Directive{}
+	for p.pos < len(p.toks) {
+		t := p.toks[p.pos]
+		if !t.quoted && t.text == "}" {
+			if !nested {
+				return nil, fmt.Errorf("line %d: unexpected \"}\"", t.line)
+			}
+			p.pos++
+			return dirs, nil
+		}
+		if !t.quoted && (t.text == "{" || t.text == ";") {
+			return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+		}
+
+		d := Directive{Name: t.text, Line: t.line}
+		p.pos++
+		for {
+			if p.pos >= len(p.toks) {
+				return nil, fmt.Errorf("line %d: %s is not terminated by \";\" or \"{\"", d.Line, d.Name)
+			}
+			t := p.toks[p.pos]
+			p.pos++
+			if t.quoted || (t.text != ";" && t.text != "{" && t.text != "}") {
+				d.Args = append(d.Args, t.text)
+				continue
+			}
+			if t.text == "}" {
+				return nil, fmt.Errorf("line %d: unexpected \"}\"", t.line)
+			}
+			if t.text == "{" {
+				block, err := p.block(true)
+				if err != nil {
+					return nil, err
+				}
+				d.Block = block
+			}
+			break
+		}
+		dirs = append(dirs, d)
+	}
+	if nested {
+		return nil, fmt.Errorf("unexpected end of file, expecting \"}\"")
+	}
+	return dirs, nil
+}
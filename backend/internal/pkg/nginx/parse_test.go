@@ -0,0 +1,261 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want []Directive
+	}{
+		{
+			name: "empty",
+			conf: "",
+			want: []Directive{},
+		},
+		{
+			name: "simple directives",
+			conf: "user www-data;\nworker_processes  auto ;",
+			want: []Directive{
+				{Name: "user", Args: []string{"www-data"}, Line: 1},
+				{Name: "worker_processes", Args: []string{"auto"}, Line: 2},
+			},
+		},
+		{
+			name: "comments",
+			conf: "# managed by hand\nlisten 80; # default\n#server { broken\n",
+			want: []Directive{{Name: "listen", Args: []string{"80"}, Line: 2}},
+		},
+		{
+			name: "quoting",
+			conf: `add_header Content-Security-Policy "default-src 'self'; img-src *" always;` + "\n" +
+				`return 200 'a {block} # not a comment';`,
+			want: []Directive{
+				{Name: "add_header", Args: []string{"Content-Security-Policy", "default-src 'self'; img-src *", "always"}, Line: 1},
+				{Name: "return", Args: []string{"200", "a {block} # not a comment"}, Line: 2},
+			},
+		},
+		{
+			name: "escapes",
+			conf: `return 200 "say \"hi\" \\ bye";` + "\n" + `set $a 'it\'s';`,
+			want: []Directive{
+				{Name: "return", Args: []string{"200", `say "hi" \ bye`}, Line: 1},
+				{Name: "set", Args: []string{"$a", "it's"}, Line: 2},
+			},
+		},
+		{
+			name: "multi-line string",
+			conf: "return 200 \"a\nb\";\nlisten 80;",
+			want: []Directive{
+				{Name: "return", Args: []string{"200", "a\nb"}, Line: 1},
+				{Name: "listen", Args: []string{"80"}, Line: 3},
+			},
+		},
+		{
+			name: "nested blocks",
+			conf: "http {\n  server {\n    location / {\n      if ($x) { return 404; }\n    }\n    location /empty {}\n  }\n}\n",
+			want: []Directive{{Name: "http", Line: 1, Block: []Directive{
+				{Name: "server", Line: 2, Block: []Directive{
+					{Name: "location", Args: []string{"/"}, Line: 3, Block: []Directive{
+						{Name: "if", Args: []string{"($x)"}, Line: 4, Block: []Directive{
+							{Name: "return", Args: []string{"404"}, Line: 4},
+						}},
+					}},
+					{Name: "location", Args: []string{"/empty"}, Line: 6, Block: []Directive{}},
+				}},
+			}}},
+		},
+		{
+			name: "no space around braces",
+			conf: "events{worker_connections 768;}",
+			want: []Directive{{Name: "events", Line: 1, Block: []Directive{
+				{Name: "worker_connections", Args: []string{"768"}, Line: 1},
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.conf))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		err  string // part of the error
+	}{
+		{"unterminated string", "server {\n  return 200 \"oops;\n}\n", "line 2: unterminated string"},
+		{"unterminated single quote", "return 200 'oops;", "unterminated string"},
+		{"escaped closing quote", `return 200 "oops\";`, "unterminated string"},
+		{"unterminated directive", "server {\n  listen 80\n", "not terminated"},
+		{"missing semicolon at the end", "listen 80", "listen is not terminated"},
+		{"unclosed block", "server {\n  listen 80;\n", "unexpected end of file"},
+		{"stray closing brace", "listen 80;\n}\n", "line 2: unexpected \"}\""},
+		{"brace ending a directive", "server {\n  listen 80 }\n", "line 2: unexpected \"}\""},
+		{"semicolon without a directive", "server { ; }", "unexpected \";\""},
+		{"block without a directive", "{ listen 80; }", "unexpected \"{\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.conf))
+			if err == nil {
+				t.Fatal("Parse accepted the config")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %q does not contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseServers(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want []Server
+	}{
+		{
+			name: "no server",
+			conf: "upstream app { server 127.0.0.1:3000; }",
+			want: nil,
+		},
+		{
+			name: "default listen",
+			conf: "server {\n  server_name example.com www.example.com;\n  root /srv/example.com;\n}",
+			want: []Server{{Line: 1, Names: []string{"example.com", "www.example.com"}, Listen: []string{"80"}, Root: "/srv/example.com"}},
+		},
+		{
+			name: "listen, ssl and upstreams",
+			conf: `
+server {
+    listen 443 ssl http2;
+    listen [::]:443 ssl;
+    server_name app.example.com;
+    ssl_certificate /etc/letsencrypt/live/app.example.com/fullchain.pem;
+    location / { proxy_pass http://127.0.0.1:3000; }
+    location /ws { proxy_pass http://127.0.0.1:3000; }
+    location ~ \.php$ {
+        if ($request_method = POST) { fastcgi_pass unix:/run/php/php8.3-fpm.sock; }
+    }
+}
+server {
+    listen 80;
+    server_name app.example.com;
+    return 301 https://$host$request_uri;
+}`,
+			want: []Server{
+				{
+					Line:           2,
+					Names:          []string{"app.example.com"},
+					Listen:         []string{"443 ssl http2", "[::]:443 ssl"},
+					SSLCertificate: "/etc/letsencrypt/live/app.example.com/fullchain.pem",
+					FastCGIPass:    []string{"unix:/run/php/php8.3-fpm.sock"},
+					ProxyPass:      []string{"http://127.0.0.1:3000"},
+				},
+				{Line: 13, Names: []string{"app.example.com"}, Listen: []string{"80"}},
+			},
+		},
+		{
+			name: "server names over several directives",
+			conf: "server { server_name a.example.com; server_name \"b.example.com\" _; listen 8080; }",
+			want: []Server{{Line: 1, Names: []string{"a.example.com", "b.example.com", "_"}, Listen: []string{"8080"}}},
+		},
+		{
+			name: "server as a simple directive is skipped",
+			conf: "server 127.0.0.1;\nserver { listen 80; }",
+			want: []Server{{Line: 2, Listen: []string{"80"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseServers([]byte(tt.conf))
+			if err != nil {
+				t.Fatalf("ParseServers: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseServers = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseServers([]byte("server { listen 80")); err == nil {
+		t.Error("ParseServers accepted an unterminated server")
+	}
+}
+
+func TestScanEnabled(t *testing.T) {
+	root := t.TempDir()
+	l := layout.Default()
+	l.Root = root
+	layout.Set(l)
+	t.Cleanup(func() { layout.Set(layout.Default()) })
+
+	enabled := layout.SitesEnabled("")
+	available := layout.SitesAvailable("")
+	for _, dir := range []string{enabled, available, filepath.Join(enabled, "subdir")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, conf string) {
+		if err := os.WriteFile(path, []byte(conf), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(available, "linked.conf"), "server { server_name linked.com; }")
+	if err := os.Symlink(filepath.Join(available, "linked.conf"), filepath.Join(enabled, "linked.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(available, "gone.conf"), filepath.Join(enabled, "gone.conf")); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(enabled, "plain.conf"), "server { listen 8080; server_name plain.com; }")
+	write(filepath.Join(enabled, "malformed.conf"), "server { server_name bad.com;")
+	write(filepath.Join(enabled, "empty.conf"), "# nothing here\n")
+
+	configs, err := ScanEnabled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	byName := map[string]EnabledConfig{}
+	for _, c := range configs {
+		names = append(names, c.Name)
+		byName[c.Name] = c
+	}
+	if want := []string{"empty.conf", "gone.conf", "linked.conf", "malformed.conf", "plain.conf"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("configs = %v, want %v", names, want)
+	}
+
+	if c := byName["linked.conf"]; c.Target != filepath.Join(available, "linked.conf") || c.Broken || len(c.Servers) != 1 || c.Servers[0].Names[0] != "linked.com" {
+		t.Errorf("linked.conf = %+v", c)
+	}
+	if c := byName["gone.conf"]; !c.Broken || c.ParseError != "" || len(c.Servers) != 0 {
+		t.Errorf("gone.conf = %+v", c)
+	}
+	if c := byName["plain.conf"]; c.Target != "" || len(c.Servers) != 1 || c.Servers[0].Listen[0] != "8080" {
+		t.Errorf("plain.conf = %+v", c)
+	}
+	if c := byName["malformed.conf"]; c.ParseError == "" || c.Servers == nil || len(c.Servers) != 0 {
+		t.Errorf("malformed.conf = %+v", c)
+	}
+	if c := byName["empty.conf"]; c.ParseError != "" || c.Servers == nil || len(c.Servers) != 0 {
+		t.Errorf("empty.conf = %+v", c)
+	}
+}
@@ -0,0 +1,70 @@
+package nginx
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = newTemplates()
+
+func newTemplates() *template.Template {
+	t := template.New("")
+	t.Funcs(template.FuncMap{
+		"join":   strings.Join,
+		"quote":  quote,
+		"indent": indent,
+		// include renders a defined template, so a block can be indented where it is used
+		"include": func(name string, data any) (string, error) {
+			var buf bytes.Buffer
+			err := t.ExecuteTemplate(&buf, name, data)
+			return buf.String(), err
+		},
+	})
+	return template.Must(t.ParseFS(templateFS, "templates/*.tmpl"))
+}
+
+// Render returns the server block of s
+func Render(s Site) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "vhost.conf.tmpl", withDefaults(s)); err != nil {
+		return nil, fmt.Errorf("render vhost of %s: %w", s.Domain, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// withDefaults fills the fields left empty with the defaults of the site's upstream
+func withDefaults(s Site) Site {
+	if len(s.Index) == 0 {
+		if s.PHP != nil {
+			s.Index = []string{"index.php", "index.html"}
+		} else {
+			s.Index = []string{"index.html", "index.htm"}
+		}
+	}
+	if s.TryFiles == "" {
+		if s.PHP != nil && s.PHP.FrontController {
+			s.TryFiles = "$uri /index.php$is_args$args"
+		} else if s.PHP != nil {
+			s.TryFiles = "$uri $uri/ /index.php?$query_string"
+		} else {
+			s.TryFiles = "$uri $uri/ =404"
+		}
+	}
+	return s
+}
+
+// quote returns v as an nginx double quoted string
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
@@ -0,0 +1,147 @@
+package nginx
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenSites are the variants of vhost the panel renders, each compared with
+// testdata/<name>.conf
+var goldenSites = map[string]Site{
+	"static": {
+		Domain:      "example.com",
+		Aliases:     WithWWW("example.com"),
+		Root:        "/srv/sites/example.com",
+		Gzip:        true,
+		CacheAssets: true,
+		DenyHidden:  true,
+	},
+	"php": {
+		Domain:  "example.com",
+		Aliases: WithWWW("example.com"),
+		Root:    "/srv/sites/example.com/public",
+		Charset: "utf-8",
+		Headers: []Header{
+			{Name: "X-Frame-Options", Value: "SAMEORIGIN"},
+			{Name: "X-Content-Type-Options", Value: "nosniff"},
+		},
+		ErrorPages: []ErrorPage{{Code: 404, URI: "/index.php"}},
+		QuietFiles: true,
+		DenyHidden: true,
+		PHP:        &PHP{Socket: "/run/php/php8.3-example.com-fpm.sock", ScriptFilename: true, Buffers: true},
+	},
+	"php_front_controller": {
+		Domain:     "example.com",
+		Root:       "/srv/sites/example.com/public",
+		DenyHidden: true,
+		PHP:        &PHP{Socket: "/run/php/php8.3-example.com-fpm.sock", FrontController: true, Timeout: 300},
+	},
+	"proxy_websocket": {
+		Domain:      "app.example.com",
+		MaxBodySize: "50M",
+		Proxy:       &Proxy{Pass: "http://127.0.0.1:3000", WebSocket: true, Timeout: 120},
+		StaticDirs:  []StaticDir{{Path: "/static/", Dir: "/srv/sites/app.example.com/staticfiles"}},
+	},
+	"ssl_redirect": {
+		Domain:  "example.com",
+		Aliases: WithWWW("example.com"),
+		Root:    "/srv/sites/example.com",
+		SSL: &SSL{
+			Certificate:    "/etc/letsencrypt/live/example.com/fullchain.pem",
+			CertificateKey: "/etc/letsencrypt/live/example.com/privkey.pem",
+			RedirectHTTP:   true,
+		},
+		Redirects: []models.NginxRedirect{{From: "/old", To: "/new", Code: 301}},
+	},
+	"return": {
+		Domain:  "example.com",
+		Aliases: WithWWW("example.com"),
+		Return:  &Return{Code: 503, Text: "This site is suspended"},
+	},
+	"access": {
+		Domain: "example.com",
+		Root:   "/srv/sites/example.com",
+		Access: []Access{
+			{Path: "/", Deny: []string{"203.0.113.7"}},
+			{Path: "/admin/", AuthFile: layout.Htpasswd("example.com"), Allow: []string{"10.0.0.0/8"}, SatisfyAny: true},
+		},
+	},
+}
+
+func TestRenderGolden(t *testing.T) {
+	for name, site := range goldenSites {
+		t.Run(name, func(t *testing.T) {
+			got, err := Render(site)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			golden := filepath.Join("testdata", name+".conf")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("Render differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestRenderRejects(t *testing.T) {
+	base := goldenSites["php"]
+	tests := map[string]func(s *Site){
+		"root with a space":         func(s *Site) { s.Root = "/srv/a b" },
+		"root ending the directive": func(s *Site) { s.Root = "/srv/a;include /etc/passwd" },
+		"socket with a brace":       func(s *Site) { s.PHP = &PHP{Socket: "/run/php.sock}"} },
+		"header with a newline":     func(s *Site) { s.Headers = []Header{{Name: "X-A", Value: "a\nadd_header X-B b"}} },
+		"header name":               func(s *Site) { s.Headers = []Header{{Name: "X A", Value: "a"}} },
+		"alias":                     func(s *Site) { s.Aliases = []string{"example.com;"} },
+		"uppercase domain":          func(s *Site) { s.Domain = "Example.com" },
+		"index with a quote":        func(s *Site) { s.Index = []string{`index.php"`} },
+		"try_files with a comment":  func(s *Site) { s.TryFiles = "$uri #" },
+		"body size":                 func(s *Site) { s.MaxBodySize = "10 M" },
+		"php and proxy":             func(s *Site) { s.Proxy = &Proxy{Pass: "http://127.0.0.1:3000"} },
+		"other htpasswd file":       func(s *Site) { s.Access = []Access{{Path: "/", AuthFile: "/etc/shadow"}} },
+		"snippet directive":         func(s *Site) { s.Snippet = "proxy_pass http://127.0.0.1:9000;" },
+		"return code":               func(s *Site) { s.Return = &Return{Code: 700} },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := base
+			change(&s)
+			if _, err := Render(s); err == nil {
+				t.Error("Render accepted the site")
+			}
+		})
+	}
+}
+
+func TestCheckValue(t *testing.T) {
+	for _, v := range []string{"", "/srv/sites/example.com", "$uri", "/index.php?$query_string", "http://127.0.0.1:3000"} {
+		if err := checkValue("value", v); err != nil {
+			t.Errorf("checkValue(%q): %v", v, err)
+		}
+	}
+	for _, c := range []string{" ", "\t", "\r", "\n", ";", "{", "}", `"`, "'", `\`, "#"} {
+		v := "/srv" + c + "x"
+		if err := checkValue("value", v); err == nil {
+			t.Errorf("checkValue(%q) accepted", v)
+		} else if !strings.Contains(err.Error(), "invalid value") {
+			t.Errorf("checkValue(%q) error %q does not name the value", v, err)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+// Package nginx renders the server blocks of the sites the panel hosts. Every vhost is
+// described by a Site and rendered from one template, so all sites follow the same
+// conventions and a given Site always renders to the same bytes.
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+var (
+	// hostPattern matches a server name of dot separated labels
+	hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	// headerNamePattern matches an HTTP header name
+	headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	// sizePattern matches an nginx size such as 2048M
+	sizePattern = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+)
+
+// Upstreams a site can be served by
+const (
+	UpstreamStatic = "static" // files under Root
+	UpstreamPHP    = "php"    // PHP-FPM, with Root as the document root
+	UpstreamProxy  = "proxy"  // an HTTP application listening elsewhere
+)
+
+// Site describes the server block of one domain
+type Site struct {
+	Domain  string
+	Aliases []string // other server names, e.g. www.example.com
+
+	Root        string   // document root; not used by proxied sites
+	Index       []string // defaults to index.php index.html for PHP, index.html index.htm otherwise
+	TryFiles    string   // fallback of location /, defaults to the front controller for PHP and =404 otherwise
+	MaxBodySize string   // client_max_body_size, nginx's default (1m) when empty
+	Charset     string
+
+	Headers    []Header    // add_header lines
+	ErrorPages []ErrorPage // error_page lines
+	QuietFiles bool        // serve favicon.ico and robots.txt without logging
+	DenyHidden bool        // deny dot files, except .well-known
+
+	Gzip        bool // compress text responses (scripts, styles, JSON, SVG)
+	CacheAssets bool // serve hashed build assets (app.3f9a1c2e.js) as immutable for a year
+
+	PHP   *PHP   // set for PHP-FPM sites
+	Proxy *Proxy // set for proxied sites
+
+	StaticDirs []StaticDir // folders nginx serves itself in front of the proxy
+
+	SSL *SSL // listen on 443 with this certificate
+
+	Redirects []models.NginxRedirect // rendered before the locations of the site
+	Snippet   string                 // custom directives, validated by ValidateSettings
+
+	Access []Access // basic auth and address restrictions, at most one per path
+
+	// Return, when set, answers every request with it instead of serving the site,
+	// e.g. for a suspended site
+	Return *Return
+}
+
+// Header is one add_header directive
+type Header struct {
+	Name  string
+	Value string
+}
+
+// ErrorPage is one error_page directive
+type ErrorPage struct {
+	Code int
+	URI  string
+}
+
+// PHP describes the PHP-FPM pool of a site
+type PHP struct {
+	Socket         string // the pool's unix socket
+	ScriptFilename bool   // set SCRIPT_FILENAME from $document_root
+	Buffers        bool   // larger fastcgi buffers, for frameworks with large headers
+	Timeout        int    // fastcgi connect, send and read timeout in seconds; nginx's default when 0
+	// FrontController passes only /index.php to the pool, as an internal location, and
+	// answers any other .php request with 404 (Symfony). ScriptFilename is implied.
+	FrontController bool
+}
+
+// Proxy describes the application a proxied site forwards to
+type Proxy struct {
+	Pass      string // e.g. http://127.0.0.1:3000
+	WebSocket bool   // forward Upgrade requests
+	Timeout   int    // proxy connect, send and read timeout in seconds; nginx's default when 0
+}
+
+// StaticDir is a folder served under a URL prefix, e.g. the collected static files of a
+// Django project
+type StaticDir struct {
+	Path string // URL prefix ending with /, e.g. /static/
+	Dir  string // absolute, clean path of the folder
+}
+
+// SSL holds the certificate of a site
+type SSL struct {
+	Certificate    string
+	CertificateKey string
+	RedirectHTTP   bool // answer plain HTTP with a redirect to HTTPS
+}
+
+// Return is a fixed answer to every request
+type Return struct {
+	Code int
+	Text string
+}
+
+// ConfName returns the canonical name of the vhost of domain in sites-available and
+// sites-enabled
+func ConfName(domain string) string {
+	return strings.ToLower(domain) + ".conf"
+}
+
+// SuspendedConfName returns the name of the vhost served while domain is suspended
+func SuspendedConfName(domain string) string {
+	return ConfName(domain) + ".suspended"
+}
+
+// LegacyConfNames returns the names earlier versions of the panel gave the vhost of
+// domain, so they can be cleaned up
+func LegacyConfNames(domain string) []string {
+	domain = strings.ToLower(domain)
+	return []string{
+		strings.ReplaceAll(domain, ".", "_") + ".conf", // WordPress
+		domain, // CodeIgniter
+	}
+}
+
+// WithWWW returns the www alias of domain as a one element slice, for Site.Aliases
+func WithWWW(domain string) []string {
+	return []string{"www." + strings.ToLower(domain)}
+}
+
+// Upstream returns which of the Upstream* kinds serves the site
+func (s Site) Upstream() string {
+	switch {
+	case s.PHP != nil:
+		return UpstreamPHP
+	case s.Proxy != nil:
+		return UpstreamProxy
+	}
+	return UpstreamStatic
+}
+
+// ServerNames returns the domain followed by its aliases
+func (s Site) ServerNames() []string {
+	return append([]string{s.Domain}, s.Aliases...)
+}
+
+// Validate checks the site can be rendered into a valid, single server block: values
+// that end up in the file unquoted may not contain spaces, quotes, ';' or braces.
+func (s Site) Validate() error {
+	for _, name := range s.ServerNames() {
+		if len(name) > 253 || !hostPattern.MatchString(name) {
+			return fmt.Errorf("invalid server name %q", name)
+		}
+	}
+	if s.PHP != nil && s.Proxy != nil {
+		return errors.New("a site is served by PHP-FPM or a proxy, not both")
+	}
+
+	if s.Return != nil {
+		if s.Return.Code < 100 || s.Return.Code > 599 {
+			return fmt.Errorf("invalid return code %d", s.Return.Code)
+		}
+		if err := checkText("return text", s.Return.Text); err != nil {
+			return err
+		}
+	} else if s.Proxy == nil && s.Root == "" {
+		return errors.New("root is required")
+	}
+
+	if err := checkValue("root", s.Root); err != nil {
+		return err
+	}
+	for _, index := range s.Index {
+		if err := checkValue("index", index); err != nil {
+			return err
+		}
+	}
+	for _, f := range strings.Fields(s.TryFiles) {
+		if err := checkValue("try_files", f); err != nil {
+			return err
+		}
+	}
+	if s.MaxBodySize != "" && !sizePattern.MatchString(s.MaxBodySize) {
+		return fmt.Errorf("invalid client_max_body_size %q", s.MaxBodySize)
+	}
+	if err := checkValue("charset", s.Charset); err != nil {
+		return err
+	}
+	for _, h := range s.Headers {
+		if !headerNamePattern.MatchString(h.Name) {
+			return fmt.Errorf("invalid header name %q", h.Name)
+		}
+		if err := checkText("header "+h.Name, h.Value); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.ErrorPages {
+		if p.Code < 300 || p.Code > 599 {
+			return fmt.Errorf("invalid error_page code %d", p.Code)
+		}
+		if p.URI == "" {
+			return fmt.Errorf("error_page %d has no uri", p.Code)
+		}
+		if err := checkValue("error_page", p.URI); err != nil {
+			return err
+		}
+	}
+
+	if s.PHP != nil {
+		if s.PHP.Socket == "" {
+			return errors.New("PHP-FPM socket is required")
+		}
+		if err := checkValue("PHP-FPM socket", s.PHP.Socket); err != nil {
+			return err
+		}
+		if s.PHP.Timeout < 0 {
+			return errors.New("PHP timeout cannot be negative")
+		}
+	}
+	if s.Proxy != nil {
+		if !strings.HasPrefix(s.Proxy.Pass, "http://") && !strings.HasPrefix(s.Proxy.Pass, "https://") {
+			return fmt.Errorf("invalid proxy_pass %q", s.Proxy.Pass)
+		}
+		if err := checkValue("proxy_pass", s.Proxy.Pass); err != nil {
+			return err
+		}
+		if s.Proxy.Timeout < 0 {
+			return errors.New("proxy timeout cannot be negative")
+		}
+	}
+	for _, d := range s.StaticDirs {
+		if !strings.HasPrefix(d.Path, "/") || !strings.HasSuffix(d.Path, "/") {
+			return fmt.Errorf("invalid static path %q", d.Path)
+		}
+		if err := checkValue("static path", d.Path); err != nil {
+			return err
+		}
+		if !path.IsAbs(d.Dir) || path.Clean(d.Dir) != d.Dir || d.Dir == "/" {
+			return fmt.Errorf("invalid static folder %q", d.Dir)
+		}
+		if err := checkValue("static folder", d.Dir); err != nil {
+			return err
+		}
+	}
+	if err := ValidateSettings(&models.ProjectNginxSettings{Redirects: s.Redirects, Snippet: s.Snippet}); err != nil {
+		return err
+	}
+	if err := validateAccessList(s.Access); err != nil {
+		return err
+	}
+	// basic auth reads the users of the site, never another file
+	for _, a := range s.Access {
+		if a.AuthFile != "" && a.AuthFile != layout.Htpasswd(strings.ToLower(s.Domain)) {
+			return fmt.Errorf("invalid htpasswd file %q", a.AuthFile)
+		}
+	}
+	if s.SSL != nil {
+		if s.SSL.Certificate == "" || s.SSL.CertificateKey == "" {
+			return errors.New("SSL needs a certificate and a key")
+		}
+		if err := checkValue("ssl_certificate", s.SSL.Certificate); err != nil {
+			return err
+		}
+		if err := checkValue("ssl_certificate_key", s.SSL.CertificateKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue rejects a value that would end or escape the directive it is written in
+func checkValue(what, v string) error {
+	if strings.ContainsAny(v, " \t\r\n;{}\"'\\#") {
+		return fmt.Errorf("invalid %s %q", what, v)
+	}
+	return nil
+}
+
+// checkText rejects a value that cannot be written as one quoted string
+func checkText(what, v string) error {
+	if strings.ContainsAny(v, "\r\n") {
+		return fmt.Errorf("invalid %s %q", what, v)
+	}
+	return nil
+}
+
+// SSLFromConfig returns the certificate and key an existing vhost uses, or nil when it
+// has none
+func SSLFromConfig(conf []byte) *SSL {
+	ssl := &SSL{}
+	for _, line := range strings.Split(string(conf), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ";"))
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "ssl_certificate":
+			ssl.Certificate = fields[1]
+		case "ssl_certificate_key":
+			ssl.CertificateKey = fields[1]
+		}
+	}
+	if ssl.Certificate == "" || ssl.CertificateKey == "" {
+		return nil
+	}
+	return ssl
+}
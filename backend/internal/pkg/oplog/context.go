@@ -0,0 +1,76 @@
+package oplog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type contextKey struct{}
+
+// WithLog returns a context whose commands and messages go to l
+func WithLog(ctx context.Context, l *Log) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the operation log carried by ctx, or nil
+func FromContext(ctx context.Context) *Log {
+	l, _ := ctx.Value(contextKey{}).(*Log)
+	return l
+}
+
+// Printf writes a message to the operation log in ctx, or to the server's stdout without one
+func Printf(ctx context.Context, format string, args ...any) {
+	if l := FromContext(ctx); l != nil {
+		l.Printf(format, args...)
+		return
+	}
+	fmt.Printf(format, args...)
+	if !strings.HasSuffix(format, "\n") {
+		fmt.Println()
+	}
+}
+
+// Println is Printf with the formatting of fmt.Println
+func Println(ctx context.Context, args ...any) {
+	Printf(ctx, "%s", strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+// Run runs cmd with its stdout and stderr captured into the operation log in ctx.
+// Writers already set on cmd keep receiving the output. Without a log the output goes
+// to the server's stdout/stderr as before.
+func Run(ctx context.Context, cmd *exec.Cmd) error {
+	l := FromContext(ctx)
+	if l == nil {
+		if cmd.Stdout == nil {
+			cmd.Stdout = os.Stdout
+		}
+		if cmd.Stderr == nil {
+			cmd.Stderr = os.Stderr
+		}
+		return cmd.Run()
+	}
+
+	l.Printf("$ %s", strings.Join(cmd.Args, " "))
+	stdout, stderr := l.Writer(StreamStdout), l.Writer(StreamStderr)
+	cmd.Stdout = tee(cmd.Stdout, stdout)
+	cmd.Stderr = tee(cmd.Stderr, stderr)
+
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	if err != nil {
+		l.Printf("command failed: %v", err)
+	}
+	return err
+}
+
+func tee(existing io.Writer, w io.Writer) io.Writer {
+	if existing == nil {
+		return w
+	}
+	return io.MultiWriter(existing, w)
+}
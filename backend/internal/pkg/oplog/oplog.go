@@ -0,0 +1,300 @@
+// Package oplog captures the output of long operations (deployments, certificate issuance)
+// line by line. Each operation gets a log that is written to disk as JSON lines, so it can be
+// fetched after the fact, and that can be tailed live by any number of subscribers.
+package oplog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Streams a line can come from
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamInfo   = "info" // messages from the panel itself: steps, commands being run, outcome
+)
+
+const (
+	maxLineLength    = 8 << 10 // longer lines are split
+	subscriberBuffer = 512     // lines a subscriber may fall behind before it is dropped
+)
+
+// ErrNotFound is returned when no log exists for an ID
+var ErrNotFound = errors.New("operation log not found")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,100}$`)
+
+// Line is one line of output
+type Line struct {
+	Seq    int       `json:"seq"`
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// Store keeps operation logs in a directory, one file per operation
+type Store struct {
+	dir    string
+	mu     sync.Mutex
+	active map[string]*Log
+}
+
+func NewStore(dir string) *Store {
+	return &Store{
+		dir:    dir,
+		active: map[string]*Log{},
+	}
+}
+
+// Create opens the log for id. If a log already exists for id (e.g. a resumed job),
+// new lines are appended after the old ones.
+func (s *Store) Create(id string) (*Log, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("invalid operation log id %q", id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.active[id]; ok {
+		return l, nil
+	}
+
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	seq := 0
+	if lines, err := s.Read(id, 0); err == nil && len(lines) > 0 {
+		seq = lines[len(lines)-1].Seq
+	}
+	f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("open operation log: %w", err)
+	}
+
+	l := &Log{
+		ID:    id,
+		store: s,
+		file:  f,
+		enc:   json.NewEncoder(f),
+		seq:   seq,
+		subs:  map[chan Line]struct{}{},
+	}
+	s.active[id] = l
+	return l, nil
+}
+
+// Active returns the log for id while its operation is still running
+func (s *Store) Active(id string) (*Log, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.active[id]
+	return l, ok
+}
+
+// Read returns the stored lines of id with a sequence number above afterSeq
+func (s *Store) Read(id string, afterSeq int) ([]Line, error) {
+	if !validID.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []Line{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 4*maxLineLength)
+	for sc.Scan() {
+		var line Line
+		if json.Unmarshal(sc.Bytes(), &line) != nil {
+			continue // a line cut short by a crash
+		}
+		if line.Seq > afterSeq {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// Purge deletes logs of finished operations not written to for longer than retention
+func (s *Store) Purge(retention time.Duration) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, running := s.Active(id); running {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			_ = os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+	return nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".log")
+}
+
+// Log is the output of one running operation
+type Log struct {
+	ID string
+
+	store  *Store
+	mu     sync.Mutex
+	file   *os.File
+	enc    *json.Encoder
+	seq    int
+	subs   map[chan Line]struct{}
+	closed bool
+}
+
+// Printf writes a panel message to the log
+func (l *Log) Printf(format string, args ...any) {
+	for _, text := range strings.Split(strings.TrimRight(fmt.Sprintf(format, args...), "\n"), "\n") {
+		l.append(StreamInfo, text)
+	}
+}
+
+// Writer returns a writer that splits what is written into lines of stream.
+// Call Flush when done to emit a final line without a trailing newline.
+func (l *Log) Writer(stream string) *Writer {
+	return &Writer{log: l, stream: stream}
+}
+
+// Subscribe returns a channel receiving every line written from now on. Lines written
+// before are already on disk (see Store.Read), so subscribing first and then reading
+// the file misses nothing. The channel is closed when the log is closed or when the
+// subscriber falls too far behind. Call cancel once done reading.
+func (l *Log) Subscribe() (lines <-chan Line, cancel func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ch := make(chan Line, subscriberBuffer)
+	if l.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	l.subs[ch] = struct{}{}
+	return ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.subs[ch]; ok {
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends the log: subscribers are released and the file is closed
+func (l *Log) Close() error {
+	l.store.mu.Lock()
+	delete(l.store.active, l.ID)
+	l.store.mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	for ch := range l.subs {
+		close(ch)
+	}
+	l.subs = nil
+	return l.file.Close()
+}
+
+func (l *Log) append(stream, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+
+	l.seq++
+	line := Line{Seq: l.seq, Time: time.Now().UTC(), Stream: stream, Text: text}
+	_ = l.enc.Encode(line) // the live tail keeps working even if the disk is full
+
+	for ch := range l.subs {
+		select {
+		case ch <- line:
+		default:
+			// too slow; it can catch up from the stored log
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Writer turns a byte stream (e.g. a command's stdout) into log lines
+type Writer struct {
+	log    *Log
+	stream string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	for len(w.buf) > maxLineLength {
+		w.emit(w.buf[:maxLineLength])
+		w.buf = w.buf[maxLineLength:]
+	}
+	return len(p), nil
+}
+
+// Flush emits any partial last line
+func (w *Writer) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+// emit logs one line. Like a terminal, a carriage return starts the line over,
+// so progress bars only leave their final state.
+func (w *Writer) emit(b []byte) {
+	text := strings.TrimRight(string(b), "\r")
+	if i := strings.LastIndexByte(text, '\r'); i >= 0 {
+		text = text[i+1:]
+	}
+	w.log.append(w.stream, strings.ToValidUTF8(text, "�"))
+}
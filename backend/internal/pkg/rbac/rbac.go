@@ -0,0 +1,107 @@
+// Package rbac declares the panel roles and the permissions each role holds.
+// It has no database or HTTP dependency so the whole model can be checked in isolation.
+package rbac
+
+import "slices"
+
+// Panel roles stored in users.role and carried in the JWT "role" claim
+const (
+	RoleAdmin    = "admin"    // full access, including destructive database operations
+	RoleOperator = "operator" // day-to-day operations: deploy, suspend, issue SSL, create databases
+	RoleViewer   = "viewer"   // read-only access
+)
+
+// Permission is a "resource:action" pair that a route requires
+type Permission string
+
+const (
+	DatabaseRead   Permission = "db:read"
+	DatabaseWrite  Permission = "db:write"  // create databases and users, import SQL
+	DatabaseDelete Permission = "db:delete" // drop or reset databases
+
+	ProjectRead   Permission = "project:read"
+	ProjectDeploy Permission = "project:deploy" // init, upload, deploy, suspend, restart
+	ProjectDelete Permission = "project:delete"
+
+	DomainRead   Permission = "domain:read"
+	DomainWrite  Permission = "domain:write"
+	DomainDelete Permission = "domain:delete"
+
+	SSLRead  Permission = "ssl:read"
+	SSLIssue Permission = "ssl:issue"
+
+	UserManage Permission = "user:manage" // panel accounts and their sessions
+
+	AuditRead Permission = "audit:read" // audit trail and its export
+
+	JobRead   Permission = "job:read"   // background job status and results
+	JobCancel Permission = "job:cancel" // stop a queued or running job
+
+	NginxRead Permission = "nginx:read" // inventory of the enabled vhosts and drift report
+)
+
+var viewerPermissions = []Permission{
+	DatabaseRead,
+	ProjectRead,
+	DomainRead,
+	SSLRead,
+	JobRead,
+	NginxRead,
+}
+
+var operatorPermissions = append(slices.Clone(viewerPermissions),
+	DatabaseWrite,
+	ProjectDeploy,
+	DomainWrite,
+	SSLIssue,
+	JobCancel,
+)
+
+var adminPermissions = append(slices.Clone(operatorPermissions),
+	DatabaseDelete,
+	ProjectDelete,
+	DomainDelete,
+	UserManage,
+	AuditRead,
+)
+
+// rolePermissions maps every known role to the permissions it holds
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:    adminPermissions,
+	RoleOperator: operatorPermissions,
+	RoleViewer:   viewerPermissions,
+}
+
+// Roles returns the known roles, most privileged first
+func Roles() []string {
+	return []string{RoleAdmin, RoleOperator, RoleViewer}
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// PermissionsFor returns the permissions held by role. Unknown roles hold none.
+func PermissionsFor(role string) []Permission {
+	return slices.Clone(rolePermissions[role])
+}
+
+// Allows reports whether role holds permission p
+func Allows(role string, p Permission) bool {
+	return slices.Contains(rolePermissions[role], p)
+}
+
+// APIKeyScopes returns the permissions an API key may carry.
+// Panel account management is left out: automation never needs to create users or kill sessions.
+func APIKeyScopes() []Permission {
+	return slices.DeleteFunc(slices.Clone(adminPermissions), func(p Permission) bool {
+		return p == UserManage
+	})
+}
+
+// ValidAPIKeyScope reports whether p may be granted to an API key
+func ValidAPIKeyScope(p Permission) bool {
+	return slices.Contains(APIKeyScopes(), p)
+}
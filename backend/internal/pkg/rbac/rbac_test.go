@@ -0,0 +1,106 @@
+package rbac
+
+import (
+	"slices"
+	"testing"
+)
+
+// allPermissions lists every permission, so a new one must be placed in the matrix below
+var allPermissions = []Permission{
+	DatabaseRead, DatabaseWrite, DatabaseDelete,
+	ProjectRead, ProjectDeploy, ProjectDelete,
+	DomainRead, DomainWrite, DomainDelete,
+	SSLRead, SSLIssue,
+	UserManage,
+	AuditRead,
+	JobRead, JobCancel,
+	NginxRead,
+}
+
+func TestRolePermissionMatrix(t *testing.T) {
+	const (
+		v = 1 << iota // viewer
+		o             // operator
+		a             // admin
+	)
+	matrix := map[Permission]int{
+		DatabaseRead:   v | o | a,
+		DatabaseWrite:  o | a,
+		DatabaseDelete: a,
+		ProjectRead:    v | o | a,
+		ProjectDeploy:  o | a,
+		ProjectDelete:  a,
+		DomainRead:     v | o | a,
+		DomainWrite:    o | a,
+		DomainDelete:   a,
+		SSLRead:        v | o | a,
+		SSLIssue:       o | a,
+		UserManage:     a,
+		AuditRead:      a,
+		JobRead:        v | o | a,
+		JobCancel:      o | a,
+		NginxRead:      v | o | a,
+	}
+	roles := map[string]int{RoleViewer: v, RoleOperator: o, RoleAdmin: a}
+
+	for _, p := range allPermissions {
+		holders, ok := matrix[p]
+		if !ok {
+			t.Errorf("%s is missing from the matrix", p)
+			continue
+		}
+		for role, bit := range roles {
+			if got, want := Allows(role, p), holders&bit != 0; got != want {
+				t.Errorf("Allows(%s, %s) = %v, want %v", role, p, got, want)
+			}
+		}
+	}
+	// no role holds a permission outside the list
+	for role := range roles {
+		for _, p := range PermissionsFor(role) {
+			if !slices.Contains(allPermissions, p) {
+				t.Errorf("%s holds unlisted permission %s", role, p)
+			}
+		}
+	}
+}
+
+func TestUnknownRole(t *testing.T) {
+	for _, role := range []string{"", "editor", "Admin", "root"} {
+		if ValidRole(role) {
+			t.Errorf("ValidRole(%q) = true", role)
+		}
+		if len(PermissionsFor(role)) != 0 {
+			t.Errorf("unknown role %q holds permissions", role)
+		}
+		for _, p := range allPermissions {
+			if Allows(role, p) {
+				t.Errorf("unknown role %q allowed %s", role, p)
+			}
+		}
+	}
+	for _, role := range Roles() {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%q) = false", role)
+		}
+	}
+}
+
+func TestPermissionsForIsACopy(t *testing.T) {
+	perms := PermissionsFor(RoleViewer)
+	perms[0] = UserManage
+	if Allows(RoleViewer, UserManage) {
+		t.Error("changing the result of PermissionsFor granted a permission")
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	if ValidAPIKeyScope(UserManage) {
+		t.Error("an API key may carry user:manage")
+	}
+	for _, p := range allPermissions {
+		if p != UserManage && !ValidAPIKeyScope(p) {
+			t.Errorf("an API key may not carry %s", p)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package sitelock serialises operations on the same site, so a suspend cannot race a
+// delete and two deployments cannot write the same nginx config. Locks are held in
+// memory and cover one panel process; the jobs table additionally allows only one
+// active job per target.
+package sitelock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BusyError is returned when another operation holds the lock
+type BusyError struct {
+	Key    string
+	Holder string
+	Since  time.Time
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%s is busy: %s is in progress (since %s)", e.Key, e.Holder, e.Since.Format(time.RFC3339))
+}
+
+type entry struct {
+	holder string
+	since  time.Time
+	done   chan struct{} // closed on unlock
+}
+
+// Manager holds one lock per key
+type Manager struct {
+	mu   sync.Mutex
+	held map[string]*entry
+}
+
+func New() *Manager {
+	return &Manager{held: map[string]*entry{}}
+}
+
+// Key normalises a domain into a lock key
+func Key(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// TryLock takes the lock for key on behalf of holder (a short description shown to
+// whoever is turned away) or fails with *BusyError.
+func (m *Manager) TryLock(key, holder string) (unlock func(), err error) {
+	key = Key(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.held[key]; ok {
+		return nil, &BusyError{Key: key, Holder: e.holder, Since: e.since}
+	}
+	return m.take(key, holder), nil
+}
+
+// Lock waits for the lock for key until ctx is done
+func (m *Manager) Lock(ctx context.Context, key, holder string) (unlock func(), err error) {
+	key = Key(key)
+	for {
+		m.mu.Lock()
+		e, busy := m.held[key]
+		if !busy {
+			unlock := m.take(key, holder)
+			m.mu.Unlock()
+			return unlock, nil
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-e.done:
+		}
+	}
+}
+
+// take must be called with m.mu held
+func (m *Manager) take(key, holder string) func() {
+	e := &entry{holder: holder, since: time.Now(), done: make(chan struct{})}
+	m.held[key] = e
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			if m.held[key] == e {
+				delete(m.held, key)
+			}
+			m.mu.Unlock()
+			close(e.done)
+		})
+	}
+}
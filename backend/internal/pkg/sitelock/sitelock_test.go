@@ -0,0 +1,162 @@
+package sitelock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTryLockTwice(t *testing.T) {
+	m := New()
+	unlock, err := m.TryLock("Example.com.", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same site under another spelling is the same lock
+	_, err = m.TryLock(" example.COM", "suspend")
+	var busy *BusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("second TryLock: err = %v, want *BusyError", err)
+	}
+	if busy.Key != "example.com" || busy.Holder != "deploy" || busy.Since.IsZero() {
+		t.Errorf("busy = %+v", busy)
+	}
+
+	// other sites are not affected
+	other, err := m.TryLock("other.com", "deploy")
+	if err != nil {
+		t.Fatalf("other site: %v", err)
+	}
+	other()
+
+	unlock()
+	unlock() // a second unlock is a no-op
+	again, err := m.TryLock("example.com", "suspend")
+	if err != nil {
+		t.Fatalf("TryLock after unlock: %v", err)
+	}
+	// the stale unlock must not release the new holder's lock
+	unlock()
+	if _, err := m.TryLock("example.com", "delete"); err == nil {
+		t.Fatal("stale unlock released the lock of the next holder")
+	}
+	again()
+}
+
+func TestLockWaitsForUnlock(t *testing.T) {
+	m := New()
+	unlock, err := m.TryLock("example.com", "suspend")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		u, err := m.Lock(context.Background(), "example.com", "deploy")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- u
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Lock returned while the lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case u := <-acquired:
+		u()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock did not return after unlock")
+	}
+}
+
+func TestLockCancelled(t *testing.T) {
+	m := New()
+	unlock, err := m.TryLock("example.com", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.Lock(ctx, "example.com", "suspend"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock: err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestReleasedOnPanic(t *testing.T) {
+	m := New()
+	func() {
+		defer func() { _ = recover() }()
+		unlock, err := m.TryLock("example.com", "deploy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unlock()
+		panic("deploy failed")
+	}()
+	unlock, err := m.TryLock("example.com", "deploy")
+	if err != nil {
+		t.Fatalf("lock still held after a panic: %v", err)
+	}
+	unlock()
+}
+
+func TestConcurrentLocks(t *testing.T) {
+	m := New()
+	const workers = 20
+
+	// of simultaneous TryLocks, exactly one wins
+	var wg sync.WaitGroup
+	unlocks := make(chan func(), workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if unlock, err := m.TryLock("example.com", "deploy"); err == nil {
+				unlocks <- unlock
+			}
+		}()
+	}
+	wg.Wait()
+	close(unlocks)
+	if len(unlocks) != 1 {
+		t.Fatalf("%d TryLocks succeeded, want 1", len(unlocks))
+	}
+	(<-unlocks)()
+
+	// waiting Locks run one at a time
+	var mu sync.Mutex
+	inside, most := 0, 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := m.Lock(context.Background(), "example.com", "deploy")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			inside++
+			most = max(most, inside)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			inside--
+			mu.Unlock()
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Fatalf("%d holders at once, want 1", most)
+	}
+}
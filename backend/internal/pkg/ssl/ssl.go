@@ -1,19 +1,26 @@
 package ssl
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/broker"
 	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
 )
 
+// domainPattern matches a host name of dot separated labels
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
 // --------------------
 // Local Certificate Check
 // --------------------
@@ -40,12 +47,12 @@ func sslExistsP12(path string) bool {
 // sslExistsLocal checks standard local paths for domain certificates
 func sslExistsLocal(domain string) bool {
 	possiblePaths := []struct{ cert, key string }{
-		{filepath.Join("/etc/letsencrypt/live", domain, "fullchain.pem"),
-			filepath.Join("/etc/letsencrypt/live", domain, "privkey.pem")},
-		{filepath.Join("/etc/ssl", domain, "cert.pem"),
-			filepath.Join("/etc/ssl", domain, "key.pem")},
-		{filepath.Join("/home/user/certs", domain, "cert.pem"),
-			filepath.Join("/home/user/certs", domain, "key.pem")},
+		{layout.LetsEncrypt("live", domain, "fullchain.pem"),
+			layout.LetsEncrypt("live", domain, "privkey.pem")},
+		{layout.SSL(domain, "cert.pem"),
+			layout.SSL(domain, "key.pem")},
+		{filepath.Join(layout.UserHome("user"), "certs", domain, "cert.pem"),
+			filepath.Join(layout.UserHome("user"), "certs", domain, "key.pem")},
 	}
 
 	for _, p := range possiblePaths {
@@ -56,10 +63,10 @@ func sslExistsLocal(domain string) bool {
 
 	// Check P12/PFX
 	p12Paths := []string{
-		filepath.Join("/etc/ssl", domain, "cert.p12"),
-		filepath.Join("/etc/ssl", domain, "cert.pfx"),
-		filepath.Join("/home/user/certs", domain, "cert.p12"),
-		filepath.Join("/home/user/certs", domain, "cert.pfx"),
+		layout.SSL(domain, "cert.p12"),
+		layout.SSL(domain, "cert.pfx"),
+		filepath.Join(layout.UserHome("user"), "certs", domain, "cert.p12"),
+		filepath.Join(layout.UserHome("user"), "certs", domain, "cert.pfx"),
 	}
 	for _, path := range p12Paths {
 		if sslExistsP12(path) {
@@ -75,53 +82,58 @@ func sslExistsLocal(domain string) bool {
 // --------------------
 
 // sslExistsRemote checks if a domain has an SSL certificate on port 443
-func sslExistsRemote(domain string, timeout time.Duration) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func sslExistsRemote(ctx context.Context, domain string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	// Use "echo | openssl s_client" to prevent hanging stdin
-	cmd := exec.CommandContext(ctx, "sh", "-c",
-		fmt.Sprintf("echo | openssl s_client -connect %s:443 -servername %s -showcerts", domain, domain),
-	)
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-
-	if err := cmd.Run(); err != nil {
+	// an empty stdin has s_client close the connection after the handshake instead of hanging
+	out, err := syscmd.FromContext(ctx).Query(ctx, syscmd.Cmd{
+		Name:  "openssl",
+		Args:  []string{"s_client", "-connect", net.JoinHostPort(domain, "443"), "-servername", domain, "-showcerts"},
+		Stdin: strings.NewReader(""),
+	})
+	if err != nil {
 		return false
 	}
 
-	output := out.String()
-	if strings.Contains(output, "-----BEGIN CERTIFICATE-----") {
-		return true
-	}
-
-	return false
+	return strings.Contains(string(out), "-----BEGIN CERTIFICATE-----")
 }
 
 // --------------------
 // Automatic Decision
 // --------------------
 
+// ValidateDomain checks domain is a host name, so it is safe to put in a path or pass
+// to a command
+func ValidateDomain(domain string) error {
+	if len(domain) > 253 || !domainPattern.MatchString(strings.ToLower(domain)) {
+		return fmt.Errorf("invalid domain %q", domain)
+	}
+	return nil
+}
+
 // CheckSSL determines if SSL exists locally or remotely.
 // Returns true if SSL exists, false if issuance is needed.
-func CheckSSL(domain string) (sslExists bool) {
+func CheckSSL(ctx context.Context, domain string) (sslExists bool, err error) {
+	if err := ValidateDomain(domain); err != nil {
+		return false, err
+	}
+	domain = strings.ToLower(domain)
+
 	// 1. Check local files first
 	if sslExistsLocal(domain) {
-		return true
+		return true, nil
 	}
 
 	// 2. Check remote SSL
 	timeout := 2 * time.Minute
-	if sslExistsRemote(domain, timeout) {
-		return true
+	if sslExistsRemote(ctx, domain, timeout) {
+		return true, nil
 	}
 	//SSL record doesn't exist
-	return false
+	return false, nil
 }
 
-
 // SetupSSL sets up SSL for the given domain using Certbot.
 // useNginx: true = use nginx plugin, false = standalone
 func SetupSSL(ctx context.Context, domain, email string, useNginx bool) error {
@@ -132,54 +144,27 @@ func SetupSSL(ctx context.Context, domain, email string, useNginx bool) error {
 	// ----------------------------------------
 	// 1. CHECK & INSTALL CERTBOT IF NEEDED
 	// ----------------------------------------
-	fmt.Println("Checking Certbot installation...")
+	oplog.Println(ctx, "Checking Certbot installation...")
 
 	if _, err := exec.LookPath("certbot"); err != nil {
-		fmt.Println("Certbot not found. Installing...")
-
-		if err := syscmd.RunSudoCmd(ctx, "apt", "update"); err != nil {
-			return fmt.Errorf("failed apt update: %v", err)
-		}
+		oplog.Println(ctx, "Certbot not found. Installing...")
 
+		packages := []string{"certbot"}
 		if useNginx {
-			if err := syscmd.RunSudoCmd(ctx, "apt", "install", "-y", "certbot", "python3-certbot-nginx"); err != nil {
-				return fmt.Errorf("failed to install certbot with nginx: %v", err)
-			}
-		} else {
-			if err := syscmd.RunSudoCmd(ctx, "apt", "install", "-y", "certbot"); err != nil {
-				return fmt.Errorf("failed to install certbot: %v", err)
-			}
+			packages = append(packages, "python3-certbot-nginx")
+		}
+		if err := syscmd.Privileged(ctx, &broker.InstallPackages{Packages: packages}); err != nil {
+			return fmt.Errorf("failed to install certbot: %v", err)
 		}
 	}
 
 	// ----------------------------------------
 	// 2. RUN CERTBOT
 	// ----------------------------------------
-	fmt.Println("Starting SSL setup for domain:", domain)
-
-	args := []string{}
-	if useNginx {
-		args = []string{
-			"--nginx",
-			"-d", domain,
-			"-d", "www." + domain,
-			"--non-interactive",
-			"--agree-tos",
-			"--email", email,
-		}
-	} else {
-		args = []string{
-			"certonly",
-			"--standalone",
-			"--non-interactive",
-			"--agree-tos",
-			"--email", email,
-			"-d", domain,
-		}
-	}
+	oplog.Println(ctx, "Starting SSL setup for domain:", domain)
 
-	// Run certbot via sudo and stream output
-	if err := syscmd.RunSudoCmd(ctx, "certbot", args...); err != nil {
+	// Run certbot as root and stream output
+	if err := syscmd.Privileged(ctx, &broker.IssueCertificate{Domain: strings.ToLower(domain), Email: email, Nginx: useNginx}); err != nil {
 		return fmt.Errorf("certbot failed: %v", err)
 	}
 
@@ -187,16 +172,16 @@ func SetupSSL(ctx context.Context, domain, email string, useNginx bool) error {
 	// 3. RELOAD NGINX IF USED
 	// ----------------------------------------
 	if useNginx {
-		if err := syscmd.RunSudoCmd(ctx, "systemctl", "reload", "nginx"); err != nil {
-			return fmt.Errorf("failed to reload nginx: %v", err)
+		if err := syscmd.Privileged(ctx, &broker.ReloadNginx{}); err != nil {
+			return fmt.Errorf("failed to reload nginx: %w", err)
 		}
 	}
 
-	fmt.Println("\n------------------------------------------------")
-	fmt.Println("SSL Certificate obtained successfully!")
-	fmt.Printf("Cert Path: /etc/letsencrypt/live/%s/fullchain.pem\n", domain)
-	fmt.Printf("Key Path : /etc/letsencrypt/live/%s/privkey.pem\n", domain)
-	fmt.Println("------------------------------------------------")
+	oplog.Println(ctx, "------------------------------------------------")
+	oplog.Println(ctx, "SSL Certificate obtained successfully!")
+	oplog.Printf(ctx, "Cert Path: %s\n", layout.LetsEncrypt("live", domain, "fullchain.pem"))
+	oplog.Printf(ctx, "Key Path : %s\n", layout.LetsEncrypt("live", domain, "privkey.pem"))
+	oplog.Println(ctx, "------------------------------------------------")
 
 	return nil
 }
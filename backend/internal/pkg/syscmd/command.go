@@ -0,0 +1,23 @@
+package syscmd
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// stopGracePeriod is how long a cancelled command may take to exit before it is killed
+const stopGracePeriod = 10 * time.Second
+
+// Command is exec.CommandContext with a graceful stop: when ctx is cancelled the process
+// receives SIGTERM (sudo relays it to the command it runs, so apt or certbot can clean up)
+// and is killed if it has not exited within stopGracePeriod.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = stopGracePeriod
+	return cmd
+}
@@ -0,0 +1,243 @@
+package syscmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+)
+
+// Cmd describes one command to run
+type Cmd struct {
+	Name  string
+	Args  []string
+	Dir   string    // working directory; the panel's when empty
+	Env   []string  // added to the panel's environment
+	Stdin io.Reader // optional
+}
+
+// String returns the command line, quoting arguments that need it
+func (c Cmd) String() string {
+	parts := make([]string, 0, len(c.Args)+1)
+	for _, a := range append([]string{c.Name}, c.Args...) {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\$`|&;<>(){}*?!#~") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		parts = append(parts, a)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Executor runs commands and writes files on the host. Deploy, SSL and site operations
+// take it from the context (see WithExecutor), so the same code can run for real,
+// against a recording fake, or as a dry run.
+type Executor interface {
+	// Run runs a command that may change the host. Its output goes to the operation log
+	// in ctx and is also returned (stdout and stderr combined).
+	Run(ctx context.Context, c Cmd) ([]byte, error)
+
+	// Query runs a command that only inspects the host and returns its stdout.
+	// A dry run executes queries for real, so the plan reflects the actual host.
+	Query(ctx context.Context, c Cmd) ([]byte, error)
+
+	// WriteFile writes data to path as root (sudo tee), replacing the file
+	WriteFile(ctx context.Context, path string, data []byte) error
+
+	// WriteUserFile writes data to path as the panel's own user, replacing the file, and
+	// gives it mode perm. A new file takes the place of the old one, so a file the
+	// panel may not change in place, such as one of a project's user, can be replaced too.
+	WriteUserFile(ctx context.Context, path string, data []byte, perm os.FileMode) error
+
+	// Privileged performs a typed operation that needs root, such as writing an nginx
+	// vhost. It is sent to the privilege broker, or runs here through sudo when the
+	// panel is explicitly configured without one (SudoFallback).
+	Privileged(ctx context.Context, op Op) error
+}
+
+// Op is a typed operation that needs root. Its fields are validated before it runs,
+// so a caller cannot smuggle arbitrary paths or shell into it.
+type Op interface {
+	// Kind names the operation, e.g. "nginx.reload"
+	Kind() string
+	// Validate checks the fields of the operation
+	Validate() error
+	// Apply performs the operation with the executor in ctx, using Sudo and WriteFile
+	Apply(ctx context.Context) error
+}
+
+type executorKey struct{}
+
+// defaultExecutor is used when the context carries none
+var defaultExecutor Executor = Real{}
+
+// SetDefault sets the executor used when the context carries none. It is meant to be
+// called once at startup, e.g. to send privileged operations to the broker.
+func SetDefault(ex Executor) {
+	defaultExecutor = ex
+}
+
+// WithExecutor returns a context whose commands run through ex
+func WithExecutor(ctx context.Context, ex Executor) context.Context {
+	return context.WithValue(ctx, executorKey{}, ex)
+}
+
+// FromContext returns the executor carried by ctx, or the default one
+func FromContext(ctx context.Context) Executor {
+	if ex, ok := ctx.Value(executorKey{}).(Executor); ok {
+		return ex
+	}
+	return defaultExecutor
+}
+
+// Run runs name with args through the executor in ctx
+func Run(ctx context.Context, name string, args ...string) error {
+	_, err := FromContext(ctx).Run(ctx, Cmd{Name: name, Args: args})
+	return err
+}
+
+// Query runs the read-only command name with args through the executor in ctx and returns its stdout
+func Query(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return FromContext(ctx).Query(ctx, Cmd{Name: name, Args: args})
+}
+
+// Sudo runs name with args as root through the executor in ctx: directly when the
+// process already is root (the broker), through sudo otherwise
+func Sudo(ctx context.Context, name string, args ...string) error {
+	_, err := FromContext(ctx).Run(ctx, SudoCmd(name, args...))
+	return err
+}
+
+// SudoCmd returns the Cmd that runs name with args as root, for callers that need the
+// output of a command run through Sudo
+func SudoCmd(name string, args ...string) Cmd {
+	argv := rootCmd(name, args...)
+	return Cmd{Name: argv[0], Args: argv[1:]}
+}
+
+// WriteFile writes data to path as root through the executor in ctx
+func WriteFile(ctx context.Context, path string, data []byte) error {
+	return FromContext(ctx).WriteFile(ctx, path, data)
+}
+
+// WriteUserFile writes data to path as the panel's user through the executor in ctx
+func WriteUserFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	return FromContext(ctx).WriteUserFile(ctx, path, data, perm)
+}
+
+// Privileged performs op through the executor in ctx
+func Privileged(ctx context.Context, op Op) error {
+	return FromContext(ctx).Privileged(ctx, op)
+}
+
+// rootCmd returns the argv that runs name as root
+func rootCmd(name string, args ...string) []string {
+	if os.Geteuid() == 0 {
+		return append([]string{name}, args...)
+	}
+	return append([]string{"sudo", name}, args...)
+}
+
+// Real executes commands on the host
+type Real struct{}
+
+func (Real) Run(ctx context.Context, c Cmd) ([]byte, error) {
+	cmd := c.command(ctx)
+	cmd.Stdin = c.Stdin
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if oplog.FromContext(ctx) == nil {
+		// no operation log: keep echoing to the server's terminal
+		cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &out)
+	}
+	err := oplog.Run(ctx, cmd)
+	return out.Bytes(), err
+}
+
+func (Real) Query(ctx context.Context, c Cmd) ([]byte, error) {
+	cmd := c.command(ctx)
+	cmd.Stdin = c.Stdin
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%s: %w: %s", c, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (Real) WriteFile(ctx context.Context, path string, data []byte) error {
+	oplog.Printf(ctx, "Writing %s (%d bytes)", path, len(data))
+	argv := rootCmd("tee", path)
+	cmd := Command(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", strings.Join(argv, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (Real) WriteUserFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	oplog.Printf(ctx, "Writing %s (%d bytes)", path, len(data))
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ErrNoBroker is returned by Real.Privileged: privileged operations go to the broker (see
+// SetDefault), or through sudo only on a host configured for it (see SudoFallback)
+var ErrNoBroker = errors.New("no privilege broker configured")
+
+// Privileged refuses op, as the panel itself has no root rights
+func (Real) Privileged(ctx context.Context, op Op) error {
+	return fmt.Errorf("%s: %w", op.Kind(), ErrNoBroker)
+}
+
+// SudoFallback executes commands like Real but runs privileged operations in this process,
+// through sudo. It is for hosts without the broker, where the panel needs passwordless
+// sudo for the commands of every operation.
+type SudoFallback struct {
+	Real
+}
+
+// Privileged runs op in this process, through sudo
+func (SudoFallback) Privileged(ctx context.Context, op Op) error {
+	if err := op.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", op.Kind(), err)
+	}
+	return op.Apply(ctx)
+}
+
+func (c Cmd) command(ctx context.Context) *exec.Cmd {
+	cmd := Command(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	return cmd
+}
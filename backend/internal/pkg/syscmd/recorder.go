@@ -0,0 +1,124 @@
+package syscmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Kinds of recorded steps
+const (
+	StepRun        = "run"
+	StepQuery      = "query"
+	StepWrite      = "write"
+	StepWriteUser  = "write_user" // a file written as the panel's user
+	StepPrivileged = "privileged" // followed by the steps the operation takes
+)
+
+// Step is one command or file write recorded by a Recorder
+type Step struct {
+	Kind    string   `json:"kind"`
+	Command string   `json:"command,omitempty"` // for run and query
+	Args    []string `json:"args,omitempty"`    // argv of the command, Args[0] is the program
+	Dir     string   `json:"dir,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Path    string   `json:"path,omitempty"`    // for write
+	Content string   `json:"content,omitempty"` // for write
+	Mode    string   `json:"mode,omitempty"`    // for write_user, e.g. 0640
+	Op      Op       `json:"op,omitempty"`      // for privileged
+}
+
+// Recorder is an Executor that records what it is asked to do instead of doing it.
+// Respond, when set, supplies the output and error of each run and query; otherwise
+// every command succeeds with no output.
+type Recorder struct {
+	Respond func(ctx context.Context, s Step) ([]byte, error)
+
+	mu    sync.Mutex
+	steps []Step
+}
+
+// NewRecorder returns a Recorder for tests, optionally scripted through Respond
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// NewDryRun returns a Recorder that runs queries on the host for real, so a plan can
+// follow the decisions the code would take there, and records everything else.
+func NewDryRun() *Recorder {
+	return &Recorder{Respond: func(ctx context.Context, s Step) ([]byte, error) {
+		if s.Kind != StepQuery {
+			return nil, nil
+		}
+		return Real{}.Query(ctx, Cmd{Name: s.Args[0], Args: s.Args[1:], Dir: s.Dir, Env: s.Env})
+	}}
+}
+
+// Steps returns the recorded steps in order
+func (r *Recorder) Steps() []Step {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Step(nil), r.steps...)
+}
+
+// Changes returns the recorded steps that would change the host (everything but queries)
+func (r *Recorder) Changes() []Step {
+	var changes []Step
+	for _, s := range r.Steps() {
+		if s.Kind != StepQuery {
+			changes = append(changes, s)
+		}
+	}
+	return changes
+}
+
+func (r *Recorder) Run(ctx context.Context, c Cmd) ([]byte, error) {
+	return r.record(ctx, c.step(StepRun))
+}
+
+func (r *Recorder) Query(ctx context.Context, c Cmd) ([]byte, error) {
+	return r.record(ctx, c.step(StepQuery))
+}
+
+func (r *Recorder) WriteFile(ctx context.Context, path string, data []byte) error {
+	_, err := r.record(ctx, Step{Kind: StepWrite, Path: path, Content: string(data)})
+	return err
+}
+
+func (r *Recorder) WriteUserFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	_, err := r.record(ctx, Step{Kind: StepWriteUser, Path: path, Content: string(data), Mode: fmt.Sprintf("%#o", perm)})
+	return err
+}
+
+// Privileged records op and then the steps it takes, so a plan shows both
+func (r *Recorder) Privileged(ctx context.Context, op Op) error {
+	if _, err := r.record(ctx, Step{Kind: StepPrivileged, Command: op.Kind(), Op: op}); err != nil {
+		return err
+	}
+	if err := op.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", op.Kind(), err)
+	}
+	return op.Apply(WithExecutor(ctx, r))
+}
+
+func (r *Recorder) record(ctx context.Context, s Step) ([]byte, error) {
+	r.mu.Lock()
+	r.steps = append(r.steps, s)
+	r.mu.Unlock()
+
+	if r.Respond == nil {
+		return nil, nil
+	}
+	return r.Respond(ctx, s)
+}
+
+func (c Cmd) step(kind string) Step {
+	return Step{
+		Kind:    kind,
+		Command: c.String(),
+		Args:    append([]string{c.Name}, c.Args...),
+		Dir:     c.Dir,
+		Env:     c.Env,
+	}
+}
@@ -0,0 +1,207 @@
+// Package systemd renders the service units of the applications the panel runs behind
+// nginx. Units are rendered from fields, never taken as text, so the broker can check
+// what it installs: an application never runs as root or outside its project.
+package systemd
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"escape": escape,
+}).ParseFS(templateFS, "templates/*.tmpl"))
+
+// Restart policies of a unit
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNo        = "no"
+)
+
+const (
+	// maxCommandSize bounds the command line of a unit
+	maxCommandSize = 4 << 10
+	// maxEnv bounds the environment variables of a unit
+	maxEnv = 100
+	// maxEnvValueSize bounds one environment value
+	maxEnvValueSize = 4 << 10
+	// commandPrefixes are the characters systemd takes as flags at the start of ExecStart
+	commandPrefixes = "+!@-:"
+)
+
+var (
+	// domainPattern matches a host name of dot separated labels
+	domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	// accountPattern matches system user names
+	accountPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	// envNamePattern matches an environment variable name
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Unit describes the service of the application of a site
+type Unit struct {
+	Domain     string
+	Command    string            // command line started in WorkingDir, e.g. /usr/bin/node server.js
+	WorkingDir string            // absolute path
+	User       string            // the account the application runs as; never root
+	Env        map[string]string // PORT or SOCKET is set by the unit
+	Restart    string            // one of the Restart* policies, RestartOnFailure when empty
+	Port       int               // local port the application listens on, passed as PORT
+	// Socket, instead of Port, has the application listen on the unix socket
+	// SocketPath(Domain), passed as SOCKET. systemd creates its folder on start.
+	Socket bool
+}
+
+// UnitName returns the name of the service of the application of domain
+func UnitName(domain string) string {
+	return "vpanel-app-" + strings.ToLower(domain) + ".service"
+}
+
+// runtimeDir returns the folder systemd creates in the runtime directory (layout.Run) for
+// the service of domain
+func runtimeDir(domain string) string {
+	return "vpanel-app-" + strings.ToLower(domain)
+}
+
+// SocketPath returns the unix socket the application of domain listens on when its unit
+// has Socket set. nginx connects to it, so the folder is world readable.
+func SocketPath(domain string) string {
+	return layout.Run(runtimeDir(domain), "app.sock")
+}
+
+// Name returns the name of the unit's service
+func (u Unit) Name() string {
+	return UnitName(u.Domain)
+}
+
+// ValidateDomain checks domain can name a unit
+func ValidateDomain(domain string) error {
+	if len(domain) > 253 || !domainPattern.MatchString(domain) {
+		return fmt.Errorf("invalid domain %q", domain)
+	}
+	return nil
+}
+
+// Validate checks the unit can be rendered into a unit file of a single service
+func (u Unit) Validate() error {
+	if err := ValidateDomain(u.Domain); err != nil {
+		return err
+	}
+	if strings.TrimSpace(u.Command) == "" {
+		return errors.New("command is required")
+	}
+	if len(u.Command) > maxCommandSize {
+		return fmt.Errorf("command is longer than %d bytes", maxCommandSize)
+	}
+	if strings.ContainsAny(u.Command, "\r\n") {
+		return errors.New("command must be a single line")
+	}
+	// systemd reads a leading +, !, !!, @, - or : as a flag changing how the command runs,
+	// + even running it as root whatever User= says
+	command := strings.TrimSpace(u.Command)
+	if strings.ContainsAny(command[:1], commandPrefixes) {
+		return fmt.Errorf("command may not start with any of %s", commandPrefixes)
+	}
+	if exe := strings.Fields(command)[0]; !filepath.IsAbs(exe) {
+		return fmt.Errorf("the program %q must be an absolute path, e.g. /usr/bin/node", exe)
+	}
+	if !filepath.IsAbs(u.WorkingDir) || filepath.Clean(u.WorkingDir) != u.WorkingDir || u.WorkingDir == "/" {
+		return fmt.Errorf("invalid working directory %q", u.WorkingDir)
+	}
+	if strings.ContainsAny(u.WorkingDir, " \t\r\n\"'\\%") {
+		return fmt.Errorf("invalid working directory %q", u.WorkingDir)
+	}
+	if !accountPattern.MatchString(u.User) {
+		return fmt.Errorf("invalid user %q", u.User)
+	}
+	if u.User == "root" {
+		return errors.New("an application cannot run as root")
+	}
+	switch u.Restart {
+	case "", RestartAlways, RestartOnFailure, RestartNo:
+	default:
+		return fmt.Errorf("invalid restart policy %q (use %s, %s or %s)", u.Restart, RestartAlways, RestartOnFailure, RestartNo)
+	}
+	if u.Socket {
+		if u.Port != 0 {
+			return errors.New("an application listens on a port or a socket, not both")
+		}
+	} else if u.Port < 1024 || u.Port > 65535 {
+		return fmt.Errorf("invalid port %d", u.Port)
+	}
+	if len(u.Env) > maxEnv {
+		return fmt.Errorf("at most %d environment variables are allowed", maxEnv)
+	}
+	for name, value := range u.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if name == "PORT" || name == "SOCKET" {
+			return fmt.Errorf("%s is set by the panel", name)
+		}
+		if len(value) > maxEnvValueSize {
+			return fmt.Errorf("environment variable %s is longer than %d bytes", name, maxEnvValueSize)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("environment variable %s must be a single line", name)
+		}
+	}
+	return nil
+}
+
+// Render returns the unit file of u
+func Render(u Unit) ([]byte, error) {
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	if u.Restart == "" {
+		u.Restart = RestartOnFailure
+	}
+	data := struct {
+		Unit
+		Name       string
+		RuntimeDir string
+		SocketPath string
+		EnvLines   []string
+	}{u, u.Name(), runtimeDir(u.Domain), SocketPath(u.Domain), envLines(u.Env)}
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "app.service.tmpl", data); err != nil {
+		return nil, fmt.Errorf("render unit of %s: %w", u.Domain, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// envLines returns the quoted assignments of env, sorted by name so a unit always
+// renders to the same bytes
+func envLines(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		v := strings.ReplaceAll(env[name], `\`, `\\`)
+		v = strings.ReplaceAll(v, `"`, `\"`)
+		lines = append(lines, `"`+escape(name+"="+v)+`"`)
+	}
+	return lines
+}
+
+// escape doubles the % of v, which systemd would otherwise expand as a specifier
+func escape(v string) string {
+	return strings.ReplaceAll(v, "%", "%%")
+}
@@ -0,0 +1,28 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// ProjectUserPrefix starts the name of every project's system user
+const ProjectUserPrefix = "vp-"
+
+// projectUserPattern matches the names ProjectUser returns
+var projectUserPattern = regexp.MustCompile(`^` + ProjectUserPrefix + `[0-9a-f]{12}$`)
+
+// ProjectUser returns the system user the project of domain runs as. Every project has
+// its own, so a compromised site can read neither the panel's files nor, unless they are
+// world readable, another site's. The name is derived from the domain, as domains are
+// longer than an account name may be.
+func ProjectUser(domain string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(domain)))
+	return ProjectUserPrefix + hex.EncodeToString(sum[:])[:12]
+}
+
+// IsProjectUser reports whether name is a project's system user
+func IsProjectUser(name string) bool {
+	return projectUserPattern.MatchString(name)
+}
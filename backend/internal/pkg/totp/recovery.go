@@ -0,0 +1,45 @@
+package totp
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued when TOTP is enabled
+const RecoveryCodeCount = 10
+
+// recovery code alphabet without look-alike characters (0/O, 1/I/L)
+const recoveryAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// GenerateRecoveryCodes returns n random codes formatted as XXXXX-XXXXX
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	buf := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		for j, b := range buf {
+			if j == 5 {
+				sb.WriteByte('-')
+			}
+			// 256 is not a multiple of 31; the slight bias is irrelevant for ~50-bit codes
+			sb.WriteByte(recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+		codes[i] = sb.String()
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode uppercases a user-typed code and drops separators and spaces,
+// so "abcde-fghjk" and "ABCDE FGHJK" hash to the same value
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
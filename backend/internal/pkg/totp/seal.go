@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeySize is the size of the key secrets are encrypted with at rest (AES-256)
+const KeySize = 32
+
+// sealPrefix marks an encrypted secret; secrets stored before encryption have none
+const sealPrefix = "v1:"
+
+// ErrSealedSecret is returned when a stored secret cannot be decrypted: it was sealed with
+// another key or for another user, or it was tampered with
+var ErrSealedSecret = errors.New("cannot decrypt the TOTP secret")
+
+// ParseKey decodes a base64 encoded key of KeySize bytes, e.g. from `openssl rand -base64 32`
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("the TOTP encryption key must be %d bytes, base64 encoded", KeySize)
+	}
+	return key, nil
+}
+
+// Sealer encrypts secrets for storage with AES-GCM. The key lives in the configuration, not
+// in the database, so a leaked database does not give away the secrets.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer returns a Sealer using key, of KeySize bytes
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the TOTP encryption key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts the secret of user userID. The user is bound to the result, so a sealed
+// secret copied to another user does not open.
+func (s *Sealer) Seal(userID int64, secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), userData(userID))
+	return sealPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed for user userID
+func (s *Sealer) Open(userID int64, stored string) (string, error) {
+	if !IsSealed(stored) {
+		return "", ErrSealedSecret
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealPrefix))
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", ErrSealedSecret
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	secret, err := s.aead.Open(nil, nonce, ciphertext, userData(userID))
+	if err != nil {
+		return "", ErrSealedSecret
+	}
+	return string(secret), nil
+}
+
+// IsSealed reports whether a stored secret is encrypted
+func IsSealed(stored string) bool {
+	return strings.HasPrefix(stored, sealPrefix)
+}
+
+func userData(userID int64) []byte {
+	return []byte("totp:" + strconv.FormatInt(userID, 10))
+}
@@ -0,0 +1,124 @@
+// Package totp implements RFC 6238 time-based one-time passwords (HMAC-SHA1, 6 digits, 30s step)
+// as used by Google Authenticator, Authy and similar apps, plus single-use recovery codes.
+// Nothing here touches the network or the database, so every function is deterministic for a given time.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits     = 6
+	Period     = 30 // seconds per step
+	SecretSize = 20 // bytes, the RFC 4226 recommended key length for SHA-1
+
+	// DefaultSkew accepts codes from one step before and after the current one to absorb clock drift
+	DefaultSkew = 1
+)
+
+// ErrInvalidSecret is returned when a secret is not valid base32
+var ErrInvalidSecret = errors.New("invalid TOTP secret")
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded without padding
+func GenerateSecret() (string, error) {
+	key := make([]byte, SecretSize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(key), nil
+}
+
+// Step returns the RFC 6238 time step counter for t
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for the step containing t
+func Code(secret string, t time.Time) (string, error) {
+	return codeAt(secret, Step(t))
+}
+
+// Validate checks code against the steps within skew of t.
+// It returns the matching step so callers can refuse a step that was already used.
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		expected, err := codeAt(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth:// URI that authenticator apps read from a QR code
+func ProvisioningURI(secret, issuer, account string) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	if issuer != "" {
+		params.Set("issuer", issuer)
+	}
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// codeAt computes the HOTP value (RFC 4226) for a counter
+func codeAt(secret string, counter int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// decodeSecret accepts secrets with or without padding, in any case and with spaces, as users type them
+func decodeSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	s = strings.TrimRight(s, "=")
+	key, err := b32.DecodeString(s)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
@@ -0,0 +1,212 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// Appendix B lists 8 digit codes; 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestSecretAsTyped(t *testing.T) {
+	at := time.Unix(59, 0)
+	for _, secret := range []string{
+		strings.ToLower(rfcSecret),
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ",
+		base32.StdEncoding.EncodeToString([]byte("12345678901234567890")), // padded
+	} {
+		if got, err := Code(secret, at); err != nil || got != "287082" {
+			t.Errorf("Code(%q) = %s, %v, want 287082", secret, got, err)
+		}
+	}
+	for _, secret := range []string{"", "not base32!", "===="} {
+		if _, err := Code(secret, at); err != ErrInvalidSecret {
+			t.Errorf("Code(%q) error = %v, want ErrInvalidSecret", secret, err)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+	codeAtStep := func(step int64) string {
+		code, err := codeAt(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name   string
+		offset int64 // steps from the current one
+		skew   int
+		ok     bool
+	}{
+		{"current step", 0, DefaultSkew, true},
+		{"one step early", -1, DefaultSkew, true},
+		{"one step late", 1, DefaultSkew, true},
+		{"two steps early", -2, DefaultSkew, false},
+		{"two steps late", 2, DefaultSkew, false},
+		{"no skew, current", 0, 0, true},
+		{"no skew, one step early", -1, 0, false},
+		{"wider skew", -2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := current + tt.offset
+			step, ok := Validate(rfcSecret, codeAtStep(want), now, tt.skew)
+			if ok != tt.ok {
+				t.Fatalf("Validate ok = %v, want %v", ok, tt.ok)
+			}
+			// the matching step is returned so the caller can refuse it the next time
+			if ok && step != want {
+				t.Errorf("Validate step = %d, want %d", step, want)
+			}
+		})
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := Code(rfcSecret, now)
+	for _, bad := range []string{"", "12345", "1234567", "abcdef", code + "0"} {
+		if _, ok := Validate(rfcSecret, bad, now, DefaultSkew); ok {
+			t.Errorf("Validate(%q) accepted", bad)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " "+code+" ", now, DefaultSkew); !ok {
+		t.Error("Validate refused a code with surrounding spaces")
+	}
+	if _, ok := Validate("not base32!", code, now, DefaultSkew); ok {
+		t.Error("Validate accepted a code for an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	key, err := decodeSecret(a)
+	if err != nil || len(key) != SecretSize {
+		t.Errorf("secret %q decodes to %d bytes, %v", a, len(key), err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("%d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q is not XXXXX-XXXXX", code)
+		}
+		if strings.Trim(strings.ReplaceAll(code, "-", ""), recoveryAlphabet) != "" {
+			t.Errorf("code %q uses characters outside the alphabet", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q issued twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	// every way a user may type a code hashes the same, so it is used up whatever the spelling
+	for _, typed := range []string{"ABCDE-FGHJK", "abcde-fghjk", "ABCDE FGHJK", " abcdefghjk "} {
+		if got := NormalizeRecoveryCode(typed); got != "ABCDEFGHJK" {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", typed, got)
+		}
+	}
+}
+
+func TestSealer(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	s, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := s.Seal(42, rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, rfcSecret) {
+		t.Fatalf("sealed secret %q is not encrypted", sealed)
+	}
+	if again, _ := s.Seal(42, rfcSecret); again == sealed {
+		t.Error("sealing twice gives the same value")
+	}
+	if got, err := s.Open(42, sealed); err != nil || got != rfcSecret {
+		t.Errorf("Open = %q, %v, want the secret", got, err)
+	}
+
+	// bound to the user and the key, and tamper evident
+	if _, err := s.Open(43, sealed); err != ErrSealedSecret {
+		t.Errorf("Open for another user: %v, want ErrSealedSecret", err)
+	}
+	other, _ := NewSealer(bytes.Repeat([]byte{8}, KeySize))
+	if _, err := other.Open(42, sealed); err != ErrSealedSecret {
+		t.Errorf("Open with another key: %v, want ErrSealedSecret", err)
+	}
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+	if _, err := s.Open(42, tampered); err != ErrSealedSecret {
+		t.Errorf("Open of a tampered secret: %v, want ErrSealedSecret", err)
+	}
+	if _, err := s.Open(42, rfcSecret); err != ErrSealedSecret {
+		t.Errorf("Open of a plaintext secret: %v, want ErrSealedSecret", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="); err != nil {
+		t.Errorf("ParseKey of a 32 byte key: %v", err)
+	}
+	for _, bad := range []string{"", "short", "AAAAAAAAAAAAAAAAAAAAAA==", "not base64 at all!"} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Errorf("ParseKey(%q) accepted", bad)
+		}
+	}
+	if _, err := NewSealer(make([]byte, 16)); err == nil {
+		t.Error("NewSealer accepted a 16 byte key")
+	}
+}
@@ -0,0 +1,190 @@
+// Package drift compares what nginx actually serves (the vhosts in sites-enabled) with
+// the projects and domains the panel knows about.
+package drift
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+)
+
+// Report is the result of comparing sites-enabled with the panel's projects and domains
+type Report struct {
+	ScannedAt  time.Time             `json:"scannedAt"`
+	Directory  string                `json:"directory"`
+	Configs    []nginx.EnabledConfig `json:"configs"`
+	Orphaned   []Orphan              `json:"orphanedConfigs"`
+	Missing    []MissingConfig       `json:"projectsMissingConfig"`
+	Broken     []BrokenLink          `json:"brokenSymlinks"`
+	Collisions []Collision           `json:"serverNameCollisions"`
+}
+
+// Orphan is an enabled config none of whose server names is a project or a domain
+type Orphan struct {
+	Config      string   `json:"config"`
+	ServerNames []string `json:"serverNames"`
+}
+
+// MissingConfig is a live project whose domain no enabled server block serves
+type MissingConfig struct {
+	ProjectID   int64  `json:"projectId"`
+	ProjectName string `json:"projectName"`
+	Domain      string `json:"domain"`
+	Status      string `json:"status"`
+}
+
+// BrokenLink is a symlink in sites-enabled to a file that does not exist
+type BrokenLink struct {
+	Config string `json:"config"`
+	Target string `json:"target"`
+}
+
+// Collision is a server name claimed by more than one server block on the same address;
+// nginx only uses the first of them
+type Collision struct {
+	ServerName string   `json:"serverName"`
+	Listen     string   `json:"listen"`
+	Servers    []string `json:"servers"` // config:line of each server block
+}
+
+// liveStatuses are the project statuses that need an enabled vhost
+var liveStatuses = map[string]bool{
+	models.ProjectStatusRunning:   true,
+	models.ProjectStatusSuspended: true,
+}
+
+// Build compares the scanned configs with projects and domains
+func Build(dir string, configs []nginx.EnabledConfig, projects []*models.Project, domains []*models.Domain) *Report {
+	r := &Report{
+		ScannedAt:  time.Now(),
+		Directory:  dir,
+		Configs:    configs,
+		Orphaned:   []Orphan{},
+		Missing:    []MissingConfig{},
+		Broken:     []BrokenLink{},
+		Collisions: []Collision{},
+	}
+
+	known := map[string]bool{}
+	for _, p := range projects {
+		addHost(known, p.DomainName)
+	}
+	for _, d := range domains {
+		addHost(known, d.Domain)
+	}
+
+	served := map[string]bool{}
+	claims := map[string][]string{} // server name + listen address -> server blocks
+	for _, c := range configs {
+		if c.Broken {
+			r.Broken = append(r.Broken, BrokenLink{Config: c.Name, Target: c.Target})
+			continue
+		}
+
+		var names []string
+		matched, catchAll := false, true
+		for _, s := range c.Servers {
+			for _, name := range s.Names {
+				name = strings.ToLower(name)
+				if !isHost(name) {
+					continue
+				}
+				catchAll = false
+				names = appendUnique(names, name)
+				served[name] = true
+				if known[name] {
+					matched = true
+				}
+				for _, listen := range s.Listen {
+					key := name + " " + listenAddress(listen)
+					claims[key] = append(claims[key], fmt.Sprintf("%s:%d", c.Name, s.Line))
+				}
+			}
+		}
+		// a config that could not be read or has only a default server is not an orphan
+		if c.ParseError == "" && !catchAll && !matched {
+			r.Orphaned = append(r.Orphaned, Orphan{Config: c.Name, ServerNames: names})
+		}
+	}
+
+	for _, p := range projects {
+		domain := strings.ToLower(strings.TrimSpace(p.DomainName))
+		if domain == "" || !liveStatuses[p.Status] || served[domain] {
+			continue
+		}
+		r.Missing = append(r.Missing, MissingConfig{
+			ProjectID:   p.ID,
+			ProjectName: p.ProjectName,
+			Domain:      p.DomainName,
+			Status:      p.Status,
+		})
+	}
+
+	for key, servers := range claims {
+		if len(servers) < 2 {
+			continue
+		}
+		name, listen, _ := strings.Cut(key, " ")
+		r.Collisions = append(r.Collisions, Collision{ServerName: name, Listen: listen, Servers: servers})
+	}
+	sort.Slice(r.Collisions, func(i, j int) bool {
+		if r.Collisions[i].ServerName != r.Collisions[j].ServerName {
+			return r.Collisions[i].ServerName < r.Collisions[j].ServerName
+		}
+		return r.Collisions[i].Listen < r.Collisions[j].Listen
+	})
+	return r
+}
+
+// addHost marks domain and its www alias as known
+func addHost(known map[string]bool, domain string) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return
+	}
+	known[domain] = true
+	known["www."+domain] = true
+}
+
+// isHost reports whether a server_name value names a host, rather than being the
+// catch-all "_", a wildcard or a regular expression
+func isHost(name string) bool {
+	return name != "" && name != "_" && !strings.ContainsAny(name, "*~")
+}
+
+// listenAddress returns the address:port a listen directive binds, so "80" and
+// "0.0.0.0:80" compare equal
+func listenAddress(listen string) string {
+	addr, _, _ := strings.Cut(listen, " ")
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return addr
+	case strings.HasPrefix(addr, "["):
+		if !strings.Contains(addr, "]:") {
+			return addr + ":80"
+		}
+		return addr
+	case strings.Contains(addr, ":"):
+		host, port, _ := strings.Cut(addr, ":")
+		if host == "0.0.0.0" {
+			host = "*"
+		}
+		return host + ":" + port
+	case strings.Trim(addr, "0123456789") == "":
+		return "*:" + addr
+	}
+	return addr + ":80"
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, item := range list {
+		if item == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
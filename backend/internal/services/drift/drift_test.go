@@ -0,0 +1,121 @@
+package drift
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/nginx"
+)
+
+func config(name string, servers ...nginx.Server) nginx.EnabledConfig {
+	return nginx.EnabledConfig{Name: name, Path: "/etc/nginx/sites-enabled/" + name, Servers: servers}
+}
+
+func server(line int, listen []string, names ...string) nginx.Server {
+	return nginx.Server{Line: line, Names: names, Listen: listen}
+}
+
+func project(id int64, domain, status string) *models.Project {
+	return &models.Project{ID: id, ProjectName: domain, DomainName: domain, Status: status}
+}
+
+func TestOrphanedConfigs(t *testing.T) {
+	configs := []nginx.EnabledConfig{
+		config("example.com.conf", server(1, []string{"80"}, "example.com", "www.example.com")),
+		config("upper.conf", server(1, []string{"80"}, "WWW.Upper.COM")),
+		config("parked.conf", server(1, []string{"80"}, "parked.com")),
+		config("old.conf", server(1, []string{"80"}, "old.com", "www.old.com"), server(9, []string{"443 ssl"}, "old.com")),
+		config("default", server(1, []string{"80 default_server"}, "_")),
+		config("wildcard.conf", server(1, []string{"80"}, "*.example.org", "~^(?<sub>.+)\\.example\\.net$")),
+		{Name: "broken.conf", ParseError: "line 3: unexpected end of file"},
+	}
+	projects := []*models.Project{
+		project(1, "Example.com", models.ProjectStatusRunning),
+		project(2, "upper.com", models.ProjectStatusRunning),
+	}
+	domains := []*models.Domain{{ID: 1, Domain: "parked.com"}}
+
+	r := Build("/etc/nginx/sites-enabled", configs, projects, domains)
+	want := []Orphan{{Config: "old.conf", ServerNames: []string{"old.com", "www.old.com"}}}
+	if !reflect.DeepEqual(r.Orphaned, want) {
+		t.Errorf("orphaned = %+v, want %+v", r.Orphaned, want)
+	}
+}
+
+func TestProjectsMissingConfig(t *testing.T) {
+	configs := []nginx.EnabledConfig{
+		config("served.com.conf", server(1, []string{"80"}, "Served.com")),
+		{Name: "gone.com.conf", Target: "/etc/nginx/sites-available/gone.com.conf", Broken: true, Servers: []nginx.Server{}},
+	}
+	projects := []*models.Project{
+		project(1, "served.com", models.ProjectStatusRunning),
+		project(2, "gone.com", models.ProjectStatusRunning),
+		project(3, "paused.com", models.ProjectStatusSuspended),
+		project(4, "new.com", models.ProjectStatusInit),
+		project(5, "closed.com", models.ProjectStatusClosed),
+		project(6, "", models.ProjectStatusRunning),
+	}
+
+	r := Build("/etc/nginx/sites-enabled", configs, projects, nil)
+	want := []MissingConfig{
+		{ProjectID: 2, ProjectName: "gone.com", Domain: "gone.com", Status: models.ProjectStatusRunning},
+		{ProjectID: 3, ProjectName: "paused.com", Domain: "paused.com", Status: models.ProjectStatusSuspended},
+	}
+	if !reflect.DeepEqual(r.Missing, want) {
+		t.Errorf("missing = %+v, want %+v", r.Missing, want)
+	}
+	wantBroken := []BrokenLink{{Config: "gone.com.conf", Target: "/etc/nginx/sites-available/gone.com.conf"}}
+	if !reflect.DeepEqual(r.Broken, wantBroken) {
+		t.Errorf("broken = %+v, want %+v", r.Broken, wantBroken)
+	}
+}
+
+func TestServerNameCollisions(t *testing.T) {
+	configs := []nginx.EnabledConfig{
+		config("a.conf",
+			server(1, []string{"80"}, "example.com"),
+			server(10, []string{"443 ssl"}, "example.com"),
+		),
+		config("b.conf",
+			server(1, []string{"0.0.0.0:80"}, "Example.com"),
+			server(8, []string{"[::]:443 ssl"}, "example.com"),
+			// other ports and addresses do not collide
+			server(15, []string{"8080"}, "example.com"),
+			server(20, []string{"127.0.0.1:80"}, "example.com"),
+		),
+		config("c.conf", server(1, []string{"*:80"}, "example.com", "other.com")),
+		// catch-all names are expected to repeat
+		config("default", server(1, []string{"80 default_server"}, "_")),
+		config("default2", server(1, []string{"80"}, "_")),
+	}
+
+	r := Build("/etc/nginx/sites-enabled", configs, []*models.Project{project(1, "example.com", models.ProjectStatusRunning)}, nil)
+	want := []Collision{{ServerName: "example.com", Listen: "*:80", Servers: []string{"a.conf:1", "b.conf:1", "c.conf:1"}}}
+	if !reflect.DeepEqual(r.Collisions, want) {
+		t.Errorf("collisions = %+v, want %+v", r.Collisions, want)
+	}
+	if len(r.Orphaned) != 0 || len(r.Missing) != 0 {
+		t.Errorf("orphaned = %+v, missing = %+v", r.Orphaned, r.Missing)
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	tests := map[string]string{
+		"80":                   "*:80",
+		"80 default_server":    "*:80",
+		"*:80":                 "*:80",
+		"0.0.0.0:443 ssl":      "*:443",
+		"127.0.0.1:8080":       "127.0.0.1:8080",
+		"127.0.0.1":            "127.0.0.1:80",
+		"localhost":            "localhost:80",
+		"[::]:443 ssl":         "[::]:443",
+		"[::1]":                "[::1]:80",
+		"unix:/run/nginx.sock": "unix:/run/nginx.sock",
+	}
+	for listen, want := range tests {
+		if got := listenAddress(listen); got != want {
+			t.Errorf("listenAddress(%q) = %q, want %q", listen, got, want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+// Package jobs runs long operations (deployments, certificate issuance) on background
+// workers. Jobs are stored in Postgres, so they outlive the HTTP request that queued
+// them and are recovered when the panel restarts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/sitelock"
+)
+
+// pollInterval is how often idle workers look for jobs queued by another panel process
+const pollInterval = 2 * time.Second
+
+// ErrCancelled is the cause of a job context cancelled through Cancel
+var ErrCancelled = errors.New("job cancelled")
+
+// ErrNotCancellable is returned by Cancel for a job that is neither queued nor running
+var ErrNotCancellable = errors.New("only queued or running jobs can be cancelled")
+
+// Store persists jobs
+type Store interface {
+	EnqueueJob(ctx context.Context, j *models.Job) error
+	ClaimNextJob(ctx context.Context, workerID string) (*models.Job, error)
+	FinishJob(ctx context.Context, id int64, status string, result []byte, errMsg string) error
+	RecoverJobs(ctx context.Context, resumableTypes []string) (int64, []*models.Job, error)
+	CancelQueuedJob(ctx context.Context, id int64, reason string) (bool, error)
+}
+
+// Handler runs one type of job
+type Handler struct {
+	// Run does the work. Its result is stored as the job result (JSON).
+	Run func(ctx context.Context, job *models.Job) (any, error)
+
+	// Resumable jobs left running by a stopped panel are queued again, up to MaxAttempts runs.
+	// Only set it for operations that are safe to repeat from the start.
+	Resumable   bool
+	MaxAttempts int
+
+	// Interrupted is called on startup for a job that was running when the panel stopped
+	// and will not be resumed, e.g. to flag the project it was deploying. Optional.
+	Interrupted func(ctx context.Context, job *models.Job)
+}
+
+// LogID names the operation log holding the output of a job
+func LogID(jobID int64) string {
+	return fmt.Sprintf("job-%d", jobID)
+}
+
+// Queue dispatches stored jobs to registered handlers
+type Queue struct {
+	store    Store
+	logs     *oplog.Store
+	locks    *sitelock.Manager
+	handlers map[string]Handler
+	workerID string
+	wake     chan struct{}
+
+	stop  context.CancelFunc // stops claiming new jobs
+	abort context.CancelFunc // cancels running jobs
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[int64]context.CancelCauseFunc // jobs running on this process
+
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func New(store Store, logs *oplog.Store, locks *sitelock.Manager, infoLog, errorLog *log.Logger) *Queue {
+	host, _ := os.Hostname()
+	return &Queue{
+		store:    store,
+		logs:     logs,
+		locks:    locks,
+		running:  map[int64]context.CancelCauseFunc{},
+		handlers: map[string]Handler{},
+		workerID: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		wake:     make(chan struct{}, 1),
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+// Logs returns the store holding the output logs of jobs
+func (q *Queue) Logs() *oplog.Store {
+	return q.logs
+}
+
+// Locks returns the site locks jobs take on their target
+func (q *Queue) Locks() *sitelock.Manager {
+	return q.locks
+}
+
+// Register sets the handler for jobType. It must be called before Start.
+func (q *Queue) Register(jobType string, h Handler) {
+	if h.MaxAttempts <= 0 {
+		h.MaxAttempts = 1
+	}
+	q.handlers[jobType] = h
+}
+
+// Enqueue stores a job of jobType for target with payload (marshalled to JSON)
+// and wakes an idle worker. requestedBy is the panel user who asked for it, if any.
+// Only one job per target may be queued or running; otherwise dbrepo.ErrJobTargetBusy is returned.
+func (q *Queue) Enqueue(ctx context.Context, jobType, target string, payload any, requestedBy *int64) (*models.Job, error) {
+	h, ok := q.handlers[jobType]
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode job payload: %w", err)
+	}
+
+	job := &models.Job{
+		Type:        jobType,
+		Target:      target,
+		Payload:     raw,
+		RequestedBy: requestedBy,
+		MaxAttempts: h.MaxAttempts,
+	}
+	if err := q.store.EnqueueJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start recovers jobs left running by a previous run and starts the workers
+func (q *Queue) Start(workers int) error {
+	var resumable []string
+	for t, h := range q.handlers {
+		if h.Resumable {
+			resumable = append(resumable, t)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	requeued, interrupted, err := q.store.RecoverJobs(ctx, resumable)
+	if err != nil {
+		return fmt.Errorf("recover jobs: %w", err)
+	}
+	if requeued > 0 {
+		q.infoLog.Printf("Jobs: resumed %d job(s) interrupted by the last shutdown", requeued)
+	}
+	for _, job := range interrupted {
+		q.errorLog.Printf("Jobs: job %d (%s %s) was interrupted by the last shutdown", job.ID, job.Type, job.Target)
+		if h, ok := q.handlers[job.Type]; ok && h.Interrupted != nil {
+			h.Interrupted(ctx, job)
+		}
+	}
+
+	var stopCtx, abortCtx context.Context
+	stopCtx, q.stop = context.WithCancel(context.Background())
+	abortCtx, q.abort = context.WithCancel(context.Background())
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work(stopCtx, abortCtx, fmt.Sprintf("%s-%d", q.workerID, i+1))
+	}
+	return nil
+}
+
+// Cancel stops job id: a queued job is cancelled straight away, a running one has its
+// context cancelled, which stops the command it is running. by names who asked.
+func (q *Queue) Cancel(ctx context.Context, id int64, by string) error {
+	reason := fmt.Errorf("%w by %s", ErrCancelled, by)
+	if q.cancelRunning(id, reason) {
+		return nil
+	}
+
+	cancelled, err := q.store.CancelQueuedJob(ctx, id, reason.Error())
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		return nil
+	}
+	// a worker may have claimed it in the meantime
+	if q.cancelRunning(id, reason) {
+		return nil
+	}
+	return ErrNotCancellable
+}
+
+func (q *Queue) cancelRunning(id int64, reason error) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cancel, ok := q.running[id]
+	if ok {
+		cancel(reason)
+	}
+	return ok
+}
+
+// Stop stops claiming jobs and waits up to timeout for running jobs to finish.
+// Jobs still running after that are cancelled and left for recovery on the next start.
+func (q *Queue) Stop(timeout time.Duration) {
+	if q.stop == nil {
+		return
+	}
+	q.stop()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		q.errorLog.Println("Jobs: running jobs did not finish in time, cancelling them")
+		q.abort()
+		<-done
+	}
+	q.abort()
+}
+
+func (q *Queue) work(stopCtx, abortCtx context.Context, workerID string) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		// drain the queue before waiting again
+		for stopCtx.Err() == nil {
+			job, err := q.store.ClaimNextJob(stopCtx, workerID)
+			if err != nil {
+				if stopCtx.Err() == nil {
+					q.errorLog.Println("Jobs: failed to claim job:", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.run(abortCtx, job)
+		}
+
+		select {
+		case <-stopCtx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// run executes one claimed job and stores its outcome.
+// Output of the commands it runs is captured into the job's operation log.
+func (q *Queue) run(abortCtx context.Context, job *models.Job) {
+	start := time.Now()
+	q.infoLog.Printf("Jobs: started job %d (%s %s), attempt %d", job.ID, job.Type, job.Target, job.Attempts)
+
+	ctx, cancel := context.WithCancelCause(abortCtx)
+	defer cancel(nil)
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, job.ID)
+		q.mu.Unlock()
+	}()
+
+	l, err := q.logs.Create(LogID(job.ID))
+	if err != nil {
+		q.errorLog.Printf("Jobs: no output log for job %d: %v", job.ID, err)
+	} else {
+		defer l.Close()
+		ctx = oplog.WithLog(ctx, l)
+		l.Printf("Job %d (%s %s) started, attempt %d of %d", job.ID, job.Type, job.Target, job.Attempts, job.MaxAttempts)
+	}
+
+	result, err := q.lockAndCall(ctx, job)
+	if abortCtx.Err() != nil {
+		// cancelled by shutdown; the row stays running and is recovered on the next start
+		q.errorLog.Printf("Jobs: job %d cancelled by shutdown", job.ID)
+		oplog.Printf(ctx, "Job cancelled: the panel is shutting down")
+		return
+	}
+
+	status := models.JobStatusSucceeded
+	errMsg := ""
+	if cause := context.Cause(ctx); errors.Is(cause, ErrCancelled) {
+		status = models.JobStatusCancelled
+		errMsg = cause.Error()
+		q.infoLog.Printf("Jobs: job %d (%s %s) %v after %s", job.ID, job.Type, job.Target, cause, time.Since(start).Round(time.Second))
+		oplog.Printf(ctx, "Job %v after %s", cause, time.Since(start).Round(time.Second))
+	} else if err != nil {
+		status = models.JobStatusFailed
+		errMsg = err.Error()
+		q.errorLog.Printf("Jobs: job %d (%s %s) failed after %s: %v", job.ID, job.Type, job.Target, time.Since(start).Round(time.Second), err)
+		oplog.Printf(ctx, "Job failed after %s: %v", time.Since(start).Round(time.Second), err)
+	} else {
+		q.infoLog.Printf("Jobs: job %d (%s %s) succeeded in %s", job.ID, job.Type, job.Target, time.Since(start).Round(time.Second))
+		oplog.Printf(ctx, "Job succeeded in %s", time.Since(start).Round(time.Second))
+	}
+
+	var raw []byte
+	if result != nil {
+		if raw, err = json.Marshal(result); err != nil {
+			q.errorLog.Printf("Jobs: failed to encode result of job %d: %v", job.ID, err)
+			raw = nil
+		}
+	}
+
+	// the job context may be cancelled right after Run returns; the outcome must still be written
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer saveCancel()
+	if err := q.store.FinishJob(saveCtx, job.ID, status, raw, errMsg); err != nil {
+		q.errorLog.Printf("Jobs: failed to save outcome of job %d: %v", job.ID, err)
+	}
+}
+
+// lockAndCall takes the site lock of the job target (waiting for a quick operation such as
+// a suspend to finish first) and runs the handler
+func (q *Queue) lockAndCall(ctx context.Context, job *models.Job) (any, error) {
+	if job.Target != "" {
+		holder := fmt.Sprintf("job %d (%s)", job.ID, job.Type)
+		unlock, err := q.locks.TryLock(job.Target, holder)
+		var busy *sitelock.BusyError
+		if errors.As(err, &busy) {
+			oplog.Printf(ctx, "Waiting for %s on %s to finish...", busy.Holder, busy.Key)
+			unlock, err = q.locks.Lock(ctx, job.Target, holder)
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	return q.call(ctx, job)
+}
+
+// call runs the handler, turning a panic into a job failure
+func (q *Queue) call(ctx context.Context, job *models.Job) (result any, err error) {
+	h, ok := q.handlers[job.Type]
+	if !ok {
+		return nil, fmt.Errorf("no handler registered for job type %q", job.Type)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			q.errorLog.Printf("Jobs: job %d panicked: %v\n%s", job.ID, p, debug.Stack())
+			err = errors.New("internal error while running the job")
+		}
+	}()
+	return h.Run(ctx, job)
+}
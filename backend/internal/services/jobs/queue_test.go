@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/projuktisheba/vpanel/backend/internal/dbrepo"
+	"github.com/projuktisheba/vpanel/backend/internal/models"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/oplog"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/sitelock"
+)
+
+var discard = log.New(io.Discard, "", 0)
+
+// testPool connects to the database of VPANEL_TEST_DB_DSN, in a schema of its own holding
+// the tables of migrations, dropped when the test ends. The test is skipped without it.
+func testPool(t *testing.T, migrations ...string) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("VPANEL_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("VPANEL_TEST_DB_DSN is not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("vpanel_test_%d", time.Now().UnixNano())
+
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.Close)
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE") })
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	for _, name := range migrations {
+		sql, err := os.ReadFile(filepath.Join("..", "..", "..", "migrations", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, string(sql)); err != nil {
+			t.Fatalf("migration %s: %v", name, err)
+		}
+	}
+	return pool
+}
+
+// testQueues returns a job store and n queues sharing it, as panel processes sharing a
+// database would
+func testQueues(t *testing.T, n int) (*dbrepo.JobRepo, []*Queue) {
+	t.Helper()
+	repo := dbrepo.NewJobRepo(testPool(t, "001_users.sql", "012_jobs.sql", "013_job_cancel.sql"))
+	queues := make([]*Queue, n)
+	for i := range queues {
+		queues[i] = New(repo, oplog.NewStore(t.TempDir()), sitelock.New(), discard, discard)
+		queues[i].workerID = fmt.Sprintf("test-%d", i)
+	}
+	return repo, queues
+}
+
+// start starts the workers of queues and stops them when the test ends
+func start(t *testing.T, queues []*Queue, workers int) {
+	t.Helper()
+	for _, q := range queues {
+		q := q
+		if err := q.Start(workers); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { q.Stop(5 * time.Second) })
+	}
+}
+
+// waitFinished waits for job id to leave the queued and running states and returns it
+func waitFinished(t *testing.T, repo *dbrepo.JobRepo, id int64) *models.Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		job, err := repo.GetJob(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != models.JobStatusQueued && job.Status != models.JobStatusRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d still %s", id, job.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCallReleasesLock(t *testing.T) {
+	q := New(nil, oplog.NewStore(t.TempDir()), sitelock.New(), discard, discard)
+	q.Register("panic", Handler{Run: func(context.Context, *models.Job) (any, error) { panic("boom") }})
+	q.Register("fail", Handler{Run: func(context.Context, *models.Job) (any, error) { return nil, errors.New("failed") }})
+
+	for i, jobType := range []string{"panic", "fail"} {
+		job := &models.Job{ID: int64(i + 1), Type: jobType, Target: "example.com"}
+		if _, err := q.lockAndCall(context.Background(), job); err == nil {
+			t.Errorf("%s: no error", jobType)
+		}
+		unlock, err := q.locks.TryLock("example.com", "test")
+		if err != nil {
+			t.Fatalf("%s: lock still held: %v", jobType, err)
+		}
+		unlock()
+	}
+}
+
+func TestEnqueueTargetBusy(t *testing.T) {
+	repo, queues := testQueues(t, 1)
+	q := queues[0]
+	q.Register("deploy", Handler{Run: func(context.Context, *models.Job) (any, error) { return nil, nil }})
+	ctx := context.Background()
+
+	if _, err := q.Enqueue(ctx, "deploy", "example.com", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(ctx, "deploy", "example.com", nil, nil); !errors.Is(err, dbrepo.ErrJobTargetBusy) {
+		t.Fatalf("second job: err = %v, want ErrJobTargetBusy", err)
+	}
+	if _, err := q.Enqueue(ctx, "deploy", "other.com", nil, nil); err != nil {
+		t.Fatalf("other target: %v", err)
+	}
+	if _, err := q.Enqueue(ctx, "unknown", "third.com", nil, nil); err == nil {
+		t.Fatal("unknown job type accepted")
+	}
+	if job, err := repo.GetActiveJobForTarget(ctx, "example.com"); err != nil || job == nil {
+		t.Fatalf("active job: %v, %v", job, err)
+	}
+}
+
+func TestFailedJobsReleaseTheSite(t *testing.T) {
+	repo, queues := testQueues(t, 1)
+	q := queues[0]
+	q.Register("panic", Handler{Run: func(context.Context, *models.Job) (any, error) { panic("boom") }})
+	q.Register("fail", Handler{Run: func(context.Context, *models.Job) (any, error) { return nil, errors.New("no space left") }})
+	q.Register("deploy", Handler{Run: func(context.Context, *models.Job) (any, error) { return "ok", nil }})
+	start(t, queues, 1)
+	ctx := context.Background()
+
+	for _, tt := range []struct{ jobType, status, err string }{
+		{"panic", models.JobStatusFailed, "internal error while running the job"},
+		{"fail", models.JobStatusFailed, "no space left"},
+		{"deploy", models.JobStatusSucceeded, ""},
+	} {
+		job, err := q.Enqueue(ctx, tt.jobType, "example.com", nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.jobType, err)
+		}
+		job = waitFinished(t, repo, job.ID)
+		if job.Status != tt.status || job.Error != tt.err {
+			t.Errorf("%s: status %s, error %q, want %s, %q", tt.jobType, job.Status, job.Error, tt.status, tt.err)
+		}
+		// neither the site lock nor the target is left taken
+		unlock, err := q.Locks().TryLock("example.com", "test")
+		if err != nil {
+			t.Fatalf("%s: site lock still held: %v", tt.jobType, err)
+		}
+		unlock()
+	}
+}
+
+func TestJobWaitsForSiteLock(t *testing.T) {
+	repo, queues := testQueues(t, 1)
+	q := queues[0]
+	ran := make(chan struct{}, 1)
+	q.Register("deploy", Handler{Run: func(context.Context, *models.Job) (any, error) {
+		ran <- struct{}{}
+		return nil, nil
+	}})
+	start(t, queues, 1)
+
+	// a quick operation, e.g. a suspend, holds the site
+	unlock, err := q.Locks().TryLock("example.com", "suspend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Enqueue(context.Background(), "deploy", "example.com", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ran:
+		t.Fatal("job ran while the site was locked")
+	case <-time.After(200 * time.Millisecond):
+	}
+	unlock()
+	if job = waitFinished(t, repo, job.ID); job.Status != models.JobStatusSucceeded {
+		t.Fatalf("status %s, error %q", job.Status, job.Error)
+	}
+}
+
+func TestConcurrentClaims(t *testing.T) {
+	repo, queues := testQueues(t, 2)
+	const jobs = 30
+
+	var mu sync.Mutex
+	runs := map[int64]int{}
+	for _, q := range queues {
+		q.Register("deploy", Handler{Run: func(_ context.Context, job *models.Job) (any, error) {
+			mu.Lock()
+			runs[job.ID]++
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			return nil, nil
+		}})
+	}
+	// both processes start before any job is queued: a later Start would recover the
+	// jobs the other is running
+	start(t, queues, 4)
+
+	ids := make([]int64, jobs)
+	for i := range ids {
+		job, err := queues[i%2].Enqueue(context.Background(), "deploy", fmt.Sprintf("site%d.example.com", i), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = job.ID
+	}
+	for _, id := range ids {
+		if job := waitFinished(t, repo, id); job.Status != models.JobStatusSucceeded || job.Attempts != 1 {
+			t.Errorf("job %d: status %s, attempts %d", id, job.Status, job.Attempts)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range ids {
+		if runs[id] != 1 {
+			t.Errorf("job %d ran %d times, want 1", id, runs[id])
+		}
+	}
+}
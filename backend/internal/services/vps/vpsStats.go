@@ -1,10 +1,12 @@
 package vps
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os/exec"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -12,8 +14,12 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/syscmd"
 )
 
+// queryTimeout bounds one of the commands the stats are read from
+const queryTimeout = 5 * time.Second
+
 type ServerStats struct {
 	
 	Timestamp   int64   `json:"timestamp"`
@@ -138,45 +144,84 @@ func collectCurrentStats() ServerStats {
 }
 
 
+// query runs the read-only command name with args through the executor and returns its
+// output. The stats are parsed here rather than by a shell pipeline.
+func query(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	out, err := syscmd.Query(ctx, name, args...)
+	return string(out), err
+}
+
+// readField returns the i-th space separated field of the file at path
+func readField(path string, i int) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if i >= len(fields) {
+		return ""
+	}
+	return fields[i]
+}
+
 func getCPUUsage() float64 {
 	if runtime.GOOS != "linux" {
 		return 0.0
 	}
 
-	cmd := exec.Command("sh", "-c", "top -bn1 | grep 'Cpu(s)' | sed 's/.*, *\\([0-9.]*\\)%* id.*/\\1/' | awk '{print 100 - $1}'")
-	output, err := cmd.Output()
+	output, err := query("top", "-bn1")
 	if err != nil {
 		return 0.0
 	}
-
-	usage, _ := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
-	return usage
+	// e.g. %Cpu(s):  1.2 us,  0.3 sy,  0.0 ni, 98.4 id, ...
+	for _, line := range strings.Split(output, "\n") {
+		i := strings.Index(line, "Cpu(s)")
+		if i < 0 {
+			continue
+		}
+		for _, field := range strings.Split(line[i+len("Cpu(s)"):], ",") {
+			field = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(field), ":"))
+			if !strings.HasSuffix(field, "id") {
+				continue
+			}
+			value := strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(field, "id"), "%"))
+			idle, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0.0
+			}
+			return 100 - idle
+		}
+	}
+	return 0.0
 }
 
-func getMemoryUsed() uint64 {
+// memoryField returns the i-th field of the Mem line of free -b: 1 is the total, 2 the used
+func memoryField(i int) uint64 {
 	if runtime.GOOS != "linux" {
 		return 0
 	}
-	cmd := exec.Command("sh", "-c", "free -b | grep Mem | awk '{print $3}'")
-	output, err := cmd.Output()
+	output, err := query("free", "-b")
 	if err != nil {
 		return 0
 	}
-	used, _ := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
-	return used
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > i && fields[0] == "Mem:" {
+			value, _ := strconv.ParseUint(fields[i], 10, 64)
+			return value
+		}
+	}
+	return 0
+}
+
+func getMemoryUsed() uint64 {
+	return memoryField(2)
 }
 
 func getMemoryTotal() uint64 {
-	if runtime.GOOS != "linux" {
-		return 0
-	}
-	cmd := exec.Command("sh", "-c", "free -b | grep Mem | awk '{print $2}'")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0
-	}
-	total, _ := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
-	return total
+	return memoryField(1)
 }
 
 func getMemoryPercent() float64 {
@@ -187,80 +232,67 @@ func getMemoryPercent() float64 {
 	return float64(getMemoryUsed()) / float64(total) * 100
 }
 
-func getDiskUsed() uint64 {
+// diskField returns the i-th field of the line of / in df: 1 is the total and 2 the used
+// 1K blocks, 4 the percentage used
+func diskField(i int) string {
 	if runtime.GOOS != "linux" {
-		return 0
+		return ""
 	}
-	cmd := exec.Command("sh", "-c", "df / | tail -1 | awk '{print $3}'")
-	output, err := cmd.Output()
+	output, err := query("df", "/")
 	if err != nil {
-		return 0
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if i >= len(fields) {
+		return ""
 	}
-	used, _ := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+	return fields[i]
+}
+
+func getDiskUsed() uint64 {
+	used, _ := strconv.ParseUint(diskField(2), 10, 64)
 	return used * 1024
 }
 
 func getDiskTotal() uint64 {
-	if runtime.GOOS != "linux" {
-		return 0
-	}
-	cmd := exec.Command("sh", "-c", "df / | tail -1 | awk '{print $2}'")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0
-	}
-	total, _ := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+	total, _ := strconv.ParseUint(diskField(1), 10, 64)
 	return total * 1024
 }
 
 func getDiskPercent() float64 {
-	if runtime.GOOS != "linux" {
-		return 0.0
-	}
-	cmd := exec.Command("sh", "-c", "df / | tail -1 | awk '{print $5}' | sed 's/%//'")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0.0
-	}
-	perc, _ := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	perc, _ := strconv.ParseFloat(strings.TrimSuffix(diskField(4), "%"), 64)
 	return perc
 }
 
 // --- NETWORK FUNCTIONS WITH SPEED ---
-func getNetworkRx() uint64 {
+
+// networkBytes returns the counter name (rx_bytes or tx_bytes) of the first interface
+// other than the loopback
+func networkBytes(name string) uint64 {
 	if runtime.GOOS != "linux" {
 		return 0
 	}
-	cmd := exec.Command("sh", "-c", `for iface in /sys/class/net/*; do
-if [ "$(basename $iface)" != "lo" ]; then
-    cat $iface/statistics/rx_bytes
-    break
-fi
-done`)
-	output, err := cmd.Output()
+	ifaces, err := os.ReadDir("/sys/class/net")
 	if err != nil {
 		return 0
 	}
-	rx, _ := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
-	return rx
+	for _, iface := range ifaces {
+		if iface.Name() == "lo" {
+			continue
+		}
+		value, _ := strconv.ParseUint(readField(filepath.Join("/sys/class/net", iface.Name(), "statistics", name), 0), 10, 64)
+		return value
+	}
+	return 0
+}
+
+func getNetworkRx() uint64 {
+	return networkBytes("rx_bytes")
 }
 
 func getNetworkTx() uint64 {
-	if runtime.GOOS != "linux" {
-		return 0
-	}
-	cmd := exec.Command("sh", "-c", `for iface in /sys/class/net/*; do
-if [ "$(basename $iface)" != "lo" ]; then
-    cat $iface/statistics/tx_bytes
-    break
-fi
-done`)
-	output, err := cmd.Output()
-	if err != nil {
-		return 0
-	}
-	tx, _ := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
-	return tx
+	return networkBytes("tx_bytes")
 }
 
 func getNetworkRxSpeed() uint64 {
@@ -298,54 +330,34 @@ func getNetworkTxSpeed() uint64 {
 }
 
 // --- LOAD AND UPTIME ---
-func getLoadAvg1() float64 {
+
+// loadAvg returns the i-th load average of /proc/loadavg: 0 over 1, 1 over 5 and 2 over
+// 15 minutes
+func loadAvg(i int) float64 {
 	if runtime.GOOS != "linux" {
 		return 0.0
 	}
-	cmd := exec.Command("sh", "-c", "cat /proc/loadavg | awk '{print $1}'")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0.0
-	}
-	load, _ := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	load, _ := strconv.ParseFloat(readField("/proc/loadavg", i), 64)
 	return load
 }
 
+func getLoadAvg1() float64 {
+	return loadAvg(0)
+}
+
 func getLoadAvg5() float64 {
-	if runtime.GOOS != "linux" {
-		return 0.0
-	}
-	cmd := exec.Command("sh", "-c", "cat /proc/loadavg | awk '{print $2}'")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0.0
-	}
-	load, _ := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
-	return load
+	return loadAvg(1)
 }
 
 func getLoadAvg15() float64 {
-	if runtime.GOOS != "linux" {
-		return 0.0
-	}
-	cmd := exec.Command("sh", "-c", "cat /proc/loadavg | awk '{print $3}'")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0.0
-	}
-	load, _ := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
-	return load
+	return loadAvg(2)
 }
 
 func getUptime() uint64 {
 	if runtime.GOOS != "linux" {
 		return 0
 	}
-	cmd := exec.Command("sh", "-c", "cat /proc/uptime | awk '{print $1}' | cut -d. -f1")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0
-	}
-	uptime, _ := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+	seconds, _, _ := strings.Cut(readField("/proc/uptime", 0), ".")
+	uptime, _ := strconv.ParseUint(seconds, 10, 64)
 	return uptime
 }
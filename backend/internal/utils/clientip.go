@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseIPNets parses a list of IPs and CIDRs (e.g. "127.0.0.1", "10.0.0.0/8").
+// A bare IP is treated as a single-host network. Empty entries are skipped.
+func ParseIPNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ClientIP returns the address of the client that made the request.
+// X-Forwarded-For is only honored when the direct peer is a trusted proxy; it is then walked
+// from the right and the first address that is not itself a trusted proxy is returned.
+// Anything further left was written by the client and cannot be trusted.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if !IPInNets(peer, trustedProxies) {
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// malformed entry: stop walking rather than trusting what lies beyond it
+			break
+		}
+		if !IPInNets(hop, trustedProxies) {
+			return hop
+		}
+	}
+
+	// Every hop was a trusted proxy (or the header was missing); fall back to the peer
+	return peer
+}
+
+// IPInNets reports whether ip belongs to any of nets
+func IPInNets(ip string, nets []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
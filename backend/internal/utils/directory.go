@@ -1,21 +1,15 @@
 package utils
 
 import (
-	"log"
-	"os"
-	"path/filepath"
 	"strings"
+
+	"github.com/projuktisheba/vpanel/backend/internal/pkg/layout"
 )
 
 // GetWordpressProjectDirectory returns the full path for a WordPress project
 // based on the provided domain name.
-// It also empty string when error occurs
 func GetWordpressProjectDirectory() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return ""
-	}
-	return filepath.Join(homeDir, "projuktisheba", "bin", "wordpress")
+	return layout.Panel("bin", "wordpress")
 }
 
 // GetWordpressProjectName returns a unique name for a WordPress project
@@ -29,24 +23,13 @@ func GetWordpressProjectName(domainName string) string {
 }
 
 // GetPHPProjectBaseDirectory returns the full path for the base directory of PHP project
-// It also empty string when error occurs
 func GetPHPProjectBaseDirectory() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return ""
-	}
-	return filepath.Join(homeDir, "projuktisheba", "bin", "PHP")
+	return layout.Panel("bin", "PHP")
 }
 // GetPHPProjectDirectory returns the full path for a PHP project
 // based on the provided domain name.
-// It also empty string when error occurs
 func GetPHPProjectDirectory(domainName string) string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Println(err)
-		return ""
-	}
-	return filepath.Join(homeDir, "projuktisheba", "bin", "PHP", domainName)
+	return layout.Panel("bin", "PHP", domainName)
 }
 
 
@@ -61,11 +44,22 @@ func GetPHPProjectName(domainName string) string {
 }
 
 // GetTempDirectory returns the full path for temporary directory
-// It also empty string when error occurs
 func GetTempDirectory() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return ""
-	}
-	return filepath.Join(homeDir, "projuktisheba", "temp")
+	return layout.Panel("temp")
+}
+
+// GetDatabaseTemplateDirectory returns the directory holding the SQL files of engine
+// ("mysql" or "postgresql")
+func GetDatabaseTemplateDirectory(engine string) string {
+	return layout.Panel("templates", "databases", engine)
+}
+
+// GetImageDirectory returns the directory served under /api/v1/images/
+func GetImageDirectory() string {
+	return layout.Data("images")
+}
+
+// GetOperationLogDirectory returns the directory holding the output logs of background jobs
+func GetOperationLogDirectory() string {
+	return layout.Data("oplogs")
 }
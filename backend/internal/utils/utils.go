@@ -128,9 +128,17 @@ func GenerateJWT(user models.JWT, cfg models.JWTConfig) (string, error) {
 		"aud":        cfg.Audience,
 		"exp":        now.Add(cfg.Expiry).Unix(),
 		"iat":        now.Unix(),
+		"jti":        user.TokenID,
+		"sid":        user.SessionID,
 		"created_at": user.CreatedAt,
 		"updated_at": user.UpdatedAt,
 	}
+	if user.Purpose != "" {
+		claims["purpose"] = user.Purpose
+	}
+	if user.Target != "" {
+		claims["target"] = user.Target
+	}
 
 	token := jwt.NewWithClaims(jwt.GetSigningMethod(cfg.Algorithm), claims)
 	return token.SignedString([]byte(cfg.SecretKey))
@@ -153,16 +161,34 @@ func ParseJWT(tokenString string, cfg models.JWTConfig) (*models.JWT, error) {
 		return nil, errors.New("invalid claims")
 	}
 
-	return &models.JWT{
-		ID:        int64(claims["id"].(float64)),
-		Name:      claims["name"].(string),
-		Username:  claims["username"].(string),
-		Role:      claims["role"].(string),
-		Issuer:    claims["iss"].(string),
-		Audience:  claims["aud"].(string),
-		ExpiresAt: int64(claims["exp"].(float64)),
-		IssuedAt:  int64(claims["iat"].(float64)),
-	}, nil
+	// Map claims safely; a token without id, role or expiry is never accepted
+	user := &models.JWT{}
+	idf, ok := claims["id"].(float64)
+	if !ok {
+		return nil, errors.New("invalid claims: missing id")
+	}
+	user.ID = int64(idf)
+	expf, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("invalid claims: missing exp")
+	}
+	user.ExpiresAt = int64(expf)
+	if user.Role, ok = claims["role"].(string); !ok {
+		return nil, errors.New("invalid claims: missing role")
+	}
+	if iatf, ok := claims["iat"].(float64); ok {
+		user.IssuedAt = int64(iatf)
+	}
+	user.Name, _ = claims["name"].(string)
+	user.Username, _ = claims["username"].(string)
+	user.Issuer, _ = claims["iss"].(string)
+	user.Audience, _ = claims["aud"].(string)
+	user.TokenID, _ = claims["jti"].(string)
+	user.SessionID, _ = claims["sid"].(string)
+	user.Purpose, _ = claims["purpose"].(string)
+	user.Target, _ = claims["target"].(string)
+
+	return user, nil
 }
 
 // HashPassword generates a bcrypt hash of the password
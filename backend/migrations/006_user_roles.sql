@@ -0,0 +1,11 @@
+-- =========================
+-- Normalize users.role to the panel roles: admin, operator, viewer
+-- =========================
+-- Legacy seed accounts were created as 'editor' and administered the whole panel
+UPDATE users SET role = 'admin' WHERE role = 'editor';
+
+-- Anything else unknown falls back to read-only access
+UPDATE users SET role = 'viewer' WHERE role NOT IN ('admin', 'operator', 'viewer');
+
+ALTER TABLE users
+    ADD CONSTRAINT users_role_check CHECK (role IN ('admin', 'operator', 'viewer'));
@@ -0,0 +1,33 @@
+-- =========================
+-- Table: refresh_tokens
+-- =========================
+-- Rotating refresh tokens. Each sign-in starts a family (family_id);
+-- each refresh inserts a new row and points the old one at it via replaced_by.
+-- Presenting a replaced token again revokes the whole family.
+CREATE TABLE refresh_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE, -- sha256 of the opaque token
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    replaced_by BIGINT NULL REFERENCES refresh_tokens(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+
+-- =========================
+-- Table: revoked_access_tokens
+-- =========================
+-- Access tokens (by jti) killed before their natural expiry.
+-- Rows can be purged once expires_at has passed.
+CREATE TABLE revoked_access_tokens (
+    jti TEXT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_revoked_access_tokens_expires_at ON revoked_access_tokens(expires_at);
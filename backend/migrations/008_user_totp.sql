@@ -0,0 +1,24 @@
+-- =========================
+-- TOTP two-factor authentication
+-- =========================
+-- totp_secret is written on enrollment and only takes effect once totp_enabled is set by a confirmed code.
+-- totp_last_step is the last accepted RFC 6238 step; a code for the same or an earlier step is refused (replay).
+ALTER TABLE users
+    ADD COLUMN totp_secret TEXT NULL,
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- =========================
+-- Table: user_recovery_codes
+-- =========================
+-- Single-use codes to sign in without the authenticator. Only sha256 hashes are stored.
+CREATE TABLE user_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
+
+CREATE INDEX idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
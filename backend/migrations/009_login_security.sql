@@ -0,0 +1,27 @@
+-- =========================
+-- Account lockout
+-- =========================
+-- failed_login_count counts consecutive wrong passwords and resets on a successful sign-in.
+-- Once it reaches LOGIN_MAX_FAILURES the account is locked until locked_until.
+ALTER TABLE users
+    ADD COLUMN failed_login_count INT NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until TIMESTAMPTZ NULL;
+
+-- =========================
+-- Table: login_attempts
+-- =========================
+-- Every sign-in attempt, kept for review. user_id is NULL when the username matched no account.
+CREATE TABLE login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    username VARCHAR(255) NOT NULL,
+    user_id BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    reason VARCHAR(50) NOT NULL DEFAULT '', -- invalid_password, unknown_user, locked, inactive, invalid_totp
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_attempts_created_at ON login_attempts(created_at);
+CREATE INDEX idx_login_attempts_username ON login_attempts(username);
+CREATE INDEX idx_login_attempts_ip_address ON login_attempts(ip_address);
@@ -0,0 +1,22 @@
+-- =========================
+-- Table: api_keys
+-- =========================
+-- Keys for automation (CI deploys, SSL renewals) acting on behalf of a panel user.
+-- The full key is shown once at creation; only its sha256 is stored. prefix is kept to recognise keys in the UI.
+-- A request is allowed when the owner's role AND the key's scopes both hold the required permission.
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',        -- rbac permissions, e.g. {project:deploy,ssl:issue}
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',   -- IPs/CIDRs; empty means any address
+    expires_at TIMESTAMPTZ NULL,
+    last_used_at TIMESTAMPTZ NULL,
+    last_used_ip VARCHAR(45) NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
@@ -0,0 +1,29 @@
+-- =========================
+-- Table: audit_events
+-- =========================
+-- One row per mutating API request: who did what to which target, with which (redacted) parameters,
+-- and how it ended. Rows are never updated.
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_user_id BIGINT NULL, -- no FK: the trail must outlive deleted users
+    actor_name VARCHAR(255) NOT NULL DEFAULT '',
+    actor_role VARCHAR(20) NOT NULL DEFAULT '',
+    api_key_id BIGINT NULL,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    action VARCHAR(100) NOT NULL,   -- e.g. wordpress.delete, mysql.reset_database
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '', -- domain, project id, database name...
+    params JSONB NOT NULL DEFAULT '{}'::jsonb,
+    status_code INT NOT NULL,
+    outcome VARCHAR(20) NOT NULL,   -- success, failure, denied
+    error_message TEXT NOT NULL DEFAULT '',
+    duration_ms BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX idx_audit_events_actor_user_id ON audit_events(actor_user_id);
+CREATE INDEX idx_audit_events_action ON audit_events(action);
+CREATE INDEX idx_audit_events_target ON audit_events(target);
@@ -0,0 +1,28 @@
+-- =========================
+-- Table: jobs
+-- =========================
+-- Background work queue. Workers claim queued rows with FOR UPDATE SKIP LOCKED.
+-- Rows left 'running' by a stopped panel are requeued (resumable types) or marked 'interrupted' on startup.
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'succeeded', 'failed', 'interrupted')),
+    target TEXT NOT NULL DEFAULT '',
+    payload JSONB NOT NULL DEFAULT '{}'::jsonb,
+    result JSONB NULL,
+    error TEXT NOT NULL DEFAULT '',
+    requested_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 1,
+    worker_id VARCHAR(100) NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ NULL,
+    finished_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_jobs_queued ON jobs(id) WHERE status = 'queued';
+CREATE INDEX idx_jobs_status ON jobs(status);
+CREATE INDEX idx_jobs_target ON jobs(target);
+CREATE INDEX idx_jobs_created_at ON jobs(created_at);
@@ -0,0 +1,11 @@
+-- =========================
+-- Table: jobs (cancellation and per-target exclusivity)
+-- =========================
+ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_status_check;
+ALTER TABLE jobs ADD CONSTRAINT jobs_status_check
+    CHECK (status IN ('queued', 'running', 'succeeded', 'failed', 'interrupted', 'cancelled'));
+
+-- At most one queued or running job per target (domain): a second deployment of the
+-- same site is rejected instead of racing the first one
+CREATE UNIQUE INDEX idx_jobs_active_target ON jobs(target)
+    WHERE status IN ('queued', 'running') AND target <> '';
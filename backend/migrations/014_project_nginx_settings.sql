@@ -0,0 +1,12 @@
+-- =========================
+-- Table: project_nginx_settings
+-- =========================
+-- Custom nginx rules of a project (redirects, body size, a validated snippet).
+-- They are rendered into the project's vhost on every deploy.
+CREATE TABLE project_nginx_settings (
+    project_id INT PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    redirects JSONB NOT NULL DEFAULT '[]'::jsonb,
+    client_max_body_size VARCHAR(20) NOT NULL DEFAULT '',
+    snippet TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,29 @@
+-- =========================
+-- Table: project_access_rules
+-- =========================
+-- Who may reach a path of a project's site: basic auth and allow/deny address lists.
+-- A rule for "/" covers the whole site.
+CREATE TABLE project_access_rules (
+    id SERIAL PRIMARY KEY,
+    project_id INT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    path VARCHAR(255) NOT NULL DEFAULT '/',
+    basic_auth BOOLEAN NOT NULL DEFAULT FALSE,
+    allow_list TEXT[] NOT NULL DEFAULT '{}',
+    deny_list TEXT[] NOT NULL DEFAULT '{}',
+    satisfy_any BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, path)
+);
+
+-- =========================
+-- Table: project_access_users
+-- =========================
+-- Basic auth users of a project's site; the hashes are written to its htpasswd file.
+CREATE TABLE project_access_users (
+    id SERIAL PRIMARY KEY,
+    project_id INT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    username VARCHAR(64) NOT NULL,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, username)
+);
@@ -0,0 +1,13 @@
+-- =========================
+-- Table: project_maintenance
+-- =========================
+-- Maintenance mode of a project's site: a 503 page with Retry-After that the listed
+-- client addresses bypass. Unlike a suspension, the site keeps running behind it.
+CREATE TABLE project_maintenance (
+    project_id INT PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    retry_after INT NOT NULL DEFAULT 3600,
+    page_html TEXT NOT NULL DEFAULT '',
+    allow_ips TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,7 @@
+-- =========================
+-- Table: project_nginx_settings
+-- =========================
+-- Static sites can send every path that is not a file to /index.html, for single page
+-- applications that route in the browser.
+ALTER TABLE project_nginx_settings
+    ADD COLUMN spa_fallback BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,15 @@
+-- =========================
+-- Table: project_apps
+-- =========================
+-- Applications (Go, Node.js, ...) the panel runs as systemd services behind an nginx
+-- proxy. Each one listens on its own local port, handed out by the panel.
+CREATE TABLE project_apps (
+    project_id INT PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    command TEXT NOT NULL,
+    working_dir TEXT NOT NULL,
+    env JSONB NOT NULL DEFAULT '{}'::jsonb,
+    restart_policy VARCHAR(20) NOT NULL DEFAULT 'on-failure',
+    run_as VARCHAR(32) NOT NULL,
+    port INT NOT NULL UNIQUE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
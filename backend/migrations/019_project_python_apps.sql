@@ -0,0 +1,17 @@
+-- =========================
+-- Table: project_python_apps
+-- =========================
+-- Python (Django, FastAPI, ...) applications, run by gunicorn or uvicorn from a
+-- virtualenv of the project as systemd services listening on a unix socket.
+CREATE TABLE project_python_apps (
+    project_id INT PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    module TEXT NOT NULL,
+    server VARCHAR(20) NOT NULL DEFAULT 'gunicorn',
+    workers INT NOT NULL DEFAULT 2,
+    env JSONB NOT NULL DEFAULT '{}'::jsonb,
+    static_url TEXT NOT NULL DEFAULT '',
+    static_dir TEXT NOT NULL DEFAULT '',
+    migrate BOOLEAN NOT NULL DEFAULT FALSE,
+    collect_static BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
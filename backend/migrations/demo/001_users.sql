@@ -1,7 +1,7 @@
 INSERT INTO users (name, role, status, mobile, email, password, address, avatar_link)
 VALUES (
     'Vpanel Admin',
-    'editor',
+    'admin',
     'active',
     '+8801700000000',
     'vpanel@pssoft.xyz',
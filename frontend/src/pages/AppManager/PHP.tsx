@@ -26,7 +26,7 @@ export default function ProjectUploader() {
   const [projectNameError, setProjectNameError] = useState<string>("");
   const [domainList, setDomainList] = useState<Option[]>([]);
 
-  //project framework(Laravel, CodeIgniter)
+  //project framework(Laravel, CodeIgniter, Symfony, Static, App, Python)
   const [projectFramework, setProjectFramework] = useState<string>("");
   const [projectFrameworkError, setProjectFrameworkError] =
     useState<string>("");
@@ -39,7 +39,27 @@ export default function ProjectUploader() {
       value: "CodeIgniter",
       label: "CodeIgniter",
     },
+    {
+      value: "Symfony",
+      label: "Symfony",
+    },
+    {
+      value: "Static",
+      label: "Static (HTML/JS build)",
+    },
+    {
+      value: "App",
+      label: "Application (systemd service)",
+    },
+    {
+      value: "Python",
+      label: "Python (Django, FastAPI, ...)",
+    },
   ];
+  // static sites and applications need no database
+  const databaseRequired = !["Static", "App", "Python"].includes(
+    projectFramework,
+  );
 
   //databases
   const [databaseList, setDatabaseList] = useState<Option[]>([]);
@@ -124,7 +144,7 @@ export default function ProjectUploader() {
       setProjectNameError("Please select you project domain name.");
       return;
     }
-    if (!databaseName) {
+    if (!databaseName && databaseRequired) {
       setDatabaseNameError("Please select your project database.");
       return;
     }
@@ -406,7 +426,7 @@ export default function ProjectUploader() {
               {/* Row 3: Action Button */}
               <div className="w-full pt-2">
                 <Button
-                  disabled={!projectName || !databaseName || !projectFramework || !file || uploading}
+                  disabled={!projectName || (!databaseName && databaseRequired) || !projectFramework || !file || uploading}
                   isHidden={uploading}
                   size={"md"}
                   variant="primary"